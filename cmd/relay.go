@@ -5,7 +5,9 @@ package cmd
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -13,6 +15,7 @@ import (
 	"github.com/stafiprotocol/chainbridge/utils/keystore"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/config"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/log"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/signer"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
 	"github.com/stafiprotocol/eth-lsd-relay/service"
 )
@@ -30,7 +33,14 @@ func startRelayCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			fmt.Printf("keystore path: %s\n", cfg.KeystorePath)
+			dryRun, err := cmd.Flags().GetBool(flagDryRun)
+			if err != nil {
+				return err
+			}
+			cfg.DryRun = cfg.DryRun || dryRun
+			if !cfg.DryRun && cfg.Signer.Type == signer.TypeKeystore {
+				fmt.Printf("keystore path: %s\n", cfg.KeystorePath)
+			}
 
 			logLevelStr, err := cmd.Flags().GetString(flagLogLevel)
 			if err != nil {
@@ -42,18 +52,16 @@ func startRelayCmd() *cobra.Command {
 			}
 			logrus.SetLevel(logLevel)
 
-			// init constant variables
-			utils.StandardEffectiveBalance = cfg.Eth2EffectiveBalance * 1e9                                                        // unit Gwei
-			utils.StandardEffectiveBalanceDeci = decimal.NewFromInt(int64(utils.StandardEffectiveBalance)).Mul(utils.GweiDeci)     // unit wei
-			utils.MaxPartialWithdrawalAmount = cfg.MaxPartialWithdrawalAmount * 1e9                                                // unit Gwei
-			utils.MaxPartialWithdrawalAmountDeci = decimal.NewFromInt(int64(utils.MaxPartialWithdrawalAmount)).Mul(utils.GweiDeci) // unit wei
+			initConstants(cfg)
 
 			logrus.Infof(
 				`config info:
   logFilePath: %s
   logLevel: %s
   account: %s
+  signer: %s
   runForEntrustedLsdNetwork: %v
+  dryRun: %v
   lsdTokenAddress: %s
   factoryAddress: %s
   batchRequestBlocksNumber: %d
@@ -62,8 +70,8 @@ func startRelayCmd() *cobra.Command {
   maxGasPrice: %s Gwei
   gasPriceMultiplier: %.2f
   endpoints: %v`,
-				cfg.LogFilePath, logLevelStr, cfg.Account,
-				cfg.RunForEntrustedLsdNetwork, cfg.Contracts.LsdTokenAddress, cfg.Contracts.LsdFactoryAddress,
+				cfg.LogFilePath, logLevelStr, cfg.Account, cfg.Signer.Type,
+				cfg.RunForEntrustedLsdNetwork, cfg.DryRun, cfg.Contracts.LsdTokenAddress, cfg.Contracts.LsdFactoryAddress,
 				cfg.BatchRequestBlocksNumber, cfg.EventFilterMaxSpanBlocks, cfg.MaxEjectedValPerCycle, cfg.MaxGasPrice, cfg.GasPriceMultiplier, cfg.Endpoints)
 
 			err = log.InitLogFile(cfg.LogFilePath + "/relay")
@@ -74,16 +82,15 @@ func startRelayCmd() *cobra.Command {
 			//interrupt signal
 			ctx := utils.ShutdownListener()
 
-			// load voter account
-			kpI, err := keystore.KeypairFromAddress(cfg.Account, keystore.EthChain, cfg.KeystorePath, false)
-			if err != nil {
-				return err
+			// load voter account, dry run never sends txs so it needs no signer
+			var voter signer.Signer
+			if !cfg.DryRun {
+				voter, err = loadSigner(cfg)
+				if err != nil {
+					return err
+				}
 			}
-			kp, ok := kpI.(*secp256k1.Keypair)
-			if !ok {
-				return fmt.Errorf(" keypair err")
-			}
-			srvManager, err := service.NewServiceManager(cfg, kp)
+			srvManager, err := service.NewServiceManager(cfg, voter)
 			if err != nil {
 				return fmt.Errorf("NewServiceManager err: %w", err)
 			}
@@ -93,8 +100,7 @@ func startRelayCmd() *cobra.Command {
 			}
 
 			defer func() {
-				logrus.Infof("shutting down task ...")
-				srvManager.Stop()
+				srvManager.Shutdown(time.Duration(cfg.ShutdownTimeout) * time.Second)
 			}()
 
 			<-ctx.Done()
@@ -104,6 +110,55 @@ func startRelayCmd() *cobra.Command {
 
 	cmd.Flags().String(flagBasePath, defaultBasePath, "base path a directory where your config.toml resids")
 	cmd.Flags().String(flagLogLevel, logrus.InfoLevel.String(), "The logging level (trace|debug|info|warn|error|fatal|panic)")
+	cmd.Flags().Bool(flagDryRun, false, "compute and compare proposals with on-chain votes without sending txs, keystore is not needed")
 
 	return cmd
 }
+
+// initConstants inits the constant variables of utils from cfg.
+func initConstants(cfg *config.Config) {
+	utils.StandardEffectiveBalance = cfg.Eth2EffectiveBalance * 1e9                                                        // unit Gwei
+	utils.StandardEffectiveBalanceDeci = decimal.NewFromInt(int64(utils.StandardEffectiveBalance)).Mul(utils.GweiDeci)     // unit wei
+	utils.MaxPartialWithdrawalAmount = cfg.MaxPartialWithdrawalAmount * 1e9                                                // unit Gwei
+	utils.MaxPartialWithdrawalAmountDeci = decimal.NewFromInt(int64(utils.MaxPartialWithdrawalAmount)).Mul(utils.GweiDeci) // unit wei
+}
+
+func loadSigner(cfg *config.Config) (signer.Signer, error) {
+	if !common.IsHexAddress(cfg.Account) {
+		return nil, fmt.Errorf("account %s fmt err", cfg.Account)
+	}
+	account := common.HexToAddress(cfg.Account)
+
+	var voter signer.Signer
+	switch cfg.Signer.Type {
+	case signer.TypeKeystore:
+		kpI, err := keystore.KeypairFromAddress(cfg.Account, keystore.EthChain, cfg.KeystorePath, false)
+		if err != nil {
+			return nil, err
+		}
+		kp, ok := kpI.(*secp256k1.Keypair)
+		if !ok {
+			return nil, fmt.Errorf(" keypair err")
+		}
+		voter = signer.NewKeypairSigner(kp)
+	case signer.TypeRemote:
+		remoteSigner, err := signer.NewRemoteSigner(cfg.Signer.Endpoint, cfg.Signer.RemoteApi, account)
+		if err != nil {
+			return nil, err
+		}
+		voter = remoteSigner
+	case signer.TypeKeyFile:
+		keyFileSigner, err := signer.NewKeyFileSigner(cfg.Signer.KeyFilePath, cfg.Signer.PasswordEnv)
+		if err != nil {
+			return nil, err
+		}
+		voter = keyFileSigner
+	default:
+		return nil, fmt.Errorf("unsupported signer type %s", cfg.Signer.Type)
+	}
+
+	if voter.Address() != account {
+		return nil, fmt.Errorf("signer address %s not match account %s", voter.Address(), account)
+	}
+	return voter, nil
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/config"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stafiprotocol/eth-lsd-relay/service"
+)
+
+const (
+	flagLsdToken     = "lsd-token"
+	flagProposalType = "type"
+	flagEpoch        = "epoch"
+	flagBlock        = "block"
+)
+
+func replayCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Args:  cobra.ExactArgs(0),
+		Short: "Recompute a past proposal and diff it against the on-chain one",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			basePath, err := cmd.Flags().GetString(flagBasePath)
+			if err != nil {
+				return err
+			}
+			cfg, err := config.Load(basePath)
+			if err != nil {
+				return err
+			}
+			lsdToken, err := cmd.Flags().GetString(flagLsdToken)
+			if err != nil {
+				return err
+			}
+			if lsdToken == "" {
+				lsdToken = cfg.Contracts.LsdTokenAddress
+			}
+			if !common.IsHexAddress(lsdToken) {
+				return fmt.Errorf("lsd token %s fmt err", lsdToken)
+			}
+			proposalType, err := cmd.Flags().GetString(flagProposalType)
+			if err != nil {
+				return err
+			}
+			epoch, err := cmd.Flags().GetUint64(flagEpoch)
+			if err != nil {
+				return err
+			}
+			block, err := cmd.Flags().GetUint64(flagBlock)
+			if err != nil {
+				return err
+			}
+			if (epoch == 0) == (block == 0) {
+				return fmt.Errorf("one of --%s and --%s is needed", flagEpoch, flagBlock)
+			}
+
+			logLevelStr, err := cmd.Flags().GetString(flagLogLevel)
+			if err != nil {
+				return err
+			}
+			logLevel, err := logrus.ParseLevel(logLevelStr)
+			if err != nil {
+				return err
+			}
+			logrus.SetLevel(logLevel)
+			initConstants(cfg)
+
+			// a replay only reads, it shares no state with a running relay: the stores it may write are its own
+			cfg.DryRun = true
+			cfg.ApiListenAddr = ""
+			cfg.SubscribeBeaconEvents = false
+			cfg.Pinata.PinDays = 0
+			cfg.RewardReports = false
+			cfg.Leader.Enabled = false
+			cfg.BlockstoreFilePath += ".replay"
+			cfg.BeaconBlockStorePath += ".replay"
+			cfg.SnapshotPath += ".replay"
+			cfg.PendingTxPath += ".replay"
+
+			srvManager, err := service.NewServiceManager(cfg, nil)
+			if err != nil {
+				return fmt.Errorf("NewServiceManager err: %w", err)
+			}
+			defer srvManager.Shutdown(time.Second)
+
+			result, err := srvManager.Replay(cmd.Context(), lsdToken, proposalType, epoch, block)
+			if err != nil {
+				return err
+			}
+			bts, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(bts))
+			if result.Error != "" {
+				return errors.New(result.Error)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String(flagBasePath, defaultBasePath, "base path a directory where your config.toml resids")
+	cmd.Flags().String(flagLogLevel, logrus.InfoLevel.String(), "The logging level (trace|debug|info|warn|error|fatal|panic)")
+	cmd.Flags().String(flagLsdToken, "", "lsd token of the proposal, default the lsd token of config")
+	cmd.Flags().String(flagProposalType, metrics.ProposalSubmitBalances, fmt.Sprintf("proposal type (%s|%s|%s)",
+		metrics.ProposalSubmitBalances, metrics.ProposalDistributeWithdrawals, metrics.ProposalDistributePriorityFee))
+	cmd.Flags().Uint64(flagEpoch, 0, "target epoch of the proposal")
+	cmd.Flags().Uint64(flagBlock, 0, "target block of the proposal, the first block of its target epoch")
+
+	return cmd
+}
@@ -14,6 +14,7 @@ var (
 const (
 	flagLogLevel = "log-level"
 	flagBasePath = "base-path"
+	flagDryRun   = "dry-run"
 
 	defaultBasePath = "~/eth-stack"
 )
@@ -33,6 +34,7 @@ func NewRootCmd() *cobra.Command {
 	rootCmd.AddCommand(
 		importAccountCmd(),
 		startRelayCmd(),
+		replayCmd(),
 		versionCmd(),
 	)
 	return rootCmd
@@ -22,6 +22,7 @@ require (
 	github.com/nftstorage/go-client v0.0.0-20211129173848-be669a365634
 	github.com/pkg/errors v0.9.1
 	github.com/prysmaticlabs/go-bitfield v0.0.0-20210809151128-385d8c5e3fb7
+	github.com/prometheus/client_golang v1.14.0
 	github.com/prysmaticlabs/prysm/v4 v4.1.1
 	github.com/puzpuzpuz/xsync/v3 v3.0.2
 	github.com/samber/lo v1.36.0
@@ -33,6 +34,7 @@ require (
 	github.com/stretchr/testify v1.8.4
 	github.com/web3-storage/go-ucanto v0.1.0
 	github.com/web3-storage/go-w3up v0.0.2
+	go.etcd.io/bbolt v1.3.8
 	golang.org/x/crypto v0.21.0
 	golang.org/x/exp v0.0.0-20231110203233-9a3e6036ecaa
 	golang.org/x/sync v0.5.0
@@ -107,7 +109,6 @@ require (
 	github.com/pierrec/xxHash v0.1.5 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/polydawn/refmt v0.89.0 // indirect
-	github.com/prometheus/client_golang v1.14.0 // indirect
 	github.com/prometheus/client_model v0.3.0 // indirect
 	github.com/prometheus/common v0.42.0 // indirect
 	github.com/prometheus/procfs v0.9.0 // indirect
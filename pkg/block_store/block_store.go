@@ -0,0 +1,159 @@
+package block_store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketSlots       = []byte("slots")        // beacon slot => block data
+	bucketExecHeights = []byte("exec_heights") // execution block height => beacon slot
+)
+
+const (
+	flagMissing byte = 0 // slot without a block
+	flagExist   byte = 1
+)
+
+// BlockStore persists beacon blocks by slot and indexes them by execution block height,
+// so blocks that were already fetched survive restarts.
+type BlockStore struct {
+	db *bolt.DB
+}
+
+func NewBlockStore(path string) (*BlockStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("create block store dir err: %w", err)
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open block store err: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(bucketSlots); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(bucketExecHeights)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BlockStore{db: db}, nil
+}
+
+func (s *BlockStore) Close() error {
+	return s.db.Close()
+}
+
+// Get returns the block data stored at slot.
+// found reports whether the slot has been stored, exist whether the slot holds a block.
+func (s *BlockStore) Get(slot uint64) (data []byte, exist, found bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		data, exist, found = getSlot(tx, slot)
+		return nil
+	})
+	return
+}
+
+// GetByExecBlockHeight returns the block data whose execution block height is height.
+func (s *BlockStore) GetByExecBlockHeight(height uint64) (data []byte, found bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		slot := tx.Bucket(bucketExecHeights).Get(uint64ToKey(height))
+		if slot == nil {
+			return nil
+		}
+		data, found, _ = getSlot(tx, binary.BigEndian.Uint64(slot))
+		return nil
+	})
+	return
+}
+
+// Put stores the block data of slot and indexes it by execution block height.
+func (s *BlockStore) Put(slot, execBlockHeight uint64, data []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		value := make([]byte, 0, len(data)+1)
+		value = append(value, flagExist)
+		value = append(value, data...)
+		if err := tx.Bucket(bucketSlots).Put(uint64ToKey(slot), value); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketExecHeights).Put(uint64ToKey(execBlockHeight), uint64ToKey(slot))
+	})
+}
+
+// PutMissing marks slot as a slot without block.
+func (s *BlockStore) PutMissing(slot uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSlots).Put(uint64ToKey(slot), []byte{flagMissing})
+	})
+}
+
+// Prune removes blocks whose execution block height is less than minExecBlockHeight,
+// together with all slots before the latest removed one.
+// return (removed exec heights, removed slots)
+func (s *BlockStore) Prune(minExecBlockHeight uint64) (uint64, uint64, error) {
+	var execRemoved, slotRemoved uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var maxClearableSlot uint64
+		execBucket := tx.Bucket(bucketExecHeights)
+		execKeys := make([][]byte, 0)
+		execCursor := execBucket.Cursor()
+		for k, v := execCursor.First(); k != nil && binary.BigEndian.Uint64(k) < minExecBlockHeight; k, v = execCursor.Next() {
+			if slot := binary.BigEndian.Uint64(v); slot > maxClearableSlot {
+				maxClearableSlot = slot
+			}
+			execKeys = append(execKeys, append([]byte{}, k...))
+		}
+
+		slotBucket := tx.Bucket(bucketSlots)
+		slotKeys := make([][]byte, 0)
+		slotCursor := slotBucket.Cursor()
+		for k, _ := slotCursor.First(); k != nil && binary.BigEndian.Uint64(k) < maxClearableSlot; k, _ = slotCursor.Next() {
+			slotKeys = append(slotKeys, append([]byte{}, k...))
+		}
+
+		// deleting while iterating a cursor may skip keys, so delete afterwards
+		for _, k := range execKeys {
+			if err := execBucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		for _, k := range slotKeys {
+			if err := slotBucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		execRemoved = uint64(len(execKeys))
+		slotRemoved = uint64(len(slotKeys))
+		return nil
+	})
+	return execRemoved, slotRemoved, err
+}
+
+func getSlot(tx *bolt.Tx, slot uint64) (data []byte, exist, found bool) {
+	value := tx.Bucket(bucketSlots).Get(uint64ToKey(slot))
+	if len(value) == 0 {
+		return nil, false, false
+	}
+	if value[0] == flagMissing {
+		return nil, false, true
+	}
+	// value is only valid during the transaction
+	data = make([]byte, len(value)-1)
+	copy(data, value[1:])
+	return data, true, true
+}
+
+func uint64ToKey(n uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, n)
+	return key
+}
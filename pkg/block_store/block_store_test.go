@@ -0,0 +1,78 @@
+package block_store_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/block_store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutGetAndPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "beacon_blocks.db")
+	s, err := block_store.NewBlockStore(path)
+	assert.Nil(t, err)
+
+	{
+		_, _, found, err := s.Get(1)
+		assert.Nil(t, err)
+		assert.False(t, found)
+	}
+
+	assert.Nil(t, s.Put(100, 1000, []byte("block100")))
+	assert.Nil(t, s.PutMissing(101))
+	assert.Nil(t, s.Put(102, 1001, []byte("block102")))
+
+	{
+		data, exist, found, err := s.Get(100)
+		assert.Nil(t, err)
+		assert.True(t, found)
+		assert.True(t, exist)
+		assert.Equal(t, []byte("block100"), data)
+
+		_, exist, found, err = s.Get(101)
+		assert.Nil(t, err)
+		assert.True(t, found)
+		assert.False(t, exist)
+
+		data, found, err = s.GetByExecBlockHeight(1001)
+		assert.Nil(t, err)
+		assert.True(t, found)
+		assert.Equal(t, []byte("block102"), data)
+	}
+
+	// reopen, blocks should survive
+	assert.Nil(t, s.Close())
+	s, err = block_store.NewBlockStore(path)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	{
+		data, exist, found, err := s.Get(102)
+		assert.Nil(t, err)
+		assert.True(t, found)
+		assert.True(t, exist)
+		assert.Equal(t, []byte("block102"), data)
+	}
+
+	execRemoved, slotRemoved, err := s.Prune(1001)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), execRemoved)
+	assert.Equal(t, uint64(0), slotRemoved)
+
+	_, found, err := s.GetByExecBlockHeight(1000)
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	execRemoved, slotRemoved, err = s.Prune(1002)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), execRemoved)
+	assert.Equal(t, uint64(2), slotRemoved)
+
+	_, _, found, err = s.Get(101)
+	assert.Nil(t, err)
+	assert.False(t, found)
+	_, _, found, err = s.Get(102)
+	assert.Nil(t, err)
+	assert.True(t, found)
+}
@@ -12,8 +12,11 @@ import (
 )
 
 type Endpoint struct {
-	Eth1 string
-	Eth2 string
+	Eth1          string
+	Eth2          string
+	Weight        float64 // weighted routing: share of calls tried on this endpoint first, default 1
+	Eth1RateLimit float64 // requests per second, default unlimited
+	Eth2RateLimit float64 // requests per second, default unlimited
 }
 
 type Config struct {
@@ -21,22 +24,46 @@ type Config struct {
 	Account                    string
 	KeystorePath               string
 	BlockstoreFilePath         string
+	BeaconBlockStorePath       string
+	SnapshotPath               string
+	PendingTxPath              string
+	RewardReportPath           string
 	GasLimit                   string
 	MaxGasPrice                string // Gwei
 	GasPriceMultiplier         float64
+	FeeBumpPercent             uint64 // fee bump of a replacement of stuck tx
+	FeeBumpAfterBlocks         uint64 // replace a vote tx not mined after these blocks
 	BatchRequestBlocksNumber   uint64
 	EventFilterMaxSpanBlocks   uint64
+	ConfirmationBlocks         *uint64 // events are synced up to this many blocks below the latest block, default 2, 0 syncs up to the latest block
+	SyncEventsToFinalized      bool    // sync events up to the finalized execution block of the beacon head instead
+	SubscribeEvents            bool    // sync events when ws eth1 endpoints push contract events and new heads
+	SubscriptionPollInterval   uint64  // seconds, events are still polled at this interval when subscribed, default 600
+	SubscribeBeaconEvents      bool    // update the beacon head and run vote handlers when the beacon node pushes new epochs, finalized checkpoints and reorgs
 	MaxEjectedValPerCycle      int
 	TrustNodeDepositAmount     uint64 // ether
 	Eth2EffectiveBalance       uint64 // ether
 	MaxPartialWithdrawalAmount uint64 // ether
+	PriorityFeeAccounting      string // priority fees of proposed blocks: balance(default) increase of the fee pool, or receipts of the blocks
+	ComparePriorityFees        bool   // compute priority fees by both accountings and report the blocks they differ in the status api
+	RewardReports              bool   // write json and csv reports attributing the rewards of each distribute and merkle root proposal to validators under reward_reports
+	ApiListenAddr              string // status api listen address, such as 127.0.0.1:8080, disabled if empty
+	ShutdownTimeout            uint64 // seconds to drain in-flight votes and handlers on shutdown
 
 	RunForEntrustedLsdNetwork bool
+	DryRun                    bool // compute proposals without sending txs, no keystore is needed
 
-	Contracts   Contracts
-	Endpoints   []Endpoint
-	Web3Storage Web3Storage
-	Pinata      Pinata
+	Signer        Signer
+	FeeStrategies map[string]FeeStrategy // proposal type or "default" -> fee strategy of its votes
+	Handlers      map[string]Handler     // handler name -> schedule overrides
+	Leader        LeaderElection
+	BeaconQuorum  BeaconQuorum
+	Routing       Routing
+	Fixtures      Fixtures
+	Contracts     Contracts
+	Endpoints     []Endpoint
+	Web3Storage   Web3Storage
+	Pinata        Pinata
 }
 
 type Web3Storage struct {
@@ -51,6 +78,66 @@ type Pinata struct {
 	PinDays  uint
 }
 
+// Signer of the voter account
+type Signer struct {
+	Type        string // keystore(default), remote or keyfile
+	Endpoint    string // json-rpc endpoint of remote signer
+	RemoteApi   string // web3signer(default) or clef
+	KeyFilePath string // web3 secret storage key file
+	PasswordEnv string // env holding the key file password, default LSD_RELAY_KEY_PASSWORD
+}
+
+// FeeStrategy of votes
+type FeeStrategy struct {
+	Type              string  // legacy(default), feeHistory or baseFeeTip
+	MaxGasPrice       string  // Gwei, default maxGasPrice
+	Blocks            uint64  // feeHistory: recent blocks, default 10
+	Percentile        float64 // feeHistory: tip percentile of a block, default 50
+	Tip               string  // baseFeeTip: Gwei, default 2
+	BaseFeeMultiplier float64 // feeHistory and baseFeeTip: gas fee cap = base fee * multiplier + tip, default 2
+}
+
+// Handler schedule overrides, zero values keep the defaults of the handler
+type Handler struct {
+	Interval      uint64 // seconds between runs, default a slot for sync handlers and up to 2 epochs for vote handlers
+	Timeout       uint64 // seconds, a run longer than it counts as a failure, default no timeout
+	BackoffMax    uint64 // seconds, retry delays double up to it after failures, default retry every interval
+	FailureBudget int    // consecutive failures before OnFailure, default 600
+	OnFailure     string // shutdown(default) or continue
+	Reads         string // block of contract reads deciding votes besides the ones at the target block: finalized(default), safe, target or latest
+}
+
+// LeaderElection lets redundant instances run with the same voter account, only the leader sends txs
+type LeaderElection struct {
+	Enabled       bool
+	Backend       string // file(default)
+	SharedPath    string // file backend: directory shared by all instances, such as an NFS mount, holding the lease and pending txs
+	InstanceId    string // default hostname-pid
+	LeaseTtl      uint64 // seconds, a leader failing to renew the lease within it is replaced, default 30
+	RenewInterval uint64 // seconds, default 10
+}
+
+// Routing of calls among the healthy endpoints
+type Routing struct {
+	Policy           string // ordered(default) tries endpoints in config order, weighted at random by weight, latency the fastest first
+	FailureThreshold int    // failures in a row before calls skip an endpoint, default 5
+	CircuitOpen      uint64 // seconds an endpoint is skipped for, default 30
+}
+
+// BeaconQuorum cross-checks validator statuses and beacon blocks votes are computed from across eth2 endpoints,
+// votes are refused when the endpoints disagree
+type BeaconQuorum struct {
+	Enabled   bool
+	Endpoints int // eth2 endpoints queried at once, the first healthy ones, default all
+	Quorum    int // endpoints that must give the same answer, a majority of endpoints, default all of them
+}
+
+// Fixtures of eth1 and eth2 requests, recorded during a run to be replayed by tests without endpoints
+type Fixtures struct {
+	Mode string // record saves every request and its response, replay answers requests from the saved ones, disabled if empty
+	Path string // default fixtures under the base path
+}
+
 type Contracts struct {
 	LsdTokenAddress   string
 	LsdFactoryAddress string
@@ -68,6 +155,13 @@ func Load(basePath string) (*Config, error) {
 	cfg.LogFilePath = basePath + "/log_data"
 	cfg.KeystorePath = KeyStoreFilePath(basePath)
 	cfg.BlockstoreFilePath = basePath + "/blockstore"
+	cfg.BeaconBlockStorePath = basePath + "/beacon_blocks.db"
+	cfg.SnapshotPath = basePath + "/snapshot"
+	cfg.PendingTxPath = basePath + "/pending_txs.json"
+	cfg.RewardReportPath = basePath + "/reward_reports"
+	if cfg.Fixtures.Path == "" {
+		cfg.Fixtures.Path = basePath + "/fixtures"
+	}
 
 	// add default values
 	if cfg.TrustNodeDepositAmount == 0 {
@@ -91,6 +185,63 @@ func Load(basePath string) (*Config, error) {
 	if cfg.EventFilterMaxSpanBlocks == 0 {
 		cfg.EventFilterMaxSpanBlocks = 3000
 	}
+	if cfg.ConfirmationBlocks == nil {
+		confirmationBlocks := uint64(2)
+		cfg.ConfirmationBlocks = &confirmationBlocks
+	}
+	if cfg.SubscriptionPollInterval == 0 {
+		cfg.SubscriptionPollInterval = 600
+	}
+	if cfg.FeeBumpPercent == 0 {
+		cfg.FeeBumpPercent = 20
+	}
+	if cfg.FeeBumpAfterBlocks == 0 {
+		cfg.FeeBumpAfterBlocks = 5
+	}
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = 60
+	}
+	if cfg.PriorityFeeAccounting == "" {
+		cfg.PriorityFeeAccounting = "balance"
+	}
+	if cfg.Routing.Policy == "" {
+		cfg.Routing.Policy = "ordered"
+	}
+	if cfg.Routing.FailureThreshold == 0 {
+		cfg.Routing.FailureThreshold = 5
+	}
+	if cfg.Routing.CircuitOpen == 0 {
+		cfg.Routing.CircuitOpen = 30
+	}
+	for i := range cfg.Endpoints {
+		if cfg.Endpoints[i].Weight == 0 {
+			cfg.Endpoints[i].Weight = 1
+		}
+	}
+	if cfg.Signer.Type == "" {
+		cfg.Signer.Type = "keystore"
+	}
+	for proposalType, strategy := range cfg.FeeStrategies {
+		if strategy.Type == "" {
+			strategy.Type = "legacy"
+		}
+		if strategy.MaxGasPrice == "" {
+			strategy.MaxGasPrice = cfg.MaxGasPrice
+		}
+		if strategy.Blocks == 0 {
+			strategy.Blocks = 10
+		}
+		if strategy.Percentile == 0 {
+			strategy.Percentile = 50
+		}
+		if strategy.Tip == "" {
+			strategy.Tip = "2"
+		}
+		if strategy.BaseFeeMultiplier == 0 {
+			strategy.BaseFeeMultiplier = 2
+		}
+		cfg.FeeStrategies[proposalType] = strategy
+	}
 
 	// handle invalid parameters
 	if cfg.GasPriceMultiplier < 1 {
@@ -99,6 +250,102 @@ func Load(basePath string) (*Config, error) {
 	if cfg.GasPriceMultiplier > 10 {
 		return nil, fmt.Errorf("gas price multiplier can not be greater than 10")
 	}
+	if cfg.FeeBumpPercent < 10 {
+		return nil, fmt.Errorf("fee bump percent can not be less than 10")
+	}
+	for proposalType, strategy := range cfg.FeeStrategies {
+		if strategy.Percentile < 0 || strategy.Percentile > 100 {
+			return nil, fmt.Errorf("fee strategy of %s: percentile must be in [0, 100]", proposalType)
+		}
+		if strategy.BaseFeeMultiplier < 1 {
+			return nil, fmt.Errorf("fee strategy of %s: base fee multiplier can not be less than 1", proposalType)
+		}
+		if strategy.Blocks > 1024 {
+			return nil, fmt.Errorf("fee strategy of %s: blocks can not be greater than 1024", proposalType)
+		}
+	}
+	if cfg.PriorityFeeAccounting != "balance" && cfg.PriorityFeeAccounting != "receipts" {
+		return nil, fmt.Errorf("priorityFeeAccounting must be balance or receipts")
+	}
+	switch cfg.Routing.Policy {
+	case "ordered", "weighted", "latency":
+	default:
+		return nil, fmt.Errorf("routing policy must be ordered, weighted or latency")
+	}
+	switch cfg.Fixtures.Mode {
+	case "", "record", "replay":
+	default:
+		return nil, fmt.Errorf("fixtures mode must be record or replay")
+	}
+	if cfg.Routing.FailureThreshold < 0 {
+		return nil, fmt.Errorf("routing failure threshold can not be negative")
+	}
+	for i, endpoint := range cfg.Endpoints {
+		if endpoint.Weight < 0 || endpoint.Eth1RateLimit < 0 || endpoint.Eth2RateLimit < 0 {
+			return nil, fmt.Errorf("endpoint %d: weight and rate limits can not be negative", i)
+		}
+	}
+	for name, handler := range cfg.Handlers {
+		if handler.OnFailure != "" && handler.OnFailure != "shutdown" && handler.OnFailure != "continue" {
+			return nil, fmt.Errorf("handler %s: onFailure must be shutdown or continue", name)
+		}
+		if handler.FailureBudget < 0 {
+			return nil, fmt.Errorf("handler %s: failure budget can not be negative", name)
+		}
+		switch handler.Reads {
+		case "", "finalized", "safe", "target", "latest":
+		default:
+			return nil, fmt.Errorf("handler %s: reads must be finalized, safe, target or latest", name)
+		}
+	}
+	if cfg.Leader.Enabled {
+		if cfg.Leader.Backend == "" {
+			cfg.Leader.Backend = "file"
+		}
+		if cfg.Leader.LeaseTtl == 0 {
+			cfg.Leader.LeaseTtl = 30
+		}
+		if cfg.Leader.RenewInterval == 0 {
+			cfg.Leader.RenewInterval = 10
+		}
+		if cfg.Leader.Backend != "file" {
+			return nil, fmt.Errorf("unsupported leader election backend %s", cfg.Leader.Backend)
+		}
+		if cfg.Leader.SharedPath == "" {
+			return nil, fmt.Errorf("leader election needs sharedPath")
+		}
+		if cfg.Leader.RenewInterval >= cfg.Leader.LeaseTtl {
+			return nil, fmt.Errorf("leader renewInterval must be less than leaseTtl")
+		}
+		// the next leader resumes txs left pending by the previous one
+		cfg.PendingTxPath = strings.TrimSuffix(cfg.Leader.SharedPath, "/") + "/pending_txs.json"
+	}
+	if cfg.BeaconQuorum.Enabled {
+		if cfg.BeaconQuorum.Endpoints == 0 {
+			cfg.BeaconQuorum.Endpoints = len(cfg.Endpoints)
+		}
+		if cfg.BeaconQuorum.Quorum == 0 {
+			cfg.BeaconQuorum.Quorum = cfg.BeaconQuorum.Endpoints
+		}
+		if cfg.BeaconQuorum.Endpoints < 2 || cfg.BeaconQuorum.Endpoints > len(cfg.Endpoints) {
+			return nil, fmt.Errorf("beaconQuorum endpoints must be in [2, %d]", len(cfg.Endpoints))
+		}
+		if cfg.BeaconQuorum.Quorum <= cfg.BeaconQuorum.Endpoints/2 || cfg.BeaconQuorum.Quorum > cfg.BeaconQuorum.Endpoints {
+			return nil, fmt.Errorf("beaconQuorum quorum must be a majority of its endpoints")
+		}
+	}
+	if *cfg.ConfirmationBlocks > 64 {
+		return nil, fmt.Errorf("confirmationBlocks can not be greater than 64, use syncEventsToFinalized instead")
+	}
+	if cfg.SubscribeEvents {
+		hasWs := false
+		for _, endpoint := range cfg.Endpoints {
+			hasWs = hasWs || strings.HasPrefix(endpoint.Eth1, "ws://") || strings.HasPrefix(endpoint.Eth1, "wss://")
+		}
+		if !hasWs {
+			return nil, fmt.Errorf("subscribeEvents needs a ws or wss eth1 endpoint")
+		}
+	}
 	if cfg.BatchRequestBlocksNumber > 32 {
 		return nil, fmt.Errorf("batchRequestBlocksNumber can not be greater than 32")
 	}
@@ -36,24 +36,37 @@ const (
 	RequestValidatorsPath            = "/eth/v1/beacon/states/%s/validators"
 	RequestVoluntaryExitPath         = "/eth/v1/beacon/pool/voluntary_exits"
 	RequestBeaconBlockPath           = "/eth/v2/beacon/blocks/%d"
+	RequestEventsPath                = "/eth/v1/events?topics=%s"
 
 	MaxRequestValidatorsCount = 50
+
+	// requests without a deadline of their own are abandoned after it
+	RequestTimeout = 120 * time.Second
 )
 
 // Beacon client using the standard Beacon HTTP REST API (https://ethereum.github.io/beacon-APIs/)
 type StandardHttpClient struct {
 	providerAddress string
+	httpClient      *http.Client
 	eth2Config      beacon.Eth2Config
 	signer          gtypes.Signer
 }
 
+var _ beacon.Client = &StandardHttpClient{}
+
 // Create a new client instance
 func NewStandardHttpClient(providerAddress string, chainID *big.Int) (*StandardHttpClient, error) {
+	return NewStandardHttpClientWithTransport(providerAddress, chainID, nil)
+}
+
+// Create a new client instance sending its requests through transport, http.DefaultTransport if nil
+func NewStandardHttpClientWithTransport(providerAddress string, chainID *big.Int, transport http.RoundTripper) (*StandardHttpClient, error) {
 
 	client := &StandardHttpClient{
 		providerAddress: providerAddress,
+		httpClient:      &http.Client{Transport: transport},
 	}
-	config, err := client.GetEth2Config()
+	config, err := client.GetEth2Config(context.Background())
 	if err != nil {
 		return nil, err
 	}
@@ -74,10 +87,10 @@ func (c *StandardHttpClient) GetClientType() (beacon.BeaconClientType, error) {
 }
 
 // Get the node's sync status
-func (c *StandardHttpClient) GetSyncStatus() (beacon.SyncStatus, error) {
+func (c *StandardHttpClient) GetSyncStatus(ctx context.Context) (beacon.SyncStatus, error) {
 
 	// Get sync status
-	syncStatus, err := c.getSyncStatus()
+	syncStatus, err := c.getSyncStatus(ctx)
 	if err != nil {
 		return beacon.SyncStatus{}, err
 	}
@@ -94,7 +107,7 @@ func (c *StandardHttpClient) GetSyncStatus() (beacon.SyncStatus, error) {
 }
 
 // Get the eth2 config
-func (c *StandardHttpClient) GetEth2Config() (beacon.Eth2Config, error) {
+func (c *StandardHttpClient) GetEth2Config(ctx context.Context) (beacon.Eth2Config, error) {
 
 	// Data
 	var wg errgroup.Group
@@ -104,14 +117,14 @@ func (c *StandardHttpClient) GetEth2Config() (beacon.Eth2Config, error) {
 	// Get eth2 config
 	wg.Go(func() error {
 		var err error
-		eth2Config, err = c.getEth2Config()
+		eth2Config, err = c.getEth2Config(ctx)
 		return err
 	})
 
 	// Get genesis
 	wg.Go(func() error {
 		var err error
-		genesis, err = c.getGenesis()
+		genesis, err = c.getGenesis(ctx)
 		return err
 	})
 
@@ -135,10 +148,10 @@ func (c *StandardHttpClient) GetEth2Config() (beacon.Eth2Config, error) {
 }
 
 // Get the eth2 deposit contract info
-func (c *StandardHttpClient) GetEth2DepositContract() (beacon.Eth2DepositContract, error) {
+func (c *StandardHttpClient) GetEth2DepositContract(ctx context.Context) (beacon.Eth2DepositContract, error) {
 
 	// Get the deposit contract
-	depositContract, err := c.getEth2DepositContract()
+	depositContract, err := c.getEth2DepositContract(ctx)
 	if err != nil {
 		return beacon.Eth2DepositContract{}, err
 	}
@@ -151,9 +164,9 @@ func (c *StandardHttpClient) GetEth2DepositContract() (beacon.Eth2DepositContrac
 }
 
 // Get the beacon head
-func (c *StandardHttpClient) GetBeaconHead() (beacon.BeaconHead, error) {
+func (c *StandardHttpClient) GetBeaconHead(ctx context.Context) (beacon.BeaconHead, error) {
 	var finalityCheckpoints FinalityCheckpointsResponse
-	finalityCheckpoints, err := c.getFinalityCheckpoints("head")
+	finalityCheckpoints, err := c.getFinalityCheckpoints(ctx, "head")
 
 	if err != nil {
 		return beacon.BeaconHead{}, err
@@ -273,8 +286,8 @@ func (c *StandardHttpClient) GetValidatorStatuses(ctx context.Context, pubkeys [
 }
 
 // Perform a voluntary exit on a validator
-func (c *StandardHttpClient) ExitValidator(validatorIndex, epoch uint64, signature types.ValidatorSignature) error {
-	return c.postVoluntaryExit(VoluntaryExitRequest{
+func (c *StandardHttpClient) ExitValidator(ctx context.Context, validatorIndex, epoch uint64, signature types.ValidatorSignature) error {
+	return c.postVoluntaryExit(ctx, VoluntaryExitRequest{
 		Message: VoluntaryExitMessage{
 			Epoch:          uinteger(epoch),
 			ValidatorIndex: uinteger(validatorIndex),
@@ -284,10 +297,10 @@ func (c *StandardHttpClient) ExitValidator(validatorIndex, epoch uint64, signatu
 }
 
 // Get the ETH1 data for the target beacon block
-func (c *StandardHttpClient) GetEth1DataForEth2Block(blockId uint64) (beacon.Eth1Data, bool, error) {
+func (c *StandardHttpClient) GetEth1DataForEth2Block(ctx context.Context, blockId uint64) (beacon.Eth1Data, bool, error) {
 
 	// Get the Beacon block
-	block, exists, err := c.getBeaconBlock(blockId)
+	block, exists, err := c.getBeaconBlock(ctx, blockId)
 	if err != nil {
 		return beacon.Eth1Data{}, false, err
 	}
@@ -304,8 +317,8 @@ func (c *StandardHttpClient) GetEth1DataForEth2Block(blockId uint64) (beacon.Eth
 
 }
 
-func (c *StandardHttpClient) GetBeaconBlock(blockId uint64) (beacon.BeaconBlock, bool, error) {
-	block, exists, err := c.getBeaconBlock(blockId)
+func (c *StandardHttpClient) GetBeaconBlock(ctx context.Context, blockId uint64) (beacon.BeaconBlock, bool, error) {
+	block, exists, err := c.getBeaconBlock(ctx, blockId)
 	if err != nil {
 		return beacon.BeaconBlock{}, false, err
 	}
@@ -432,8 +445,8 @@ func (c *StandardHttpClient) GetBeaconBlock(blockId uint64) (beacon.BeaconBlock,
 }
 
 // Get sync status
-func (c *StandardHttpClient) getSyncStatus() (SyncStatusResponse, error) {
-	responseBody, status, err := c.getRequest(RequestSyncStatusPath)
+func (c *StandardHttpClient) getSyncStatus(ctx context.Context) (SyncStatusResponse, error) {
+	responseBody, status, err := c.getRequest(ctx, RequestSyncStatusPath)
 	if err != nil {
 		return SyncStatusResponse{}, fmt.Errorf("could not get node sync status: %w", err)
 	}
@@ -448,8 +461,8 @@ func (c *StandardHttpClient) getSyncStatus() (SyncStatusResponse, error) {
 }
 
 // Get the eth2 config
-func (c *StandardHttpClient) getEth2Config() (Eth2ConfigResponse, error) {
-	responseBody, status, err := c.getRequest(RequestEth2ConfigPath)
+func (c *StandardHttpClient) getEth2Config(ctx context.Context) (Eth2ConfigResponse, error) {
+	responseBody, status, err := c.getRequest(ctx, RequestEth2ConfigPath)
 	if err != nil {
 		return Eth2ConfigResponse{}, fmt.Errorf("could not get eth2 config: %w", err)
 	}
@@ -464,8 +477,8 @@ func (c *StandardHttpClient) getEth2Config() (Eth2ConfigResponse, error) {
 }
 
 // Get the eth2 deposit contract info
-func (c *StandardHttpClient) getEth2DepositContract() (Eth2DepositContractResponse, error) {
-	responseBody, status, err := c.getRequest(RequestEth2DepositContractMethod)
+func (c *StandardHttpClient) getEth2DepositContract(ctx context.Context) (Eth2DepositContractResponse, error) {
+	responseBody, status, err := c.getRequest(ctx, RequestEth2DepositContractMethod)
 	if err != nil {
 		return Eth2DepositContractResponse{}, fmt.Errorf("could not get eth2 deposit contract: %w", err)
 	}
@@ -480,8 +493,8 @@ func (c *StandardHttpClient) getEth2DepositContract() (Eth2DepositContractRespon
 }
 
 // Get genesis information
-func (c *StandardHttpClient) getGenesis() (GenesisResponse, error) {
-	responseBody, status, err := c.getRequest(RequestGenesisPath)
+func (c *StandardHttpClient) getGenesis(ctx context.Context) (GenesisResponse, error) {
+	responseBody, status, err := c.getRequest(ctx, RequestGenesisPath)
 	if err != nil {
 		return GenesisResponse{}, fmt.Errorf("could not get genesis data: %w", err)
 	}
@@ -496,8 +509,8 @@ func (c *StandardHttpClient) getGenesis() (GenesisResponse, error) {
 }
 
 // Get finality checkpoints
-func (c *StandardHttpClient) getFinalityCheckpoints(stateId string) (FinalityCheckpointsResponse, error) {
-	responseBody, status, err := c.getRequest(fmt.Sprintf(RequestFinalityCheckpointsPath, stateId))
+func (c *StandardHttpClient) getFinalityCheckpoints(ctx context.Context, stateId string) (FinalityCheckpointsResponse, error) {
+	responseBody, status, err := c.getRequest(ctx, fmt.Sprintf(RequestFinalityCheckpointsPath, stateId))
 	if err != nil {
 		return FinalityCheckpointsResponse{}, fmt.Errorf("could not get finality checkpoints: %w", err)
 	}
@@ -517,7 +530,7 @@ func (c *StandardHttpClient) getValidators(ctx context.Context, stateId string,
 	if len(pubkeys) > 0 {
 		query = fmt.Sprintf("?id=%s", strings.Join(pubkeys, ","))
 	}
-	responseBody, status, err := c.getRequest(fmt.Sprintf(RequestValidatorsPath, stateId)+query, ctx)
+	responseBody, status, err := c.getRequest(ctx, fmt.Sprintf(RequestValidatorsPath, stateId)+query)
 	if err != nil {
 		return ValidatorsResponse{}, fmt.Errorf("could not get validators: %w", err)
 	}
@@ -579,8 +592,8 @@ func (c *StandardHttpClient) getValidatorsByOpts(ctx context.Context, pubkeysOrI
 }
 
 // Send voluntary exit request
-func (c *StandardHttpClient) postVoluntaryExit(request VoluntaryExitRequest) error {
-	responseBody, status, err := c.postRequest(RequestVoluntaryExitPath, request)
+func (c *StandardHttpClient) postVoluntaryExit(ctx context.Context, request VoluntaryExitRequest) error {
+	responseBody, status, err := c.postRequest(ctx, RequestVoluntaryExitPath, request)
 	if err != nil {
 		return fmt.Errorf("could not broadcast exit for validator at index %d: %w", request.Message.ValidatorIndex, err)
 	}
@@ -591,10 +604,10 @@ func (c *StandardHttpClient) postVoluntaryExit(request VoluntaryExitRequest) err
 }
 
 // Get the target beacon block
-func (c *StandardHttpClient) getBeaconBlock(blockId uint64) (BeaconBlockResponse, bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+func (c *StandardHttpClient) getBeaconBlock(ctx context.Context, blockId uint64) (BeaconBlockResponse, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
-	responseBody, status, err := c.getRequest(fmt.Sprintf(RequestBeaconBlockPath, blockId), ctx)
+	responseBody, status, err := c.getRequest(ctx, fmt.Sprintf(RequestBeaconBlockPath, blockId))
 	if err != nil {
 		return BeaconBlockResponse{}, false, fmt.Errorf("could not get beacon block data: %w", err)
 	}
@@ -612,17 +625,9 @@ func (c *StandardHttpClient) getBeaconBlock(blockId uint64) (BeaconBlockResponse
 }
 
 // Make a GET request to the beacon node
-func (c *StandardHttpClient) getRequest(requestPath string, optionalCtx ...context.Context) ([]byte, int, error) {
-	var ctx context.Context
-	if len(optionalCtx) == 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(context.Background(), 120*time.Second)
-		defer cancel()
-	} else if len(optionalCtx) == 1 {
-		ctx = optionalCtx[0]
-	} else {
-		return nil, 0, fmt.Errorf("you can pass only one context")
-	}
+func (c *StandardHttpClient) getRequest(ctx context.Context, requestPath string) ([]byte, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, RequestTimeout)
+	defer cancel()
 
 	url := fmt.Sprintf(RequestUrlFormat, c.providerAddress, requestPath)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
@@ -630,7 +635,7 @@ func (c *StandardHttpClient) getRequest(requestPath string, optionalCtx ...conte
 		return nil, 0, err
 	}
 
-	response, err := http.DefaultClient.Do(req)
+	response, err := c.httpClient.Do(req)
 	if err != nil {
 		return []byte{}, 0, err
 	}
@@ -647,7 +652,7 @@ func (c *StandardHttpClient) getRequest(requestPath string, optionalCtx ...conte
 }
 
 // Make a POST request to the beacon node
-func (c *StandardHttpClient) postRequest(requestPath string, requestBody interface{}) ([]byte, int, error) {
+func (c *StandardHttpClient) postRequest(ctx context.Context, requestPath string, requestBody interface{}) ([]byte, int, error) {
 
 	// Get request body
 	requestBodyBytes, err := json.Marshal(requestBody)
@@ -657,8 +662,14 @@ func (c *StandardHttpClient) postRequest(requestPath string, requestBody interfa
 	requestBodyReader := bytes.NewReader(requestBodyBytes)
 
 	// Send request
-	client := http.Client{Timeout: 60 * time.Second}
-	response, err := client.Post(fmt.Sprintf(RequestUrlFormat, c.providerAddress, requestPath), RequestContentType, requestBodyReader)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(RequestUrlFormat, c.providerAddress, requestPath), requestBodyReader)
+	if err != nil {
+		return []byte{}, 0, err
+	}
+	req.Header.Set("Content-Type", RequestContentType)
+	response, err := c.httpClient.Do(req)
 	if err != nil {
 		return []byte{}, 0, err
 	}
@@ -27,14 +27,14 @@ func TestStatus(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	status, err := c.GetSyncStatus()
+	status, err := c.GetSyncStatus(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	t.Log(status)
 
-	head, err := c.GetBeaconHead()
+	head, err := c.GetBeaconHead(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -119,12 +119,12 @@ func TestBeaconBlock(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	block, exists, err := c.GetBeaconBlock(263205)
+	block, exists, err := c.GetBeaconBlock(context.Background(), 263205)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	config, err := c.GetEth2Config()
+	config, err := c.GetEth2Config(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -138,7 +138,7 @@ func TestBeaconHead(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	head, err := c.GetBeaconHead()
+	head, err := c.GetBeaconHead(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -151,7 +151,7 @@ func TestConfig(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	head, err := c.GetEth2Config()
+	head, err := c.GetEth2Config(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -170,7 +170,7 @@ func TestConfig(t *testing.T) {
 	// }
 	// t.Log(sc)
 
-	block, exist, err := c.GetBeaconBlock(5362523)
+	block, exist, err := c.GetBeaconBlock(context.Background(), 5362523)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/beacon"
+)
+
+var ErrEventStreamClosed = errors.New("event stream closed by beacon node")
+
+// Stream events of topics to handle until ctx is done or the stream fails, events of other topics are ignored
+func (c *StandardHttpClient) SubscribeEvents(ctx context.Context, topics []string, handle func(beacon.Event)) error {
+	url := fmt.Sprintf(RequestUrlFormat, c.providerAddress, fmt.Sprintf(RequestEventsPath, strings.Join(topics, ",")))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// no timeout, the stream lasts until ctx is done
+	response, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("could not subscribe events: HTTP status %d; response body: '%s'", response.StatusCode, string(body))
+	}
+	return readEventStream(response.Body, handle)
+}
+
+// Parse server-sent events, the fields of an event are lines ended by an empty line
+func readEventStream(r io.Reader, handle func(beacon.Event)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var topic string
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(data) > 0 {
+				event, known, err := decodeEvent(topic, []byte(strings.Join(data, "\n")))
+				if err != nil {
+					return err
+				}
+				if known {
+					handle(event)
+				}
+			}
+			topic, data = "", nil
+			continue
+		}
+		// comments keep the connection alive
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			topic = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return ErrEventStreamClosed
+}
+
+func decodeEvent(topic string, data []byte) (beacon.Event, bool, error) {
+	event := beacon.Event{Topic: topic}
+	switch topic {
+	case beacon.EventTopicHead:
+		var head HeadEventData
+		if err := json.Unmarshal(data, &head); err != nil {
+			return event, false, fmt.Errorf("could not decode head event: %w", err)
+		}
+		event.Head = &beacon.HeadEvent{
+			Slot:            uint64(head.Slot),
+			Block:           head.Block,
+			EpochTransition: head.EpochTransition,
+		}
+	case beacon.EventTopicFinalizedCheckpoint:
+		var checkpoint FinalizedCheckpointEventData
+		if err := json.Unmarshal(data, &checkpoint); err != nil {
+			return event, false, fmt.Errorf("could not decode finalized checkpoint event: %w", err)
+		}
+		event.FinalizedCheckpoint = &beacon.FinalizedCheckpointEvent{
+			Epoch: uint64(checkpoint.Epoch),
+			Block: checkpoint.Block,
+			State: checkpoint.State,
+		}
+	case beacon.EventTopicChainReorg:
+		var reorg ChainReorgEventData
+		if err := json.Unmarshal(data, &reorg); err != nil {
+			return event, false, fmt.Errorf("could not decode chain reorg event: %w", err)
+		}
+		event.ChainReorg = &beacon.ChainReorgEvent{
+			Slot:         uint64(reorg.Slot),
+			Depth:        uint64(reorg.Depth),
+			OldHeadBlock: reorg.OldHeadBlock,
+			NewHeadBlock: reorg.NewHeadBlock,
+			Epoch:        uint64(reorg.Epoch),
+		}
+	default:
+		return event, false, nil
+	}
+	return event, true, nil
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/beacon"
+	"github.com/stretchr/testify/assert"
+)
+
+const eventStream = `: keep alive
+
+event: head
+data: {"slot":"10", "block":"0x9a2f", "state":"0x600e", "epoch_transition":true, "previous_duty_dependent_root":"0x5e0043f107cb57913498fbf2f99ff55e730bf1e151f02f221e977c91a90a0e91", "current_duty_dependent_root":"0x5e0043f107cb57913498fbf2f99ff55e730bf1e151f02f221e977c91a90a0e91", "execution_optimistic": false}
+
+event: block
+data: {"slot":"10", "block":"0x9a2f"}
+
+event: finalized_checkpoint
+data: {"block":"0x9a2f", "state":"0x600e", "epoch":"2", "execution_optimistic": false}
+
+event: chain_reorg
+data: {"slot":"200", "depth":"50", "old_head_block":"0x9a2f", "new_head_block":"0x76262", "old_head_state":"0x9a2f", "new_head_state":"0x600e", "epoch":"6", "execution_optimistic": false}
+
+`
+
+func TestSubscribeEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/eth/v1/events", r.URL.Path)
+		assert.Equal(t, "head,finalized_checkpoint,chain_reorg", r.URL.Query().Get("topics"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, eventStream)
+	}))
+	defer srv.Close()
+
+	c := &StandardHttpClient{providerAddress: srv.URL, httpClient: srv.Client()}
+	var events []beacon.Event
+	err := c.SubscribeEvents(context.Background(),
+		[]string{beacon.EventTopicHead, beacon.EventTopicFinalizedCheckpoint, beacon.EventTopicChainReorg},
+		func(e beacon.Event) { events = append(events, e) })
+	assert.ErrorIs(t, err, ErrEventStreamClosed)
+
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, &beacon.HeadEvent{Slot: 10, Block: "0x9a2f", EpochTransition: true}, events[0].Head)
+	assert.Equal(t, &beacon.FinalizedCheckpointEvent{Epoch: 2, Block: "0x9a2f", State: "0x600e"}, events[1].FinalizedCheckpoint)
+	assert.Equal(t, beacon.EventTopicChainReorg, events[2].Topic)
+	assert.Equal(t, uint64(50), events[2].ChainReorg.Depth)
+	assert.Equal(t, uint64(6), events[2].ChainReorg.Epoch)
+
+	// malformed events end the stream
+	err = readEventStream(strings.NewReader("event: head\ndata: {\"slot\":10}\n\n"), func(beacon.Event) {})
+	assert.NotNil(t, err)
+}
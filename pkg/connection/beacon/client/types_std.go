@@ -276,6 +276,25 @@ type Attestation struct {
 	} `json:"data"`
 }
 
+// Event stream types
+type HeadEventData struct {
+	Slot            uinteger `json:"slot"`
+	Block           string   `json:"block"`
+	EpochTransition bool     `json:"epoch_transition"`
+}
+type FinalizedCheckpointEventData struct {
+	Epoch uinteger `json:"epoch"`
+	Block string   `json:"block"`
+	State string   `json:"state"`
+}
+type ChainReorgEventData struct {
+	Slot         uinteger `json:"slot"`
+	Depth        uinteger `json:"depth"`
+	OldHeadBlock string   `json:"old_head_block"`
+	NewHeadBlock string   `json:"new_head_block"`
+	Epoch        uinteger `json:"epoch"`
+}
+
 // Unsigned integer type
 type uinteger uint64
 
@@ -1,12 +1,30 @@
 package beacon
 
 import (
+	"context"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/prysmaticlabs/go-bitfield"
 	ethpb "github.com/prysmaticlabs/prysm/v4/proto/eth/v1"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/types"
 )
 
+// Client is a beacon node client, requests are abandoned once ctx is done
+type Client interface {
+	Close() error
+	GetClientType() (BeaconClientType, error)
+	GetSyncStatus(ctx context.Context) (SyncStatus, error)
+	GetEth2Config(ctx context.Context) (Eth2Config, error)
+	GetEth2DepositContract(ctx context.Context) (Eth2DepositContract, error)
+	GetBeaconHead(ctx context.Context) (BeaconHead, error)
+	GetValidatorStatus(ctx context.Context, pubkey types.ValidatorPubkey, opts *ValidatorStatusOptions) (ValidatorStatus, error)
+	GetValidatorStatuses(ctx context.Context, pubkeys []types.ValidatorPubkey, opts *ValidatorStatusOptions) (map[types.ValidatorPubkey]ValidatorStatus, error)
+	ExitValidator(ctx context.Context, validatorIndex, epoch uint64, signature types.ValidatorSignature) error
+	GetEth1DataForEth2Block(ctx context.Context, blockId uint64) (Eth1Data, bool, error)
+	GetBeaconBlock(ctx context.Context, blockId uint64) (BeaconBlock, bool, error)
+	SubscribeEvents(ctx context.Context, topics []string, handle func(Event)) error
+}
+
 // API request options
 type ValidatorStatusOptions struct {
 	Epoch *uint64
@@ -139,6 +157,41 @@ type AttestationInfo struct {
 	CommitteeIndex  uint64
 }
 
+// Topics of the beacon node event stream
+const (
+	EventTopicHead                = "head"
+	EventTopicFinalizedCheckpoint = "finalized_checkpoint"
+	EventTopicChainReorg          = "chain_reorg"
+)
+
+// Event pushed by the beacon node event stream, the field of its topic is set.
+type Event struct {
+	Topic               string
+	Head                *HeadEvent
+	FinalizedCheckpoint *FinalizedCheckpointEvent
+	ChainReorg          *ChainReorgEvent
+}
+
+type HeadEvent struct {
+	Slot            uint64
+	Block           string
+	EpochTransition bool
+}
+
+type FinalizedCheckpointEvent struct {
+	Epoch uint64
+	Block string
+	State string
+}
+
+type ChainReorgEvent struct {
+	Slot         uint64
+	Depth        uint64
+	OldHeadBlock string
+	NewHeadBlock string
+	Epoch        uint64
+}
+
 // Beacon client type
 type BeaconClientType int
 
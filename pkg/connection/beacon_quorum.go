@@ -0,0 +1,227 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/beacon"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/types"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+)
+
+// validators shown in the details of a disagreement on validator statuses
+const maxDisagreedValidatorsShown = 5
+
+// beaconQuorum cross-checks the answers of eth2 endpoints that votes are computed from, see SetBeaconQuorum.
+type beaconQuorum struct {
+	endpoints int
+	quorum    int
+}
+
+// BeaconDisagreementError is returned when less than quorum of the cross-checked eth2 endpoints give the same answer.
+type BeaconDisagreementError struct {
+	Method  string
+	Quorum  int
+	Answers []EndpointAnswer
+}
+
+// EndpointAnswer is what an eth2 endpoint answered, or its error.
+type EndpointAnswer struct {
+	Endpoint string
+	Answer   string
+}
+
+func (e *BeaconDisagreementError) Error() string {
+	answers := make([]string, len(e.Answers))
+	for i, a := range e.Answers {
+		answers[i] = fmt.Sprintf("%s => %s", a.Endpoint, a.Answer)
+	}
+	return fmt.Sprintf("eth2 endpoints disagree on %s, %d of them must agree: %s", e.Method, e.Quorum, strings.Join(answers, " | "))
+}
+
+// SetBeaconQuorum makes validator statuses and beacon blocks be queried from the first endpoints healthy eth2
+// endpoints at once, an answer is used only if quorum of them give it. Quorum must be a majority of endpoints, with
+// quorum equal to endpoints any disagreement fails the query. It must be called before querying.
+func (c *Connection) SetBeaconQuorum(endpoints, quorum int) error {
+	if endpoints < 2 || endpoints > len(c.eth2Clients) {
+		return fmt.Errorf("beacon quorum endpoints must be in [2, %d]", len(c.eth2Clients))
+	}
+	if quorum <= endpoints/2 || quorum > endpoints {
+		return fmt.Errorf("beacon quorum must be a majority of %d endpoints", endpoints)
+	}
+	c.beaconQuorum = &beaconQuorum{endpoints: endpoints, quorum: quorum}
+	return nil
+}
+
+// quorumAnswer runs query on the cross-checked endpoints at once and returns the answer of quorum of them. Answers
+// are compared by digest, describe shows the successful answers in the details of a disagreement.
+func quorumAnswer[T any](ctx context.Context, c *Connection, method string, query func(*eth2Client) (T, error),
+	digest func(T) string, describe func([]T) []string) (T, error) {
+	var zero T
+	clients, err := c.getHealthyEth2Clients()
+	if err != nil {
+		return zero, err
+	}
+	q := c.beaconQuorum
+	if len(clients) < q.quorum {
+		return zero, fmt.Errorf("%d eth2 endpoints healthy, beacon quorum needs %d", len(clients), q.quorum)
+	}
+	clients = clients[:min(len(clients), q.endpoints)]
+
+	values := make([]T, len(clients))
+	errs := make([]error, len(clients))
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *eth2Client) {
+			defer wg.Done()
+			if errs[i] = client.score.acquire(ctx); errs[i] != nil {
+				return
+			}
+			start := time.Now()
+			values[i], errs[i] = query(client)
+			client.observe(c.routing, method, start, errs[i])
+		}(i, client)
+	}
+	wg.Wait()
+
+	votes := make(map[string]int)
+	var answered []T
+	for i, value := range values {
+		if errs[i] != nil {
+			continue
+		}
+		answered = append(answered, value)
+		d := digest(value)
+		votes[d]++
+		if votes[d] >= q.quorum {
+			return value, nil
+		}
+	}
+
+	if len(votes) <= 1 {
+		// no disagreement, too few endpoints answered
+		for _, err := range errs {
+			if err != nil {
+				return zero, fmt.Errorf("%d of %d eth2 endpoints answered %s, beacon quorum needs %d: %w",
+					len(answered), len(clients), method, q.quorum, err)
+			}
+		}
+	}
+
+	descriptions := describe(answered)
+	disagreement := &BeaconDisagreementError{Method: method, Quorum: q.quorum}
+	for i, client := range clients {
+		answer := EndpointAnswer{Endpoint: fmt.Sprintf("#%d %s", i, client.label)}
+		if errs[i] != nil {
+			answer.Answer = "err: " + errs[i].Error()
+		} else {
+			answer.Answer, descriptions = descriptions[0], descriptions[1:]
+		}
+		disagreement.Answers = append(disagreement.Answers, answer)
+	}
+	metrics.BeaconDisagreements.WithLabelValues(method).Inc()
+	logrus.WithField("method", method).Error(disagreement.Error())
+	return zero, disagreement
+}
+
+func digestOf(format string, args ...interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf(format, args...)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func describeValidatorStatus(status beacon.ValidatorStatus) string {
+	if !status.Exists {
+		return "not exist"
+	}
+	return fmt.Sprintf("index=%d balance=%d effective=%d status=%s slashed=%t activation=%d exit=%d withdrawable=%d",
+		status.Index, status.Balance, status.EffectiveBalance, status.Status, status.Slashed,
+		status.ActivationEpoch, status.ExitEpoch, status.WithdrawableEpoch)
+}
+
+func digestValidatorStatuses(statuses map[types.ValidatorPubkey]beacon.ValidatorStatus) string {
+	pubkeys := sortedPubkeys(statuses)
+	var b strings.Builder
+	for _, pubkey := range pubkeys {
+		b.WriteString(pubkey.Hex())
+		b.WriteString(describeValidatorStatus(statuses[pubkey]))
+	}
+	return digestOf("%s", b.String())
+}
+
+func sortedPubkeys[V any](m map[types.ValidatorPubkey]V) []types.ValidatorPubkey {
+	pubkeys := make([]types.ValidatorPubkey, 0, len(m))
+	for pubkey := range m {
+		pubkeys = append(pubkeys, pubkey)
+	}
+	sort.Slice(pubkeys, func(i, j int) bool { return bytes.Compare(pubkeys[i][:], pubkeys[j][:]) < 0 })
+	return pubkeys
+}
+
+// describeValidatorStatuses shows the validators whose statuses differ among the answers.
+func describeValidatorStatuses(answers []map[types.ValidatorPubkey]beacon.ValidatorStatus) []string {
+	pubkeys := make(map[types.ValidatorPubkey]bool)
+	for _, statuses := range answers {
+		for pubkey := range statuses {
+			pubkeys[pubkey] = true
+		}
+	}
+	var disagreed []types.ValidatorPubkey
+	for _, pubkey := range sortedPubkeys(pubkeys) {
+		first, firstExist := answers[0][pubkey]
+		for _, statuses := range answers[1:] {
+			status, exist := statuses[pubkey]
+			if exist != firstExist || status != first {
+				disagreed = append(disagreed, pubkey)
+				break
+			}
+		}
+	}
+
+	descriptions := make([]string, len(answers))
+	for i, statuses := range answers {
+		parts := []string{fmt.Sprintf("%d validators, %d disagreed", len(statuses), len(disagreed))}
+		for _, pubkey := range disagreed[:min(len(disagreed), maxDisagreedValidatorsShown)] {
+			status, exist := statuses[pubkey]
+			description := "missing"
+			if exist {
+				description = describeValidatorStatus(status)
+			}
+			parts = append(parts, fmt.Sprintf("%s: %s", pubkey.Hex(), description))
+		}
+		descriptions[i] = strings.Join(parts, ", ")
+	}
+	return descriptions
+}
+
+// beaconBlockAnswer is an answer of GetBeaconBlock
+type beaconBlockAnswer struct {
+	block beacon.BeaconBlock
+	exist bool
+}
+
+func digestBeaconBlock(a beaconBlockAnswer) string {
+	return digestOf("%t %+v", a.exist, a.block)
+}
+
+func describeBeaconBlocks(answers []beaconBlockAnswer) []string {
+	descriptions := make([]string, len(answers))
+	for i, a := range answers {
+		if !a.exist {
+			descriptions[i] = "not exist"
+			continue
+		}
+		descriptions[i] = fmt.Sprintf("slot=%d proposer=%d executionBlock=%d withdrawals=%s exits=%d digest=%s",
+			a.block.Slot, a.block.ProposerIndex, a.block.ExecutionBlockNumber,
+			digestOf("%+v", a.block.Withdrawals), len(a.block.VoluntaryExits), digestBeaconBlock(a))
+	}
+	return descriptions
+}
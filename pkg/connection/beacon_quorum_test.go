@@ -0,0 +1,64 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/beacon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBeaconQuorum(t *testing.T) {
+	c := &Connection{eth2Clients: []*eth2Client{
+		{endpoint: "a", label: "a"}, {endpoint: "b", label: "b"}, {endpoint: "c", label: "c"},
+	}}
+	assert.NotNil(t, c.SetBeaconQuorum(1, 1))
+	assert.NotNil(t, c.SetBeaconQuorum(4, 3))
+	assert.NotNil(t, c.SetBeaconQuorum(3, 1))
+	assert.Nil(t, c.SetBeaconQuorum(3, 2))
+
+	answers := map[string]beaconBlockAnswer{}
+	errs := map[string]error{}
+	getBlock := func() (beacon.BeaconBlock, error) {
+		answer, err := quorumAnswer(context.Background(), c, "GetBeaconBlock", func(client *eth2Client) (beaconBlockAnswer, error) {
+			return answers[client.endpoint], errs[client.endpoint]
+		}, digestBeaconBlock, describeBeaconBlocks)
+		return answer.block, err
+	}
+	block := beacon.BeaconBlock{Slot: 10, ExecutionBlockNumber: 100,
+		Withdrawals: []beacon.Withdrawal{{ValidatorIndex: 1, Amount: 5}}}
+	other := block
+	other.Withdrawals = []beacon.Withdrawal{{ValidatorIndex: 1, Amount: 6}}
+
+	answers["a"] = beaconBlockAnswer{block: block, exist: true}
+	answers["b"] = beaconBlockAnswer{block: other, exist: true}
+	answers["c"] = beaconBlockAnswer{block: block, exist: true}
+	got, err := getBlock()
+	assert.Nil(t, err)
+	assert.Equal(t, block, got)
+
+	// a failed endpoint leaves no quorum, the others disagreeing is reported
+	errs["c"] = errors.New("timeout")
+	_, err = getBlock()
+	var disagreement *BeaconDisagreementError
+	assert.True(t, errors.As(err, &disagreement))
+	assert.Equal(t, "GetBeaconBlock", disagreement.Method)
+	assert.Len(t, disagreement.Answers, 3)
+	assert.Contains(t, disagreement.Answers[2].Answer, "timeout")
+
+	// too few answers without disagreement is a plain error
+	answers["b"] = answers["a"]
+	errs["b"] = errors.New("refused")
+	_, err = getBlock()
+	assert.NotNil(t, err)
+	assert.False(t, errors.As(err, &disagreement))
+
+	// any disagreement fails with quorum of all endpoints
+	assert.Nil(t, c.SetBeaconQuorum(2, 2))
+	delete(errs, "b")
+	answers["b"] = beaconBlockAnswer{block: other, exist: true}
+	_, err = getBlock()
+	assert.True(t, errors.As(err, &disagreement))
+	assert.Len(t, disagreement.Answers, 2)
+}
@@ -0,0 +1,67 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// BlockTag names a block relative to the head of the chain, as the block parameter of json-rpc calls.
+type BlockTag string
+
+const (
+	BlockTagLatest    BlockTag = "latest"
+	BlockTagSafe      BlockTag = "safe"
+	BlockTagFinalized BlockTag = "finalized"
+)
+
+func ParseBlockTag(tag string) (BlockTag, error) {
+	switch BlockTag(tag) {
+	case BlockTagLatest, BlockTagSafe, BlockTagFinalized:
+		return BlockTag(tag), nil
+	default:
+		return "", fmt.Errorf("unknown block tag %s", tag)
+	}
+}
+
+// BlockNumber returns the block number argument of tag, nil for latest as go-ethereum expects.
+func (t BlockTag) BlockNumber() *big.Int {
+	switch t {
+	case BlockTagSafe:
+		return big.NewInt(int64(rpc.SafeBlockNumber))
+	case BlockTagFinalized:
+		return big.NewInt(int64(rpc.FinalizedBlockNumber))
+	default:
+		return nil
+	}
+}
+
+// CallOptsAtTag returns call opts reading the block of tag when each call is made, so calls made
+// one after another may read different blocks, use PinnedCallOpts to read the same block.
+func (c *Connection) CallOptsAtTag(tag BlockTag) *bind.CallOpts {
+	return c.CallOpts(tag.BlockNumber())
+}
+
+// BlockNumberOfTag returns the number of the block of tag.
+func (c *Connection) BlockNumberOfTag(ctx context.Context, tag BlockTag) (uint64, error) {
+	header, err := c.eth1Client.HeaderByNumber(ctx, tag.BlockNumber())
+	if err != nil {
+		return 0, fmt.Errorf("get %s block err: %w", tag, err)
+	}
+	return header.Number.Uint64(), nil
+}
+
+// PinnedCallOpts resolves tag to a block number now and returns call opts reading that block, so reads
+// made with them see the same state even if the block of tag moves meanwhile.
+func (c *Connection) PinnedCallOpts(ctx context.Context, tag BlockTag) (*bind.CallOpts, error) {
+	number, err := c.BlockNumberOfTag(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	opts := c.CallOptsOn(number)
+	opts.Context = ctx
+	return opts, nil
+}
@@ -0,0 +1,73 @@
+package connection
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+type taggedBackend struct {
+	ContractBackend
+	latest, safe, finalized uint64
+}
+
+func (b *taggedBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	n := b.latest
+	if number != nil {
+		switch rpc.BlockNumber(number.Int64()) {
+		case rpc.SafeBlockNumber:
+			n = b.safe
+		case rpc.FinalizedBlockNumber:
+			n = b.finalized
+		default:
+			n = number.Uint64()
+		}
+	}
+	return &types.Header{Number: new(big.Int).SetUint64(n)}, nil
+}
+
+func TestBlockTag(t *testing.T) {
+	for _, tag := range []string{"latest", "safe", "finalized"} {
+		parsed, err := ParseBlockTag(tag)
+		assert.Nil(t, err)
+		assert.Equal(t, BlockTag(tag), parsed)
+	}
+	_, err := ParseBlockTag("pending")
+	assert.NotNil(t, err)
+
+	backend := &taggedBackend{latest: 100, safe: 90, finalized: 64}
+	c := &Connection{eth1Client: backend}
+	assert.Nil(t, c.CallOptsAtTag(BlockTagLatest).BlockNumber)
+	assert.Equal(t, "finalized", toBlockNumArg(c.CallOptsAtTag(BlockTagFinalized).BlockNumber))
+	assert.Equal(t, "safe", toBlockNumArg(c.CallOptsAtTag(BlockTagSafe).BlockNumber))
+
+	ctx := context.Background()
+	opts, err := c.PinnedCallOpts(ctx, BlockTagFinalized)
+	assert.Nil(t, err)
+	// the finalized block moves but pinned opts keep reading the resolved one
+	backend.finalized = 96
+	assert.Equal(t, uint64(64), opts.BlockNumber.Uint64())
+	assert.Equal(t, ctx, opts.Context)
+
+	opts, err = c.PinnedCallOpts(ctx, BlockTagSafe)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(90), opts.BlockNumber.Uint64())
+	opts, err = c.PinnedCallOpts(ctx, BlockTagLatest)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), opts.BlockNumber.Uint64())
+}
+
+// toBlockNumArg is how go-ethereum's ethclient encodes the block argument of eth_call.
+func toBlockNumArg(number *big.Int) string {
+	if number == nil {
+		return "latest"
+	}
+	if number.Sign() >= 0 {
+		return number.String()
+	}
+	return rpc.BlockNumber(number.Int64()).String()
+}
@@ -5,34 +5,52 @@ import (
 	"fmt"
 	"math/big"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/avast/retry-go/v4"
 	"github.com/sirupsen/logrus"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/beacon"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/types"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
 )
 
 type CachedConnection struct {
 	*Connection
-	stop chan struct{}
+	stop   chan struct{}
+	ctx    context.Context // cancelled by Stop, requests of internal jobs are abandoned
+	cancel context.CancelFunc
 
 	// cache data
+	beaconHeadLock           sync.RWMutex
 	beaconHead               beacon.BeaconHead
 	beaconHeadErr            error
 	eth1LatestBlockNumber    uint64
 	eth1LatestBlockNumberErr error
 
-	chainId              *big.Int
-	eth2Config           *beacon.Eth2Config
-	validatorStatusCache sync.Map
+	chainId                  *big.Int
+	eth2Config               *beacon.Eth2Config
+	validatorStatusCache     sync.Map
+	validatorStatusCacheSize atomic.Int64
+
+	beaconEventsSubscribed atomic.Bool // an event was received from the current stream
+	listenersLock          sync.Mutex
+	beaconEventListeners   []func(beacon.Event)
 }
 
+// beacon head is polled at this pace while it is pushed by the event stream, in case events are missed
+const subscribedBeaconHeadPollInterval = 6 * time.Minute
+
+var beaconEventTopics = []string{beacon.EventTopicHead, beacon.EventTopicFinalizedCheckpoint, beacon.EventTopicChainReorg}
+
 func NewCachedConnection(conn *Connection) (*CachedConnection, error) {
+	ctx, cancel := context.WithCancel(context.Background())
 	cc := CachedConnection{
 		Connection:           conn,
 		stop:                 make(chan struct{}),
+		ctx:                  ctx,
+		cancel:               cancel,
 		validatorStatusCache: sync.Map{},
 	}
 	return &cc, nil
@@ -46,7 +64,7 @@ func (c *CachedConnection) Start() error {
 
 	if err = utils.ExecuteFns(
 		c.syncBeaconHead,
-		c.syncEth1LatestBlockNumber,
+		c.SyncEth1LatestBlock,
 	); err != nil {
 		return err
 	}
@@ -59,20 +77,38 @@ func (c *CachedConnection) Start() error {
 
 func (c *CachedConnection) Stop() {
 	close(c.stop)
+	c.cancel()
+	c.Connection.Close()
 }
 
 func (c *CachedConnection) BeaconHead() (beacon.BeaconHead, error) {
+	c.beaconHeadLock.RLock()
+	defer c.beaconHeadLock.RUnlock()
 	return c.beaconHead, c.beaconHeadErr
 }
 
+// StartBeaconEvents subscribes to the event stream of the beacon nodes. The beacon head is updated on each new
+// head, finalized checkpoint and chain reorg, and listeners are notified of the events.
+func (c *CachedConnection) StartBeaconEvents() {
+	utils.SafeGoWithRestart(c.beaconEventsService)
+}
+
+// OnBeaconEvent registers fn to be called with each event of the beacon event stream, after the beacon head
+// is updated. fn must not block the stream.
+func (c *CachedConnection) OnBeaconEvent(fn func(beacon.Event)) {
+	c.listenersLock.Lock()
+	defer c.listenersLock.Unlock()
+	c.beaconEventListeners = append(c.beaconEventListeners, fn)
+}
+
 func (c *CachedConnection) ChainID() (*big.Int, error) {
 	return c.chainId, nil
 }
 
 func (c *CachedConnection) Eth2Config() (beacon.Eth2Config, error) {
 	if c.eth2Config == nil {
-		cfg, err := retry.DoWithData(c.GetEth2Config,
-			retry.Delay(time.Second*2), retry.Attempts(150))
+		cfg, err := retry.DoWithData(func() (beacon.Eth2Config, error) { return c.GetEth2Config(c.ctx) },
+			retry.Delay(time.Second*2), retry.Attempts(150), retry.Context(c.ctx))
 		if err != nil {
 			return beacon.Eth2Config{}, err
 		}
@@ -93,14 +129,57 @@ func (c *CachedConnection) syncBeaconHeadService() {
 				logrus.Errorf("connection cache: fail to sync beacon head: %s", utils.ErrToLogStr(err))
 			}
 		}
-		time.Sleep(12 * time.Second)
+		if c.beaconEventsSubscribed.Load() {
+			utils.Sleep(c.stop, subscribedBeaconHeadPollInterval)
+		} else {
+			utils.Sleep(c.stop, 12*time.Second)
+		}
 	}
 }
 
 func (c *CachedConnection) syncBeaconHead() error {
-	c.beaconHead, c.beaconHeadErr = retry.DoWithData(c.GetBeaconHead,
-		retry.Delay(time.Second*2), retry.Attempts(5))
-	return c.beaconHeadErr
+	head, err := retry.DoWithData(func() (beacon.BeaconHead, error) { return c.GetBeaconHead(c.ctx) },
+		retry.Delay(time.Second*2), retry.Attempts(5), retry.Context(c.ctx))
+	c.beaconHeadLock.Lock()
+	defer c.beaconHeadLock.Unlock()
+	c.beaconHead, c.beaconHeadErr = head, err
+	return err
+}
+
+func (c *CachedConnection) beaconEventsService() {
+	delay := time.Second * 5
+	for {
+		err := c.SubscribeBeaconEvents(c.ctx, beaconEventTopics, c.onBeaconEvent)
+		if c.beaconEventsSubscribed.Swap(false) {
+			delay = time.Second * 5
+		}
+		select {
+		case <-c.stop:
+			return
+		default:
+		}
+		logrus.Warnf("connection cache: beacon event stream dropped, polling beacon head meanwhile: %s, retry in %s",
+			utils.ErrToLogStr(err), delay)
+		utils.Sleep(c.stop, delay)
+		delay = min(2*delay, subscribedBeaconHeadPollInterval)
+	}
+}
+
+func (c *CachedConnection) onBeaconEvent(event beacon.Event) {
+	if !c.beaconEventsSubscribed.Swap(true) {
+		logrus.Info("connection cache: beacon head is pushed by the beacon event stream")
+	}
+	// polling slows down while subscribed, so every event refreshes the head to keep its slot current
+	if err := c.syncBeaconHead(); err != nil {
+		logrus.Errorf("connection cache: fail to sync beacon head on %s event: %s", event.Topic, utils.ErrToLogStr(err))
+	}
+
+	c.listenersLock.Lock()
+	listeners := append([]func(beacon.Event){}, c.beaconEventListeners...)
+	c.listenersLock.Unlock()
+	for _, fn := range listeners {
+		fn(event)
+	}
 }
 
 func (c *CachedConnection) Eth1LatestBlock() (uint64, error) {
@@ -113,24 +192,24 @@ func (c *CachedConnection) syncEth1LatestBlockService() {
 		case <-c.stop:
 			return
 		default:
-			if err := c.syncEth1LatestBlockNumber(); err != nil {
+			if err := c.SyncEth1LatestBlock(); err != nil {
 				logrus.Errorf("connection cache: fail to sync eth1 latest block number: %s", utils.ErrToLogStr(err))
 			}
 		}
-		time.Sleep(12 * time.Second)
+		utils.Sleep(c.stop, 12*time.Second)
 	}
 }
 
-// LatestBlock returns the latest block from the current chain
-func (c *CachedConnection) syncEth1LatestBlockNumber() error {
+// SyncEth1LatestBlock refreshes the cached latest block now rather than at the next poll.
+func (c *CachedConnection) SyncEth1LatestBlock() error {
 	c.eth1LatestBlockNumber, c.eth1LatestBlockNumberErr = retry.DoWithData(c.Connection.Eth1LatestBlock,
-		retry.Delay(time.Second*2), retry.Attempts(5))
+		retry.Delay(time.Second*2), retry.Attempts(5), retry.Context(c.ctx))
 	return c.eth1LatestBlockNumberErr
 }
 
 func (c *CachedConnection) cacheChainID() (err error) {
-	c.chainId, err = retry.DoWithData(func() (*big.Int, error) { return c.eth1Client.ChainID(context.Background()) },
-		retry.Delay(time.Second*2), retry.Attempts(150))
+	c.chainId, err = retry.DoWithData(func() (*big.Int, error) { return c.eth1Client.ChainID(c.ctx) },
+		retry.Delay(time.Second*2), retry.Attempts(150), retry.Context(c.ctx))
 	return
 }
 
@@ -147,6 +226,7 @@ func (c *CachedConnection) GetValidatorStatus(ctx context.Context, pubkey types.
 	if cacheKey != "" {
 		cacheKey += "_" + pubkey.Hex()
 		status, ok := c.validatorStatusCache.Load(cacheKey)
+		metrics.ObserveCache(metrics.CacheValidatorStatus, ok)
 		if ok {
 			return status.(beacon.ValidatorStatus), nil
 		}
@@ -158,7 +238,9 @@ func (c *CachedConnection) GetValidatorStatus(ctx context.Context, pubkey types.
 	}
 
 	if cacheKey != "" {
-		c.validatorStatusCache.Store(cacheKey, status)
+		if _, loaded := c.validatorStatusCache.LoadOrStore(cacheKey, status); !loaded {
+			metrics.CacheSize.WithLabelValues(metrics.CacheValidatorStatus).Set(float64(c.validatorStatusCacheSize.Add(1)))
+		}
 	}
 
 	return status, nil
@@ -0,0 +1,43 @@
+package connection
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/beacon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type headBeacon struct {
+	beacon.Client
+	head beacon.BeaconHead
+}
+
+func (b *headBeacon) GetBeaconHead(ctx context.Context) (beacon.BeaconHead, error) {
+	return b.head, nil
+}
+
+func TestBeaconHeadOnEvents(t *testing.T) {
+	client := &headBeacon{head: beacon.BeaconHead{Epoch: 1, Slot: 32}}
+	c, err := NewCachedConnection(&Connection{eth2Clients: []*eth2Client{{Client: client, endpoint: "a", label: "a"}}})
+	require.NoError(t, err)
+	var events []beacon.Event
+	c.OnBeaconEvent(func(event beacon.Event) { events = append(events, event) })
+
+	// every head of the epoch updates the slot, not only the first one
+	for slot := uint64(33); slot < 36; slot++ {
+		client.head.Slot = slot
+		c.onBeaconEvent(beacon.Event{Topic: beacon.EventTopicHead, Head: &beacon.HeadEvent{Slot: slot}})
+		head, err := c.BeaconHead()
+		require.NoError(t, err)
+		assert.Equal(t, slot, head.Slot)
+	}
+
+	client.head.FinalizedEpoch = 1
+	c.onBeaconEvent(beacon.Event{Topic: beacon.EventTopicFinalizedCheckpoint})
+	head, err := c.BeaconHead()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), head.FinalizedEpoch)
+	assert.Len(t, events, 4)
+}
@@ -7,22 +7,26 @@ import (
 	"context"
 	"fmt"
 	"math/big"
+	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/avast/retry-go/v4"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/forta-network/go-multicall"
 	"github.com/samber/lo"
-	"github.com/stafiprotocol/chainbridge/utils/crypto/secp256k1"
+	"github.com/sirupsen/logrus"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/config"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/beacon"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/beacon/client"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/types"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/gomicrobee"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/signer"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
 )
 
@@ -43,37 +47,65 @@ var Gwei10 = big.NewInt(10e9)
 var Gwei20 = big.NewInt(20e9)
 
 type eth2Client struct {
-	*client.StandardHttpClient
+	beacon.Client
 	endpoint string
+	label    string // endpoint without path and query, which may contain api keys
 
-	config           beacon.Eth2Config
-	latestBeaconHead *beacon.BeaconHead
-	outOfSync        bool
-	healthCheckError error
-	lastCheckedAt    time.Time
+	config beacon.Eth2Config
+	health atomic.Pointer[endpointHealth] // result of the latest health check, nil before the first one
+
+	score *endpointScore
+}
+
+func (c *eth2Client) routingScore() *endpointScore {
+	return c.score
+}
+
+// observe records a request of method started at start in the metrics and the score of the endpoint.
+func (c *eth2Client) observe(routing *endpointRouting, method string, start time.Time, err error) {
+	metrics.ObserveRpc("eth2", c.label, method, start, err)
+	c.score.record(routing, start, err)
 }
 
 type Connection struct {
 	endpoints          []config.Endpoint
-	kp                 *secp256k1.Keypair
+	signer             signer.Signer
 	gasLimit           *big.Int
 	maxGasPrice        *big.Int
 	gasPriceMultiplier *big.Float
+	feePolicies        map[string]feePolicy // proposal type -> fee policy
+	beaconQuorum       *beaconQuorum        // nil if answers of eth2 endpoints are not cross-checked
+	routing            *endpointRouting     // of eth2 endpoints, nil for the default
+	transport          Transport            // nil if requests go to the endpoints directly
 
 	eth1Client  ContractBackend
 	eth2Clients []*eth2Client
 
 	txOpts      *bind.TransactOpts
+	txManager   *TxManager
 	callOpts    bind.CallOpts
 	optsLock    sync.Mutex
 	multiCaller *multicall.Caller
 
 	latestMultiCallMicrobeeSystem gomicrobee.System[*multicall.Call, *MultiCall]
+
+	stop      chan struct{} // closed by Close, stops health checks of endpoints
+	closeOnce sync.Once
 }
 
+// Transport returns the transport of requests to an endpoint, named eth1/<index> or eth2/<index> of endpoints.
+type Transport func(name, endpoint string) http.RoundTripper
+
 // NewConnection returns an uninitialized connection, must call Connection.Connect() before using.
-func NewConnection(endpoints []config.Endpoint, kp *secp256k1.Keypair, gasLimit, maxGasPrice *big.Int, gasPriceMultiplier *big.Float) (*Connection, error) {
-	if kp != nil {
+// A nil signer makes a read-only connection.
+func NewConnection(endpoints []config.Endpoint, signer signer.Signer, gasLimit, maxGasPrice *big.Int, gasPriceMultiplier *big.Float) (*Connection, error) {
+	return NewConnectionWithTransport(endpoints, signer, gasLimit, maxGasPrice, gasPriceMultiplier, nil)
+}
+
+// NewConnectionWithTransport is NewConnection sending eth1 and eth2 requests through transport, such as one
+// recording them into fixtures or replaying them from fixtures.
+func NewConnectionWithTransport(endpoints []config.Endpoint, signer signer.Signer, gasLimit, maxGasPrice *big.Int, gasPriceMultiplier *big.Float, transport Transport) (*Connection, error) {
+	if signer != nil {
 		if maxGasPrice.Cmp(big.NewInt(0)) <= 0 {
 			return nil, fmt.Errorf("max gas price empty")
 		}
@@ -83,10 +115,12 @@ func NewConnection(endpoints []config.Endpoint, kp *secp256k1.Keypair, gasLimit,
 	}
 	c := &Connection{
 		endpoints:          endpoints,
-		kp:                 kp,
+		signer:             signer,
 		gasLimit:           gasLimit,
 		maxGasPrice:        maxGasPrice,
 		gasPriceMultiplier: gasPriceMultiplier,
+		transport:          transport,
+		stop:               make(chan struct{}),
 	}
 
 	err := retry.Do(c.connect, retry.Delay(time.Second), retry.Attempts(3))
@@ -101,6 +135,30 @@ func NewConnection(endpoints []config.Endpoint, kp *secp256k1.Keypair, gasLimit,
 	return c, nil
 }
 
+// NewConnectionWithEth1Client returns a read-only connection without eth2 endpoints over eth1Client, such as
+// a fake or simulated backend.
+func NewConnectionWithEth1Client(eth1Client ContractBackend) (*Connection, error) {
+	c := &Connection{
+		eth1Client: eth1Client,
+		callOpts:   bind.CallOpts{Pending: false, From: common.Address{}, BlockNumber: nil, Context: context.Background()},
+		stop:       make(chan struct{}),
+	}
+	if err := c.initMulticall(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close stops health checks of the eth1 and eth2 endpoints.
+func (c *Connection) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		if eth1Client, ok := c.eth1Client.(*Eth1Client); ok {
+			eth1Client.Close()
+		}
+	})
+}
+
 // Connect starts the ethereum WS connection
 func (c *Connection) connect() error {
 	if err := c.connectEth1(); err != nil {
@@ -116,14 +174,14 @@ func (c *Connection) connect() error {
 		return err
 	}
 
-	if c.kp != nil {
+	if c.signer != nil {
 		// Construct tx opts, call opts, and nonce mechanism
 		opts, err := c.newTransactOpts(big.NewInt(0), c.gasLimit)
 		if err != nil {
 			return err
 		}
 		c.txOpts = opts
-		c.callOpts = bind.CallOpts{Pending: false, From: c.kp.CommonAddress(), BlockNumber: nil, Context: context.Background()}
+		c.callOpts = bind.CallOpts{Pending: false, From: c.signer.Address(), BlockNumber: nil, Context: context.Background()}
 	} else {
 		c.callOpts = bind.CallOpts{Pending: false, From: common.Address{}, BlockNumber: nil, Context: context.Background()}
 	}
@@ -131,26 +189,32 @@ func (c *Connection) connect() error {
 }
 
 func (c *Connection) connectEth1() (err error) {
-	c.eth1Client, err = NewEth1Client(lo.Map(c.endpoints, func(e config.Endpoint, i int) string { return e.Eth1 }))
+	c.eth1Client, err = NewEth1ClientWithTransport(lo.Map(c.endpoints, func(e config.Endpoint, i int) string { return e.Eth1 }), c.transport)
 	return
 }
 
 func (c *Connection) connectEth2(chainId *big.Int) error {
 	c.eth2Clients = make([]*eth2Client, 0, len(c.endpoints))
-	for _, e := range c.endpoints {
-		stdClient, err := client.NewStandardHttpClient(e.Eth2, chainId)
+	for i, e := range c.endpoints {
+		var transport http.RoundTripper
+		if c.transport != nil {
+			transport = c.transport(fmt.Sprintf("eth2/%d", i), e.Eth2)
+		}
+		stdClient, err := client.NewStandardHttpClientWithTransport(e.Eth2, chainId, transport)
 		if err != nil {
 			return err
 		}
 
-		config, err := stdClient.GetEth2Config()
+		config, err := stdClient.GetEth2Config(context.Background())
 		if err != nil {
 			return err
 		}
 		client := eth2Client{
-			StandardHttpClient: stdClient,
-			endpoint:           e.Eth2,
-			config:             config,
+			Client:   stdClient,
+			endpoint: e.Eth2,
+			label:    metrics.EndpointLabel(e.Eth2),
+			config:   config,
+			score:    newEndpointScore("eth2", metrics.EndpointLabel(e.Eth2)),
 		}
 		checkEth2Health(&client)
 		c.eth2Clients = append(c.eth2Clients, &client)
@@ -158,7 +222,12 @@ func (c *Connection) connectEth2(chainId *big.Int) error {
 
 	utils.SafeGoWithRestart(func() {
 		for {
-			time.Sleep(time.Minute)
+			utils.Sleep(c.stop, time.Minute)
+			select {
+			case <-c.stop:
+				return
+			default:
+			}
 			for i := range c.eth2Clients {
 				checkEth2Health(c.eth2Clients[i])
 			}
@@ -172,12 +241,13 @@ func (c *Connection) getHealthyEth2Clients() ([]*eth2Client, error) {
 	clients := make([]*eth2Client, 0, len(c.eth2Clients))
 	errMsgs := make([]string, 0, len(c.eth2Clients))
 	for _, client := range c.eth2Clients {
-		if client.healthCheckError != nil {
-			errMsgs = append(errMsgs, fmt.Sprintf("endpoint: %s checked at %s health check err: %s", client.endpoint, client.lastCheckedAt, client.healthCheckError.Error()))
+		health := loadHealth(&client.health)
+		if health.err != nil {
+			errMsgs = append(errMsgs, fmt.Sprintf("endpoint: %s checked at %s health check err: %s", client.endpoint, health.checkedAt, health.err.Error()))
 			continue
 		}
-		if client.outOfSync {
-			errMsgs = append(errMsgs, fmt.Sprintf("endpoint: %s checked at %s slot number: %d", client.endpoint, client.lastCheckedAt, client.latestBeaconHead.Slot))
+		if health.outOfSync {
+			errMsgs = append(errMsgs, fmt.Sprintf("endpoint: %s checked at %s slot number: %d", client.endpoint, health.checkedAt, health.latestBlock))
 			continue
 		}
 
@@ -186,31 +256,31 @@ func (c *Connection) getHealthyEth2Clients() ([]*eth2Client, error) {
 	if len(clients) == 0 {
 		return nil, fmt.Errorf("all eth2 endpoints are out of sync: %s", strings.Join(errMsgs, ";"))
 	}
-	return clients, nil
+	return route(c.routing, clients), nil
 }
 
 func checkEth2Health(client *eth2Client) {
 	beaconHead, err := retry.DoWithData(
-		client.GetBeaconHead,
+		func() (beacon.BeaconHead, error) { return client.GetBeaconHead(context.Background()) },
 		retry.Delay(time.Second),
 		retry.Attempts(5),
 	)
-	client.healthCheckError = nil
-	client.latestBeaconHead = &beaconHead
+	// out of sync is kept from the previous check if this one fails
+	health := loadHealth(&client.health)
+	health.err = nil
+	health.latestBlock = beaconHead.Slot
 	if err != nil {
-		client.healthCheckError = err
-	} else if client.latestBeaconHead == nil {
-		client.healthCheckError = fmt.Errorf("failed to get latest beacon head")
+		health.err = err
 	} else {
-		client.outOfSync = utils.TimestampOfSlot(client.config, beaconHead.FinalizedSlot) < uint64(time.Now().Add(-time.Minute*20).Unix())
+		health.outOfSync = utils.TimestampOfSlot(client.config, beaconHead.FinalizedSlot) < uint64(time.Now().Add(-time.Minute*20).Unix())
 	}
-	client.lastCheckedAt = time.Now()
+	health.checkedAt = time.Now()
+	client.health.Store(&health)
 }
 
-// newTransactOpts builds the TransactOpts for the connection's keypair.
+// newTransactOpts builds the TransactOpts for the connection's signer.
 func (c *Connection) newTransactOpts(value, gasLimit *big.Int) (*bind.TransactOpts, error) {
-	privateKey := c.kp.PrivateKey()
-	address := ethcrypto.PubkeyToAddress(privateKey.PublicKey)
+	address := c.signer.Address()
 
 	nonce, err := c.eth1Client.PendingNonceAt(context.Background(), address)
 	if err != nil {
@@ -221,26 +291,37 @@ func (c *Connection) newTransactOpts(value, gasLimit *big.Int) (*bind.TransactOp
 		return nil, err
 	}
 
-	auth, err := bind.NewKeyedTransactorWithChainID(privateKey, chainId)
-	if err != nil {
-		return nil, err
+	auth := &bind.TransactOpts{
+		From: address,
+		Signer: func(from common.Address, tx *ethtypes.Transaction) (*ethtypes.Transaction, error) {
+			if from != address {
+				return nil, bind.ErrNotAuthorized
+			}
+			return c.signer.SignTx(tx, chainId)
+		},
+		Nonce:    big.NewInt(int64(nonce)),
+		Value:    value,
+		GasLimit: uint64(gasLimit.Int64()),
+		Context:  context.Background(),
 	}
-	auth.Nonce = big.NewInt(int64(nonce))
-	auth.Value = value
-	auth.GasLimit = uint64(gasLimit.Int64())
-	auth.Context = context.Background()
 
 	return auth, nil
 }
 
-func (c *Connection) Keypair() *secp256k1.Keypair {
-	return c.kp
+// Signer returns the signer of voter account, nil if the connection is read-only.
+func (c *Connection) Signer() signer.Signer {
+	return c.signer
 }
 
 func (c *Connection) Eth1Client() ContractBackend {
 	return c.eth1Client
 }
 
+// GasLimit is the configured gas limit of txs.
+func (c *Connection) GasLimit() uint64 {
+	return c.gasLimit.Uint64()
+}
+
 func (c *Connection) TxOpts() *bind.TransactOpts {
 	return c.txOpts
 }
@@ -261,36 +342,46 @@ func (c *Connection) CallOptsOn(targetBlockNumber uint64) *bind.CallOpts {
 	return &newCallOpts
 }
 
-// return suggest gastipcap gasfeecap
-func (c *Connection) SafeEstimateFee(ctx context.Context) (*big.Int, *big.Int, error) {
-	marketGasTipCap, err := c.eth1Client.SuggestGasTipCap(ctx)
-	if err != nil {
-		return nil, nil, err
-	}
-	marketGasFeeCap, err := c.eth1Client.SuggestGasPrice(ctx)
-	if err != nil {
-		return nil, nil, err
+// SafeEstimateFee returns gasTipCap and gasFeeCap of a tx voting proposals of proposalTypes, the default fee
+// policy is used if no proposal type is given. A tx voting several types is sent if any of them allows its
+// fees, taking the highest fees among the types allowing them.
+func (c *Connection) SafeEstimateFee(ctx context.Context, proposalTypes ...string) (*big.Int, *big.Int, error) {
+	if len(proposalTypes) == 0 {
+		proposalTypes = []string{DefaultFeePolicy}
 	}
 
-	if marketGasFeeCap.Cmp(Gwei20) < 0 {
-		marketGasFeeCap = new(big.Int).Add(marketGasFeeCap, Gwei5)
-	} else {
-		marketGasFeeCap = new(big.Int).Add(marketGasFeeCap, Gwei10)
+	var gasTipCap, gasFeeCap *big.Int
+	var gasPriceErr *GasPriceError
+	for _, proposalType := range lo.Uniq(proposalTypes) {
+		policy := c.feePolicyOf(proposalType)
+		tipCap, feeCap, err := policy.strategy.Fees(ctx, c.eth1Client)
+		if err != nil {
+			return nil, nil, err
+		}
+		if feeCap.Cmp(policy.maxGasPrice) > 0 {
+			gasPriceErr = &GasPriceError{Current: feeCap, Max: policy.maxGasPrice}
+			continue
+		}
+		if gasFeeCap == nil || feeCap.Cmp(gasFeeCap) > 0 {
+			gasFeeCap = feeCap
+		}
+		if gasTipCap == nil || tipCap.Cmp(gasTipCap) > 0 {
+			gasTipCap = tipCap
+		}
 	}
-
-	gasFeeCap, _ := new(big.Float).Mul(new(big.Float).SetInt(marketGasFeeCap), c.gasPriceMultiplier).Int(nil)
-	gasTipCap, _ := new(big.Float).Mul(new(big.Float).SetInt(marketGasTipCap), c.gasPriceMultiplier).Int(nil)
-
-	if gasFeeCap.Cmp(c.maxGasPrice) > 0 {
-		return nil, nil, &GasPriceError{Current: gasFeeCap, Max: c.maxGasPrice}
+	if gasFeeCap == nil {
+		return nil, nil, gasPriceErr
+	}
+	if gasTipCap.Cmp(gasFeeCap) > 0 {
+		gasTipCap = gasFeeCap
 	}
 
 	return gasTipCap, gasFeeCap, nil
 }
 
 // LockAndUpdateOpts acquires a lock on the opts before updating the nonce
-// and gas price.
-func (c *Connection) LockAndUpdateTxOpts() error {
+// and gas price, fees follow the fee policies of proposalTypes.
+func (c *Connection) LockAndUpdateTxOpts(proposalTypes ...string) error {
 	c.optsLock.Lock()
 
 	var err error
@@ -300,14 +391,19 @@ func (c *Connection) LockAndUpdateTxOpts() error {
 		}
 	}()
 
-	gasTipCap, gasFeeCap, err := c.SafeEstimateFee(context.Background())
+	gasTipCap, gasFeeCap, err := c.SafeEstimateFee(context.Background(), proposalTypes...)
 	if err != nil {
 		return err
 	}
 	c.txOpts.GasTipCap = gasTipCap
 	c.txOpts.GasFeeCap = gasFeeCap
 
-	nonce, err := c.eth1Client.NonceAt(context.Background(), c.txOpts.From, nil)
+	var nonce uint64
+	if c.txManager != nil {
+		nonce, err = c.txManager.NextNonce()
+	} else {
+		nonce, err = c.eth1Client.NonceAt(context.Background(), c.txOpts.From, nil)
+	}
 	if err != nil {
 		return err
 	}
@@ -329,6 +425,19 @@ func (c *Connection) Eth1LatestBlock() (uint64, error) {
 }
 
 func (c *Connection) GetValidatorStatus(ctx context.Context, pubkey types.ValidatorPubkey, opts *beacon.ValidatorStatusOptions) (validatorStatus beacon.ValidatorStatus, err error) {
+	if c.beaconQuorum != nil {
+		return quorumAnswer(ctx, c, "GetValidatorStatus", func(client *eth2Client) (beacon.ValidatorStatus, error) {
+			return client.GetValidatorStatus(ctx, pubkey, opts)
+		}, func(status beacon.ValidatorStatus) string {
+			return digestOf("%s", describeValidatorStatus(status))
+		}, func(statuses []beacon.ValidatorStatus) []string {
+			descriptions := make([]string, len(statuses))
+			for i, status := range statuses {
+				descriptions[i] = describeValidatorStatus(status)
+			}
+			return descriptions
+		})
+	}
 	var clients []*eth2Client
 	clients, err = c.getHealthyEth2Clients()
 	if err != nil {
@@ -336,7 +445,12 @@ func (c *Connection) GetValidatorStatus(ctx context.Context, pubkey types.Valida
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		validatorStatus, err = client.GetValidatorStatus(ctx, pubkey, opts)
+		client.observe(c.routing, "GetValidatorStatus", start, err)
 		if err == nil {
 			return
 		}
@@ -345,6 +459,11 @@ func (c *Connection) GetValidatorStatus(ctx context.Context, pubkey types.Valida
 }
 
 func (c *Connection) GetValidatorStatuses(ctx context.Context, pubkeys []types.ValidatorPubkey, opts *beacon.ValidatorStatusOptions) (validatorStatus map[types.ValidatorPubkey]beacon.ValidatorStatus, err error) {
+	if c.beaconQuorum != nil {
+		return quorumAnswer(ctx, c, "GetValidatorStatuses", func(client *eth2Client) (map[types.ValidatorPubkey]beacon.ValidatorStatus, error) {
+			return client.GetValidatorStatuses(ctx, pubkeys, opts)
+		}, digestValidatorStatuses, describeValidatorStatuses)
+	}
 	var clients []*eth2Client
 	clients, err = c.getHealthyEth2Clients()
 	if err != nil {
@@ -352,7 +471,12 @@ func (c *Connection) GetValidatorStatuses(ctx context.Context, pubkeys []types.V
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		validatorStatus, err = client.GetValidatorStatuses(ctx, pubkeys, opts)
+		client.observe(c.routing, "GetValidatorStatuses", start, err)
 		if err == nil {
 			return
 		}
@@ -360,7 +484,15 @@ func (c *Connection) GetValidatorStatuses(ctx context.Context, pubkeys []types.V
 	return
 }
 
-func (c *Connection) GetBeaconBlock(blockId uint64) (block beacon.BeaconBlock, exist bool, err error) {
+func (c *Connection) GetBeaconBlock(ctx context.Context, blockId uint64) (block beacon.BeaconBlock, exist bool, err error) {
+	if c.beaconQuorum != nil {
+		var answer beaconBlockAnswer
+		answer, err = quorumAnswer(ctx, c, "GetBeaconBlock", func(client *eth2Client) (beaconBlockAnswer, error) {
+			block, exist, err := client.GetBeaconBlock(ctx, blockId)
+			return beaconBlockAnswer{block: block, exist: exist}, err
+		}, digestBeaconBlock, describeBeaconBlocks)
+		return answer.block, answer.exist, err
+	}
 	var clients []*eth2Client
 	clients, err = c.getHealthyEth2Clients()
 	if err != nil {
@@ -368,7 +500,12 @@ func (c *Connection) GetBeaconBlock(blockId uint64) (block beacon.BeaconBlock, e
 	}
 
 	for _, client := range clients {
-		block, exist, err = client.GetBeaconBlock(blockId)
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
+		block, exist, err = client.GetBeaconBlock(ctx, blockId)
+		client.observe(c.routing, "GetBeaconBlock", start, err)
 		if exist {
 			return
 		}
@@ -376,7 +513,7 @@ func (c *Connection) GetBeaconBlock(blockId uint64) (block beacon.BeaconBlock, e
 	return
 }
 
-func (c *Connection) GetEth2Config() (cfg beacon.Eth2Config, err error) {
+func (c *Connection) GetEth2Config(ctx context.Context) (cfg beacon.Eth2Config, err error) {
 	var clients []*eth2Client
 	clients, err = c.getHealthyEth2Clients()
 	if err != nil {
@@ -384,7 +521,12 @@ func (c *Connection) GetEth2Config() (cfg beacon.Eth2Config, err error) {
 	}
 
 	for _, client := range clients {
-		cfg, err = client.GetEth2Config()
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
+		cfg, err = client.GetEth2Config(ctx)
+		client.observe(c.routing, "GetEth2Config", start, err)
 		if err == nil {
 			return
 		}
@@ -392,7 +534,7 @@ func (c *Connection) GetEth2Config() (cfg beacon.Eth2Config, err error) {
 	return
 }
 
-func (c *Connection) GetBeaconHead() (head beacon.BeaconHead, err error) {
+func (c *Connection) GetBeaconHead(ctx context.Context) (head beacon.BeaconHead, err error) {
 	var clients []*eth2Client
 	clients, err = c.getHealthyEth2Clients()
 	if err != nil {
@@ -400,10 +542,36 @@ func (c *Connection) GetBeaconHead() (head beacon.BeaconHead, err error) {
 	}
 
 	for _, client := range clients {
-		head, err = client.GetBeaconHead()
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
+		head, err = client.GetBeaconHead(ctx)
+		client.observe(c.routing, "GetBeaconHead", start, err)
 		if err == nil {
 			return
 		}
 	}
 	return
 }
+
+// SubscribeBeaconEvents streams events of topics from a healthy eth2 endpoint to handle until ctx is done. A failed
+// stream falls over to the next endpoint, the error of the last one is returned.
+func (c *Connection) SubscribeBeaconEvents(ctx context.Context, topics []string, handle func(beacon.Event)) (err error) {
+	var clients []*eth2Client
+	clients, err = c.getHealthyEth2Clients()
+	if err != nil {
+		return
+	}
+
+	for _, client := range clients {
+		start := time.Now()
+		err = client.SubscribeEvents(ctx, topics, handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		metrics.ObserveRpc("eth2", client.label, "SubscribeEvents", start, err)
+		logrus.WithField("endpoint", client.label).Warnf("beacon event stream err: %s", err.Error())
+	}
+	return
+}
@@ -115,7 +115,7 @@ func TestEth2Config(t *testing.T) {
 	}
 	c, err := connection.NewConnection(endpoints, nil, nil, nil, nil)
 	assert.Nil(t, err)
-	config, err := c.GetEth2Config()
+	config, err := c.GetEth2Config(context.Background())
 	assert.Nil(t, err)
 	cfgBytes, err := json.MarshalIndent(config, "", "  ")
 	assert.Nil(t, err)
@@ -136,7 +136,7 @@ func TestBlockDetail(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, _, err = c.GetBeaconBlock(7312423)
+	_, _, err = c.GetBeaconBlock(context.Background(), 7312423)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,297 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+)
+
+// order of the healthy endpoints a call tries
+const (
+	RoutingOrdered  = "ordered"  // config order
+	RoutingWeighted = "weighted" // random, endpoints of larger weights first more often
+	RoutingLatency  = "latency"  // lowest latency first, slowed by the error rate
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultCircuitOpen      = 30 * time.Second
+	// weight of the latest call in the moving averages of latency and error rate
+	scoreDecay = 0.1
+	// json-rpc error code of providers rejecting requests over their limits
+	limitExceededCode = -32005
+)
+
+// endpointRouting orders the healthy endpoints calls try and opens the circuit of an endpoint failing
+// failureThreshold calls in a row, so calls skip it for openDuration.
+type endpointRouting struct {
+	policy           string
+	failureThreshold int
+	openDuration     time.Duration
+}
+
+var defaultRouting = &endpointRouting{
+	policy:           RoutingOrdered,
+	failureThreshold: defaultFailureThreshold,
+	openDuration:     defaultCircuitOpen,
+}
+
+// endpointScore tracks the latency, error rate and circuit of an endpoint.
+type endpointScore struct {
+	chain string
+	label string
+
+	mutex     sync.Mutex
+	weight    float64
+	limiter   *rateLimiter // nil if not limited
+	latency   time.Duration
+	errorRate float64
+	failures  int // in a row
+	openUntil time.Time
+}
+
+func newEndpointScore(chain, label string) *endpointScore {
+	return &endpointScore{chain: chain, label: label, weight: 1}
+}
+
+func (s *endpointScore) configure(weight, rateLimit float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.weight = weight
+	s.limiter = nil
+	if rateLimit > 0 {
+		s.limiter = newRateLimiter(rateLimit)
+	}
+}
+
+// acquire waits until the rate limit of the endpoint allows a request.
+func (s *endpointScore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	var wait time.Duration
+	if s.limiter != nil {
+		wait = s.limiter.reserve(time.Now())
+	}
+	s.mutex.Unlock()
+	if wait == 0 {
+		return nil
+	}
+	return utils.SleepContext(ctx, wait)
+}
+
+// record updates the score with a call started at start, the circuit opens after routing.failureThreshold
+// failures in a row and closes on the next success.
+func (s *endpointScore) record(routing *endpointRouting, start time.Time, err error) {
+	if s == nil || !countsAsCall(err) {
+		return
+	}
+	if routing == nil {
+		routing = defaultRouting
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	failed := 0.0
+	if err != nil {
+		failed = 1
+	}
+	s.errorRate += (failed - s.errorRate) * scoreDecay
+	if err != nil {
+		s.failures++
+		if s.failures >= routing.failureThreshold {
+			if !s.isOpen(start) {
+				logrus.WithFields(logrus.Fields{
+					"endpoint": s.label,
+					"failures": s.failures,
+					"err":      err.Error(),
+				}).Warnf("%s endpoint circuit opened for %s", s.chain, routing.openDuration)
+			}
+			s.openUntil = time.Now().Add(routing.openDuration)
+			metrics.CircuitOpen.WithLabelValues(s.chain, s.label).Set(1)
+		}
+		return
+	}
+
+	latency := time.Since(start)
+	if s.latency == 0 {
+		s.latency = latency
+	} else {
+		s.latency += time.Duration(float64(latency-s.latency) * scoreDecay)
+	}
+	if s.failures >= routing.failureThreshold {
+		logrus.WithField("endpoint", s.label).Infof("%s endpoint circuit closed", s.chain)
+		metrics.CircuitOpen.WithLabelValues(s.chain, s.label).Set(0)
+	}
+	s.failures = 0
+	s.openUntil = time.Time{}
+}
+
+func (s *endpointScore) isOpen(now time.Time) bool {
+	return now.Before(s.openUntil)
+}
+
+// countsAsCall tells if err says anything about the endpoint. Canceled calls, json-rpc errors such as reverts
+// and missing txs are answers of a working endpoint, except the ones of providers rejecting requests over limits.
+func countsAsCall(err error) bool {
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, ethereum.NotFound) || errors.Is(err, rpc.ErrNotificationsUnsupported) {
+		return false
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr.ErrorCode() == limitExceededCode
+	}
+	return true
+}
+
+// routedEndpoint is a client of an endpoint with its score.
+type routedEndpoint interface {
+	routingScore() *endpointScore
+}
+
+// route orders clients by the policy of routing. Endpoints with open circuits go last, so they are only
+// tried when all the others fail, and endpoints out of their rate limits go after the ones within them.
+func route[C routedEndpoint](routing *endpointRouting, clients []C) []C {
+	if routing == nil {
+		routing = defaultRouting
+	}
+	now := time.Now()
+	type ranked struct {
+		client  C
+		open    bool
+		limited bool
+		key     float64 // less first
+	}
+	ranks := make([]ranked, len(clients))
+	for i, client := range clients {
+		s := client.routingScore()
+		r := ranked{client: client}
+		if s != nil {
+			s.mutex.Lock()
+			r.open = s.isOpen(now)
+			r.limited = s.limiter != nil && !s.limiter.allows(now)
+			switch routing.policy {
+			case RoutingWeighted:
+				// weighted random order, the key of Efraimidis-Spirakis sampling
+				r.key = -math.Pow(rand.Float64(), 1/math.Max(s.weight, 1e-9))
+			case RoutingLatency:
+				r.key = float64(s.latency) / (1 - math.Min(s.errorRate, 0.9))
+			}
+			s.mutex.Unlock()
+		}
+		ranks[i] = r
+	}
+	sort.SliceStable(ranks, func(i, j int) bool {
+		if ranks[i].open != ranks[j].open {
+			return !ranks[i].open
+		}
+		if ranks[i].limited != ranks[j].limited {
+			return !ranks[i].limited
+		}
+		return ranks[i].key < ranks[j].key
+	})
+	ordered := make([]C, len(ranks))
+	for i, r := range ranks {
+		ordered[i] = r.client
+	}
+	return ordered
+}
+
+func newEndpointRouting(policy string, failureThreshold int, openDuration time.Duration) (*endpointRouting, error) {
+	switch policy {
+	case "":
+		policy = RoutingOrdered
+	case RoutingOrdered, RoutingWeighted, RoutingLatency:
+	default:
+		return nil, fmt.Errorf("unknown routing policy %s", policy)
+	}
+	if failureThreshold <= 0 {
+		failureThreshold = defaultFailureThreshold
+	}
+	if openDuration <= 0 {
+		openDuration = defaultCircuitOpen
+	}
+	return &endpointRouting{policy: policy, failureThreshold: failureThreshold, openDuration: openDuration}, nil
+}
+
+// EndpointLimits of an endpoint, rate limits are requests per second, 0 for unlimited
+type EndpointLimits struct {
+	Weight        float64
+	Eth1RateLimit float64
+	Eth2RateLimit float64
+}
+
+// SetEndpointRouting makes calls try the healthy endpoints in the order of policy and skip endpoints failing
+// failureThreshold calls in a row for openDuration, limits are of the endpoints in config order. It must be
+// called before querying.
+func (c *Connection) SetEndpointRouting(policy string, failureThreshold int, openDuration time.Duration, limits []EndpointLimits) error {
+	routing, err := newEndpointRouting(policy, failureThreshold, openDuration)
+	if err != nil {
+		return err
+	}
+	if len(limits) != len(c.eth2Clients) {
+		return fmt.Errorf("limits of %d endpoints, %d configured", len(limits), len(c.eth2Clients))
+	}
+	for i, client := range c.eth2Clients {
+		client.score.configure(limits[i].Weight, limits[i].Eth2RateLimit)
+	}
+	c.routing = routing
+
+	if eth1Client, ok := c.eth1Client.(*Eth1Client); ok {
+		for i, client := range eth1Client.clients {
+			client.score.configure(limits[i].Weight, limits[i].Eth1RateLimit)
+		}
+		eth1Client.routing = routing
+	}
+	return nil
+}
+
+// rateLimiter is a token bucket holding up to a second of requests.
+type rateLimiter struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	burst := math.Max(rate, 1)
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst}
+}
+
+func (l *rateLimiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+}
+
+func (l *rateLimiter) allows(now time.Time) bool {
+	l.refill(now)
+	return l.tokens >= 1
+}
+
+// reserve takes a token and returns how long to wait until it is available.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.refill(now)
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
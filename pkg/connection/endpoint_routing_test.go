@@ -0,0 +1,82 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointRouting(t *testing.T) {
+	clients := make([]*eth2Client, 3)
+	for i := range clients {
+		label := fmt.Sprintf("http://%d", i)
+		clients[i] = &eth2Client{label: label, score: newEndpointScore("eth2", label)}
+	}
+	routing, err := newEndpointRouting(RoutingOrdered, 2, time.Minute)
+	assert.Nil(t, err)
+	_, err = newEndpointRouting("random", 0, 0)
+	assert.NotNil(t, err)
+	assert.Equal(t, clients, route(routing, clients))
+
+	// the circuit of the first endpoint opens after 2 failures in a row
+	clients[0].observe(routing, "GetBeaconHead", time.Now(), errors.New("timeout"))
+	assert.Equal(t, clients, route(routing, clients))
+	clients[0].observe(routing, "GetBeaconHead", time.Now(), errors.New("timeout"))
+	assert.Equal(t, []*eth2Client{clients[1], clients[2], clients[0]}, route(routing, clients))
+	// answers of a working endpoint do not count as failures
+	clients[1].observe(routing, "GetBeaconHead", time.Now(), ethereum.NotFound)
+	clients[1].observe(routing, "GetBeaconHead", time.Now(), context.Canceled)
+	assert.Equal(t, []*eth2Client{clients[1], clients[2], clients[0]}, route(routing, clients))
+	// and a success closes the circuit
+	clients[0].observe(routing, "GetBeaconHead", time.Now(), nil)
+	assert.Equal(t, clients, route(routing, clients))
+
+	// endpoints out of their rate limits go after the others
+	clients[0].score.configure(1, 1)
+	assert.Nil(t, clients[0].score.acquire(context.Background()))
+	assert.Equal(t, []*eth2Client{clients[1], clients[2], clients[0]}, route(routing, clients))
+	clients[0].score.configure(1, 0)
+
+	routing, err = newEndpointRouting(RoutingLatency, 2, time.Minute)
+	assert.Nil(t, err)
+	for _, client := range clients {
+		client.score = newEndpointScore("eth2", client.label)
+	}
+	clients[0].observe(routing, "GetBeaconHead", time.Now().Add(-3*time.Second), nil)
+	clients[1].observe(routing, "GetBeaconHead", time.Now().Add(-time.Second), nil)
+	clients[2].observe(routing, "GetBeaconHead", time.Now().Add(-2*time.Second), nil)
+	assert.Equal(t, []*eth2Client{clients[1], clients[2], clients[0]}, route(routing, clients))
+
+	routing, err = newEndpointRouting(RoutingWeighted, 2, time.Minute)
+	assert.Nil(t, err)
+	clients[0].score.configure(0.001, 0)
+	clients[1].score.configure(1000, 0)
+	clients[2].score.configure(0.001, 0)
+	firsts := 0
+	for i := 0; i < 100; i++ {
+		if route(routing, clients)[0] == clients[1] {
+			firsts++
+		}
+	}
+	assert.Greater(t, firsts, 95)
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(2)
+	assert.True(t, l.allows(now))
+	assert.Equal(t, time.Duration(0), l.reserve(now))
+	assert.Equal(t, time.Duration(0), l.reserve(now))
+	assert.False(t, l.allows(now))
+	assert.Equal(t, 500*time.Millisecond, l.reserve(now))
+	// the reserved token is repaid first
+	assert.False(t, l.allows(now.Add(400*time.Millisecond)))
+	assert.True(t, l.allows(now.Add(time.Second)))
+	assert.Equal(t, time.Duration(0), l.reserve(now.Add(time.Second)))
+	assert.False(t, l.allows(now.Add(time.Second)))
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"math/big"
+	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/avast/retry-go/v4"
@@ -16,6 +19,7 @@ import (
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
 )
 
@@ -26,7 +30,12 @@ type ContractBackend interface {
 	ChainID(ctx context.Context) (*big.Int, error)
 	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
 	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
-	WaitTxOkCommon(txHash common.Hash) (blockNumber uint64, err error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	TransactionByHash(ctx context.Context, txHash common.Hash) (tx *types.Transaction, isPending bool, err error)
+	WaitTxOkCommon(ctx context.Context, txHash common.Hash) (blockNumber uint64, err error)
+	FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error)
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+	BatchCallContext(ctx context.Context, batch []rpc.BatchElem) error
 }
 
 var _ ContractBackend = &Eth1Client{}
@@ -34,18 +43,37 @@ var _ ContractBackend = &Eth1Client{}
 type underlyingEth1Client struct {
 	*ethclient.Client
 	endpoint string
+	label    string // endpoint without path and query, which may contain api keys
 
-	latestBlock      *types.Block
-	outOfSync        bool
-	healthCheckError error
-	lastCheckedAt    time.Time
+	score *endpointScore
+
+	health atomic.Pointer[endpointHealth] // result of the latest health check, nil before the first one
+}
+
+func (c *underlyingEth1Client) routingScore() *endpointScore {
+	return c.score
+}
+
+// observe records a request of method started at start in the metrics and the score of the endpoint.
+func (c *underlyingEth1Client) observe(routing *endpointRouting, method string, start time.Time, err error) {
+	metrics.ObserveRpc("eth1", c.label, method, start, err)
+	c.score.record(routing, start, err)
 }
 
 type Eth1Client struct {
 	clients []*underlyingEth1Client
+	routing *endpointRouting
+
+	stop      chan struct{} // closed by Close, stops health checks of endpoints
+	closeOnce sync.Once
 }
 
 func NewEth1Client(endpoints []string) (*Eth1Client, error) {
+	return NewEth1ClientWithTransport(endpoints, nil)
+}
+
+// NewEth1ClientWithTransport is NewEth1Client sending requests through transport, which only supports http endpoints.
+func NewEth1ClientWithTransport(endpoints []string, transport Transport) (*Eth1Client, error) {
 	if len(endpoints) == 0 {
 		return nil, fmt.Errorf("endpoints can not be empty")
 	}
@@ -61,8 +89,17 @@ func NewEth1Client(endpoints []string) (*Eth1Client, error) {
 		}
 		switch u.Scheme {
 		case "http", "https":
-			rpcClient, err = rpc.DialHTTP(e)
+			if transport == nil {
+				rpcClient, err = rpc.DialHTTP(e)
+			} else {
+				httpClient := &http.Client{Transport: transport(fmt.Sprintf("eth1/%d", i), e)}
+				rpcClient, err = rpc.DialOptions(context.Background(), e, rpc.WithHTTPClient(httpClient))
+			}
 		case "ws", "wss":
+			if transport != nil {
+				err = fmt.Errorf("transport of %s endpoint unsupported", u.Scheme)
+				break
+			}
 			rpcClient, err = rpc.DialWebsocket(context.Background(), e, fmt.Sprintf("/%s", u.Scheme))
 		default:
 			err = fmt.Errorf("unsupported scheme: %s", u.Scheme)
@@ -74,14 +111,22 @@ func NewEth1Client(endpoints []string) (*Eth1Client, error) {
 		client := &underlyingEth1Client{
 			Client:   ethclient.NewClient(rpcClient),
 			endpoint: e,
+			label:    metrics.EndpointLabel(e),
+			score:    newEndpointScore("eth1", metrics.EndpointLabel(e)),
 		}
 		checkHealth(client)
 		clients[i] = client
 	}
 
+	stop := make(chan struct{})
 	utils.SafeGoWithRestart(func() {
 		for {
-			time.Sleep(time.Minute)
+			utils.Sleep(stop, time.Minute)
+			select {
+			case <-stop:
+				return
+			default:
+			}
 			for i := range clients {
 				checkHealth(clients[i])
 			}
@@ -89,20 +134,30 @@ func NewEth1Client(endpoints []string) (*Eth1Client, error) {
 	})
 
 	return &Eth1Client{
-		clients,
+		clients: clients,
+		routing: defaultRouting,
+		stop:    stop,
 	}, nil
 }
 
+// Close stops health checks of the endpoints.
+func (c *Eth1Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
 func (c *Eth1Client) getHealthyClients() ([]*underlyingEth1Client, error) {
 	clients := make([]*underlyingEth1Client, 0, len(c.clients))
 	errMsgs := make([]string, 0, len(c.clients))
 	for _, client := range c.clients {
-		if client.healthCheckError != nil {
-			errMsgs = append(errMsgs, fmt.Sprintf("endpoint: %s checked at %s health check err: %s", client.endpoint, client.lastCheckedAt, client.healthCheckError.Error()))
+		health := loadHealth(&client.health)
+		if health.err != nil {
+			errMsgs = append(errMsgs, fmt.Sprintf("endpoint: %s checked at %s health check err: %s", client.endpoint, health.checkedAt, health.err.Error()))
 			continue
 		}
-		if client.outOfSync {
-			errMsgs = append(errMsgs, fmt.Sprintf("endpoint: %s checked at %s latest block number: %d", client.endpoint, client.lastCheckedAt, client.latestBlock.NumberU64()))
+		if health.outOfSync {
+			errMsgs = append(errMsgs, fmt.Sprintf("endpoint: %s checked at %s latest block number: %d", client.endpoint, health.checkedAt, health.latestBlock))
 			continue
 		}
 
@@ -111,7 +166,7 @@ func (c *Eth1Client) getHealthyClients() ([]*underlyingEth1Client, error) {
 	if len(clients) == 0 {
 		return nil, fmt.Errorf("all eth1 endpoints are out of sync: %s", strings.Join(errMsgs, ";"))
 	}
-	return clients, nil
+	return route(c.routing, clients), nil
 }
 
 func checkHealth(client *underlyingEth1Client) {
@@ -124,16 +179,20 @@ func checkHealth(client *underlyingEth1Client) {
 		retry.Delay(time.Second),
 		retry.Attempts(5),
 	)
-	client.healthCheckError = nil
-	client.latestBlock = block
+	// out of sync is kept from the previous check if this one fails
+	health := loadHealth(&client.health)
+	health.err = nil
+	health.latestBlock = 0
 	if err != nil {
-		client.healthCheckError = err
-	} else if client.latestBlock == nil {
-		client.healthCheckError = fmt.Errorf("failed to get latest block")
+		health.err = err
+	} else if block == nil {
+		health.err = fmt.Errorf("failed to get latest block")
 	} else {
-		client.outOfSync = block.Time() < uint64(time.Now().Add(-time.Minute*5).Unix())
+		health.latestBlock = block.NumberU64()
+		health.outOfSync = block.Time() < uint64(time.Now().Add(-time.Minute*5).Unix())
 	}
-	client.lastCheckedAt = time.Now()
+	health.checkedAt = time.Now()
+	client.health.Store(&health)
 }
 
 func (c *Eth1Client) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (balance *big.Int, err error) {
@@ -144,7 +203,12 @@ func (c *Eth1Client) BalanceAt(ctx context.Context, account common.Address, bloc
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		balance, err = client.BalanceAt(ctx, account, blockNumber)
+		client.observe(c.routing, "BalanceAt", start, err)
 		if err == nil {
 			return
 		}
@@ -160,7 +224,12 @@ func (c *Eth1Client) BlockByNumber(ctx context.Context, number *big.Int) (block
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		block, err = client.BlockByNumber(ctx, number)
+		client.observe(c.routing, "BlockByNumber", start, err)
 		if err == nil {
 			return
 		}
@@ -176,7 +245,12 @@ func (c *Eth1Client) ChainID(ctx context.Context) (id *big.Int, err error) {
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		id, err = client.ChainID(ctx)
+		client.observe(c.routing, "ChainID", start, err)
 		if err == nil {
 			return
 		}
@@ -192,7 +266,12 @@ func (c *Eth1Client) NonceAt(ctx context.Context, account common.Address, blockN
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		nonce, err = client.NonceAt(ctx, account, blockNumber)
+		client.observe(c.routing, "NonceAt", start, err)
 		if err == nil {
 			return
 		}
@@ -208,7 +287,12 @@ func (c *Eth1Client) BlockNumber(ctx context.Context) (number uint64, err error)
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		number, err = client.BlockNumber(ctx)
+		client.observe(c.routing, "BlockNumber", start, err)
 		if err == nil {
 			return
 		}
@@ -224,7 +308,12 @@ func (c *Eth1Client) CallContract(ctx context.Context, call ethereum.CallMsg, bl
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		bytes, err = client.CallContract(ctx, call, blockNumber)
+		client.observe(c.routing, "CallContract", start, err)
 		if err == nil {
 			return
 		}
@@ -240,7 +329,12 @@ func (c *Eth1Client) CodeAt(ctx context.Context, contract common.Address, blockN
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		bytes, err = client.CodeAt(ctx, contract, blockNumber)
+		client.observe(c.routing, "CodeAt", start, err)
 		if err == nil {
 			return
 		}
@@ -256,7 +350,12 @@ func (c *Eth1Client) EstimateGas(ctx context.Context, call ethereum.CallMsg) (ga
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		gas, err = client.EstimateGas(ctx, call)
+		client.observe(c.routing, "EstimateGas", start, err)
 		if err == nil {
 			return
 		}
@@ -272,7 +371,12 @@ func (c *Eth1Client) FilterLogs(ctx context.Context, query ethereum.FilterQuery)
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		logs, err = client.FilterLogs(ctx, query)
+		client.observe(c.routing, "FilterLogs", start, err)
 		if err == nil {
 			return
 		}
@@ -288,7 +392,12 @@ func (c *Eth1Client) HeaderByNumber(ctx context.Context, number *big.Int) (heade
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		header, err = client.HeaderByNumber(ctx, number)
+		client.observe(c.routing, "HeaderByNumber", start, err)
 		if err == nil {
 			return
 		}
@@ -304,7 +413,12 @@ func (c *Eth1Client) PendingCodeAt(ctx context.Context, account common.Address)
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		bytes, err = client.PendingCodeAt(ctx, account)
+		client.observe(c.routing, "PendingCodeAt", start, err)
 		if err == nil {
 			return
 		}
@@ -320,7 +434,12 @@ func (c *Eth1Client) PendingNonceAt(ctx context.Context, account common.Address)
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		nonce, err = client.PendingNonceAt(ctx, account)
+		client.observe(c.routing, "PendingNonceAt", start, err)
 		if err == nil {
 			return
 		}
@@ -336,7 +455,12 @@ func (c *Eth1Client) SendTransaction(ctx context.Context, tx *types.Transaction)
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		err = client.SendTransaction(ctx, tx)
+		client.observe(c.routing, "SendTransaction", start, err)
 		if err == nil {
 			return
 		}
@@ -352,7 +476,34 @@ func (c *Eth1Client) SubscribeFilterLogs(ctx context.Context, query ethereum.Fil
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		sub, err = client.SubscribeFilterLogs(ctx, query, ch)
+		client.observe(c.routing, "SubscribeFilterLogs", start, err)
+		if err == nil {
+			return
+		}
+	}
+	return
+}
+
+// SubscribeNewHead subscribes to new heads on the first healthy endpoint supporting it, only ws endpoints do.
+func (c *Eth1Client) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (sub ethereum.Subscription, err error) {
+	var clients []*underlyingEth1Client
+	clients, err = c.getHealthyClients()
+	if err != nil {
+		return
+	}
+
+	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
+		sub, err = client.SubscribeNewHead(ctx, ch)
+		client.observe(c.routing, "SubscribeNewHead", start, err)
 		if err == nil {
 			return
 		}
@@ -368,7 +519,12 @@ func (c *Eth1Client) SuggestGasPrice(ctx context.Context) (price *big.Int, err e
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		price, err = client.SuggestGasPrice(ctx)
+		client.observe(c.routing, "SuggestGasPrice", start, err)
 		if err == nil {
 			return
 		}
@@ -384,7 +540,75 @@ func (c *Eth1Client) SuggestGasTipCap(ctx context.Context) (cap *big.Int, err er
 	}
 
 	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
 		cap, err = client.SuggestGasTipCap(ctx)
+		client.observe(c.routing, "SuggestGasTipCap", start, err)
+		if err == nil {
+			return
+		}
+	}
+	return
+}
+
+func (c *Eth1Client) FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (history *ethereum.FeeHistory, err error) {
+	var clients []*underlyingEth1Client
+	clients, err = c.getHealthyClients()
+	if err != nil {
+		return
+	}
+
+	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
+		history, err = client.FeeHistory(ctx, blockCount, lastBlock, rewardPercentiles)
+		client.observe(c.routing, "FeeHistory", start, err)
+		if err == nil {
+			return
+		}
+	}
+	return
+}
+
+func (c *Eth1Client) TransactionReceipt(ctx context.Context, txHash common.Hash) (receipt *types.Receipt, err error) {
+	var clients []*underlyingEth1Client
+	clients, err = c.getHealthyClients()
+	if err != nil {
+		return
+	}
+
+	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
+		receipt, err = client.TransactionReceipt(ctx, txHash)
+		client.observe(c.routing, "TransactionReceipt", start, err)
+		if err == nil {
+			return
+		}
+	}
+	return
+}
+
+func (c *Eth1Client) TransactionByHash(ctx context.Context, txHash common.Hash) (tx *types.Transaction, isPending bool, err error) {
+	var clients []*underlyingEth1Client
+	clients, err = c.getHealthyClients()
+	if err != nil {
+		return
+	}
+
+	for _, client := range clients {
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
+		tx, isPending, err = client.TransactionByHash(ctx, txHash)
+		client.observe(c.routing, "TransactionByHash", start, err)
 		if err == nil {
 			return
 		}
@@ -392,7 +616,8 @@ func (c *Eth1Client) SuggestGasTipCap(ctx context.Context) (cap *big.Int, err er
 	return
 }
 
-func (c *Eth1Client) WaitTxOkCommon(txHash common.Hash) (blockNumber uint64, err error) {
+// BatchCallContext sends batch in one json-rpc request, errors of the elements are set in their Error.
+func (c *Eth1Client) BatchCallContext(ctx context.Context, batch []rpc.BatchElem) (err error) {
 	var clients []*underlyingEth1Client
 	clients, err = c.getHealthyClients()
 	if err != nil {
@@ -400,7 +625,12 @@ func (c *Eth1Client) WaitTxOkCommon(txHash common.Hash) (blockNumber uint64, err
 	}
 
 	for _, client := range clients {
-		blockNumber, err = waitTxOkCommon(client, txHash)
+		if err = client.score.acquire(ctx); err != nil {
+			return
+		}
+		start := time.Now()
+		err = client.Client.Client().BatchCallContext(ctx, batch)
+		client.observe(c.routing, "BatchCall", start, err)
 		if err == nil {
 			return
 		}
@@ -408,20 +638,39 @@ func (c *Eth1Client) WaitTxOkCommon(txHash common.Hash) (blockNumber uint64, err
 	return
 }
 
-func waitTxOkCommon(client *underlyingEth1Client, txHash common.Hash) (blockNumber uint64, err error) {
+// WaitTxOkCommon waits until the tx is mined successfully, it gives up when ctx is done.
+func (c *Eth1Client) WaitTxOkCommon(ctx context.Context, txHash common.Hash) (blockNumber uint64, err error) {
+	var clients []*underlyingEth1Client
+	clients, err = c.getHealthyClients()
+	if err != nil {
+		return
+	}
+
+	for _, client := range clients {
+		blockNumber, err = waitTxOkCommon(ctx, client, txHash)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+	}
+	return
+}
+
+func waitTxOkCommon(ctx context.Context, client *underlyingEth1Client, txHash common.Hash) (blockNumber uint64, err error) {
 	retry := 0
 	for {
 		if retry > utils.RetryLimit {
 			return 0, fmt.Errorf("waitTx %s reach retry limit", txHash.String())
 		}
-		_, pending, err := client.TransactionByHash(context.Background(), txHash)
+		_, pending, err := client.TransactionByHash(ctx, txHash)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
 				"hash": txHash.String(),
 				"err":  err.Error(),
 			}).Warn("TransactionByHash")
 
-			time.Sleep(utils.RetryInterval)
+			if err := utils.SleepContext(ctx, utils.RetryInterval); err != nil {
+				return 0, err
+			}
 			retry++
 			continue
 		} else {
@@ -431,7 +680,9 @@ func waitTxOkCommon(client *underlyingEth1Client, txHash common.Hash) (blockNumb
 					"pending": pending,
 				}).Warn("TransactionByHash")
 
-				time.Sleep(utils.RetryInterval)
+				if err := utils.SleepContext(ctx, utils.RetryInterval); err != nil {
+					return 0, err
+				}
 				retry++
 				continue
 			} else {
@@ -443,14 +694,16 @@ func waitTxOkCommon(client *underlyingEth1Client, txHash common.Hash) (blockNumb
 						return 0, fmt.Errorf("TransactionReceipt %s reach retry limit", txHash.String())
 					}
 
-					receipt, err = client.TransactionReceipt(context.Background(), txHash)
+					receipt, err = client.TransactionReceipt(ctx, txHash)
 					if err != nil {
 						logrus.WithFields(logrus.Fields{
 							"hash": txHash.String(),
 							"err":  err.Error(),
 						}).Warn("tx TransactionReceipt")
 
-						time.Sleep(utils.RetryInterval)
+						if err := utils.SleepContext(ctx, utils.RetryInterval); err != nil {
+							return 0, err
+						}
 						subRetry++
 						continue
 					}
@@ -0,0 +1,197 @@
+// Copyright 2024 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package connection
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/shopspring/decimal"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/config"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+)
+
+// fee strategy types
+const (
+	FeeStrategyLegacy     = "legacy"     // suggested gas price plus 5 or 10 Gwei, multiplied by gas price multiplier
+	FeeStrategyFeeHistory = "feeHistory" // tip percentile of recent blocks from eth_feeHistory
+	FeeStrategyBaseFeeTip = "baseFeeTip" // base fee plus a fixed tip
+)
+
+// DefaultFeePolicy is the key of the fee policy of proposal types without their own.
+const DefaultFeePolicy = "default"
+
+// FeeStrategy estimates the fees of a new dynamic fee tx.
+type FeeStrategy interface {
+	Fees(ctx context.Context, backend ContractBackend) (gasTipCap, gasFeeCap *big.Int, err error)
+}
+
+// feePolicy is the fee strategy and max gas price of a proposal type.
+type feePolicy struct {
+	strategy    FeeStrategy
+	maxGasPrice *big.Int
+}
+
+// LegacyFeeStrategy adds 5 Gwei(10 Gwei above 20 Gwei) to the suggested gas price, then multiplies fees by Multiplier.
+type LegacyFeeStrategy struct {
+	Multiplier *big.Float
+}
+
+func (s *LegacyFeeStrategy) Fees(ctx context.Context, backend ContractBackend) (*big.Int, *big.Int, error) {
+	marketGasTipCap, err := backend.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	marketGasFeeCap, err := backend.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if marketGasFeeCap.Cmp(Gwei20) < 0 {
+		marketGasFeeCap = new(big.Int).Add(marketGasFeeCap, Gwei5)
+	} else {
+		marketGasFeeCap = new(big.Int).Add(marketGasFeeCap, Gwei10)
+	}
+
+	gasFeeCap := mulFloat(marketGasFeeCap, s.Multiplier)
+	gasTipCap := mulFloat(marketGasTipCap, s.Multiplier)
+	return gasTipCap, gasFeeCap, nil
+}
+
+// FeeHistoryStrategy tips the median of the Percentile tips of the last Blocks blocks, the gas fee cap
+// is the next base fee multiplied by BaseFeeMultiplier plus the tip.
+type FeeHistoryStrategy struct {
+	Blocks            uint64
+	Percentile        float64
+	BaseFeeMultiplier *big.Float
+}
+
+func (s *FeeHistoryStrategy) Fees(ctx context.Context, backend ContractBackend) (*big.Int, *big.Int, error) {
+	history, err := backend.FeeHistory(ctx, s.Blocks, nil, []float64{s.Percentile})
+	if err != nil {
+		return nil, nil, fmt.Errorf("eth_feeHistory err: %w", err)
+	}
+	// base fees include the one of the next block
+	if len(history.BaseFee) == 0 || history.BaseFee[len(history.BaseFee)-1] == nil {
+		return nil, nil, fmt.Errorf("eth_feeHistory returns no base fee")
+	}
+	nextBaseFee := history.BaseFee[len(history.BaseFee)-1]
+
+	tips := make([]*big.Int, 0, len(history.Reward))
+	for _, rewards := range history.Reward {
+		if len(rewards) > 0 && rewards[0] != nil {
+			tips = append(tips, rewards[0])
+		}
+	}
+	var gasTipCap *big.Int
+	if len(tips) == 0 {
+		gasTipCap, err = backend.SuggestGasTipCap(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+		gasTipCap = new(big.Int).Set(tips[len(tips)/2])
+	}
+
+	gasFeeCap := new(big.Int).Add(mulFloat(nextBaseFee, s.BaseFeeMultiplier), gasTipCap)
+	return gasTipCap, gasFeeCap, nil
+}
+
+// BaseFeeTipStrategy tips a fixed Tip, the gas fee cap is the latest base fee multiplied by BaseFeeMultiplier plus the tip.
+type BaseFeeTipStrategy struct {
+	Tip               *big.Int
+	BaseFeeMultiplier *big.Float
+}
+
+func (s *BaseFeeTipStrategy) Fees(ctx context.Context, backend ContractBackend) (*big.Int, *big.Int, error) {
+	header, err := backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.BaseFee == nil {
+		return nil, nil, fmt.Errorf("block %d has no base fee", header.Number)
+	}
+
+	gasTipCap := new(big.Int).Set(s.Tip)
+	gasFeeCap := new(big.Int).Add(mulFloat(header.BaseFee, s.BaseFeeMultiplier), gasTipCap)
+	return gasTipCap, gasFeeCap, nil
+}
+
+// NewFeeStrategy builds the fee strategy of cfg, gasPriceMultiplier is used by the legacy strategy.
+func NewFeeStrategy(cfg config.FeeStrategy, gasPriceMultiplier *big.Float) (FeeStrategy, error) {
+	switch cfg.Type {
+	case FeeStrategyLegacy, "":
+		return &LegacyFeeStrategy{Multiplier: gasPriceMultiplier}, nil
+	case FeeStrategyFeeHistory:
+		return &FeeHistoryStrategy{
+			Blocks:            cfg.Blocks,
+			Percentile:        cfg.Percentile,
+			BaseFeeMultiplier: new(big.Float).SetFloat64(cfg.BaseFeeMultiplier),
+		}, nil
+	case FeeStrategyBaseFeeTip:
+		tipDeci, err := decimal.NewFromString(cfg.Tip)
+		if err != nil {
+			return nil, fmt.Errorf("parse tip err: %w", err)
+		}
+		if tipDeci.IsNegative() {
+			return nil, fmt.Errorf("tip can not be negative")
+		}
+		return &BaseFeeTipStrategy{
+			Tip:               tipDeci.Mul(utils.GweiDeci).BigInt(),
+			BaseFeeMultiplier: new(big.Float).SetFloat64(cfg.BaseFeeMultiplier),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported fee strategy %s", cfg.Type)
+	}
+}
+
+// SetFeePolicy makes votes of proposalType use strategy and maxGasPrice, DefaultFeePolicy sets the one of
+// proposal types without their own. It must be called before sending txs.
+func (c *Connection) SetFeePolicy(proposalType string, strategy FeeStrategy, maxGasPrice *big.Int) error {
+	if maxGasPrice.Sign() <= 0 {
+		return fmt.Errorf("max gas price of %s empty", proposalType)
+	}
+	if c.feePolicies == nil {
+		c.feePolicies = make(map[string]feePolicy)
+	}
+	c.feePolicies[proposalType] = feePolicy{strategy: strategy, maxGasPrice: maxGasPrice}
+	return nil
+}
+
+// feePolicyOf falls back to the default policy, then to the legacy strategy with maxGasPrice.
+func (c *Connection) feePolicyOf(proposalType string) feePolicy {
+	if policy, exist := c.feePolicies[proposalType]; exist {
+		return policy
+	}
+	if policy, exist := c.feePolicies[DefaultFeePolicy]; exist {
+		return policy
+	}
+	return feePolicy{
+		strategy:    &LegacyFeeStrategy{Multiplier: c.gasPriceMultiplier},
+		maxGasPrice: c.maxGasPrice,
+	}
+}
+
+// MaxGasPriceOf returns the highest max gas price of proposalTypes.
+func (c *Connection) MaxGasPriceOf(proposalTypes ...string) *big.Int {
+	if len(proposalTypes) == 0 {
+		return c.feePolicyOf(DefaultFeePolicy).maxGasPrice
+	}
+	maxGasPrice := big.NewInt(0)
+	for _, proposalType := range proposalTypes {
+		policyMax := c.feePolicyOf(proposalType).maxGasPrice
+		if policyMax.Cmp(maxGasPrice) > 0 {
+			maxGasPrice = policyMax
+		}
+	}
+	return maxGasPrice
+}
+
+func mulFloat(x *big.Int, multiplier *big.Float) *big.Int {
+	ret, _ := new(big.Float).Mul(new(big.Float).SetInt(x), multiplier).Int(nil)
+	return ret
+}
@@ -0,0 +1,116 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type feeBackend struct {
+	fakeBackend
+	baseFee *big.Int
+	tips    []*big.Int
+}
+
+func (b *feeBackend) FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error) {
+	history := &ethereum.FeeHistory{}
+	for _, tip := range b.tips {
+		history.Reward = append(history.Reward, []*big.Int{tip})
+		history.BaseFee = append(history.BaseFee, b.baseFee)
+	}
+	history.BaseFee = append(history.BaseFee, b.baseFee)
+	return history, nil
+}
+
+func (b *feeBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return &types.Header{Number: big.NewInt(100), BaseFee: b.baseFee}, nil
+}
+
+func TestFeeStrategies(t *testing.T) {
+	backend := &feeBackend{
+		baseFee: big.NewInt(10e9),
+		tips:    []*big.Int{big.NewInt(1e9), big.NewInt(3e9), big.NewInt(2e9)},
+	}
+	ctx := context.Background()
+
+	tip, feeCap, err := (&LegacyFeeStrategy{Multiplier: big.NewFloat(2)}).Fees(ctx, backend)
+	assert.Nil(t, err)
+	assert.Equal(t, "2000000000", tip.String())
+	assert.Equal(t, "30000000000", feeCap.String())
+
+	tip, feeCap, err = (&FeeHistoryStrategy{Blocks: 3, Percentile: 50, BaseFeeMultiplier: big.NewFloat(2)}).Fees(ctx, backend)
+	assert.Nil(t, err)
+	assert.Equal(t, "2000000000", tip.String())
+	assert.Equal(t, "22000000000", feeCap.String())
+
+	tip, feeCap, err = (&BaseFeeTipStrategy{Tip: big.NewInt(1e9), BaseFeeMultiplier: big.NewFloat(1.5)}).Fees(ctx, backend)
+	assert.Nil(t, err)
+	assert.Equal(t, "1000000000", tip.String())
+	assert.Equal(t, "16000000000", feeCap.String())
+
+	conn := &Connection{
+		maxGasPrice:        big.NewInt(100e9),
+		gasPriceMultiplier: big.NewFloat(1),
+		eth1Client:         backend,
+	}
+	assert.Nil(t, conn.SetFeePolicy("voteWithdrawCredentials",
+		&BaseFeeTipStrategy{Tip: big.NewInt(1e9), BaseFeeMultiplier: big.NewFloat(2)}, big.NewInt(15e9)))
+	assert.Nil(t, conn.SetFeePolicy("notifyValidatorExit",
+		&FeeHistoryStrategy{Blocks: 3, Percentile: 50, BaseFeeMultiplier: big.NewFloat(2)}, big.NewInt(50e9)))
+
+	// legacy with the connection max gas price if not configured
+	tip, feeCap, err = conn.SafeEstimateFee(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "1000000000", tip.String())
+	assert.Equal(t, "15000000000", feeCap.String())
+
+	_, _, err = conn.SafeEstimateFee(ctx, "voteWithdrawCredentials")
+	var gasPriceErr *GasPriceError
+	assert.True(t, errors.As(err, &gasPriceErr))
+
+	// a time-critical vote takes others along
+	tip, feeCap, err = conn.SafeEstimateFee(ctx, "voteWithdrawCredentials", "notifyValidatorExit")
+	assert.Nil(t, err)
+	assert.Equal(t, "2000000000", tip.String())
+	assert.Equal(t, "22000000000", feeCap.String())
+	assert.Equal(t, "50000000000", conn.MaxGasPriceOf("voteWithdrawCredentials", "notifyValidatorExit").String())
+}
+
+func TestFeePolicyCaps(t *testing.T) {
+	backend := &feeBackend{
+		baseFee: big.NewInt(10e9),
+		tips:    []*big.Int{big.NewInt(2e9)},
+	}
+	ctx := context.Background()
+	conn := &Connection{
+		maxGasPrice:        big.NewInt(100e9),
+		gasPriceMultiplier: big.NewFloat(1),
+		eth1Client:         backend,
+	}
+	strategy := &FeeHistoryStrategy{Blocks: 1, Percentile: 50, BaseFeeMultiplier: big.NewFloat(2)}
+	assert.Nil(t, conn.SetFeePolicy("voteWithdrawCredentials", strategy, big.NewInt(20e9)))
+	assert.Nil(t, conn.SetFeePolicy("notifyValidatorExit", strategy, big.NewInt(30e9)))
+
+	// 22 Gwei is within the cap of exits only
+	_, _, err := conn.SafeEstimateFee(ctx, "voteWithdrawCredentials")
+	var gasPriceErr *GasPriceError
+	assert.True(t, errors.As(err, &gasPriceErr))
+	assert.Equal(t, "22000000000", gasPriceErr.Current.String())
+	assert.Equal(t, "20000000000", gasPriceErr.Max.String())
+	_, feeCap, err := conn.SafeEstimateFee(ctx, "notifyValidatorExit")
+	assert.Nil(t, err)
+	assert.Equal(t, "22000000000", feeCap.String())
+
+	// 42 Gwei is above both caps
+	backend.baseFee = big.NewInt(20e9)
+	_, _, err = conn.SafeEstimateFee(ctx, "notifyValidatorExit")
+	assert.True(t, errors.As(err, &gasPriceErr))
+	assert.Equal(t, "30000000000", gasPriceErr.Max.String())
+	_, _, err = conn.SafeEstimateFee(ctx, "voteWithdrawCredentials", "notifyValidatorExit")
+	assert.True(t, errors.As(err, &gasPriceErr))
+}
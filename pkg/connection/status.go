@@ -0,0 +1,75 @@
+package connection
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// EndpointStatus is the result of the latest health check of an endpoint.
+type EndpointStatus struct {
+	Endpoint    string    `json:"endpoint"`
+	Healthy     bool      `json:"healthy"`
+	Error       string    `json:"error,omitempty"`
+	OutOfSync   bool      `json:"outOfSync"`
+	LatestBlock uint64    `json:"latestBlock,omitempty"` // eth1 block number or eth2 slot
+	CheckedAt   time.Time `json:"checkedAt"`
+}
+
+// endpointHealth is the result of a health check, each check stores a new one so readers never see a
+// partially updated result.
+type endpointHealth struct {
+	latestBlock uint64 // eth1 block number or eth2 slot
+	outOfSync   bool
+	err         error
+	checkedAt   time.Time
+}
+
+// loadHealth returns the latest health check result, an endpoint not checked yet is healthy.
+func loadHealth(p *atomic.Pointer[endpointHealth]) endpointHealth {
+	if health := p.Load(); health != nil {
+		return *health
+	}
+	return endpointHealth{}
+}
+
+func (h endpointHealth) status(endpoint string) EndpointStatus {
+	s := EndpointStatus{
+		Endpoint:    endpoint,
+		Healthy:     h.err == nil && !h.outOfSync,
+		OutOfSync:   h.outOfSync,
+		LatestBlock: h.latestBlock,
+		CheckedAt:   h.checkedAt,
+	}
+	if h.err != nil {
+		s.Error = h.err.Error()
+	}
+	return s
+}
+
+// EndpointStatus reports all eth1 endpoints, healthy ones are those returned by getHealthyClients.
+func (c *Eth1Client) EndpointStatus() []EndpointStatus {
+	status := make([]EndpointStatus, 0, len(c.clients))
+	for _, client := range c.clients {
+		status = append(status, loadHealth(&client.health).status(client.endpoint))
+	}
+	return status
+}
+
+// Eth1EndpointStatus reports the eth1 endpoints, it returns nil if the eth1 client
+// is not an *Eth1Client.
+func (c *Connection) Eth1EndpointStatus() []EndpointStatus {
+	eth1Client, ok := c.eth1Client.(*Eth1Client)
+	if !ok {
+		return nil
+	}
+	return eth1Client.EndpointStatus()
+}
+
+// Eth2EndpointStatus reports all eth2 endpoints, healthy ones are those returned by getHealthyEth2Clients.
+func (c *Connection) Eth2EndpointStatus() []EndpointStatus {
+	status := make([]EndpointStatus, 0, len(c.eth2Clients))
+	for _, client := range c.eth2Clients {
+		status = append(status, loadHealth(&client.health).status(client.endpoint))
+	}
+	return status
+}
@@ -0,0 +1,41 @@
+package connection
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/beacon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEth2EndpointStatus(t *testing.T) {
+	client := &eth2Client{Client: &headBeacon{head: beacon.BeaconHead{Slot: 64, FinalizedSlot: 32}}, endpoint: "a"}
+	c := &Connection{eth2Clients: []*eth2Client{client}}
+
+	// not checked yet
+	assert.Equal(t, []EndpointStatus{{Endpoint: "a", Healthy: true}}, c.Eth2EndpointStatus())
+
+	// status is read while health checks run
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			checkEth2Health(client)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		c.Eth2EndpointStatus()
+		_, _ = c.getHealthyEth2Clients()
+	}
+	wg.Wait()
+
+	// the genesis of the zero config is long ago, the finalized slot is out of sync
+	status := c.Eth2EndpointStatus()
+	assert.Equal(t, uint64(64), status[0].LatestBlock)
+	assert.True(t, status[0].OutOfSync)
+	assert.False(t, status[0].Healthy)
+	assert.False(t, status[0].CheckedAt.IsZero())
+	_, err := c.getHealthyEth2Clients()
+	assert.ErrorContains(t, err, "slot number: 64")
+}
@@ -0,0 +1,579 @@
+// Copyright 2024 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+	network_proposal "github.com/stafiprotocol/eth-lsd-relay/bindings/NetworkProposal"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+)
+
+const (
+	// nodes reject replacements with less than 10% fee bump
+	minFeeBumpPercent = 10
+
+	proposalStatusExecuted = 2
+	cancelTxGasLimit       = 21000
+)
+
+var (
+	ErrTxCancelled = errors.New("tx cancelled as its proposals are executed")
+	ErrNotLeader   = errors.New("not the leader, only the leader sends txs")
+)
+
+type TxManagerConfig struct {
+	PendingTxPath      string      // file to persist pending txs
+	FeeBumpPercent     uint64      // fee bump of a replacement tx
+	FeeBumpAfterBlocks uint64      // replace a tx not mined after these blocks
+	IsLeader           func() bool // whether this instance may send txs, nil if it runs alone
+}
+
+// PendingTx is a sent tx not mined yet, replacements share its nonce.
+type PendingTx struct {
+	Nonce         uint64
+	Hashes        []common.Hash // hashes of all sent txs of this nonce, the last one is the latest
+	Raw           hexutil.Bytes // the latest signed tx
+	ProposalIds   []common.Hash // proposals voted by the tx
+	ProposalTypes []string      // types of the voted proposals, their fee policies apply to replacements
+	SentAtBlock   uint64        // block when the latest tx was sent
+	Cancelled     bool          // replaced by a self transfer
+	CreatedAt     time.Time
+
+	replacing bool // a replacement is being sent
+	sending   bool // the first tx is being sent, its nonce is reserved
+}
+
+func (p *PendingTx) tx() (*ethtypes.Transaction, error) {
+	tx := new(ethtypes.Transaction)
+	if err := tx.UnmarshalBinary(p.Raw); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// TxManager tracks txs of the voter account by nonce. Txs not mined after FeeBumpAfterBlocks are replaced with
+// bumped fees, or cancelled with a self transfer once their proposals are executed. Pending txs are persisted,
+// so a restarted relay continues them instead of sending new txs with conflicting nonces.
+type TxManager struct {
+	conn    *Connection
+	cfg     TxManagerConfig
+	from    common.Address
+	chainId *big.Int
+
+	mutex   sync.Mutex
+	pending map[uint64]*PendingTx // nonce -> pending tx
+}
+
+func newTxManager(conn *Connection, cfg TxManagerConfig) (*TxManager, error) {
+	if conn.signer == nil {
+		return nil, fmt.Errorf("tx manager needs a signer")
+	}
+	if cfg.FeeBumpPercent < minFeeBumpPercent {
+		return nil, fmt.Errorf("fee bump percent can not be less than %d", minFeeBumpPercent)
+	}
+	if cfg.FeeBumpAfterBlocks == 0 {
+		return nil, fmt.Errorf("fee bump after blocks can not be zero")
+	}
+	chainId, err := conn.eth1Client.ChainID(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	m := &TxManager{
+		conn:    conn,
+		cfg:     cfg,
+		from:    conn.signer.Address(),
+		chainId: chainId,
+		pending: make(map[uint64]*PendingTx),
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// StartTxManager makes txs built with TxOpts go through a TxManager, pending txs of previous run are resumed.
+func (c *Connection) StartTxManager(cfg TxManagerConfig) error {
+	m, err := newTxManager(c, cfg)
+	if err != nil {
+		return err
+	}
+	c.optsLock.Lock()
+	c.txManager = m
+	c.txOpts.NoSend = true
+	c.optsLock.Unlock()
+
+	if m.isLeader() {
+		m.resume()
+	}
+	return nil
+}
+
+func (m *TxManager) resume() {
+	for _, p := range m.PendingTxs() {
+		nonce := p.Nonce
+		logrus.WithFields(logrus.Fields{
+			"nonce":  nonce,
+			"txHash": p.Hashes[len(p.Hashes)-1].String(),
+		}).Info("resume pending tx")
+		utils.SafeGo(func() {
+			if _, err := m.Wait(context.Background(), nonce); err != nil && !errors.Is(err, ErrTxCancelled) {
+				logrus.Warnf("wait resumed tx of nonce %d err: %s", nonce, err.Error())
+			}
+		})
+	}
+}
+
+func (m *TxManager) isLeader() bool {
+	return m.cfg.IsLeader == nil || m.cfg.IsLeader()
+}
+
+// TakeOver reloads pending txs persisted by the previous leader, which shares the pending tx file,
+// and resumes them. It is called when this instance becomes the leader.
+func (m *TxManager) TakeOver() error {
+	m.mutex.Lock()
+	m.pending = make(map[uint64]*PendingTx)
+	err := m.load()
+	m.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	m.resume()
+	return nil
+}
+
+// StepDown forgets pending txs without persisting the change, the next leader takes them over from the pending
+// tx file. Waits of them return ErrNotLeader. It is called when this instance is no longer the leader.
+func (m *TxManager) StepDown() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.pending = make(map[uint64]*PendingTx)
+}
+
+func (c *Connection) TxManager() *TxManager {
+	return c.txManager
+}
+
+// NextNonce returns the nonce after both mined and pending txs.
+func (m *TxManager) NextNonce() (uint64, error) {
+	nonce, err := m.conn.eth1Client.NonceAt(context.Background(), m.from, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for pendingNonce := range m.pending {
+		if pendingNonce >= nonce {
+			nonce = pendingNonce + 1
+		}
+	}
+	return nonce, nil
+}
+
+// Send sends a signed tx voting proposalIds of proposalTypes and returns its nonce. If a pending tx already
+// votes the same proposals, tx is dropped and the nonce of the pending tx is returned. The nonce of tx is
+// reserved under the mutex, which is not held during rpc calls.
+func (m *TxManager) Send(ctx context.Context, tx *ethtypes.Transaction, proposalTypes []string, proposalIds ...[32]byte) (uint64, error) {
+	if !m.isLeader() {
+		return 0, ErrNotLeader
+	}
+	ids := make([]common.Hash, len(proposalIds))
+	for i := range proposalIds {
+		ids[i] = proposalIds[i]
+	}
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+
+	m.mutex.Lock()
+	for _, p := range m.pending {
+		if len(ids) > 0 && sameProposals(p.ProposalIds, ids) {
+			m.mutex.Unlock()
+			logrus.WithFields(logrus.Fields{
+				"nonce":  p.Nonce,
+				"txHash": p.Hashes[len(p.Hashes)-1].String(),
+			}).Info("proposals already voted by pending tx")
+			return p.Nonce, nil
+		}
+	}
+	if p, exist := m.pending[tx.Nonce()]; exist {
+		m.mutex.Unlock()
+		return 0, fmt.Errorf("nonce %d is used by pending tx %s", tx.Nonce(), p.Hashes[len(p.Hashes)-1])
+	}
+	p := &PendingTx{
+		Nonce:         tx.Nonce(),
+		Hashes:        []common.Hash{tx.Hash()},
+		Raw:           raw,
+		ProposalIds:   ids,
+		ProposalTypes: proposalTypes,
+		CreatedAt:     time.Now(),
+		sending:       true,
+	}
+	m.pending[tx.Nonce()] = p
+	m.mutex.Unlock()
+
+	latestBlock, err := m.conn.eth1Client.BlockNumber(ctx)
+	if err == nil {
+		err = m.conn.eth1Client.SendTransaction(ctx, tx)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.pending[tx.Nonce()] != p {
+		// stepped down while sending
+		if err != nil {
+			return 0, err
+		}
+		return 0, ErrNotLeader
+	}
+	if err != nil {
+		delete(m.pending, tx.Nonce())
+		return 0, err
+	}
+	p.SentAtBlock = latestBlock
+	p.sending = false
+	if err := m.save(); err != nil {
+		logrus.Warnf("save pending txs err: %s", err.Error())
+	}
+	return tx.Nonce(), nil
+}
+
+// Wait waits until a tx of nonce is mined, replacing it when it is stuck. It returns ErrTxCancelled
+// with the receipt if the tx was cancelled. If ctx is done first, the tx is kept pending and resumed
+// by the next run.
+func (m *TxManager) Wait(ctx context.Context, nonce uint64) (*ethtypes.Receipt, error) {
+	nonceUsedTimes := 0
+	for retry := 0; retry <= utils.RetryLimit; retry++ {
+		m.mutex.Lock()
+		p, exist := m.pending[nonce]
+		var hashes []common.Hash
+		if exist {
+			hashes = append(hashes, p.Hashes...)
+		}
+		m.mutex.Unlock()
+		if !exist {
+			if !m.isLeader() {
+				return nil, ErrNotLeader
+			}
+			return nil, fmt.Errorf("no pending tx of nonce %d", nonce)
+		}
+
+		// any of the replacements may be mined
+		for i := len(hashes) - 1; i >= 0; i-- {
+			receipt, err := m.conn.eth1Client.TransactionReceipt(ctx, hashes[i])
+			if err != nil || receipt == nil {
+				continue
+			}
+			cancelled := m.remove(nonce)
+			if receipt.Status != ethtypes.ReceiptStatusSuccessful {
+				return receipt, fmt.Errorf("tx %s failed", hashes[i].String())
+			}
+			logrus.WithFields(logrus.Fields{
+				"tx": hashes[i].String(),
+			}).Info("tx send ok")
+			if cancelled {
+				return receipt, ErrTxCancelled
+			}
+			return receipt, nil
+		}
+
+		minedNonce, err := m.conn.eth1Client.NonceAt(ctx, m.from, nil)
+		if err == nil && minedNonce > nonce {
+			// receipts of nodes may lag behind the nonce, give them a few rounds
+			nonceUsedTimes++
+			if nonceUsedTimes > 3 {
+				m.remove(nonce)
+				return nil, fmt.Errorf("nonce %d is used by a tx not sent by tx manager", nonce)
+			}
+		} else if err := m.replaceIfStuck(nonce); err != nil {
+			logrus.Warnf("replace tx of nonce %d err: %s", nonce, err.Error())
+		}
+
+		if err := utils.SleepContext(ctx, utils.RetryInterval); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("wait tx of nonce %d reach retry limit", nonce)
+}
+
+// replaceIfStuck bumps fees of the tx of nonce if it is not mined after FeeBumpAfterBlocks, or
+// cancels it if its proposals are executed. A tx that can not be bumped is rebroadcast. The mutex is
+// not held during rpc calls, a pending tx being replaced is skipped by other callers.
+func (m *TxManager) replaceIfStuck(nonce uint64) error {
+	if !m.isLeader() {
+		return nil
+	}
+	latestBlock, err := m.conn.eth1Client.BlockNumber(context.Background())
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	p, exist := m.pending[nonce]
+	if !exist || p.replacing || p.sending || latestBlock < p.SentAtBlock+m.cfg.FeeBumpAfterBlocks {
+		m.mutex.Unlock()
+		return nil
+	}
+	p.replacing = true
+	cancelled := p.Cancelled
+	proposalIds, proposalTypes := p.ProposalIds, p.ProposalTypes
+	oldTx, err := p.tx()
+	m.mutex.Unlock()
+	defer func() {
+		m.mutex.Lock()
+		p.replacing = false
+		m.mutex.Unlock()
+	}()
+	if err != nil {
+		return err
+	}
+
+	cancel := cancelled
+	if !cancel && oldTx.To() != nil && len(proposalIds) > 0 {
+		cancel, err = m.proposalsExecuted(*oldTx.To(), proposalIds)
+		if err != nil {
+			return err
+		}
+	}
+
+	// a cancel is capped by the max gas price of the proposals it cancels like a bump
+	gasTipCap, gasFeeCap, canBump, err := m.bumpedFees(oldTx, proposalTypes, m.conn.MaxGasPriceOf(proposalTypes...))
+	if err != nil {
+		return err
+	}
+	// keep the tx in mempool when it can not be replaced
+	if !canBump {
+		metrics.TxReplacements.WithLabelValues("rebroadcast").Inc()
+		if err := m.conn.eth1Client.SendTransaction(context.Background(), oldTx); err != nil {
+			logrus.Debugf("rebroadcast tx %s err: %s", oldTx.Hash(), err.Error())
+		}
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		p.SentAtBlock = latestBlock
+		return nil
+	}
+
+	var newTx *ethtypes.Transaction
+	kind := "bump"
+	if cancel {
+		kind = "cancel"
+		to := m.from
+		newTx = ethtypes.NewTx(&ethtypes.DynamicFeeTx{
+			ChainID:   m.chainId,
+			Nonce:     nonce,
+			GasTipCap: gasTipCap,
+			GasFeeCap: gasFeeCap,
+			Gas:       cancelTxGasLimit,
+			To:        &to,
+			Value:     big.NewInt(0),
+		})
+	} else {
+		newTx = ethtypes.NewTx(&ethtypes.DynamicFeeTx{
+			ChainID:   m.chainId,
+			Nonce:     nonce,
+			GasTipCap: gasTipCap,
+			GasFeeCap: gasFeeCap,
+			Gas:       oldTx.Gas(),
+			To:        oldTx.To(),
+			Value:     oldTx.Value(),
+			Data:      oldTx.Data(),
+		})
+	}
+	signedTx, err := m.conn.signer.SignTx(newTx, m.chainId)
+	if err != nil {
+		return err
+	}
+	raw, err := signedTx.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if err := m.conn.eth1Client.SendTransaction(context.Background(), signedTx); err != nil {
+		return err
+	}
+	metrics.TxReplacements.WithLabelValues(kind).Inc()
+	logrus.WithFields(logrus.Fields{
+		"nonce":     nonce,
+		"oldTxHash": oldTx.Hash().String(),
+		"newTxHash": signedTx.Hash().String(),
+		"gasFeeCap": gasFeeCap.String(),
+		"gasTipCap": gasTipCap.String(),
+		"kind":      kind,
+	}).Info("replace stuck tx")
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	p.Hashes = append(p.Hashes, signedTx.Hash())
+	p.Raw = raw
+	p.SentAtBlock = latestBlock
+	p.Cancelled = cancel
+	if _, exist := m.pending[nonce]; !exist {
+		// mined while replacing
+		return nil
+	}
+	return m.save()
+}
+
+// bumpedFees returns fees of a replacement of tx, which are bumped by FeeBumpPercent and not less than
+// the market fees of proposalTypes. canBump is false if the bumped fee exceeds maxGasPrice.
+func (m *TxManager) bumpedFees(tx *ethtypes.Transaction, proposalTypes []string, maxGasPrice *big.Int) (gasTipCap, gasFeeCap *big.Int, canBump bool, err error) {
+	bump := func(fee *big.Int) *big.Int {
+		bumped := new(big.Int).Mul(fee, big.NewInt(int64(100+m.cfg.FeeBumpPercent)))
+		return bumped.Div(bumped, big.NewInt(100))
+	}
+	gasTipCap = bump(tx.GasTipCap())
+	gasFeeCap = bump(tx.GasFeeCap())
+
+	marketTipCap, marketFeeCap, err := m.conn.SafeEstimateFee(context.Background(), proposalTypes...)
+	if err != nil {
+		var gasPriceErr *GasPriceError
+		if !errors.As(err, &gasPriceErr) {
+			return nil, nil, false, err
+		}
+		marketTipCap, marketFeeCap = tx.GasTipCap(), tx.GasFeeCap()
+	}
+	if marketTipCap.Cmp(gasTipCap) > 0 {
+		gasTipCap = marketTipCap
+	}
+	if marketFeeCap.Cmp(gasFeeCap) > 0 {
+		gasFeeCap = marketFeeCap
+	}
+	if gasFeeCap.Cmp(maxGasPrice) > 0 {
+		return nil, nil, false, nil
+	}
+	if gasTipCap.Cmp(gasFeeCap) > 0 {
+		gasTipCap = gasFeeCap
+	}
+	return gasTipCap, gasFeeCap, true, nil
+}
+
+func (m *TxManager) proposalsExecuted(networkProposal common.Address, proposalIds []common.Hash) (bool, error) {
+	caller, err := network_proposal.NewNetworkProposalCaller(networkProposal, m.conn.eth1Client)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range proposalIds {
+		p, err := caller.Proposals(&bind.CallOpts{Context: context.Background()}, id)
+		if err != nil {
+			return false, err
+		}
+		if p.Status != proposalStatusExecuted {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// remove drops the pending tx of nonce and returns whether it was cancelled.
+func (m *TxManager) remove(nonce uint64) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	p, exist := m.pending[nonce]
+	if !exist {
+		return false
+	}
+	delete(m.pending, nonce)
+	if err := m.save(); err != nil {
+		logrus.Warnf("save pending txs err: %s", err.Error())
+	}
+	return p.Cancelled
+}
+
+// PendingTxs returns pending txs ordered by nonce.
+func (m *TxManager) PendingTxs() []PendingTx {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	txs := make([]PendingTx, 0, len(m.pending))
+	for _, p := range m.pending {
+		txs = append(txs, *p)
+	}
+	sort.Slice(txs, func(i, j int) bool {
+		return txs[i].Nonce < txs[j].Nonce
+	})
+	return txs
+}
+
+// save must be called with mutex held.
+func (m *TxManager) save() error {
+	txs := make([]*PendingTx, 0, len(m.pending))
+	for _, p := range m.pending {
+		txs = append(txs, p)
+	}
+	bts, err := json.Marshal(txs)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.cfg.PendingTxPath), 0700); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(m.cfg.PendingTxPath, bts, 0600)
+}
+
+// load restores pending txs of the voter account, txs already mined are dropped by Wait.
+func (m *TxManager) load() error {
+	bts, err := os.ReadFile(m.cfg.PendingTxPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	txs := make([]*PendingTx, 0)
+	if err := json.Unmarshal(bts, &txs); err != nil {
+		return fmt.Errorf("decode pending txs err: %w", err)
+	}
+
+	txSigner := ethtypes.LatestSignerForChainID(m.chainId)
+	for _, p := range txs {
+		tx, err := p.tx()
+		if err != nil {
+			return fmt.Errorf("decode pending tx of nonce %d err: %w", p.Nonce, err)
+		}
+		sender, err := ethtypes.Sender(txSigner, tx)
+		if err != nil {
+			return fmt.Errorf("recover pending tx of nonce %d sender err: %w", p.Nonce, err)
+		}
+		// txs of another account are left by a previous config
+		if sender != m.from {
+			continue
+		}
+		m.pending[p.Nonce] = p
+	}
+	return nil
+}
+
+func sameProposals(a, b []common.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[common.Hash]struct{}, len(a))
+	for _, id := range a {
+		set[id] = struct{}{}
+	}
+	for _, id := range b {
+		if _, exist := set[id]; !exist {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,290 @@
+package connection
+
+import (
+	"context"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/signer"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBackend implements the methods of ContractBackend used by TxManager.
+type fakeBackend struct {
+	ContractBackend
+
+	mutex    sync.Mutex
+	chainId  *big.Int
+	nonce    uint64
+	block    uint64
+	sent     []*types.Transaction
+	receipts map[common.Hash]*types.Receipt
+	executed bool
+}
+
+func (b *fakeBackend) ChainID(ctx context.Context) (*big.Int, error) {
+	return b.chainId, nil
+}
+
+func (b *fakeBackend) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.nonce, nil
+}
+
+func (b *fakeBackend) BlockNumber(ctx context.Context) (uint64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.block, nil
+}
+
+func (b *fakeBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.sent = append(b.sent, tx)
+	return nil
+}
+
+func (b *fakeBackend) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if receipt, exist := b.receipts[txHash]; exist {
+		return receipt, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+// CallContract answers proposals(bytes32) of NetworkProposal, proposals are executed once executed is set.
+func (b *fakeBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ret := make([]byte, 96)
+	if b.executed {
+		ret[31] = proposalStatusExecuted
+	}
+	return ret, nil
+}
+
+func (b *fakeBackend) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(1e9), nil
+}
+
+func (b *fakeBackend) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(10e9), nil
+}
+
+func TestTxManager(t *testing.T) {
+	key, err := ethcrypto.GenerateKey()
+	assert.Nil(t, err)
+	backend := &fakeBackend{
+		chainId:  big.NewInt(17000),
+		block:    100,
+		receipts: make(map[common.Hash]*types.Receipt),
+	}
+	conn := &Connection{
+		signer:             signer.NewLocalSigner(key),
+		maxGasPrice:        big.NewInt(100e9),
+		gasPriceMultiplier: big.NewFloat(1),
+		eth1Client:         backend,
+	}
+	cfg := TxManagerConfig{
+		PendingTxPath:      filepath.Join(t.TempDir(), "pending_txs.json"),
+		FeeBumpPercent:     20,
+		FeeBumpAfterBlocks: 5,
+	}
+	m, err := newTxManager(conn, cfg)
+	assert.Nil(t, err)
+
+	to := common.HexToAddress("0x179386303fC2B51c306Ae9D961C73Ea9a9EA0C8d")
+	newTx := func(nonce uint64) *types.Transaction {
+		tx, err := conn.signer.SignTx(types.NewTx(&types.DynamicFeeTx{ChainID: backend.chainId, Nonce: nonce,
+			GasTipCap: big.NewInt(1e9), GasFeeCap: big.NewInt(20e9), Gas: 300000, To: &to, Data: []byte{1, 2, 3, 4}}), backend.chainId)
+		assert.Nil(t, err)
+		return tx
+	}
+	proposalId := [32]byte{1}
+
+	// the nonce reserved by a failed send is released
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = m.Send(ctx, newTx(0), []string{"submitBalances"}, proposalId)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, len(m.PendingTxs()))
+
+	nonce, err := m.NextNonce()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), nonce)
+	sentNonce, err := m.Send(context.Background(), newTx(nonce), []string{"submitBalances"}, proposalId)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), sentNonce)
+
+	// the same proposal is not sent twice
+	nonce, err = m.NextNonce()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), nonce)
+	sentNonce, err = m.Send(context.Background(), newTx(nonce), []string{"submitBalances"}, proposalId)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), sentNonce)
+	assert.Equal(t, 1, len(backend.sent))
+
+	// not stuck yet
+	assert.Nil(t, m.replaceIfStuck(0))
+	assert.Equal(t, 1, len(backend.sent))
+
+	// bump after blocks
+	backend.block += cfg.FeeBumpAfterBlocks
+	assert.Nil(t, m.replaceIfStuck(0))
+	assert.Equal(t, 2, len(backend.sent))
+	bumped := backend.sent[1]
+	assert.Equal(t, uint64(0), bumped.Nonce())
+	assert.Equal(t, "24000000000", bumped.GasFeeCap().String())
+	assert.Equal(t, "1200000000", bumped.GasTipCap().String())
+	assert.Equal(t, backend.sent[0].Data(), bumped.Data())
+
+	// pending txs survive restart
+	restarted, err := newTxManager(conn, cfg)
+	assert.Nil(t, err)
+	pending := restarted.PendingTxs()
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, []common.Hash{backend.sent[0].Hash(), bumped.Hash()}, pending[0].Hashes)
+
+	// the first tx is mined
+	backend.receipts[backend.sent[0].Hash()] = &types.Receipt{Status: types.ReceiptStatusSuccessful, TxHash: backend.sent[0].Hash()}
+	receipt, err := restarted.Wait(context.Background(), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, backend.sent[0].Hash(), receipt.TxHash)
+	assert.Equal(t, 0, len(restarted.PendingTxs()))
+
+	restarted, err = newTxManager(conn, cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(restarted.PendingTxs()))
+}
+
+func TestTxManagerCancel(t *testing.T) {
+	key, err := ethcrypto.GenerateKey()
+	assert.Nil(t, err)
+	backend := &fakeBackend{
+		chainId:  big.NewInt(17000),
+		block:    100,
+		receipts: make(map[common.Hash]*types.Receipt),
+	}
+	conn := &Connection{
+		signer:             signer.NewLocalSigner(key),
+		maxGasPrice:        big.NewInt(100e9),
+		gasPriceMultiplier: big.NewFloat(1),
+		eth1Client:         backend,
+	}
+	cfg := TxManagerConfig{
+		PendingTxPath:      filepath.Join(t.TempDir(), "pending_txs.json"),
+		FeeBumpPercent:     20,
+		FeeBumpAfterBlocks: 5,
+	}
+	m, err := newTxManager(conn, cfg)
+	assert.Nil(t, err)
+
+	to := common.HexToAddress("0x179386303fC2B51c306Ae9D961C73Ea9a9EA0C8d")
+	tx, err := conn.signer.SignTx(types.NewTx(&types.DynamicFeeTx{ChainID: backend.chainId, Nonce: 0,
+		GasTipCap: big.NewInt(1e9), GasFeeCap: big.NewInt(60e9), Gas: 300000, To: &to, Data: []byte{1, 2, 3, 4}}), backend.chainId)
+	assert.Nil(t, err)
+	_, err = m.Send(context.Background(), tx, []string{"submitBalances"}, [32]byte{1})
+	assert.Nil(t, err)
+
+	// a stuck tx is bumped
+	backend.block += cfg.FeeBumpAfterBlocks
+	assert.Nil(t, m.replaceIfStuck(0))
+	assert.Equal(t, 2, len(backend.sent))
+	assert.Equal(t, "72000000000", backend.sent[1].GasFeeCap().String())
+	assert.Equal(t, tx.Data(), backend.sent[1].Data())
+
+	// the tx of an executed proposal is cancelled
+	backend.executed = true
+	backend.block += cfg.FeeBumpAfterBlocks
+	assert.Nil(t, m.replaceIfStuck(0))
+	assert.Equal(t, 3, len(backend.sent))
+	cancel := backend.sent[2]
+	assert.Equal(t, conn.signer.Address(), *cancel.To())
+	assert.Equal(t, uint64(cancelTxGasLimit), cancel.Gas())
+	assert.Equal(t, "86400000000", cancel.GasFeeCap().String())
+
+	// a bump of the cancel would exceed the max gas price, the cancel is rebroadcast
+	backend.block += cfg.FeeBumpAfterBlocks
+	assert.Nil(t, m.replaceIfStuck(0))
+	assert.Equal(t, 4, len(backend.sent))
+	assert.Equal(t, cancel.Hash(), backend.sent[3].Hash())
+
+	pending := m.PendingTxs()
+	assert.Equal(t, 1, len(pending))
+	assert.True(t, pending[0].Cancelled)
+	assert.Equal(t, []common.Hash{tx.Hash(), backend.sent[1].Hash(), cancel.Hash()}, pending[0].Hashes)
+}
+
+func TestTxManagerLeadership(t *testing.T) {
+	key, err := ethcrypto.GenerateKey()
+	assert.Nil(t, err)
+	backend := &fakeBackend{
+		chainId:  big.NewInt(17000),
+		block:    100,
+		receipts: make(map[common.Hash]*types.Receipt),
+	}
+	conn := &Connection{
+		signer:             signer.NewLocalSigner(key),
+		maxGasPrice:        big.NewInt(100e9),
+		gasPriceMultiplier: big.NewFloat(1),
+		eth1Client:         backend,
+	}
+	// two instances with the same voter key share the pending tx file
+	aIsLeader, bIsLeader := true, false
+	cfg := TxManagerConfig{
+		PendingTxPath:      filepath.Join(t.TempDir(), "pending_txs.json"),
+		FeeBumpPercent:     20,
+		FeeBumpAfterBlocks: 5,
+	}
+	cfgA, cfgB := cfg, cfg
+	cfgA.IsLeader = func() bool { return aIsLeader }
+	cfgB.IsLeader = func() bool { return bIsLeader }
+	a, err := newTxManager(conn, cfgA)
+	assert.Nil(t, err)
+	b, err := newTxManager(conn, cfgB)
+	assert.Nil(t, err)
+
+	to := common.HexToAddress("0x179386303fC2B51c306Ae9D961C73Ea9a9EA0C8d")
+	tx, err := conn.signer.SignTx(types.NewTx(&types.DynamicFeeTx{ChainID: backend.chainId, Nonce: 0,
+		GasTipCap: big.NewInt(1e9), GasFeeCap: big.NewInt(20e9), Gas: 300000, To: &to, Data: []byte{1, 2, 3, 4}}), backend.chainId)
+	assert.Nil(t, err)
+
+	_, err = b.Send(context.Background(), tx, []string{"submitBalances"}, [32]byte{1})
+	assert.ErrorIs(t, err, ErrNotLeader)
+	_, err = a.Send(context.Background(), tx, []string{"submitBalances"}, [32]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(backend.sent))
+
+	// a loses the lease, b takes over the stuck tx
+	aIsLeader, bIsLeader = false, true
+	a.StepDown()
+	backend.block += cfg.FeeBumpAfterBlocks
+	assert.Nil(t, a.replaceIfStuck(0))
+	assert.Equal(t, 1, len(backend.sent))
+	_, err = a.Wait(context.Background(), 0)
+	assert.ErrorIs(t, err, ErrNotLeader)
+
+	assert.Nil(t, b.TakeOver())
+	pending := b.PendingTxs()
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, tx.Hash(), pending[0].Hashes[0])
+
+	// once the tx is mined the wait resumed by b no longer writes the pending tx file
+	backend.mutex.Lock()
+	backend.receipts[tx.Hash()] = &types.Receipt{Status: types.ReceiptStatusSuccessful, TxHash: tx.Hash()}
+	backend.mutex.Unlock()
+	_, _ = b.Wait(context.Background(), 0)
+	assert.Equal(t, 0, len(b.PendingTxs()))
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package leader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Elector makes one of the instances sharing a lease backend the leader. The leader renews the lease
+// every renewInterval, followers try to take it at the same pace and get it once the leader releases
+// it or fails to renew it within ttl.
+type Elector struct {
+	backend       LeaseBackend
+	id            string
+	ttl           time.Duration
+	renewInterval time.Duration
+	onChange      func(isLeader bool)
+
+	leader     atomic.Bool
+	validUntil atomic.Int64 // unix nano, the lease taken before it is surely not expired
+}
+
+// NewElector returns an elector of instance id, onChange is called from Run when the leadership changes.
+func NewElector(backend LeaseBackend, id string, ttl, renewInterval time.Duration, onChange func(isLeader bool)) (*Elector, error) {
+	if id == "" {
+		return nil, fmt.Errorf("instance id empty")
+	}
+	if renewInterval <= 0 || renewInterval >= ttl {
+		return nil, fmt.Errorf("renew interval %s must be positive and less than lease ttl %s", renewInterval, ttl)
+	}
+	return &Elector{
+		backend:       backend,
+		id:            id,
+		ttl:           ttl,
+		renewInterval: renewInterval,
+		onChange:      onChange,
+	}, nil
+}
+
+// DefaultInstanceId identifies this process among the instances.
+func DefaultInstanceId() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+func (e *Elector) Id() string {
+	return e.id
+}
+
+// IsLeader reports whether this instance holds a lease that has not expired. A leader whose renewals
+// keep failing stops being the leader when its lease expires, even before Run notices it.
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load() && time.Now().UnixNano() < e.validUntil.Load()
+}
+
+// Lease returns the lease in the backend, which may be held by another instance.
+func (e *Elector) Lease(ctx context.Context) (Lease, error) {
+	return e.backend.Current(ctx)
+}
+
+// Run campaigns until ctx is done, then releases the lease if it is held.
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.renewInterval)
+	defer ticker.Stop()
+	for {
+		e.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			e.resign()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign takes or renews the lease once.
+func (e *Elector) campaign(ctx context.Context) {
+	// the lease expires ttl after the write, which is after start
+	start := time.Now()
+	acquired, err := e.backend.TryAcquire(ctx, e.id, e.ttl)
+	if err != nil {
+		logrus.WithField("instance", e.id).Warnf("acquire leader lease err: %s", err.Error())
+	} else if acquired {
+		e.validUntil.Store(start.Add(e.ttl).UnixNano())
+	} else {
+		e.validUntil.Store(0)
+	}
+	e.setLeader(time.Now().UnixNano() < e.validUntil.Load())
+}
+
+func (e *Elector) resign() {
+	wasLeader := e.leader.Load()
+	e.validUntil.Store(0)
+	e.setLeader(false)
+	if !wasLeader {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.renewInterval)
+	defer cancel()
+	if err := e.backend.Release(ctx, e.id); err != nil {
+		logrus.WithField("instance", e.id).Warnf("release leader lease err: %s", err.Error())
+	}
+}
+
+func (e *Elector) setLeader(isLeader bool) {
+	if e.leader.Swap(isLeader) == isLeader {
+		return
+	}
+	log := logrus.WithField("instance", e.id)
+	if isLeader {
+		log.Info("became leader")
+	} else {
+		log.Info("became follower")
+	}
+	if e.onChange != nil {
+		e.onChange(isLeader)
+	}
+}
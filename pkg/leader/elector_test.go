@@ -0,0 +1,84 @@
+package leader
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type changes struct {
+	mutex sync.Mutex
+	list  []bool
+}
+
+func (c *changes) record(isLeader bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.list = append(c.list, isLeader)
+}
+
+func (c *changes) get() []bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]bool{}, c.list...)
+}
+
+func TestElectorFailover(t *testing.T) {
+	backend := &MemoryLease{}
+	changesA, changesB := &changes{}, &changes{}
+	a, err := NewElector(backend, "a", 200*time.Millisecond, 20*time.Millisecond, changesA.record)
+	assert.Nil(t, err)
+	b, err := NewElector(backend, "b", 200*time.Millisecond, 20*time.Millisecond, changesB.record)
+	assert.Nil(t, err)
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	doneA := make(chan struct{})
+	go func() {
+		a.Run(ctxA)
+		close(doneA)
+	}()
+	assert.Eventually(t, a.IsLeader, time.Second, 5*time.Millisecond)
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	go b.Run(ctxB)
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, a.IsLeader())
+	assert.False(t, b.IsLeader())
+
+	// a released lease is taken at the next try
+	cancelA()
+	<-doneA
+	assert.False(t, a.IsLeader())
+	assert.Eventually(t, b.IsLeader, 100*time.Millisecond, 5*time.Millisecond)
+	assert.Equal(t, []bool{true, false}, changesA.get())
+	assert.Equal(t, []bool{true}, changesB.get())
+}
+
+func TestElectorLeaseExpiry(t *testing.T) {
+	backend := &MemoryLease{}
+	ctx := context.Background()
+	a, err := NewElector(backend, "a", 100*time.Millisecond, 20*time.Millisecond, nil)
+	assert.Nil(t, err)
+	b, err := NewElector(backend, "b", 100*time.Millisecond, 20*time.Millisecond, nil)
+	assert.Nil(t, err)
+
+	a.campaign(ctx)
+	b.campaign(ctx)
+	assert.True(t, a.IsLeader())
+	assert.False(t, b.IsLeader())
+
+	// a stops renewing, it steps down on its own when the lease expires
+	time.Sleep(110 * time.Millisecond)
+	assert.False(t, a.IsLeader())
+	b.campaign(ctx)
+	assert.True(t, b.IsLeader())
+	a.campaign(ctx)
+	assert.False(t, a.IsLeader())
+
+	_, err = NewElector(backend, "c", time.Second, time.Second, nil)
+	assert.NotNil(t, err)
+}
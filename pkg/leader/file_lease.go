@@ -0,0 +1,117 @@
+// Copyright 2024 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+//go:build unix
+
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+)
+
+// FileLease keeps the lease in a json file on storage shared by the instances, such as an NFS mount.
+// Reads and writes of the lease file are serialized by an exclusive lock on a sibling lock file.
+type FileLease struct {
+	path     string
+	lockPath string
+}
+
+var _ LeaseBackend = &FileLease{}
+
+func NewFileLease(path string) (*FileLease, error) {
+	if path == "" {
+		return nil, fmt.Errorf("lease file path empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return &FileLease{path: path, lockPath: path + ".lock"}, nil
+}
+
+func (f *FileLease) TryAcquire(ctx context.Context, holder string, ttl time.Duration) (acquired bool, err error) {
+	err = f.withLock(func() error {
+		lease, err := f.read()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if lease.heldByOtherThan(holder, now) {
+			return nil
+		}
+		if err := f.write(Lease{Holder: holder, ExpiresAt: now.Add(ttl)}); err != nil {
+			return err
+		}
+		acquired = true
+		return nil
+	})
+	return acquired, err
+}
+
+func (f *FileLease) Release(ctx context.Context, holder string) error {
+	return f.withLock(func() error {
+		lease, err := f.read()
+		if err != nil {
+			return err
+		}
+		if lease.Holder != holder {
+			return nil
+		}
+		return f.write(Lease{})
+	})
+}
+
+func (f *FileLease) Current(ctx context.Context) (lease Lease, err error) {
+	err = f.withLock(func() error {
+		lease, err = f.read()
+		return err
+	})
+	return lease, err
+}
+
+func (f *FileLease) withLock(fn func() error) error {
+	lockFile, err := os.OpenFile(f.lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("lock %s err: %w", f.lockPath, err)
+	}
+	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+	return fn()
+}
+
+func (f *FileLease) read() (Lease, error) {
+	lease := Lease{}
+	bts, err := os.ReadFile(f.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return lease, nil
+		}
+		return lease, err
+	}
+	if len(bts) == 0 {
+		return lease, nil
+	}
+	if err := json.Unmarshal(bts, &lease); err != nil {
+		return lease, fmt.Errorf("decode lease file err: %w", err)
+	}
+	return lease, nil
+}
+
+func (f *FileLease) write(lease Lease) error {
+	bts, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(f.path, bts, 0600)
+}
@@ -0,0 +1,14 @@
+// Copyright 2024 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+//go:build !unix
+
+package leader
+
+import (
+	"fmt"
+)
+
+func NewFileLease(path string) (LeaseBackend, error) {
+	return nil, fmt.Errorf("file lease is not supported on this platform")
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package leader
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileLease(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "shared", "leader.lease")
+	a, err := NewFileLease(path)
+	assert.Nil(t, err)
+	b, err := NewFileLease(path)
+	assert.Nil(t, err)
+
+	acquired, err := a.TryAcquire(ctx, "a", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	acquired, err = b.TryAcquire(ctx, "b", time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+	lease, err := b.Current(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", lease.Holder)
+
+	// only the holder can release
+	assert.Nil(t, b.Release(ctx, "b"))
+	acquired, err = a.TryAcquire(ctx, "a", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	assert.Nil(t, a.Release(ctx, "a"))
+	acquired, err = b.TryAcquire(ctx, "b", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package leader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Lease is held by Holder until ExpiresAt.
+type Lease struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (l Lease) heldByOtherThan(holder string, now time.Time) bool {
+	return l.Holder != "" && l.Holder != holder && now.Before(l.ExpiresAt)
+}
+
+// LeaseBackend stores the lease shared by relay instances. Expiry is compared with local clocks,
+// so clocks of the instances must be in sync within a small fraction of ttl.
+type LeaseBackend interface {
+	// TryAcquire takes the lease for holder until ttl from now if it is free or expired, or extends it
+	// if holder already holds it. It returns whether holder holds the lease.
+	TryAcquire(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+	// Release frees the lease if holder holds it, so others can take it at once.
+	Release(ctx context.Context, holder string) error
+	// Current returns the current lease, Holder is empty if nobody has held it.
+	Current(ctx context.Context) (Lease, error)
+}
+
+// MemoryLease is a lease in memory, shared by electors of the same process. It is meant for tests.
+type MemoryLease struct {
+	mutex sync.Mutex
+	lease Lease
+}
+
+var _ LeaseBackend = &MemoryLease{}
+
+func (m *MemoryLease) TryAcquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	if m.lease.heldByOtherThan(holder, now) {
+		return false, nil
+	}
+	m.lease = Lease{Holder: holder, ExpiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (m *MemoryLease) Release(ctx context.Context, holder string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.lease.Holder == holder {
+		m.lease = Lease{}
+	}
+	return nil
+}
+
+func (m *MemoryLease) Current(ctx context.Context) (Lease, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.lease, nil
+}
@@ -6,16 +6,58 @@ import (
 	"fmt"
 	"os"
 	"sync"
+
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
 )
 
+// Version of the store schema, bump it and add a migration when the schema changes.
+//
+//	version 1: {"<address>": {"SyncedHeight": 1}}
+//	version 2: {"Version": 2, "Infos": {"<address>": {"SyncedHeight": 1, "Checkpoint": {...}}}}
+const Version = 2
+
 type Info struct {
 	SyncedHeight uint64
-	Address      string `json:"-"`
+	Checkpoint   *Checkpoint `json:",omitempty"`
+	Address      string      `json:"-"`
+}
+
+// Checkpoint holds the handler progress and the event state derived from it,
+// so a restarted relay can continue where it stopped.
+type Checkpoint struct {
+	LatestBlockOfSyncEvents      uint64
+	LatestBlockHashOfSyncEvents  string // hex, the checkpoint is dropped if the block is reorged
+	LatestSlotOfSyncBlock        uint64
+	LatestBlockOfSyncBlock       uint64
+	LatestEpochOfUpdateValidator uint64
+
+	GovDeposits       map[string][]string // pubkey(hex) -> withdrawalCredentials(hex)
+	ExitElections     []*ExitElection
+	StakerWithdrawals []*StakerWithdrawal
+}
+
+type ExitElection struct {
+	WithdrawCycle      uint64
+	ValidatorIndexList []uint64
+}
+
+type StakerWithdrawal struct {
+	WithdrawIndex      uint64
+	Address            string
+	EthAmount          string // decimals 18
+	BlockNumber        uint64
+	ClaimedBlockNumber uint64
+}
+
+type content struct {
+	Version uint64
+	Infos   map[string]Info
 }
 
 type LocalStore struct {
-	mu   sync.Mutex
-	path string
+	mu      sync.Mutex
+	path    string
+	content *content
 }
 
 func NewLocalStore(path string) (*LocalStore, error) {
@@ -30,17 +72,25 @@ func NewLocalStore(path string) (*LocalStore, error) {
 	}
 	defer f.Close()
 
+	c, migrated, err := s.readContent()
+	if err != nil {
+		return nil, fmt.Errorf("read local store file err: %w", err)
+	}
+	s.content = c
+	if migrated {
+		if err := s.writeContent(c); err != nil {
+			return nil, fmt.Errorf("migrate local store file err: %w", err)
+		}
+	}
+
 	return &s, nil
 }
 
 func (s *LocalStore) Read(addr string) (*Info, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	content, err := s.readContent()
-	if err != nil {
-		return nil, err
-	}
-	info, ok := content[addr]
+
+	info, ok := s.content.Infos[addr]
 	if !ok {
 		return nil, nil // address info does not exist
 	}
@@ -48,35 +98,97 @@ func (s *LocalStore) Read(addr string) (*Info, error) {
 	return &info, nil
 }
 
+// Update replaces the info of update.Address, the file is rewritten atomically
+// so a crash never leaves a partially written store behind.
 func (s *LocalStore) Update(update Info) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.update(update)
+}
 
-	content, err := s.readContent()
-	if err != nil {
-		return err
+// update must be called with mu held.
+func (s *LocalStore) update(update Info) error {
+	infos := make(map[string]Info, len(s.content.Infos)+1)
+	for addr, info := range s.content.Infos {
+		infos[addr] = info
 	}
-	content[update.Address] = update
+	infos[update.Address] = update
 
-	bytes, err := json.Marshal(content)
-	if err != nil {
+	c := &content{Version: Version, Infos: infos}
+	if err := s.writeContent(c); err != nil {
 		return err
 	}
-	return os.WriteFile(s.path, bytes, 0644)
+	s.content = c
+	return nil
 }
 
-func (s *LocalStore) readContent() (map[string]Info, error) {
-	content, err := os.ReadFile(s.path)
+// UpdateCheckpoint replaces the checkpoint of addr and keeps the other fields of its info.
+func (s *LocalStore) UpdateCheckpoint(addr string, checkpoint *Checkpoint) error {
+	return s.modify(addr, func(info *Info) { info.Checkpoint = checkpoint })
+}
+
+// UpdateSyncedHeight replaces the synced height of addr and keeps the other fields of its info.
+func (s *LocalStore) UpdateSyncedHeight(addr string, syncedHeight uint64) error {
+	return s.modify(addr, func(info *Info) { info.SyncedHeight = syncedHeight })
+}
+
+// modify updates the info of addr with fn, reading and writing it under mu so concurrent
+// modifications of different fields are not lost.
+func (s *LocalStore) modify(addr string, fn func(info *Info)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := s.content.Infos[addr]
+	info.Address = addr
+	fn(&info)
+	return s.update(info)
+}
+
+// readContent reads the store file and migrates it to the current version.
+// return (content, migrated, err)
+func (s *LocalStore) readContent() (*content, bool, error) {
+	bts, err := os.ReadFile(s.path)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	bts = bytes.TrimSpace(bts)
+	if len(bts) == 0 {
+		return &content{Version: Version, Infos: map[string]Info{}}, false, nil
 	}
-	content = bytes.TrimSpace(content)
-	info := map[string]Info{}
-	if len(content) == 0 {
-		return info, nil
+
+	raw := map[string]json.RawMessage{}
+	if err = json.Unmarshal(bts, &raw); err != nil {
+		return nil, false, err
+	}
+
+	// version 1 has no version field
+	if _, ok := raw["Version"]; !ok {
+		infos := map[string]Info{}
+		if err = json.Unmarshal(bts, &infos); err != nil {
+			return nil, false, err
+		}
+		return &content{Version: Version, Infos: infos}, true, nil
+	}
+
+	c := content{}
+	if err = json.Unmarshal(bts, &c); err != nil {
+		return nil, false, err
+	}
+	if c.Version > Version {
+		return nil, false, fmt.Errorf("unsupported local store version %d, max supported: %d", c.Version, Version)
 	}
-	if err = json.Unmarshal(content, &info); err != nil {
-		return nil, err
+	if c.Infos == nil {
+		c.Infos = map[string]Info{}
+	}
+	migrated := c.Version < Version
+	c.Version = Version
+	return &c, migrated, nil
+}
+
+func (s *LocalStore) writeContent(c *content) error {
+	bts, err := json.Marshal(c)
+	if err != nil {
+		return err
 	}
-	return info, nil
+	return utils.WriteFileAtomic(s.path, bts, 0644)
 }
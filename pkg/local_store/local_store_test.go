@@ -2,6 +2,8 @@ package local_store_test
 
 import (
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/local_store"
@@ -49,3 +51,90 @@ func TestReadAndUpdate(t *testing.T) {
 		assert.Equal(t, uint64(299), val.SyncedHeight)
 	}
 }
+
+func TestMigrateFromVersion1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blockstore")
+	addr := "0x179386303fC2B51c306Ae9D961C73Ea9a9EA0C8d"
+	err := os.WriteFile(path, []byte(`{"`+addr+`":{"SyncedHeight":100}}`), 0644)
+	assert.Nil(t, err)
+
+	s, err := local_store.NewLocalStore(path)
+	assert.Nil(t, err)
+
+	val, err := s.Read(addr)
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	assert.Equal(t, uint64(100), val.SyncedHeight)
+	assert.Nil(t, val.Checkpoint)
+
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), `"Version":2`)
+}
+
+func TestUpdateCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blockstore")
+	addr := "0x179386303fC2B51c306Ae9D961C73Ea9a9EA0C8d"
+	s, err := local_store.NewLocalStore(path)
+	assert.Nil(t, err)
+
+	err = s.Update(local_store.Info{
+		Address:      addr,
+		SyncedHeight: 100,
+	})
+	assert.Nil(t, err)
+
+	err = s.UpdateCheckpoint(addr, &local_store.Checkpoint{
+		LatestBlockOfSyncEvents: 200,
+		GovDeposits:             map[string][]string{"aa": {"bb"}},
+		ExitElections:           []*local_store.ExitElection{{WithdrawCycle: 3, ValidatorIndexList: []uint64{1, 2}}},
+		StakerWithdrawals:       []*local_store.StakerWithdrawal{{WithdrawIndex: 1, EthAmount: "1000"}},
+	})
+	assert.Nil(t, err)
+
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	// reopen
+	s, err = local_store.NewLocalStore(path)
+	assert.Nil(t, err)
+	val, err := s.Read(addr)
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	assert.Equal(t, uint64(100), val.SyncedHeight)
+	assert.NotNil(t, val.Checkpoint)
+	assert.Equal(t, uint64(200), val.Checkpoint.LatestBlockOfSyncEvents)
+	assert.Equal(t, []string{"bb"}, val.Checkpoint.GovDeposits["aa"])
+	assert.Equal(t, []uint64{1, 2}, val.Checkpoint.ExitElections[0].ValidatorIndexList)
+	assert.Equal(t, "1000", val.Checkpoint.StakerWithdrawals[0].EthAmount)
+
+	// concurrent updates of the synced height and the checkpoint keep each other
+	wg := sync.WaitGroup{}
+	for i := uint64(1); i <= 10; i++ {
+		i := i
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, s.UpdateSyncedHeight(addr, 100+i))
+		}()
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, s.UpdateCheckpoint(addr, &local_store.Checkpoint{LatestBlockOfSyncEvents: 200 + i}))
+		}()
+	}
+	wg.Wait()
+	val, err = s.Read(addr)
+	assert.Nil(t, err)
+	assert.Greater(t, val.SyncedHeight, uint64(100))
+	assert.NotNil(t, val.Checkpoint)
+	assert.Greater(t, val.Checkpoint.LatestBlockOfSyncEvents, uint64(200))
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blockstore")
+	err := os.WriteFile(path, []byte(`{"Version":100,"Infos":{}}`), 0644)
+	assert.Nil(t, err)
+
+	_, err = local_store.NewLocalStore(path)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,195 @@
+// Copyright 2024 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package metrics
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "lsd_relay"
+
+// proposal types of votes
+const (
+	ProposalSubmitBalances          = "submitBalances"
+	ProposalDistributeWithdrawals   = "distributeWithdrawals"
+	ProposalDistributePriorityFee   = "distributePriorityFee"
+	ProposalSetMerkleRoot           = "setMerkleRoot"
+	ProposalNotifyValidatorExit     = "notifyValidatorExit"
+	ProposalVoteWithdrawCredentials = "voteWithdrawCredentials"
+)
+
+// caches
+const (
+	CacheBeaconBlock     = "beaconBlock"
+	CacheValidatorStatus = "validatorStatus"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	HandlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "handler_duration_seconds",
+		Help:      "Duration of a handler run.",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 15, 30, 60, 180, 600},
+	}, []string{"lsd_token", "handler"})
+	HandlerFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "handler_failures_total",
+		Help:      "Number of failed handler runs.",
+	}, []string{"lsd_token", "handler"})
+	GasPriceErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gas_price_errors_total",
+		Help:      "Number of handler runs skipped for exceeding max gas price.",
+	}, []string{"lsd_token", "handler"})
+
+	RpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Latency of rpc requests to eth1 and eth2 endpoints.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"chain", "endpoint", "method"})
+	RpcFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_failures_total",
+		Help:      "Number of failed rpc requests to eth1 and eth2 endpoints.",
+	}, []string{"chain", "endpoint", "method"})
+
+	CircuitOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "endpoint_circuit_open",
+		Help:      "1 while calls skip an endpoint after its failures in a row, 0 once it answers again.",
+	}, []string{"chain", "endpoint"})
+
+	CacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_hits_total",
+		Help:      "Number of cache hits.",
+	}, []string{"cache"})
+	CacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_misses_total",
+		Help:      "Number of cache misses.",
+	}, []string{"cache"})
+	CacheSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_size",
+		Help:      "Number of entries in cache.",
+	}, []string{"cache"})
+
+	VotesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "votes_sent_total",
+		Help:      "Number of proposal votes sent, votes of a batch tx are counted separately.",
+	}, []string{"lsd_token", "proposal"})
+	VoteFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "vote_failures_total",
+		Help:      "Number of proposal votes failed while the proposal is not executed.",
+	}, []string{"lsd_token", "proposal"})
+	GasSpent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gas_spent_gwei_total",
+		Help:      "Fee spent by vote txs in Gwei, fee of a batch tx is shared by its votes.",
+	}, []string{"lsd_token", "proposal"})
+
+	TxReplacements = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tx_replacements_total",
+		Help:      "Number of stuck txs bumped, cancelled or rebroadcast.",
+	}, []string{"kind"})
+
+	DryRunProposals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dry_run_proposals_total",
+		Help:      "Number of proposals computed in dry run mode by comparison result.",
+	}, []string{"lsd_token", "proposal", "result"})
+
+	ProposalDivergences = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proposal_divergences_total",
+		Help:      "Number of own proposals diverged from the proposal leading in votes of other voters.",
+	}, []string{"lsd_token", "proposal"})
+
+	SyncLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sync_lag_blocks",
+		Help:      "Number of eth1 blocks the sync is behind the latest block.",
+	}, []string{"lsd_token", "sync"})
+
+	Reorgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reorgs_total",
+		Help:      "Number of reorgs that rolled back synced events.",
+	}, []string{"lsd_token"})
+
+	EventsSubscribed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "events_subscribed",
+		Help:      "1 if contract events and new heads are pushed by an eth1 endpoint, 0 while resubscribing.",
+	}, []string{"lsd_token"})
+
+	BeaconDisagreements = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "beacon_disagreements_total",
+		Help:      "Number of beacon queries refused because the cross-checked eth2 endpoints disagreed.",
+	}, []string{"method"})
+
+	Leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "1 if this instance is the leader sending txs, 0 if it is a follower.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HandlerDuration, HandlerFailures, GasPriceErrors,
+		RpcDuration, RpcFailures, CircuitOpen,
+		CacheHits, CacheMisses, CacheSize,
+		VotesSent, VoteFailures, GasSpent, TxReplacements, DryRunProposals, ProposalDivergences,
+		SyncLag, Reorgs, EventsSubscribed, BeaconDisagreements, Leader,
+	)
+}
+
+// Handler serves the registered metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRpc records latency of a rpc request started at start, and a failure if err is not nil.
+func ObserveRpc(chain, endpoint, method string, start time.Time, err error) {
+	RpcDuration.WithLabelValues(chain, endpoint, method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		RpcFailures.WithLabelValues(chain, endpoint, method).Inc()
+	}
+}
+
+// ObserveCache records a hit or miss of cache.
+func ObserveCache(cache string, hit bool) {
+	if hit {
+		CacheHits.WithLabelValues(cache).Inc()
+	} else {
+		CacheMisses.WithLabelValues(cache).Inc()
+	}
+}
+
+// EndpointLabel returns scheme and host of endpoint, path and query are dropped
+// as they may contain api keys.
+func EndpointLabel(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || len(u.Host) == 0 {
+		return "invalid"
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,351 @@
+package rpc_fixture
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+)
+
+// modes of a fixture store
+const (
+	ModeRecord = "record" // requests go to the endpoints, each request and its response is saved
+	ModeReplay = "replay" // requests are answered by the saved responses, the endpoints are never requested
+)
+
+// Fixture is a request to an endpoint and the responses it got, in the order they were received.
+type Fixture struct {
+	Endpoint  string     `json:"endpoint"` // name of the endpoint, such as eth1/0, so fixtures do not hold endpoint urls
+	Method    string     `json:"method"`
+	Path      string     `json:"path"` // relative to the endpoint url
+	Body      string     `json:"body,omitempty"`
+	Responses []Response `json:"responses"`
+}
+
+// Response holds a json body as it is, so fixtures stay readable, and other bodies as text. A response
+// received again right after is counted by Repeats rather than saved again, so polled requests do not
+// grow fixtures while the chain does not change.
+type Response struct {
+	Status  int             `json:"status"`
+	Body    json.RawMessage `json:"body,omitempty"`
+	Text    string          `json:"text,omitempty"`
+	Repeats int             `json:"repeats,omitempty"`
+}
+
+func newFixtureResponse(status int, body []byte) Response {
+	if json.Valid(body) {
+		return Response{Status: status, Body: body}
+	}
+	return Response{Status: status, Text: string(body)}
+}
+
+func (r Response) sameAs(other Response) bool {
+	return r.Status == other.Status && bytes.Equal(r.body(), other.body())
+}
+
+func (r Response) body() []byte {
+	if r.Body != nil {
+		// json bodies are indented within fixtures
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, r.Body); err == nil {
+			return compacted.Bytes()
+		}
+		return r.Body
+	}
+	return []byte(r.Text)
+}
+
+// Store records requests of eth1 json-rpc and beacon http endpoints into a fixture directory, or replays
+// them from it. Json-rpc ids are not part of a request, a replayed response gets the ids of the request.
+// Requests made more than once get the recorded responses in turn, then the last one again, so runs
+// replaying the same handlers see the chain advance as it did while recording.
+type Store struct {
+	dir  string
+	mode string
+
+	mutex    sync.Mutex
+	fixtures map[string]*Fixture // key -> fixture
+	replayed map[string]int      // key -> responses replayed
+}
+
+func NewStore(dir, mode string) (*Store, error) {
+	switch mode {
+	case ModeRecord:
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("create fixture dir err: %w", err)
+		}
+	case ModeReplay:
+		if isDir, err := utils.IsDir(dir); err != nil {
+			return nil, fmt.Errorf("fixture dir err: %w", err)
+		} else if !isDir {
+			return nil, fmt.Errorf("fixture dir %s is not dir", dir)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported fixture mode %s", mode)
+	}
+	return &Store{
+		dir:      dir,
+		mode:     mode,
+		fixtures: make(map[string]*Fixture),
+		replayed: make(map[string]int),
+	}, nil
+}
+
+// Transport returns the transport of requests to the endpoint named name, base sends the requests
+// being recorded, http.DefaultTransport if nil.
+func (s *Store) Transport(name, endpoint string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{store: s, name: name, endpoint: strings.TrimSuffix(endpoint, "/"), base: base}
+}
+
+type transport struct {
+	store    *Store
+	name     string
+	endpoint string
+	base     http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	fixture := &Fixture{
+		Endpoint: t.name,
+		Method:   req.Method,
+		Path:     strings.TrimPrefix(req.URL.String(), t.endpoint),
+		Body:     string(withoutIds(body)),
+	}
+
+	if t.store.mode == ModeReplay {
+		res, err := t.store.replay(fixture)
+		if err != nil {
+			return nil, err
+		}
+		return newResponse(req, res, withIdsOf(res.body(), body)), nil
+	}
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// streams never end, they are not recorded
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream") {
+		return res, nil
+	}
+	resBody, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err = t.store.record(fixture, newFixtureResponse(res.StatusCode, withoutIdsOf(resBody, body))); err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(resBody))
+	return res, nil
+}
+
+func (s *Store) record(fixture *Fixture, res Response) error {
+	key := fixture.key()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	recorded, exist := s.fixtures[key]
+	if !exist {
+		recorded = fixture
+		s.fixtures[key] = recorded
+	}
+	if n := len(recorded.Responses); n > 0 && recorded.Responses[n-1].sameAs(res) {
+		recorded.Responses[n-1].Repeats++
+	} else {
+		recorded.Responses = append(recorded.Responses, res)
+	}
+	bts, err := json.MarshalIndent(recorded, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(s.path(key), bts, 0600)
+}
+
+func (s *Store) replay(fixture *Fixture) (Response, error) {
+	key := fixture.key()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	recorded, exist := s.fixtures[key]
+	if !exist {
+		bts, err := os.ReadFile(s.path(key))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return Response{}, fmt.Errorf("no fixture of %s %s %s %s", fixture.Endpoint, fixture.Method, fixture.Path, fixture.Body)
+			}
+			return Response{}, err
+		}
+		recorded = &Fixture{}
+		if err := json.Unmarshal(bts, recorded); err != nil {
+			return Response{}, fmt.Errorf("decode fixture %s err: %w", s.path(key), err)
+		}
+		if len(recorded.Responses) == 0 {
+			return Response{}, fmt.Errorf("fixture %s has no responses", s.path(key))
+		}
+		s.fixtures[key] = recorded
+	}
+	n := s.replayed[key]
+	s.replayed[key]++
+	for _, res := range recorded.Responses {
+		if n <= res.Repeats {
+			return res, nil
+		}
+		n -= res.Repeats + 1
+	}
+	return recorded.Responses[len(recorded.Responses)-1], nil
+}
+
+func (s *Store) path(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
+
+func (f *Fixture) key() string {
+	h := sha256.New()
+	for _, part := range []string{f.Endpoint, f.Method, f.Path, f.Body} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func newResponse(req *http.Request, res Response, body []byte) *http.Response {
+	status := res.Status
+	contentType := "application/json"
+	if res.Body == nil {
+		contentType = "text/plain"
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{contentType}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+type jsonRpcMessage = map[string]json.RawMessage
+
+// jsonRpcMessages decodes a json-rpc request or response, batch reports whether it is a batch.
+func jsonRpcMessages(body []byte) (msgs []jsonRpcMessage, batch bool, ok bool) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, false, false
+	}
+	if trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &msgs); err != nil {
+			return nil, false, false
+		}
+		batch = true
+	} else {
+		msg := jsonRpcMessage{}
+		if err := json.Unmarshal(trimmed, &msg); err != nil {
+			return nil, false, false
+		}
+		msgs = []jsonRpcMessage{msg}
+	}
+	for _, msg := range msgs {
+		if _, exist := msg["jsonrpc"]; !exist {
+			return nil, false, false
+		}
+	}
+	return msgs, batch, true
+}
+
+func marshalMessages(msgs []jsonRpcMessage, batch bool) []byte {
+	var bts []byte
+	if batch {
+		bts, _ = json.Marshal(msgs)
+	} else {
+		bts, _ = json.Marshal(msgs[0])
+	}
+	return bts
+}
+
+// withoutIds returns a json-rpc request without its ids, other bodies as they are.
+func withoutIds(body []byte) []byte {
+	msgs, batch, ok := jsonRpcMessages(body)
+	if !ok {
+		return body
+	}
+	for _, msg := range msgs {
+		delete(msg, "id")
+	}
+	return marshalMessages(msgs, batch)
+}
+
+// withoutIdsOf returns the json-rpc response of request without ids, responses of a batch in the order of
+// its requests.
+func withoutIdsOf(response, request []byte) []byte {
+	resMsgs, batch, ok := jsonRpcMessages(response)
+	if !ok {
+		return response
+	}
+	reqMsgs, _, ok := jsonRpcMessages(request)
+	if !ok {
+		return response
+	}
+	position := make(map[string]int, len(reqMsgs))
+	for i, msg := range reqMsgs {
+		position[string(msg["id"])] = i
+	}
+	ordered := make([]jsonRpcMessage, len(reqMsgs))
+	for _, msg := range resMsgs {
+		i, exist := position[string(msg["id"])]
+		if !exist || ordered[i] != nil {
+			return response
+		}
+		delete(msg, "id")
+		ordered[i] = msg
+	}
+	for _, msg := range ordered {
+		if msg == nil {
+			return response
+		}
+	}
+	return marshalMessages(ordered, batch)
+}
+
+// withIdsOf returns a response recorded by withoutIdsOf with the ids of request.
+func withIdsOf(response, request []byte) []byte {
+	resMsgs, batch, ok := jsonRpcMessages(response)
+	if !ok {
+		return response
+	}
+	reqMsgs, _, ok := jsonRpcMessages(request)
+	if !ok || len(reqMsgs) != len(resMsgs) {
+		return response
+	}
+	for i, msg := range resMsgs {
+		if id, exist := reqMsgs[i]["id"]; exist {
+			msg["id"] = id
+		}
+	}
+	return marshalMessages(resMsgs, batch)
+}
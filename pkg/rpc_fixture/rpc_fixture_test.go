@@ -0,0 +1,195 @@
+package rpc_fixture_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/rpc_fixture"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type rpcMessage struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Method  string          `json:"method,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+}
+
+// newNode serves eth_blockNumber advancing on each call, eth_chainId, batches answered in reverse order,
+// and a beacon path
+func newNode(t *testing.T) *httptest.Server {
+	var blockNumber atomic.Uint64
+	answer := func(req rpcMessage) rpcMessage {
+		res := rpcMessage{Jsonrpc: "2.0", Id: req.Id}
+		switch req.Method {
+		case "eth_blockNumber":
+			res.Result = fmt.Sprintf("0x%x", blockNumber.Add(1))
+		case "eth_chainId":
+			res.Result = "0x1"
+		}
+		return res
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"data":{"path":%q}}`, r.URL.Path)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/json")
+		var batch []rpcMessage
+		if json.Unmarshal(body, &batch) == nil {
+			res := make([]rpcMessage, 0, len(batch))
+			for i := len(batch) - 1; i >= 0; i-- {
+				res = append(res, answer(batch[i]))
+			}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		var req rpcMessage
+		require.NoError(t, json.Unmarshal(body, &req))
+		json.NewEncoder(w).Encode(answer(req))
+	}))
+}
+
+type run struct {
+	blockNumbers []string
+	chainId      string
+	batch        []string
+	beacon       string
+}
+
+func runRequests(t *testing.T, store *rpc_fixture.Store, endpoint string) (run, error) {
+	var r run
+	client, err := rpc.DialOptions(context.Background(), endpoint,
+		rpc.WithHTTPClient(&http.Client{Transport: store.Transport("eth1/0", endpoint, nil)}))
+	require.NoError(t, err)
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		var blockNumber string
+		if err := client.Call(&blockNumber, "eth_blockNumber"); err != nil {
+			return r, err
+		}
+		r.blockNumbers = append(r.blockNumbers, blockNumber)
+	}
+	if err := client.Call(&r.chainId, "eth_chainId"); err != nil {
+		return r, err
+	}
+	r.batch = make([]string, 2)
+	batch := []rpc.BatchElem{
+		{Method: "eth_chainId", Result: &r.batch[0]},
+		{Method: "eth_blockNumber", Result: &r.batch[1]},
+	}
+	if err := client.BatchCall(batch); err != nil {
+		return r, err
+	}
+	for _, elem := range batch {
+		if elem.Error != nil {
+			return r, elem.Error
+		}
+	}
+
+	beaconClient := &http.Client{Transport: store.Transport("eth2/0", endpoint, nil)}
+	res, err := beaconClient.Get(endpoint + "/eth/v1/beacon/genesis")
+	if err != nil {
+		return r, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	r.beacon = string(body)
+	return r, nil
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	node := newNode(t)
+
+	recordStore, err := rpc_fixture.NewStore(dir, rpc_fixture.ModeRecord)
+	require.NoError(t, err)
+	recorded, err := runRequests(t, recordStore, node.URL)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0x1", "0x2", "0x3"}, recorded.blockNumbers)
+	assert.Equal(t, "0x1", recorded.chainId)
+	assert.Equal(t, []string{"0x1", "0x4"}, recorded.batch)
+	node.Close()
+
+	// fixtures hold no endpoint urls, so the replay runs against any endpoint
+	replayStore, err := rpc_fixture.NewStore(dir, rpc_fixture.ModeReplay)
+	require.NoError(t, err)
+	replayed, err := runRequests(t, replayStore, node.URL)
+	require.NoError(t, err)
+	assert.Equal(t, recorded, replayed)
+
+	// repeated requests get the last recorded response once the recorded ones are used up
+	client, err := rpc.DialOptions(context.Background(), "http://localhost:1",
+		rpc.WithHTTPClient(&http.Client{Transport: replayStore.Transport("eth1/0", "http://localhost:1", nil)}))
+	require.NoError(t, err)
+	defer client.Close()
+	var blockNumber string
+	require.NoError(t, client.Call(&blockNumber, "eth_blockNumber"))
+	assert.Equal(t, "0x3", blockNumber)
+
+	// requests not recorded fail
+	assert.Error(t, client.Call(&blockNumber, "eth_gasPrice"))
+	_, err = rpc_fixture.NewStore(dir+"/missing", rpc_fixture.ModeReplay)
+	assert.Error(t, err)
+}
+
+func TestRecordRepeats(t *testing.T) {
+	dir := t.TempDir()
+	var calls atomic.Uint64
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req rpcMessage
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		// the block advances every third call
+		res := rpcMessage{Jsonrpc: "2.0", Id: req.Id, Result: fmt.Sprintf("0x%x", calls.Add(1)/3)}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer node.Close()
+
+	blockNumbers := func(store *rpc_fixture.Store, endpoint string) []string {
+		client, err := rpc.DialOptions(context.Background(), endpoint,
+			rpc.WithHTTPClient(&http.Client{Transport: store.Transport("eth1/0", endpoint, nil)}))
+		require.NoError(t, err)
+		defer client.Close()
+		numbers := make([]string, 7)
+		for i := range numbers {
+			require.NoError(t, client.Call(&numbers[i], "eth_blockNumber"))
+		}
+		return numbers
+	}
+
+	recordStore, err := rpc_fixture.NewStore(dir, rpc_fixture.ModeRecord)
+	require.NoError(t, err)
+	recorded := blockNumbers(recordStore, node.URL)
+	assert.Equal(t, []string{"0x0", "0x0", "0x1", "0x1", "0x1", "0x2", "0x2"}, recorded)
+
+	// identical responses in a row are saved once
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	bts, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.NoError(t, err)
+	var fixture rpc_fixture.Fixture
+	require.NoError(t, json.Unmarshal(bts, &fixture))
+	require.Len(t, fixture.Responses, 3)
+	assert.Equal(t, []int{1, 2, 1}, []int{fixture.Responses[0].Repeats, fixture.Responses[1].Repeats, fixture.Responses[2].Repeats})
+
+	replayStore, err := rpc_fixture.NewStore(dir, rpc_fixture.ModeReplay)
+	require.NoError(t, err)
+	assert.Equal(t, recorded, blockNumbers(replayStore, "http://localhost:1"))
+}
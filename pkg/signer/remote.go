@@ -0,0 +1,180 @@
+// Copyright 2024 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package signer
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/samber/lo"
+)
+
+// json-rpc apis of remote signers
+const (
+	RemoteApiWeb3Signer = "web3signer" // eth_accounts, eth_signTransaction, eth_sign
+	RemoteApiClef       = "clef"       // account_list, account_signTransaction, account_signData
+)
+
+const remoteSignTimeout = 30 * time.Second
+
+// RemoteSigner signs with a json-rpc signer such as Web3Signer or Clef, so no key is kept on the relay host.
+type RemoteSigner struct {
+	client  *rpc.Client
+	api     string
+	address common.Address
+}
+
+// sendTxArgs is the tx accepted by eth_signTransaction and account_signTransaction.
+type sendTxArgs struct {
+	From                 common.Address  `json:"from"`
+	To                   *common.Address `json:"to"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	GasPrice             *hexutil.Big    `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas,omitempty"`
+	Value                hexutil.Big     `json:"value"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	Data                 hexutil.Bytes   `json:"data"`
+	ChainID              *hexutil.Big    `json:"chainId,omitempty"`
+}
+
+// clefSignTxResult is the result of account_signTransaction.
+type clefSignTxResult struct {
+	Raw hexutil.Bytes `json:"raw"`
+}
+
+// NewRemoteSigner connects to the remote signer at endpoint and checks it manages address.
+func NewRemoteSigner(endpoint, api string, address common.Address) (*RemoteSigner, error) {
+	if len(api) == 0 {
+		api = RemoteApiWeb3Signer
+	}
+	if api != RemoteApiWeb3Signer && api != RemoteApiClef {
+		return nil, fmt.Errorf("unsupported remote signer api %s", api)
+	}
+	client, err := rpc.Dial(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("dial remote signer err: %w", err)
+	}
+	s := &RemoteSigner{
+		client:  client,
+		api:     api,
+		address: address,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), remoteSignTimeout)
+	defer cancel()
+	method := "eth_accounts"
+	if api == RemoteApiClef {
+		method = "account_list"
+	}
+	var addresses []common.Address
+	if err := client.CallContext(ctx, &addresses, method); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("list remote signer accounts err: %w", err)
+	}
+	if !lo.Contains(addresses, address) {
+		client.Close()
+		return nil, fmt.Errorf("account %s not managed by remote signer", address)
+	}
+	return s, nil
+}
+
+func (s *RemoteSigner) Address() common.Address {
+	return s.address
+}
+
+// SignTx signs tx remotely, the signed tx is checked against tx and the signer address
+// as the remote signer is not trusted to sign what we asked for.
+func (s *RemoteSigner) SignTx(tx *types.Transaction, chainId *big.Int) (*types.Transaction, error) {
+	args := sendTxArgs{
+		From:  s.address,
+		To:    tx.To(),
+		Gas:   hexutil.Uint64(tx.Gas()),
+		Value: hexutil.Big(*tx.Value()),
+		Nonce: hexutil.Uint64(tx.Nonce()),
+		Data:  tx.Data(),
+	}
+	if tx.Type() == types.LegacyTxType {
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	} else {
+		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), remoteSignTimeout)
+	defer cancel()
+	var raw hexutil.Bytes
+	switch s.api {
+	case RemoteApiClef:
+		args.ChainID = (*hexutil.Big)(chainId)
+		var result clefSignTxResult
+		if err := s.client.CallContext(ctx, &result, "account_signTransaction", args); err != nil {
+			return nil, fmt.Errorf("remote sign tx err: %w", err)
+		}
+		raw = result.Raw
+	default:
+		if err := s.client.CallContext(ctx, &raw, "eth_signTransaction", args); err != nil {
+			return nil, fmt.Errorf("remote sign tx err: %w", err)
+		}
+	}
+
+	signedTx := new(types.Transaction)
+	if err := signedTx.UnmarshalBinary(raw); err != nil {
+		return nil, fmt.Errorf("decode remote signed tx err: %w", err)
+	}
+	txSigner := types.LatestSignerForChainID(chainId)
+	if txSigner.Hash(signedTx) != txSigner.Hash(tx) {
+		return nil, fmt.Errorf("remote signed tx %s not match", signedTx.Hash())
+	}
+	sender, err := types.Sender(txSigner, signedTx)
+	if err != nil {
+		return nil, fmt.Errorf("recover remote signed tx sender err: %w", err)
+	}
+	if sender != s.address {
+		return nil, fmt.Errorf("remote signed tx sender %s not match %s", sender, s.address)
+	}
+	return signedTx, nil
+}
+
+func (s *RemoteSigner) SignText(data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteSignTimeout)
+	defer cancel()
+
+	var sig hexutil.Bytes
+	var err error
+	switch s.api {
+	case RemoteApiClef:
+		err = s.client.CallContext(ctx, &sig, "account_signData", "text/plain", s.address, hexutil.Bytes(data))
+	default:
+		err = s.client.CallContext(ctx, &sig, "eth_sign", s.address, hexutil.Bytes(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("remote sign text err: %w", err)
+	}
+	if len(sig) != 65 {
+		return nil, fmt.Errorf("remote signature length %d invalid", len(sig))
+	}
+	// remote signers return V as 27 or 28
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	signer, err := RecoverText(data, sig)
+	if err != nil {
+		return nil, fmt.Errorf("recover remote signature err: %w", err)
+	}
+	if signer != s.address {
+		return nil, fmt.Errorf("remote signature signer %s not match %s", signer, s.address)
+	}
+	return sig, nil
+}
+
+func (s *RemoteSigner) Close() {
+	s.client.Close()
+}
@@ -0,0 +1,148 @@
+package signer_test
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/signer"
+	"github.com/stretchr/testify/assert"
+)
+
+var testChainId = big.NewInt(17000)
+
+type rpcRequest struct {
+	Id     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type txArgs struct {
+	To                   *common.Address `json:"to"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	GasPrice             *hexutil.Big    `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas"`
+	Value                hexutil.Big     `json:"value"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	Data                 hexutil.Bytes   `json:"data"`
+}
+
+// newSignerServer stands in for a remote signer holding key, tamper changes the nonce of signed txs.
+func newSignerServer(t *testing.T, key *ecdsa.PrivateKey, tamper bool) *httptest.Server {
+	address := ethcrypto.PubkeyToAddress(key.PublicKey)
+	signTx := func(param json.RawMessage) hexutil.Bytes {
+		args := txArgs{}
+		assert.Nil(t, json.Unmarshal(param, &args))
+		nonce := uint64(args.Nonce)
+		if tamper {
+			nonce++
+		}
+		var txData types.TxData
+		if args.MaxFeePerGas != nil {
+			txData = &types.DynamicFeeTx{ChainID: testChainId, Nonce: nonce, GasTipCap: args.MaxPriorityFeePerGas.ToInt(),
+				GasFeeCap: args.MaxFeePerGas.ToInt(), Gas: uint64(args.Gas), To: args.To, Value: args.Value.ToInt(), Data: args.Data}
+		} else {
+			txData = &types.LegacyTx{Nonce: nonce, GasPrice: args.GasPrice.ToInt(), Gas: uint64(args.Gas), To: args.To,
+				Value: args.Value.ToInt(), Data: args.Data}
+		}
+		tx, err := types.SignNewTx(key, types.LatestSignerForChainID(testChainId), txData)
+		assert.Nil(t, err)
+		raw, err := tx.MarshalBinary()
+		assert.Nil(t, err)
+		return raw
+	}
+	signText := func(param json.RawMessage) hexutil.Bytes {
+		data := hexutil.Bytes{}
+		assert.Nil(t, json.Unmarshal(param, &data))
+		sig, err := signer.NewLocalSigner(key).SignText(data)
+		assert.Nil(t, err)
+		sig[64] += 27
+		return sig
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := rpcRequest{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+
+		var result interface{}
+		switch req.Method {
+		case "eth_accounts", "account_list":
+			result = []common.Address{address}
+		case "eth_signTransaction":
+			result = signTx(req.Params[0])
+		case "account_signTransaction":
+			result = map[string]interface{}{"raw": signTx(req.Params[0])}
+		case "eth_sign":
+			result = signText(req.Params[1])
+		case "account_signData":
+			result = signText(req.Params[2])
+		default:
+			t.Errorf("unexpected method %s", req.Method)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		assert.Nil(t, json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": result}))
+	}))
+}
+
+func TestRemoteSigner(t *testing.T) {
+	key, err := ethcrypto.GenerateKey()
+	assert.Nil(t, err)
+	address := ethcrypto.PubkeyToAddress(key.PublicKey)
+	to := common.HexToAddress("0x179386303fC2B51c306Ae9D961C73Ea9a9EA0C8d")
+
+	txs := []*types.Transaction{
+		types.NewTx(&types.DynamicFeeTx{ChainID: testChainId, Nonce: 7, GasTipCap: big.NewInt(1e9), GasFeeCap: big.NewInt(30e9),
+			Gas: 300000, To: &to, Value: big.NewInt(0), Data: []byte{1, 2, 3, 4}}),
+		types.NewTx(&types.LegacyTx{Nonce: 8, GasPrice: big.NewInt(20e9), Gas: 21000, To: &to, Value: big.NewInt(1)}),
+	}
+
+	for _, api := range []string{signer.RemoteApiWeb3Signer, signer.RemoteApiClef} {
+		t.Run(api, func(t *testing.T) {
+			server := newSignerServer(t, key, false)
+			defer server.Close()
+
+			s, err := signer.NewRemoteSigner(server.URL, api, address)
+			assert.Nil(t, err)
+			defer s.Close()
+
+			for _, tx := range txs {
+				signedTx, err := s.SignTx(tx, testChainId)
+				assert.Nil(t, err)
+				sender, err := types.Sender(types.LatestSignerForChainID(testChainId), signedTx)
+				assert.Nil(t, err)
+				assert.Equal(t, address, sender)
+				assert.Equal(t, tx.Nonce(), signedTx.Nonce())
+			}
+
+			data := ethcrypto.Keccak256([]byte("snapshot"))
+			sig, err := s.SignText(data)
+			assert.Nil(t, err)
+			recovered, err := signer.RecoverText(data, sig)
+			assert.Nil(t, err)
+			assert.Equal(t, address, recovered)
+
+			_, err = signer.NewRemoteSigner(server.URL, api, to)
+			assert.NotNil(t, err)
+		})
+	}
+
+	t.Run("tampered", func(t *testing.T) {
+		server := newSignerServer(t, key, true)
+		defer server.Close()
+
+		s, err := signer.NewRemoteSigner(server.URL, signer.RemoteApiWeb3Signer, address)
+		assert.Nil(t, err)
+		defer s.Close()
+
+		_, err = s.SignTx(txs[0], testChainId)
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package signer
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/stafiprotocol/chainbridge/utils/crypto/secp256k1"
+)
+
+// signer types of config
+const (
+	TypeKeystore = "keystore" // keystore of gen_account, default
+	TypeRemote   = "remote"   // web3signer or clef
+	TypeKeyFile  = "keyfile"  // web3 secret storage key file, password from env
+)
+
+const DefaultPasswordEnv = "LSD_RELAY_KEY_PASSWORD"
+
+// Signer signs txs and messages of the voter account.
+type Signer interface {
+	Address() common.Address
+	// SignTx returns tx signed for chainId.
+	SignTx(tx *types.Transaction, chainId *big.Int) (*types.Transaction, error)
+	// SignText returns the EIP-191 personal signature of data, in [R || S || V] format where V is 0 or 1.
+	SignText(data []byte) ([]byte, error)
+}
+
+// LocalSigner signs with a private key held in memory.
+type LocalSigner struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+func NewLocalSigner(key *ecdsa.PrivateKey) *LocalSigner {
+	return &LocalSigner{
+		key:     key,
+		address: ethcrypto.PubkeyToAddress(key.PublicKey),
+	}
+}
+
+// NewKeypairSigner returns a signer of keypair loaded from the keystore.
+func NewKeypairSigner(kp *secp256k1.Keypair) *LocalSigner {
+	return NewLocalSigner(kp.PrivateKey())
+}
+
+// NewKeyFileSigner decrypts a web3 secret storage key file with the password in env passwordEnv,
+// the env is unset after reading.
+func NewKeyFileSigner(path, passwordEnv string) (*LocalSigner, error) {
+	if len(passwordEnv) == 0 {
+		passwordEnv = DefaultPasswordEnv
+	}
+	password, exist := os.LookupEnv(passwordEnv)
+	if !exist {
+		return nil, fmt.Errorf("password env %s of key file not set", passwordEnv)
+	}
+	os.Unsetenv(passwordEnv)
+
+	keyJson, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file err: %w", err)
+	}
+	key, err := keystore.DecryptKey(keyJson, password)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt key file err: %w", err)
+	}
+	return NewLocalSigner(key.PrivateKey), nil
+}
+
+func (s *LocalSigner) Address() common.Address {
+	return s.address
+}
+
+func (s *LocalSigner) SignTx(tx *types.Transaction, chainId *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, types.LatestSignerForChainID(chainId), s.key)
+}
+
+func (s *LocalSigner) SignText(data []byte) ([]byte, error) {
+	return ethcrypto.Sign(accounts.TextHash(data), s.key)
+}
+
+// RecoverText returns the address that signed data with SignText.
+func RecoverText(data, sig []byte) (common.Address, error) {
+	pubkey, err := ethcrypto.SigToPub(accounts.TextHash(data), sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return ethcrypto.PubkeyToAddress(*pubkey), nil
+}
@@ -1,11 +1,7 @@
 package utils
 
 import (
-	"encoding/json"
-	"fmt"
-	"io"
 	"math/big"
-	"net/http"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -107,86 +103,6 @@ func EndSlotOfEpoch(config beacon.Eth2Config, epoch uint64) uint64 {
 	return config.SlotsPerEpoch*(epoch+1) - 1
 }
 
-func GetGaspriceFromEthgasstation() (base, priority uint64, err error) {
-	rsp, err := http.Get("https://api.ethgasstation.info/api/fee-estimate")
-	if err != nil {
-		return 0, 0, err
-	}
-	defer rsp.Body.Close()
-	if rsp.StatusCode != http.StatusOK {
-		return 0, 0, fmt.Errorf("status err %d", rsp.StatusCode)
-	}
-	bodyBytes, err := io.ReadAll(rsp.Body)
-	if err != nil {
-		return 0, 0, err
-	}
-	if len(bodyBytes) == 0 {
-		return 0, 0, fmt.Errorf("bodyBytes zero err")
-	}
-	resGasPrice := ResGasPrice{}
-	err = json.Unmarshal(bodyBytes, &resGasPrice)
-	if err != nil {
-		return 0, 0, err
-	}
-	return uint64(resGasPrice.BaseFee), uint64(resGasPrice.PriorityFee.Fast), nil
-}
-
-type ResGasPrice struct {
-	BaseFee     int     `json:"baseFee"`
-	BlockNumber int     `json:"blockNumber"`
-	BlockTime   float64 `json:"blockTime"`
-	GasPrice    struct {
-		Fast     int `json:"fast"`
-		Instant  int `json:"instant"`
-		Standard int `json:"standard"`
-	} `json:"gasPrice"`
-	NextBaseFee int `json:"nextBaseFee"`
-	PriorityFee struct {
-		Fast     int `json:"fast"`
-		Instant  int `json:"instant"`
-		Standard int `json:"standard"`
-	} `json:"priorityFee"`
-}
-
-func GetGaspriceFromBeacon() (base uint64, err error) {
-	rsp, err := http.Get("https://beaconcha.in/api/v1/execution/gasnow")
-	if err != nil {
-		return 0, err
-	}
-	defer rsp.Body.Close()
-
-	if rsp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("status err %d", rsp.StatusCode)
-	}
-
-	bodyBytes, err := io.ReadAll(rsp.Body)
-	if err != nil {
-		return 0, err
-	}
-	if len(bodyBytes) == 0 {
-		return 0, fmt.Errorf("bodyBytes zero err")
-	}
-	resGasPrice := ResGasPriceFromBeacon{}
-	err = json.Unmarshal(bodyBytes, &resGasPrice)
-	if err != nil {
-		return 0, err
-	}
-
-	return uint64(resGasPrice.Data.Standard), nil
-}
-
-type ResGasPriceFromBeacon struct {
-	Code int `json:"code"`
-	Data struct {
-		Rapid     int64   `json:"rapid"`
-		Fast      int64   `json:"fast"`
-		Standard  int64   `json:"standard"`
-		Slow      int64   `json:"slow"`
-		Timestamp int64   `json:"timestamp"`
-		PriceUSD  float64 `json:"priceUSD"`
-	} `json:"data"`
-}
-
 // bytes32 proposalId = keccak256(abi.encodePacked("execProposal", _to, _callData, _proposalFactor));
 func ProposalId(to common.Address, callData []byte, proposalFactor *big.Int) [32]byte {
 	return crypto.Keccak256Hash([]byte("execProposal"), to.Bytes(), callData, common.LeftPadBytes(proposalFactor.Bytes(), 32))
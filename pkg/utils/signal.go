@@ -5,21 +5,52 @@ package utils
 
 import (
 	"context"
-	"github.com/sirupsen/logrus"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
 )
 
 // shutdownRequestChannel is used to initiate shutdown from one of the
 // subsystems using the same code paths as when an interrupt signal is received.
-var ShutdownRequestChannel = make(chan struct{})
+// It is buffered and only the first request is kept, so requesters never block.
+var shutdownRequestChannel = make(chan string, 1)
+
+var (
+	shutdownReasonMutex sync.Mutex
+	shutdownReason      string
+)
 
 // interruptSignals defines the default signals to catch in order to do a proper
 // shutdown.  This may be modified during init depending on the platform.
-var interruptSignals = []os.Signal{os.Interrupt}
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// RequestShutdown asks ShutdownListener to shut down for reason, requests after the first one are dropped.
+func RequestShutdown(reason string) {
+	select {
+	case shutdownRequestChannel <- reason:
+	default:
+		logrus.Infof("Shutdown requested (%s).  Already shutting down...", reason)
+	}
+}
+
+// ShutdownReason returns why the shutdown started, empty before it.
+func ShutdownReason() string {
+	shutdownReasonMutex.Lock()
+	defer shutdownReasonMutex.Unlock()
+	return shutdownReason
+}
+
+func setShutdownReason(reason string) {
+	shutdownReasonMutex.Lock()
+	defer shutdownReasonMutex.Unlock()
+	shutdownReason = reason
+}
 
 // shutdowntListener listens for OS Signals such as SIGINT (Ctrl+C) and shutdown
-// requests from shutdownRequestChannel.  It returns a context that is canceled
+// requests from RequestShutdown.  It returns a context that is canceled
 // when either signal is received.
 func ShutdownListener() context.Context {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -32,24 +63,19 @@ func ShutdownListener() context.Context {
 		case sig := <-interruptChannel:
 			logrus.Infof("Received signal (%s).  Shutting down...",
 				sig)
+			setShutdownReason("signal " + sig.String())
 
-		case <-ShutdownRequestChannel:
-			logrus.Infof("Shutdown requested.  Shutting down...")
+		case reason := <-shutdownRequestChannel:
+			logrus.Infof("Shutdown requested (%s).  Shutting down...", reason)
+			setShutdownReason(reason)
 		}
 		cancel()
 		// Listen for repeated signals and display a message so the user
 		// knows the shutdown is in progress and the process is not
 		// hung.
-		for {
-			select {
-			case sig := <-interruptChannel:
-				logrus.Infof("Received signal (%s).  Already "+
-					"shutting down...", sig)
-
-			case <-ShutdownRequestChannel:
-				logrus.Info("Shutdown requested.  Already " +
-					"shutting down...")
-			}
+		for sig := range interruptChannel {
+			logrus.Infof("Received signal (%s).  Already "+
+				"shutting down...", sig)
 		}
 	}()
 
@@ -1,13 +1,29 @@
 package utils
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 const Day = time.Hour * 24
 
 func Sleep(stop <-chan struct{}, dur time.Duration) {
 	t := time.NewTimer(dur)
+	defer t.Stop()
 	select {
 	case <-stop:
 	case <-t.C:
 	}
 }
+
+// SleepContext sleeps for dur, it returns the error of ctx if ctx is done first.
+func SleepContext(ctx context.Context, dur time.Duration) error {
+	t := time.NewTimer(dur)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
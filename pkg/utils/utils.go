@@ -202,6 +202,38 @@ func AppendToFile(filePath, content string) error {
 	return writer.Flush()
 }
 
+// WriteFileAtomic writes data to a temp file and renames it to filePath,
+// so readers never see a partially written file.
+func WriteFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	tmpPath := filePath + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, filePath); err != nil {
+		return err
+	}
+
+	// persist the rename
+	dir, err := os.Open(filepath.Dir(filePath))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
 func ReadLastLine(filePath string) (string, error) {
 	// make sure the dir is existed, eg:
 	// ./foo/bar/baz/hello.log must make sure ./foo/bar/baz is existed
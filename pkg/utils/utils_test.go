@@ -143,27 +143,6 @@ func TestDecodeInputData(t *testing.T) {
 	// utils_test.go:222: 4146766312500000 855681937500000 263286750000000
 }
 
-// 0x04df80 319360
-// 0x039fc6d02bbbc0 1020101175000000
-
-func TestGetGas(t *testing.T) {
-	base, err := utils.GetGaspriceFromBeacon()
-	if err != nil {
-		t.Log(err)
-	}
-	t.Log(base)
-	client, err := ethclient.Dial("https://mainnet-rpc.wetez.io/eth/v1/601083a01bf2f40729c5f75e62042208")
-	if err != nil {
-		t.Fatal(err)
-	}
-	gasTip, err := client.SuggestGasTipCap(context.Background())
-	if err != nil {
-		t.Log(err)
-	}
-
-	t.Log(gasTip)
-}
-
 func TestTx(t *testing.T) {
 	t.Log("3")
 	client, err := ethclient.Dial("https://goerli.infura.io/v3/b3611f564322439ab2491e04ddd55b39")
@@ -212,7 +191,7 @@ func TestConfig(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	config, err := c.GetEth2Config()
+	config, err := c.GetEth2Config(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+)
+
+type VoterStatus struct {
+	Address    string `json:"address"`
+	Balance    string `json:"balance,omitempty"` // ether
+	BalanceErr string `json:"balanceErr,omitempty"`
+}
+
+type LeaderStatus struct {
+	Instance       string    `json:"instance"`
+	IsLeader       bool      `json:"isLeader"`
+	Holder         string    `json:"holder,omitempty"`
+	LeaseExpiresAt time.Time `json:"leaseExpiresAt"`
+	LeaseErr       string    `json:"leaseErr,omitempty"`
+}
+
+type ManagerStatus struct {
+	Started       bool                        `json:"started"`
+	Voter         *VoterStatus                `json:"voter,omitempty"`
+	Leader        *LeaderStatus               `json:"leader,omitempty"`
+	Eth1Endpoints []connection.EndpointStatus `json:"eth1Endpoints"`
+	Eth2Endpoints []connection.EndpointStatus `json:"eth2Endpoints"`
+	Services      []ServiceStatus             `json:"services"`
+}
+
+type probeResponse struct {
+	Status  string   `json:"status"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// startApiServer serves the status api on cfg.ApiListenAddr, it is disabled if the address is empty.
+//
+//	GET /livez             liveness probe
+//	GET /readyz            readiness probe
+//	GET /status            status of voter, endpoints and all lsd token services
+//	GET /status/{lsdToken} status of one lsd token service
+//	GET /metrics           prometheus metrics
+func (m *ServiceManager) startApiServer() {
+	if len(m.cfg.ApiListenAddr) == 0 {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", m.handleLivez)
+	mux.HandleFunc("/readyz", m.handleReadyz)
+	mux.HandleFunc("/status", m.handleStatus)
+	mux.HandleFunc("/status/", m.handleServiceStatus)
+	mux.Handle("/metrics", metrics.Handler())
+
+	m.apiServer = &http.Server{
+		Addr:              m.cfg.ApiListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	utils.SafeGo(func() {
+		logrus.Infof("api server listening on %s", m.cfg.ApiListenAddr)
+		if err := m.apiServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Errorf("api server err: %s", err.Error())
+		}
+	})
+}
+
+func (m *ServiceManager) stopApiServer() {
+	if m.apiServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.apiServer.Shutdown(ctx); err != nil {
+		logrus.Warnf("shutdown api server err: %s", err.Error())
+	}
+}
+
+func (m *ServiceManager) Status() ManagerStatus {
+	status := ManagerStatus{
+		Started:       m.started.Load(),
+		Eth1Endpoints: m.connection.Eth1EndpointStatus(),
+		Eth2Endpoints: m.connection.Eth2EndpointStatus(),
+		Services:      make([]ServiceStatus, 0),
+	}
+	m.srvs.Range(func(_ string, srv *Service) bool {
+		status.Services = append(status.Services, srv.Status())
+		return true
+	})
+	sort.Slice(status.Services, func(i, j int) bool {
+		return status.Services[i].LsdToken < status.Services[j].LsdToken
+	})
+
+	if sg := m.connection.Signer(); sg != nil {
+		status.Voter = &VoterStatus{Address: sg.Address().String()}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		balance, err := m.connection.Eth1Client().BalanceAt(ctx, sg.Address(), nil)
+		if err != nil {
+			status.Voter.BalanceErr = err.Error()
+		} else {
+			status.Voter.Balance = decimal.NewFromBigInt(balance, -18).String()
+		}
+	}
+	if m.elector != nil {
+		status.Leader = &LeaderStatus{Instance: m.elector.Id(), IsLeader: m.elector.IsLeader()}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		lease, err := m.elector.Lease(ctx)
+		if err != nil {
+			status.Leader.LeaseErr = err.Error()
+		} else {
+			status.Leader.Holder = lease.Holder
+			status.Leader.LeaseExpiresAt = lease.ExpiresAt
+		}
+	}
+	return status
+}
+
+// notReadyReasons returns why the relay can not serve, empty if it is ready.
+func (m *ServiceManager) notReadyReasons() []string {
+	reasons := make([]string, 0)
+	if !m.started.Load() {
+		reasons = append(reasons, "service manager not started")
+	}
+	if !hasHealthyEndpoint(m.connection.Eth1EndpointStatus()) {
+		reasons = append(reasons, "no healthy eth1 endpoint")
+	}
+	if !hasHealthyEndpoint(m.connection.Eth2EndpointStatus()) {
+		reasons = append(reasons, "no healthy eth2 endpoint")
+	}
+	m.srvs.Range(func(token string, srv *Service) bool {
+		if !srv.Ready() {
+			reasons = append(reasons, "service of "+token+" not ready")
+		}
+		return true
+	})
+	return reasons
+}
+
+func hasHealthyEndpoint(status []connection.EndpointStatus) bool {
+	for _, s := range status {
+		if s.Healthy {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *ServiceManager) handleLivez(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	select {
+	case <-m.stop:
+		writeJson(w, http.StatusServiceUnavailable, probeResponse{Status: "stopping"})
+	default:
+		writeJson(w, http.StatusOK, probeResponse{Status: "ok"})
+	}
+}
+
+func (m *ServiceManager) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if reasons := m.notReadyReasons(); len(reasons) > 0 {
+		writeJson(w, http.StatusServiceUnavailable, probeResponse{Status: "not ready", Reasons: reasons})
+		return
+	}
+	writeJson(w, http.StatusOK, probeResponse{Status: "ok"})
+}
+
+func (m *ServiceManager) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJson(w, http.StatusOK, m.Status())
+}
+
+func (m *ServiceManager) handleServiceStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimPrefix(r.URL.Path, "/status/")
+	if !common.IsHexAddress(token) {
+		writeJson(w, http.StatusBadRequest, probeResponse{Status: "invalid lsd token address"})
+		return
+	}
+	// srvs is keyed by the configured token string, which may not be checksummed
+	var srv *Service
+	m.srvs.Range(func(_ string, value *Service) bool {
+		if value.lsdTokenAddress == common.HexToAddress(token) {
+			srv = value
+			return false
+		}
+		return true
+	})
+	if srv == nil {
+		writeJson(w, http.StatusNotFound, probeResponse{Status: "lsd token service not found"})
+		return
+	}
+	writeJson(w, http.StatusOK, srv.Status())
+}
+
+func writeJson(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Debugf("write api response err: %s", err.Error())
+	}
+}
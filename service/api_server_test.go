@@ -0,0 +1,85 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	xsync "github.com/puzpuzpuz/xsync/v3"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/config"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiHandlers(t *testing.T) {
+	s := newFakeBackendService(t, newFakeBackend(100))
+	s.lsdTokenAddress = fakeDepositAddress
+	s.handlerStatus = xsync.NewMapOf[string, HandlerStatus]()
+	s.recordHandlerRun("syncEvents", time.Second, nil)
+	s.latestBlockOfSyncEvents.Store(90)
+	m := &ServiceManager{
+		stop:       make(chan struct{}),
+		cfg:        &config.Config{},
+		connection: s.connection,
+		srvs:       xsync.NewMapOf[string, *Service](),
+	}
+	// keyed by the configured token, which may not be checksummed
+	m.srvs.Store(strings.ToLower(fakeDepositAddress.String()), s)
+
+	get := func(handler http.HandlerFunc, path string, v any) int {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if v != nil {
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+		}
+		return w.Code
+	}
+
+	probe := probeResponse{}
+	assert.Equal(t, http.StatusOK, get(m.handleLivez, "/livez", &probe))
+	assert.Equal(t, "ok", probe.Status)
+	w := httptest.NewRecorder()
+	m.handleLivez(w, httptest.NewRequest(http.MethodPost, "/livez", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	// the fake backend has no endpoints to check
+	probe = probeResponse{}
+	assert.Equal(t, http.StatusServiceUnavailable, get(m.handleReadyz, "/readyz", &probe))
+	assert.Equal(t, []string{
+		"service manager not started",
+		"no healthy eth1 endpoint",
+		"no healthy eth2 endpoint",
+		"service of " + strings.ToLower(fakeDepositAddress.String()) + " not ready",
+	}, probe.Reasons)
+	m.started.Store(true)
+	s.handlersStarted.Store(true)
+	probe = probeResponse{}
+	get(m.handleReadyz, "/readyz", &probe)
+	assert.Equal(t, []string{"no healthy eth1 endpoint", "no healthy eth2 endpoint"}, probe.Reasons)
+
+	status := ManagerStatus{}
+	assert.Equal(t, http.StatusOK, get(m.handleStatus, "/status", &status))
+	assert.True(t, status.Started)
+	assert.Nil(t, status.Voter)
+	require.Len(t, status.Services, 1)
+	assert.Equal(t, fakeDepositAddress.String(), status.Services[0].LsdToken)
+
+	srvStatus := ServiceStatus{}
+	assert.Equal(t, http.StatusOK, get(m.handleServiceStatus, "/status/"+fakeDepositAddress.String(), &srvStatus))
+	assert.Equal(t, uint64(90), srvStatus.LatestBlockOfSyncEvents)
+	require.Len(t, srvStatus.Handlers, 1)
+	assert.Equal(t, "syncEvents", srvStatus.Handlers[0].Name)
+	assert.Equal(t, uint64(1), srvStatus.Handlers[0].Runs)
+	assert.Equal(t, http.StatusBadRequest, get(m.handleServiceStatus, "/status/0x01", &probe))
+	assert.Equal(t, http.StatusNotFound, get(m.handleServiceStatus, "/status/"+fakeFeePoolAddress.String(), &probe))
+
+	w = httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "# TYPE")
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/local_store"
+)
+
+// each save rewrites the whole local store, progress of a crashed relay since the last save is synced again
+const checkpointInterval = 5 * time.Minute
+
+// restoreCheckpoint restores the handler heights and event state saved by a previous run, so handlers
+// resume where they stopped. Each part is restored only if it is ahead of the heights derived at start
+// and still consistent with the chain, the block store and the restored snapshot.
+func (s *Service) restoreCheckpoint() error {
+	cp := s.localCheckpoint
+	if cp == nil || s.waitFirstNodeStakeEvent.Load() {
+		return nil
+	}
+	s.savedCheckpoint = *cp
+	if err := s.restoreCheckpointEvents(cp); err != nil {
+		return err
+	}
+	if err := s.restoreCheckpointSyncBlock(cp); err != nil {
+		return err
+	}
+	s.restoreCheckpointValidatorEpoch(cp)
+	return nil
+}
+
+// restoreCheckpointEvents restores the event state, so syncEvents only replays events after the saved block.
+func (s *Service) restoreCheckpointEvents(cp *local_store.Checkpoint) error {
+	if cp.LatestBlockOfSyncEvents <= s.latestBlockOfSyncEvents.Load() {
+		return nil
+	}
+	// the events of a checkpoint on reorged blocks can not be rolled back
+	var syncedHash common.Hash
+	if cp.LatestBlockHashOfSyncEvents != "" {
+		syncedHash = common.HexToHash(cp.LatestBlockHashOfSyncEvents)
+		header, err := s.headerOf(s.ctx, cp.LatestBlockOfSyncEvents)
+		if err != nil {
+			return err
+		}
+		if header.Hash() != syncedHash {
+			s.log.WithFields(logrus.Fields{
+				"latestBlockOfSyncEvents": cp.LatestBlockOfSyncEvents,
+				"checkpointHash":          cp.LatestBlockHashOfSyncEvents,
+				"canonicalHash":           header.Hash().String(),
+			}).Warn("checkpoint block is reorged, checkpoint dropped")
+			return nil
+		}
+	}
+
+	govDeposits := make(map[string][][]byte, len(cp.GovDeposits))
+	for pubkey, credentials := range cp.GovDeposits {
+		for _, c := range credentials {
+			bts, err := hex.DecodeString(c)
+			if err != nil {
+				return fmt.Errorf("checkpoint gov deposit of %s err: %w", pubkey, err)
+			}
+			govDeposits[pubkey] = append(govDeposits[pubkey], bts)
+		}
+	}
+
+	exitElections := make(map[uint64]*ExitElection, len(cp.ExitElections))
+	for _, e := range cp.ExitElections {
+		exitElections[e.WithdrawCycle] = &ExitElection{
+			WithdrawCycle:      e.WithdrawCycle,
+			ValidatorIndexList: e.ValidatorIndexList,
+		}
+	}
+
+	stakerWithdrawals := make(map[uint64]*StakerWithdrawal, len(cp.StakerWithdrawals))
+	for _, w := range cp.StakerWithdrawals {
+		amount, err := decimal.NewFromString(w.EthAmount)
+		if err != nil {
+			return fmt.Errorf("checkpoint staker withdrawal %d amount err: %w", w.WithdrawIndex, err)
+		}
+		stakerWithdrawals[w.WithdrawIndex] = &StakerWithdrawal{
+			WithdrawIndex:      w.WithdrawIndex,
+			Address:            common.HexToAddress(w.Address),
+			EthAmount:          amount,
+			BlockNumber:        w.BlockNumber,
+			ClaimedBlockNumber: w.ClaimedBlockNumber,
+		}
+	}
+
+	s.govDeposits = govDeposits
+	s.exitElections = exitElections
+	s.stakerWithdrawals = stakerWithdrawals
+	s.latestBlockOfSyncEvents.Store(cp.LatestBlockOfSyncEvents)
+	if syncedHash != (common.Hash{}) {
+		s.syncedRanges = []*syncedRange{{start: cp.LatestBlockOfSyncEvents, end: cp.LatestBlockOfSyncEvents, endHash: syncedHash, restored: true}}
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"latestBlockOfSyncEvents": cp.LatestBlockOfSyncEvents,
+		"govDeposits":             len(govDeposits),
+		"exitElections":           len(exitElections),
+		"stakerWithdrawals":       len(stakerWithdrawals),
+	}).Info("restored checkpoint events")
+
+	return nil
+}
+
+// restoreCheckpointSyncBlock restores the heights of syncBlocks. Handlers read the synced blocks from the
+// block store, so the heights are only restored if the blocks from the start height up to the saved one
+// are still there.
+func (s *Service) restoreCheckpointSyncBlock(cp *local_store.Checkpoint) error {
+	startBlock, startSlot := s.latestBlockOfSyncBlock.Load(), s.latestSlotOfSyncBlock.Load()
+	if cp.LatestBlockOfSyncBlock <= startBlock || cp.LatestSlotOfSyncBlock <= startSlot {
+		return nil
+	}
+	log := s.log.WithFields(logrus.Fields{
+		"latestBlockOfSyncBlock": cp.LatestBlockOfSyncBlock,
+		"latestSlotOfSyncBlock":  cp.LatestSlotOfSyncBlock,
+		"startBlock":             startBlock,
+	})
+	first, found, err := s.manager.GetBeaconBlockByExecBlockHeight(startBlock + 1)
+	if err != nil {
+		return err
+	}
+	if !found || first.BeaconBlockId <= startSlot {
+		log.Warn("checkpoint synced blocks are not in block store, heights of syncBlocks not restored")
+		return nil
+	}
+	last, found, err := s.manager.GetBeaconBlockByExecBlockHeight(cp.LatestBlockOfSyncBlock)
+	if err != nil {
+		return err
+	}
+	if !found || last.BeaconBlockId > cp.LatestSlotOfSyncBlock {
+		log.Warn("checkpoint synced blocks are not in block store, heights of syncBlocks not restored")
+		return nil
+	}
+
+	s.latestBlockOfSyncBlock.Store(cp.LatestBlockOfSyncBlock)
+	s.latestSlotOfSyncBlock.Store(cp.LatestSlotOfSyncBlock)
+	log.Info("restored checkpoint heights of syncBlocks")
+	return nil
+}
+
+// restoreCheckpointValidatorEpoch restores the epoch validator statuses were updated at. Statuses are only
+// restored from a snapshot, so the epoch is capped at the one of the snapshot, a later epoch would skip
+// updating them.
+func (s *Service) restoreCheckpointValidatorEpoch(cp *local_store.Checkpoint) {
+	epoch := min(cp.LatestEpochOfUpdateValidator, s.snapshotEpochOfUpdateValidator)
+	if epoch <= s.latestEpochOfUpdateValidator.Load() {
+		return
+	}
+	s.latestEpochOfUpdateValidator.Store(epoch)
+	s.log.WithField("latestEpochOfUpdateValidator", epoch).Info("restored checkpoint epoch of updateValidators")
+}
+
+// saveCheckpoint periodically persists handler progress and event state.
+func (s *Service) saveCheckpoint(ctx context.Context) error {
+	if time.Since(s.lastCheckpointAt) < checkpointInterval {
+		return nil
+	}
+	return s.writeCheckpoint()
+}
+
+// writeCheckpoint persists handler progress and event state when it changed since the last save.
+func (s *Service) writeCheckpoint() error {
+	if s.savedCheckpoint.LatestBlockOfSyncEvents == s.latestBlockOfSyncEvents.Load() &&
+		s.savedCheckpoint.LatestSlotOfSyncBlock == s.latestSlotOfSyncBlock.Load() &&
+		s.savedCheckpoint.LatestBlockOfSyncBlock == s.latestBlockOfSyncBlock.Load() &&
+		s.savedCheckpoint.LatestEpochOfUpdateValidator == s.latestEpochOfUpdateValidator.Load() {
+		return nil
+	}
+
+	cp := local_store.Checkpoint{
+		LatestBlockOfSyncEvents:      s.latestBlockOfSyncEvents.Load(),
+		LatestSlotOfSyncBlock:        s.latestSlotOfSyncBlock.Load(),
+		LatestBlockOfSyncBlock:       s.latestBlockOfSyncBlock.Load(),
+		LatestEpochOfUpdateValidator: s.latestEpochOfUpdateValidator.Load(),
+		GovDeposits:                  make(map[string][]string, len(s.govDeposits)),
+		ExitElections:                make([]*local_store.ExitElection, 0, len(s.exitElections)),
+		StakerWithdrawals:            make([]*local_store.StakerWithdrawal, 0, len(s.stakerWithdrawals)),
+	}
+	if last := s.lastSyncedRange(); last != nil && last.end == s.latestBlockOfSyncEvents.Load() {
+		cp.LatestBlockHashOfSyncEvents = last.endHash.String()
+	}
+	for pubkey, credentials := range s.govDeposits {
+		for _, c := range credentials {
+			cp.GovDeposits[pubkey] = append(cp.GovDeposits[pubkey], hex.EncodeToString(c))
+		}
+	}
+	for _, e := range s.exitElections {
+		cp.ExitElections = append(cp.ExitElections, &local_store.ExitElection{
+			WithdrawCycle:      e.WithdrawCycle,
+			ValidatorIndexList: e.ValidatorIndexList,
+		})
+	}
+	for _, w := range s.stakerWithdrawals {
+		cp.StakerWithdrawals = append(cp.StakerWithdrawals, &local_store.StakerWithdrawal{
+			WithdrawIndex:      w.WithdrawIndex,
+			Address:            w.Address.String(),
+			EthAmount:          w.EthAmount.String(),
+			BlockNumber:        w.BlockNumber,
+			ClaimedBlockNumber: w.ClaimedBlockNumber,
+		})
+	}
+
+	if err := s.localStore.UpdateCheckpoint(s.lsdTokenAddress.String(), &cp); err != nil {
+		return fmt.Errorf("save checkpoint err: %w", err)
+	}
+	s.savedCheckpoint = cp
+	s.lastCheckpointAt = time.Now()
+	return nil
+}
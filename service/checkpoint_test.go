@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	xsync "github.com/puzpuzpuz/xsync/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/block_store"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/local_store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCheckpointTestService(t *testing.T, storedBlocks ...uint64) *Service {
+	blockStore, err := block_store.NewBlockStore(filepath.Join(t.TempDir(), "blocks.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { blockStore.Close() })
+	// slot of block n is n+100
+	for _, block := range storedBlocks {
+		data, err := json.Marshal(&CachedBeaconBlock{BeaconBlockId: block + 100, ExecutionBlockNumber: block})
+		require.NoError(t, err)
+		require.NoError(t, blockStore.Put(block+100, block, data))
+	}
+
+	s := &Service{
+		log: logrus.NewEntry(logrus.StandardLogger()),
+		manager: &ServiceManager{
+			blockStore:                         blockStore,
+			cachedBeaconBlockByExecBlockHeight: xsync.NewMapOf[uint64, *CachedBeaconBlock](),
+		},
+	}
+	s.latestBlockOfSyncBlock.Store(10)
+	s.latestSlotOfSyncBlock.Store(110)
+	return s
+}
+
+func TestRestoreCheckpointHeights(t *testing.T) {
+	cp := &local_store.Checkpoint{LatestBlockOfSyncBlock: 20, LatestSlotOfSyncBlock: 125, LatestEpochOfUpdateValidator: 7}
+
+	s := newCheckpointTestService(t, 11, 20)
+	s.snapshotEpochOfUpdateValidator = 5
+	s.localCheckpoint = cp
+	require.NoError(t, s.restoreCheckpoint())
+	assert.Equal(t, uint64(20), s.latestBlockOfSyncBlock.Load())
+	assert.Equal(t, uint64(125), s.latestSlotOfSyncBlock.Load())
+	// validator statuses are the ones of the snapshot
+	assert.Equal(t, uint64(5), s.latestEpochOfUpdateValidator.Load())
+	assert.Equal(t, *cp, s.savedCheckpoint)
+
+	// blocks after the start height are not in the block store
+	s = newCheckpointTestService(t, 20)
+	s.localCheckpoint = cp
+	require.NoError(t, s.restoreCheckpoint())
+	assert.Equal(t, uint64(10), s.latestBlockOfSyncBlock.Load())
+	assert.Equal(t, uint64(110), s.latestSlotOfSyncBlock.Load())
+	// no snapshot restored
+	assert.Equal(t, uint64(0), s.latestEpochOfUpdateValidator.Load())
+
+	// the saved block is not in the block store
+	s = newCheckpointTestService(t, 11)
+	s.localCheckpoint = cp
+	require.NoError(t, s.restoreCheckpoint())
+	assert.Equal(t, uint64(10), s.latestBlockOfSyncBlock.Load())
+
+	// the saved slot is before the saved block
+	s = newCheckpointTestService(t, 11, 20)
+	s.localCheckpoint = &local_store.Checkpoint{LatestBlockOfSyncBlock: 20, LatestSlotOfSyncBlock: 115}
+	require.NoError(t, s.restoreCheckpoint())
+	assert.Equal(t, uint64(10), s.latestBlockOfSyncBlock.Load())
+
+	// heights behind the start heights are kept
+	s = newCheckpointTestService(t)
+	s.localCheckpoint = &local_store.Checkpoint{LatestBlockOfSyncBlock: 5, LatestSlotOfSyncBlock: 105}
+	require.NoError(t, s.restoreCheckpoint())
+	assert.Equal(t, uint64(10), s.latestBlockOfSyncBlock.Load())
+	assert.Equal(t, uint64(110), s.latestSlotOfSyncBlock.Load())
+}
+
+func TestSaveCheckpoint(t *testing.T) {
+	localStore, err := local_store.NewLocalStore(filepath.Join(t.TempDir(), "blockstore"))
+	require.NoError(t, err)
+	s := newCheckpointTestService(t)
+	s.localStore = localStore
+	saved := func() uint64 {
+		info, err := localStore.Read(s.lsdTokenAddress.String())
+		require.NoError(t, err)
+		return info.Checkpoint.LatestBlockOfSyncBlock
+	}
+
+	require.NoError(t, s.saveCheckpoint(context.Background()))
+	assert.Equal(t, uint64(10), saved())
+
+	// progress is saved once the interval passed
+	s.latestBlockOfSyncBlock.Store(11)
+	require.NoError(t, s.saveCheckpoint(context.Background()))
+	assert.Equal(t, uint64(10), saved())
+	s.lastCheckpointAt = time.Now().Add(-checkpointInterval)
+	require.NoError(t, s.saveCheckpoint(context.Background()))
+	assert.Equal(t, uint64(11), saved())
+
+	// shutdown saves at once
+	s.latestBlockOfSyncBlock.Store(12)
+	require.NoError(t, s.writeCheckpoint())
+	assert.Equal(t, uint64(12), saved())
+}
@@ -6,7 +6,6 @@ import (
 	"math"
 	"math/big"
 
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/pkg/errors"
 	ethpb "github.com/prysmaticlabs/prysm/v4/proto/eth/v1"
@@ -21,49 +20,28 @@ func init() {
 	decimal.MarshalJSONWithoutQuotes = true
 }
 
-func (s *Service) waitProposalTxOk(txHash common.Hash, proposalId [32]byte) error {
-	_, err := s.connection.Eth1Client().WaitTxOkCommon(txHash)
+// voteProposal queues a vote of the proposal to the vote aggregator unless we have voted it. In a handler run
+// the scheduler waits for the vote once the handler returns, otherwise it is waited here.
+func (s *Service) voteProposal(ctx context.Context, proposalType string, to common.Address, callData []byte, factor *big.Int) error {
+	proposalId := utils.ProposalId(to, callData, factor)
+	hasVoted, err := s.networkProposalContract.HasVoted(nil, proposalId, s.connection.Signer().Address())
 	if err != nil {
-		p, err := s.networkProposalContract.Proposals(nil, proposalId)
-		if err != nil {
-			return err
-		}
-		// proposal is executed
-		if p.Status == 2 {
-			return nil
-		}
-		return err
+		return fmt.Errorf("networkProposalContract.HasVoted err: %s", err)
 	}
-
-	return nil
-}
-
-func (s *Service) waitProposalsTxOk(txHash common.Hash, proposalIds [][32]byte) error {
-	_, err := s.connection.Eth1Client().WaitTxOkCommon(txHash)
-	if err != nil {
-		allProposalsExecuted := true
-		for _, proposalId := range proposalIds {
-			p, err := s.networkProposalContract.Proposals(nil, proposalId)
-			if err != nil {
-				return err
-			}
-			// proposal is executed
-			if p.Status != 2 {
-				allProposalsExecuted = false
-				break
-			}
-		}
-		if allProposalsExecuted {
-			return nil
-		}
-
-		return err
+	if hasVoted {
+		s.log.WithField("proposalType", proposalType).Debug("already voted wait other voters")
+		return nil
 	}
 
-	return nil
+	result := s.manager.voteAggregator.Add(s, proposalType, to, callData, factor)
+	if votes, ok := ctx.Value(votesKey{}).(*handlerVotes); ok {
+		votes.add(result)
+		return nil
+	}
+	return result.wait(ctx)
 }
 
-func (s *Service) getEpochStartBlocknumberWithCheck(epoch uint64) (uint64, error) {
+func (s *Service) getEpochStartBlocknumberWithCheck(ctx context.Context, epoch uint64) (uint64, error) {
 	s.cacheEpochToBlockIDMutex.Lock()
 	defer s.cacheEpochToBlockIDMutex.Unlock()
 
@@ -71,7 +49,7 @@ func (s *Service) getEpochStartBlocknumberWithCheck(epoch uint64) (uint64, error
 		return blockID, nil
 	}
 
-	targetBlock, err := s.getEpochStartBlocknumber(epoch)
+	targetBlock, err := s.getEpochStartBlocknumber(ctx, epoch)
 	if err != nil {
 		return 0, err
 	}
@@ -83,7 +61,7 @@ func (s *Service) getEpochStartBlocknumberWithCheck(epoch uint64) (uint64, error
 	return targetBlock, nil
 }
 
-func (s *Service) getEpochStartBlocknumber(epoch uint64) (uint64, error) {
+func (s *Service) getEpochStartBlocknumber(ctx context.Context, epoch uint64) (uint64, error) {
 	eth2ValidatorBalanceSyncerStartSlot := utils.StartSlotOfEpoch(s.eth2Config, epoch)
 	retry := 0
 	for {
@@ -91,7 +69,7 @@ func (s *Service) getEpochStartBlocknumber(epoch uint64) (uint64, error) {
 			return 0, fmt.Errorf("targetBeaconBlock.executionBlockNumber zero err")
 		}
 
-		targetBeaconBlock, exist, err := s.connection.GetBeaconBlock(eth2ValidatorBalanceSyncerStartSlot)
+		targetBeaconBlock, exist, err := s.connection.GetBeaconBlock(ctx, eth2ValidatorBalanceSyncerStartSlot)
 		if err != nil {
 			return 0, fmt.Errorf("fail to get beacon block[%d]: %w", eth2ValidatorBalanceSyncerStartSlot, err)
 		}
@@ -108,8 +86,8 @@ func (s *Service) getEpochStartBlocknumber(epoch uint64) (uint64, error) {
 	}
 }
 
-// return (user reward, node reward, platform fee, nodeRewardMap) decimals 18
-func (s *Service) getUserNodePlatformFromWithdrawals(latestDistributeHeight, targetEth1BlockHeight uint64) (decimal.Decimal, decimal.Decimal, decimal.Decimal, NodeNewRewardsMap, error) {
+// return (user reward, node reward, platform fee, nodeRewardMap) decimals 18, each withdrawal is added to report
+func (s *Service) getUserNodePlatformFromWithdrawals(latestDistributeHeight, targetEth1BlockHeight uint64, report *rewardReport) (decimal.Decimal, decimal.Decimal, decimal.Decimal, NodeNewRewardsMap, error) {
 	totalUserEthDeci := decimal.Zero
 	totalNodeEthDeci := decimal.Zero
 	totalPlatformEthDeci := decimal.Zero
@@ -130,14 +108,17 @@ func (s *Service) getUserNodePlatformFromWithdrawals(latestDistributeHeight, tar
 			totalReward := uint64(0)
 			userDeposit := uint64(0)
 			nodeDeposit := uint64(0)
+			classification := ""
 
 			switch {
 
 			case w.Amount < utils.MaxPartialWithdrawalAmount: // partial withdrawal
 				totalReward = w.Amount
+				classification = RewardPartialWithdrawal
 
 			case w.Amount >= utils.MaxPartialWithdrawalAmount && w.Amount < utils.StandardEffectiveBalance: // slash
 				totalReward = 0
+				classification = RewardSlashWithdrawal
 
 				userDeposit = utils.StandardEffectiveBalance - val.NodeDepositAmount
 				if userDeposit > w.Amount {
@@ -149,6 +130,7 @@ func (s *Service) getUserNodePlatformFromWithdrawals(latestDistributeHeight, tar
 
 			case w.Amount >= utils.StandardEffectiveBalance: // full withdrawal
 				totalReward = w.Amount - utils.StandardEffectiveBalance
+				classification = RewardFullWithdrawal
 
 				userDeposit = utils.StandardEffectiveBalance - val.NodeDepositAmount
 				nodeDeposit = val.NodeDepositAmount
@@ -162,6 +144,12 @@ func (s *Service) getUserNodePlatformFromWithdrawals(latestDistributeHeight, tar
 			userDepositDeci := decimal.NewFromInt(int64(userDeposit)).Mul(utils.GweiDeci)
 			nodeDepositDeci := decimal.NewFromInt(int64(nodeDeposit)).Mul(utils.GweiDeci)
 
+			entry := newRewardEntry(block, val, classification)
+			entry.Amount = decimal.NewFromInt(int64(w.Amount)).Mul(utils.GweiDeci)
+			entry.UserReward, entry.NodeReward, entry.PlatformFee = userRewardDeci, nodeRewardDeci, platformFeeDeci
+			entry.UserDeposit, entry.NodeDeposit = userDepositDeci, nodeDepositDeci
+			report.add(entry)
+
 			// cal node reward
 			nodeNewReward, exist := nodeNewRewardsMap[val.NodeAddress]
 			if exist {
@@ -186,13 +174,17 @@ func (s *Service) getUserNodePlatformFromWithdrawals(latestDistributeHeight, tar
 	return totalUserEthDeci, totalNodeEthDeci, totalPlatformEthDeci, nodeNewRewardsMap, nil
 }
 
-// return (user reward, node reward, platform fee) decimals 18
-func (s *Service) getUserNodePlatformFromPriorityFee(latestDistributeHeight, targetEth1BlockHeight uint64) (decimal.Decimal, decimal.Decimal, decimal.Decimal, NodeNewRewardsMap, error) {
+// return (user reward, node reward, platform fee) decimals 18, each proposed block is added to report
+func (s *Service) getUserNodePlatformFromPriorityFee(ctx context.Context, latestDistributeHeight, targetEth1BlockHeight uint64, report *rewardReport) (decimal.Decimal, decimal.Decimal, decimal.Decimal, NodeNewRewardsMap, error) {
 	totalUserEthDeci := decimal.Zero
 	totalNodeEthDeci := decimal.Zero
 	totalPlatformEthDeci := decimal.Zero
 	nodeNewRewardsMap := make(NodeNewRewardsMap)
 
+	// blocks proposed by our validators
+	proposers := make(map[uint64]*Validator)
+	beaconBlocks := make(map[uint64]*CachedBeaconBlock)
+	blocks := make([]uint64, 0)
 	for i := latestDistributeHeight + 1; i <= targetEth1BlockHeight; i++ {
 		block, err := s.getBeaconBlock(i)
 		if err != nil {
@@ -202,41 +194,32 @@ func (s *Service) getUserNodePlatformFromPriorityFee(latestDistributeHeight, tar
 		if !exist {
 			continue
 		}
+		proposers[i] = val
+		beaconBlocks[i] = block
+		blocks = append(blocks, i)
+	}
 
-		// cal priority fee at this block
-		preBlockNumber := big.NewInt(int64(i - 1))
-		curBlockNumber := big.NewInt(int64(i))
-		feePoolPreBalance, err := s.connection.Eth1Client().BalanceAt(context.Background(), s.feePoolAddress, preBlockNumber)
-		if err != nil {
-			return decimal.Zero, decimal.Zero, decimal.Zero, nil, err
-		}
-		feePoolCurBalance, err := s.connection.Eth1Client().BalanceAt(context.Background(), s.feePoolAddress, curBlockNumber)
-		if err != nil {
-			return decimal.Zero, decimal.Zero, decimal.Zero, nil, err
-		}
+	s.feeCache.prune(latestDistributeHeight)
+	fees, err := s.priorityFeesOf(ctx, blocks)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, decimal.Zero, nil, err
+	}
+	if s.feeComparison {
+		s.comparePriorityFees(ctx, blocks, proposers, fees)
+	}
 
-		decreaseAmount := big.NewInt(0)
-		curBlockNumberUint := curBlockNumber.Uint64()
-		withdrawIter, err := s.feePoolContract.FilterEtherWithdrawn(&bind.FilterOpts{
-			Start:   curBlockNumberUint,
-			End:     &curBlockNumberUint,
-			Context: context.Background(),
-		})
-		if err != nil {
-			return decimal.Zero, decimal.Zero, decimal.Zero, nil, err
-		}
-		for withdrawIter.Next() {
-			decreaseAmount = new(big.Int).Add(decreaseAmount, withdrawIter.Event.Amount)
-		}
-		totalFeePoolCurBalance := new(big.Int).Add(feePoolCurBalance, decreaseAmount)
-		if totalFeePoolCurBalance.Cmp(feePoolPreBalance) < 0 {
-			return decimal.Zero, decimal.Zero, decimal.Zero, nil, fmt.Errorf("should not happened here when cal priority fee, block: %d", i)
-		}
-		feeAmountAtThisBlock := decimal.NewFromBigInt(new(big.Int).Sub(totalFeePoolCurBalance, feePoolPreBalance), 0)
+	for _, i := range blocks {
+		val := proposers[i]
+		feeAmountAtThisBlock := decimal.NewFromBigInt(fees[i], 0)
 
 		// cal rewards
 		userRewardDeci, nodeRewardDeci, platformFeeDeci := utils.GetUserNodePlatformReward(s.nodeCommissionRate, s.platformCommissionRate, val.NodeDepositAmountDeci, feeAmountAtThisBlock)
 
+		entry := newRewardEntry(beaconBlocks[i], val, RewardPriorityFee)
+		entry.Amount = feeAmountAtThisBlock
+		entry.UserReward, entry.NodeReward, entry.PlatformFee = userRewardDeci, nodeRewardDeci, platformFeeDeci
+		report.add(entry)
+
 		// cal node reward
 		nodeNewReward, exist := nodeNewRewardsMap[val.NodeAddress]
 		if exist {
@@ -260,12 +243,12 @@ func (s *Service) getUserNodePlatformFromPriorityFee(latestDistributeHeight, tar
 }
 
 // include withdrawals fee
-func (s *Service) getNodeNewRewardsBetween(latestDistributeHeight, targetEth1BlockHeight uint64) (NodeNewRewardsMap, error) {
-	_, _, _, nodeNewRewardsMapFromWithdrawals, err := s.getUserNodePlatformFromWithdrawals(latestDistributeHeight, targetEth1BlockHeight)
+func (s *Service) getNodeNewRewardsBetween(ctx context.Context, latestDistributeHeight, targetEth1BlockHeight uint64, report *rewardReport) (NodeNewRewardsMap, error) {
+	_, _, _, nodeNewRewardsMapFromWithdrawals, err := s.getUserNodePlatformFromWithdrawals(latestDistributeHeight, targetEth1BlockHeight, report)
 	if err != nil {
 		return nil, err
 	}
-	_, _, _, nodeNewRewardsMapFromPriorityFee, err := s.getUserNodePlatformFromPriorityFee(latestDistributeHeight, targetEth1BlockHeight)
+	_, _, _, nodeNewRewardsMapFromPriorityFee, err := s.getUserNodePlatformFromPriorityFee(ctx, latestDistributeHeight, targetEth1BlockHeight, report)
 	if err != nil {
 		return nil, err
 	}
@@ -1,16 +1,18 @@
 package service
 
 import (
+	"context"
 	"math/big"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
 )
 
-func (s *Service) distributePriorityFee() error {
+func (s *Service) distributePriorityFee(ctx context.Context) error {
 
-	latestDistributeHeight, targetEth1BlockHeight, shouldGoNext, err := s.checkStateForDistributePriorityFee()
+	latestDistributeHeight, targetEth1BlockHeight, shouldGoNext, err := s.checkStateForDistributePriorityFee(ctx)
 	if err != nil {
 		return errors.Wrap(err, "distributePriorityFee checkSyncState failed")
 	}
@@ -19,17 +21,27 @@ func (s *Service) distributePriorityFee() error {
 		return nil
 	}
 
+	s.replay.note(logrus.Fields{
+		"latestDistributeHeight": latestDistributeHeight,
+		"targetEth1BlockHeight":  targetEth1BlockHeight,
+	})
 	s.log.WithFields(logrus.Fields{
 		"latestDistributeHeight": latestDistributeHeight,
 		"targetEth1BlockHeight":  targetEth1BlockHeight,
-		"latestBlockOfSyncBlock": s.latestBlockOfSyncBlock,
+		"latestBlockOfSyncBlock": s.latestBlockOfSyncBlock.Load(),
 	}).Debug("distributePriorityFee")
 
 	// ----1 cal eth(from withdrawals) of user/node/platform
-	totalUserEthDeci, totalNodeEthDeci, totalPlatformEthDeci, _, err := s.getUserNodePlatformFromPriorityFee(latestDistributeHeight, targetEth1BlockHeight)
+	report := s.newRewardReport()
+	totalUserEthDeci, totalNodeEthDeci, totalPlatformEthDeci, _, err := s.getUserNodePlatformFromPriorityFee(ctx, latestDistributeHeight, targetEth1BlockHeight, report)
 	if err != nil {
 		return errors.Wrap(err, "getUserNodePlatformFromPriorityFee failed")
 	}
+	s.saveRewardReport(report, RewardReport{
+		ProposalType: metrics.ProposalDistributePriorityFee,
+		FromBlock:    latestDistributeHeight,
+		ToBlock:      targetEth1BlockHeight,
+	}, targetEth1BlockHeight)
 
 	// -----2 cal maxClaimableWithdrawIndex
 	// find distribute withdrawals height as target block to cal this
@@ -39,27 +51,35 @@ func (s *Service) distributePriorityFee() error {
 	}
 
 	// -----3 send vote tx
-	return s.sendDistributeTx(utils.DistributeTypePriorityFee, big.NewInt(int64(targetEth1BlockHeight)),
+	return s.sendDistributeTx(ctx, utils.DistributeTypePriorityFee, big.NewInt(int64(targetEth1BlockHeight)),
 		totalUserEthDeci.BigInt(), totalNodeEthDeci.BigInt(), totalPlatformEthDeci.BigInt(), big.NewInt(int64(newMaxClaimableWithdrawIndex)))
 }
 
 // check sync and vote state
 // return (latestDistributeHeight, targetEth1Blocknumber, shouldGoNext, err)
-func (s *Service) checkStateForDistributePriorityFee() (uint64, uint64, bool, error) {
-	beaconHead, err := s.connection.BeaconHead()
+func (s *Service) checkStateForDistributePriorityFee(ctx context.Context) (uint64, uint64, bool, error) {
+	beaconHead, err := s.beaconHead()
 	if err != nil {
 		return 0, 0, false, err
 	}
 	finalEpoch := beaconHead.FinalizedEpoch
 
 	targetEpoch := (finalEpoch / s.distributePriorityFeeDuEpochs) * s.distributePriorityFeeDuEpochs
-	targetEth1BlockHeight, err := s.getEpochStartBlocknumberWithCheck(targetEpoch)
+	targetEth1BlockHeight, err := s.getEpochStartBlocknumberWithCheck(ctx, targetEpoch)
 	if err != nil {
 		return 0, 0, false, err
 	}
 	s.log.Debugf("checkStateForDistributePriorityFee targetEth1Block: %d", targetEth1BlockHeight)
 
-	latestDistributeHeight := s.latestDistributePriorityFeeHeight
+	readOpts, err := s.readOpts(ctx, targetEth1BlockHeight)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	latestDistributePriorityFeeHeight, err := s.networkWithdrawContract.LatestDistributePriorityFeeHeight(readOpts)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	latestDistributeHeight := latestDistributePriorityFeeHeight.Uint64()
 	// init case
 	if latestDistributeHeight == 0 {
 		latestDistributeHeight = s.startAtBlock
@@ -71,7 +91,7 @@ func (s *Service) checkStateForDistributePriorityFee() (uint64, uint64, bool, er
 	}
 
 	// wait sync block
-	if targetEth1BlockHeight > s.latestBlockOfSyncBlock {
+	if targetEth1BlockHeight > s.latestBlockOfSyncBlock.Load() {
 		return 0, 0, false, nil
 	}
 
@@ -1,18 +1,20 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 
 	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
 )
 
-func (s *Service) distributeWithdrawals() error {
+func (s *Service) distributeWithdrawals(ctx context.Context) error {
 
-	latestDistributeHeight, targetEth1BlockHeight, shouldGoNext, err := s.checkStateForDistributeWithdraw()
+	latestDistributeHeight, targetEth1BlockHeight, shouldGoNext, err := s.checkStateForDistributeWithdraw(ctx)
 	if err != nil {
 		return errors.Wrap(err, "distributeWithdrawals checkSyncState failed")
 	}
@@ -21,17 +23,27 @@ func (s *Service) distributeWithdrawals() error {
 		return nil
 	}
 
+	s.replay.note(logrus.Fields{
+		"latestDistributeHeight": latestDistributeHeight,
+		"targetEth1BlockHeight":  targetEth1BlockHeight,
+	})
 	s.log.WithFields(logrus.Fields{
 		"latestDistributeHeight": latestDistributeHeight,
 		"targetEth1BlockHeight":  targetEth1BlockHeight,
-		"latestBlockOfSyncBlock": s.latestBlockOfSyncBlock,
+		"latestBlockOfSyncBlock": s.latestBlockOfSyncBlock.Load(),
 	}).Debug("distributeWithdrawals")
 
 	// ----1 cal eth(from withdrawals) of user/node/platform
-	totalUserEthDeci, totalNodeEthDeci, totalPlatformEthDeci, _, err := s.getUserNodePlatformFromWithdrawals(latestDistributeHeight, targetEth1BlockHeight)
+	report := s.newRewardReport()
+	totalUserEthDeci, totalNodeEthDeci, totalPlatformEthDeci, _, err := s.getUserNodePlatformFromWithdrawals(latestDistributeHeight, targetEth1BlockHeight, report)
 	if err != nil {
 		return errors.Wrap(err, "getUserNodePlatformFromWithdrawals failed")
 	}
+	s.saveRewardReport(report, RewardReport{
+		ProposalType: metrics.ProposalDistributeWithdrawals,
+		FromBlock:    latestDistributeHeight,
+		ToBlock:      targetEth1BlockHeight,
+	}, targetEth1BlockHeight)
 
 	// -----2 cal maxClaimableWithdrawIndex
 	newMaxClaimableWithdrawIndex, err := s.calMaxClaimableWithdrawIndex(targetEth1BlockHeight, totalUserEthDeci)
@@ -40,31 +52,36 @@ func (s *Service) distributeWithdrawals() error {
 	}
 
 	// -----3 send vote tx
-	return s.sendDistributeTx(utils.DistributeTypeWithdrawals, big.NewInt(int64(targetEth1BlockHeight)),
+	return s.sendDistributeTx(ctx, utils.DistributeTypeWithdrawals, big.NewInt(int64(targetEth1BlockHeight)),
 		totalUserEthDeci.BigInt(), totalNodeEthDeci.BigInt(), totalPlatformEthDeci.BigInt(), big.NewInt(int64(newMaxClaimableWithdrawIndex)))
 }
 
 // check sync and vote state
 // return (latestDistributeHeight, targetEth1Blocknumber, shouldGoNext, err)
-func (s *Service) checkStateForDistributeWithdraw() (uint64, uint64, bool, error) {
-	beaconHead, err := s.connection.BeaconHead()
+func (s *Service) checkStateForDistributeWithdraw(ctx context.Context) (uint64, uint64, bool, error) {
+	beaconHead, err := s.beaconHead()
 	if err != nil {
 		return 0, 0, false, err
 	}
 	finalEpoch := beaconHead.FinalizedEpoch
 
 	targetEpoch := (finalEpoch / s.distributeWithdrawalsDuEpochs) * s.distributeWithdrawalsDuEpochs
-	targetEth1BlockHeight, err := s.getEpochStartBlocknumberWithCheck(targetEpoch)
+	targetEth1BlockHeight, err := s.getEpochStartBlocknumberWithCheck(ctx, targetEpoch)
 	if err != nil {
 		return 0, 0, false, err
 	}
 
 	s.log.Debugf("targetEth1Block %d", targetEth1BlockHeight)
 
-	latestDistributeHeight := s.latestDistributeWithdrawalsHeight
+	readOpts, err := s.readOpts(ctx, targetEth1BlockHeight)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	latestDistributeWithdrawalsHeight, err := s.networkWithdrawContract.LatestDistributeWithdrawalsHeight(readOpts)
 	if err != nil {
 		return 0, 0, false, err
 	}
+	latestDistributeHeight := latestDistributeWithdrawalsHeight.Uint64()
 	// init case
 	if latestDistributeHeight == 0 {
 		latestDistributeHeight = s.startAtBlock
@@ -76,7 +93,7 @@ func (s *Service) checkStateForDistributeWithdraw() (uint64, uint64, bool, error
 	}
 
 	// wait sync block
-	if targetEth1BlockHeight > s.latestBlockOfSyncBlock {
+	if targetEth1BlockHeight > s.latestBlockOfSyncBlock.Load() {
 		return 0, 0, false, nil
 	}
 
@@ -136,28 +153,19 @@ func (s *Service) calMaxClaimableWithdrawIndex(targetEth1BlockHeight uint64, tot
 	return newMaxClaimableWithdrawIndex, nil
 }
 
-func (s *Service) sendDistributeTx(distributeType uint8, targetEth1BlockHeight, totalUserEth, totalNodeEth, totalPlatformEth, newMaxClaimableWithdrawIndex *big.Int) error {
-	err := s.connection.LockAndUpdateTxOpts()
-	if err != nil {
-		return fmt.Errorf("LockAndUpdateTxOpts err: %w", err)
-	}
-	defer s.connection.UnlockTxOpts()
-
+func (s *Service) sendDistributeTx(ctx context.Context, distributeType uint8, targetEth1BlockHeight, totalUserEth, totalNodeEth, totalPlatformEth, newMaxClaimableWithdrawIndex *big.Int) error {
 	encodeBts, err := s.networkWithdrawAbi.Pack("distribute", distributeType, targetEth1BlockHeight,
 		totalUserEth, totalNodeEth, totalPlatformEth, newMaxClaimableWithdrawIndex)
 	if err != nil {
 		return err
 	}
-
-	proposalId := utils.ProposalId(s.networkWithdrawAddress, encodeBts, targetEth1BlockHeight)
-
-	// check voted
-	hasVoted, err := s.networkProposalContract.HasVoted(nil, proposalId, s.connection.Keypair().CommonAddress())
-	if err != nil {
-		return fmt.Errorf("networkProposalContract.HasVoted err: %s", err)
+	proposalType := metrics.ProposalDistributeWithdrawals
+	if distributeType == utils.DistributeTypePriorityFee {
+		proposalType = metrics.ProposalDistributePriorityFee
 	}
-	if hasVoted {
-		return nil
+	s.trackOwnProposal(proposalType, s.networkWithdrawAddress, encodeBts, targetEth1BlockHeight)
+	if s.dryRun {
+		return s.dryRunProposal(proposalType, s.networkWithdrawAddress, encodeBts, targetEth1BlockHeight)
 	}
 
 	s.log.WithFields(logrus.Fields{
@@ -169,12 +177,5 @@ func (s *Service) sendDistributeTx(distributeType uint8, targetEth1BlockHeight,
 		"newMaxClaimableWithdrawIndex": newMaxClaimableWithdrawIndex,
 	}).Info("Will sendDistributeTx")
 
-	tx, err := s.networkProposalContract.ExecProposal(s.connection.TxOpts(), s.networkWithdrawAddress, encodeBts, targetEth1BlockHeight)
-	if err != nil {
-		return err
-	}
-
-	s.log.Infof("send Distribute tx hash: %s", tx.Hash().String())
-
-	return s.waitProposalTxOk(tx.Hash(), proposalId)
+	return s.voteProposal(ctx, proposalType, s.networkWithdrawAddress, encodeBts, targetEth1BlockHeight)
 }
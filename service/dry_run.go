@@ -0,0 +1,143 @@
+package service
+
+import (
+	"encoding/hex"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+)
+
+// results of a dry run proposal
+const (
+	DryRunResultExecuted = "executed" // our proposal has been executed by other voters
+	DryRunResultMatched  = "matched"  // other voters voted the same proposal
+	DryRunResultDiverged = "diverged" // other voters voted a different proposal with the same factor
+	DryRunResultPending  = "pending"  // nobody voted yet
+)
+
+const (
+	maxDryRunResults      = 100
+	dryRunRecheckInterval = 5 * time.Minute
+)
+
+// DryRunResult records a proposal computed in dry run mode and how it compares with on-chain votes.
+type DryRunResult struct {
+	ProposalType      string    `json:"proposalType"`
+	ProposalId        string    `json:"proposalId"`
+	To                string    `json:"to"`
+	CallData          string    `json:"callData"`
+	Factor            string    `json:"factor"`
+	Result            string    `json:"result"`
+	YesVotes          uint8     `json:"yesVotes"`
+	VoterHasVoted     bool      `json:"voterHasVoted"`
+	DivergedProposals []string  `json:"divergedProposals,omitempty"`
+	CheckedAt         time.Time `json:"checkedAt"`
+}
+
+// dryRunProposal compares the proposal with what other voters proposed on-chain and records
+// the result instead of sending a tx.
+func (s *Service) dryRunProposal(proposalType string, to common.Address, callData []byte, factor *big.Int) error {
+	if s.replay != nil {
+		s.replay.capture(proposalType, to, callData, factor)
+		return nil
+	}
+	proposalId := utils.ProposalId(to, callData, factor)
+	proposalIdHex := hex.EncodeToString(proposalId[:])
+
+	// executed proposals never change, others are rechecked after an interval
+	s.dryRunMutex.Lock()
+	last, checked := s.dryRunChecked[proposalId]
+	s.dryRunMutex.Unlock()
+	if checked && (last.Result == DryRunResultExecuted || time.Since(last.CheckedAt) < dryRunRecheckInterval) {
+		return nil
+	}
+
+	proposal, err := s.networkProposalContract.Proposals(nil, proposalId)
+	if err != nil {
+		return err
+	}
+	result := DryRunResult{
+		ProposalType: proposalType,
+		ProposalId:   proposalIdHex,
+		To:           to.String(),
+		CallData:     hex.EncodeToString(callData),
+		Factor:       factor.String(),
+		YesVotes:     proposal.YesVotesTotal,
+		CheckedAt:    time.Now(),
+	}
+	if s.dryRunVoter != (common.Address{}) {
+		result.VoterHasVoted, err = s.networkProposalContract.HasVoted(nil, proposalId, s.dryRunVoter)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case proposal.Status == proposalStatusExecuted:
+		result.Result = DryRunResultExecuted
+	default:
+		s.votedProposalsMutex.Lock()
+		if err := s.syncVotedProposals(s.ctx); err != nil {
+			s.votedProposalsMutex.Unlock()
+			return err
+		}
+		for id, p := range s.votedProposals {
+			if id != proposalId && p.sameKind(to, callData, factor) {
+				result.DivergedProposals = append(result.DivergedProposals, hex.EncodeToString(id[:]))
+			}
+		}
+		s.votedProposalsMutex.Unlock()
+
+		switch {
+		case len(result.DivergedProposals) > 0:
+			result.Result = DryRunResultDiverged
+		case proposal.YesVotesTotal > 0:
+			result.Result = DryRunResultMatched
+		default:
+			result.Result = DryRunResultPending
+		}
+	}
+
+	s.recordDryRunResult(proposalId, result)
+	return nil
+}
+
+func (s *Service) recordDryRunResult(proposalId [32]byte, result DryRunResult) {
+	s.dryRunMutex.Lock()
+	defer s.dryRunMutex.Unlock()
+
+	s.dryRunChecked[proposalId] = result
+	s.dryRunResults = append(s.dryRunResults, result)
+	if len(s.dryRunResults) > maxDryRunResults {
+		s.dryRunResults = s.dryRunResults[len(s.dryRunResults)-maxDryRunResults:]
+	}
+
+	metrics.DryRunProposals.WithLabelValues(s.lsdTokenAddress.String(), result.ProposalType, result.Result).Inc()
+
+	log := s.log.WithFields(logrus.Fields{
+		"proposalType": result.ProposalType,
+		"proposalId":   result.ProposalId,
+		"factor":       result.Factor,
+		"yesVotes":     result.YesVotes,
+		"result":       result.Result,
+	})
+	if result.Result == DryRunResultDiverged {
+		log.WithField("divergedProposals", result.DivergedProposals).Warn("dry run proposal diverged from other voters")
+	} else {
+		log.Info("dry run proposal")
+	}
+}
+
+// DryRunResults returns the latest dry run results, oldest first.
+func (s *Service) DryRunResults() []DryRunResult {
+	s.dryRunMutex.Lock()
+	defer s.dryRunMutex.Unlock()
+
+	results := make([]DryRunResult, len(s.dryRunResults))
+	copy(results, s.dryRunResults)
+	return results
+}
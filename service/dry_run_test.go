@@ -0,0 +1,90 @@
+package service
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunProposal(t *testing.T) {
+	backend := newFakeBackend(10000)
+	s := newFakeBackendService(t, backend)
+	s.confirmationBlocks = 2
+	s.nodeDepositAddress = common.HexToAddress("0x02")
+	s.networkWithdrawAddress = common.HexToAddress("0x03")
+	s.dryRunVoter = common.HexToAddress("0xaa")
+
+	// proposal id -> (status, yes votes)
+	statuses := make(map[[32]byte][2]uint8)
+	backend.handleCall(fakeNetworkProposalAddress, s.networkProposalAbi, "proposals", func(args []interface{}) []interface{} {
+		status := statuses[args[0].([32]byte)]
+		return []interface{}{status[0], uint16(0), status[1]}
+	})
+	backend.handleCall(fakeNetworkProposalAddress, s.networkProposalAbi, "hasVoted", func(args []interface{}) []interface{} {
+		return []interface{}{false}
+	})
+
+	merkleRoot := func(epoch int64, root byte) []byte {
+		callData, err := s.networkWithdrawAbi.Pack("setMerkleRoot", big.NewInt(epoch), [32]byte{root}, "cid")
+		require.NoError(t, err)
+		return callData
+	}
+	voteCredentials := func(pubkey byte, match bool) []byte {
+		callData, err := s.nodeDepositAbi.Pack("voteWithdrawCredentials", []byte{pubkey}, match)
+		require.NoError(t, err)
+		return callData
+	}
+	voter := common.HexToAddress("0xbb")
+	lastResult := func() DryRunResult {
+		results := s.DryRunResults()
+		require.NotEmpty(t, results)
+		return results[len(results)-1]
+	}
+	dryRun := func(to common.Address, callData []byte, factor int64) DryRunResult {
+		require.NoError(t, s.dryRunProposal(metrics.ProposalSetMerkleRoot, to, callData, big.NewInt(factor)))
+		return lastResult()
+	}
+
+	// votes before the lookback are not synced
+	backend.voteProposal(s, 100, voter, s.networkWithdrawAddress, merkleRoot(5, 9), big.NewInt(5))
+	diverged := backend.voteProposal(s, 9000, voter, s.networkWithdrawAddress, merkleRoot(10, 2), big.NewInt(10))
+	statuses[diverged] = [2]uint8{1, 1}
+	backend.voteProposal(s, 9100, voter, s.nodeDepositAddress, voteCredentials(2, true), big.NewInt(0))
+	matched := utils.ProposalId(s.networkWithdrawAddress, merkleRoot(20, 1), big.NewInt(20))
+	statuses[matched] = [2]uint8{1, 1}
+	executed := utils.ProposalId(s.networkWithdrawAddress, merkleRoot(30, 1), big.NewInt(30))
+	statuses[executed] = [2]uint8{proposalStatusExecuted, 3}
+
+	result := dryRun(s.networkWithdrawAddress, merkleRoot(10, 1), 10)
+	assert.Equal(t, DryRunResultDiverged, result.Result)
+	assert.Equal(t, []string{hex.EncodeToString(diverged[:])}, result.DivergedProposals)
+	assert.Equal(t, DryRunResultMatched, dryRun(s.networkWithdrawAddress, merkleRoot(20, 1), 20).Result)
+	assert.Equal(t, DryRunResultExecuted, dryRun(s.networkWithdrawAddress, merkleRoot(30, 1), 30).Result)
+	assert.Equal(t, DryRunResultPending, dryRun(s.networkWithdrawAddress, merkleRoot(5, 1), 5).Result)
+	// other validators voted, this one not yet
+	assert.Equal(t, DryRunResultPending, dryRun(s.nodeDepositAddress, voteCredentials(1, true), 0).Result)
+	assert.Equal(t, DryRunResultDiverged, dryRun(s.nodeDepositAddress, voteCredentials(2, false), 0).Result)
+	assert.Equal(t, uint64(9998), s.latestBlockOfSyncProposals)
+
+	// later dry runs only scan the blocks after the synced one
+	backend.filters = nil
+	backend.voteProposal(s, 10005, voter, s.networkWithdrawAddress, merkleRoot(40, 2), big.NewInt(40))
+	backend.setBlockNumber(s, 10010)
+	assert.Equal(t, DryRunResultDiverged, dryRun(s.networkWithdrawAddress, merkleRoot(40, 1), 40).Result)
+	require.NotEmpty(t, backend.filters)
+	for _, q := range backend.filters {
+		assert.Equal(t, uint64(9999), q.FromBlock.Uint64())
+		assert.Equal(t, uint64(10008), q.ToBlock.Uint64())
+	}
+
+	// results are rechecked only after an interval
+	count := len(s.DryRunResults())
+	require.NoError(t, s.dryRunProposal(metrics.ProposalSetMerkleRoot, s.networkWithdrawAddress, merkleRoot(40, 1), big.NewInt(40)))
+	assert.Len(t, s.DryRunResults(), count)
+}
@@ -0,0 +1,292 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+	deposit_contract "github.com/stafiprotocol/eth-lsd-relay/bindings/DepositContract"
+	fee_pool "github.com/stafiprotocol/eth-lsd-relay/bindings/FeePool"
+	network_proposal "github.com/stafiprotocol/eth-lsd-relay/bindings/NetworkProposal"
+	network_withdraw "github.com/stafiprotocol/eth-lsd-relay/bindings/NetworkWithdraw"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCallKey struct {
+	to       common.Address
+	selector [4]byte
+}
+
+// fakeBackend serves contract calls, logs and txs registered by a test.
+type fakeBackend struct {
+	connection.ContractBackend
+
+	mutex       sync.Mutex
+	blockNumber uint64
+	calls       map[fakeCallKey]func(input []byte) ([]byte, error)
+	logs        []types.Log
+	txs         map[common.Hash]*types.Transaction
+	filters     []ethereum.FilterQuery
+	headers     []*types.Header
+	fork        byte
+	balances    map[uint64]*big.Int // block -> balance of the fee pool
+	balanceAt   [][]uint64          // blocks of the balance batches
+}
+
+func newFakeBackend(blockNumber uint64) *fakeBackend {
+	return &fakeBackend{
+		blockNumber: blockNumber,
+		calls:       make(map[fakeCallKey]func(input []byte) ([]byte, error)),
+		txs:         make(map[common.Hash]*types.Transaction),
+	}
+}
+
+// handleCall serves calls of method of the contract at to with handle, which returns the outputs of the args.
+func (b *fakeBackend) handleCall(to common.Address, contractAbi abi.ABI, method string, handle func(args []interface{}) []interface{}) {
+	m := contractAbi.Methods[method]
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.calls[fakeCallKey{to, [4]byte(m.ID)}] = func(input []byte) ([]byte, error) {
+		args, err := m.Inputs.Unpack(input)
+		if err != nil {
+			return nil, err
+		}
+		return m.Outputs.Pack(handle(args)...)
+	}
+}
+
+// addTx adds a tx calling to with data and returns its hash.
+func (b *fakeBackend) addTx(to common.Address, data []byte) common.Hash {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	tx := types.NewTx(&types.LegacyTx{Nonce: uint64(len(b.txs)), To: &to, Data: data})
+	b.txs[tx.Hash()] = tx
+	return tx.Hash()
+}
+
+// addLog adds an event log of the contract at address, topics are the indexed args and args the others.
+func (b *fakeBackend) addLog(address common.Address, contractAbi abi.ABI, event string, block uint64, txHash common.Hash, topics []common.Hash, args ...interface{}) {
+	e := contractAbi.Events[event]
+	data, err := e.Inputs.NonIndexed().Pack(args...)
+	if err != nil {
+		panic(err)
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.logs = append(b.logs, types.Log{
+		Address:     address,
+		Topics:      append([]common.Hash{e.ID}, topics...),
+		Data:        data,
+		BlockNumber: block,
+		TxHash:      txHash,
+		Index:       uint(len(b.logs)),
+	})
+}
+
+// setBlockNumber moves the latest block of backend and of the cached connection of s.
+func (b *fakeBackend) setBlockNumber(s *Service, blockNumber uint64) {
+	b.mutex.Lock()
+	b.blockNumber = blockNumber
+	b.mutex.Unlock()
+	if err := s.connection.SyncEth1LatestBlock(); err != nil {
+		panic(err)
+	}
+}
+
+// reorg replaces the blocks from number from on with the ones of a new fork, dropping their logs.
+func (b *fakeBackend) reorg(from uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.fork++
+	b.headers = b.headers[:min(uint64(len(b.headers)), from)]
+	b.logs = lo.Filter(b.logs, func(l types.Log, _ int) bool { return l.BlockNumber < from })
+}
+
+// headerOf returns the header of block number, chained to its parent and marked with the fork it was
+// added in, the caller must hold b.mutex.
+func (b *fakeBackend) headerOf(number uint64) *types.Header {
+	for n := uint64(len(b.headers)); n <= number; n++ {
+		header := &types.Header{Number: new(big.Int).SetUint64(n), Difficulty: common.Big0, Time: 12 * n, Extra: []byte{b.fork}}
+		if n > 0 {
+			header.ParentHash = b.headers[n-1].Hash()
+		}
+		b.headers = append(b.headers, header)
+	}
+	return b.headers[number]
+}
+
+func (b *fakeBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	// block tags are read as the latest block
+	if number == nil || number.Sign() < 0 {
+		return b.headerOf(b.blockNumber), nil
+	}
+	if number.Uint64() > b.blockNumber {
+		return nil, ethereum.NotFound
+	}
+	return b.headerOf(number.Uint64()), nil
+}
+
+func (b *fakeBackend) BlockNumber(ctx context.Context) (uint64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.blockNumber, nil
+}
+
+func (b *fakeBackend) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return []byte{1}, nil
+}
+
+func (b *fakeBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if call.To == nil || len(call.Data) < 4 {
+		return nil, fmt.Errorf("fake backend: bad call")
+	}
+	b.mutex.Lock()
+	handle, exist := b.calls[fakeCallKey{*call.To, [4]byte(call.Data[:4])}]
+	b.mutex.Unlock()
+	if !exist {
+		return nil, fmt.Errorf("fake backend: no call %x of %s", call.Data[:4], call.To)
+	}
+	return handle(call.Data[4:])
+}
+
+func (b *fakeBackend) TransactionByHash(ctx context.Context, txHash common.Hash) (*types.Transaction, bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	tx, exist := b.txs[txHash]
+	if !exist {
+		return nil, false, ethereum.NotFound
+	}
+	return tx, false, nil
+}
+
+// BatchCallContext serves eth_getBalance of the fee pool from b.balances.
+func (b *fakeBackend) BatchCallContext(ctx context.Context, batch []rpc.BatchElem) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	blocks := make([]uint64, 0, len(batch))
+	for i := range batch {
+		if batch[i].Method != "eth_getBalance" {
+			return fmt.Errorf("fake backend: no method %s", batch[i].Method)
+		}
+		block, err := hexutil.DecodeUint64(batch[i].Args[1].(string))
+		if err != nil {
+			return err
+		}
+		balance, exist := b.balances[block]
+		if !exist {
+			batch[i].Error = fmt.Errorf("fake backend: no balance at block %d", block)
+			continue
+		}
+		*batch[i].Result.(*hexutil.Big) = hexutil.Big(*balance)
+		blocks = append(blocks, block)
+	}
+	b.balanceAt = append(b.balanceAt, blocks)
+	return nil
+}
+
+func (b *fakeBackend) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.filters = append(b.filters, q)
+
+	logs := make([]types.Log, 0)
+	for _, l := range b.logs {
+		if q.FromBlock != nil && l.BlockNumber < q.FromBlock.Uint64() {
+			continue
+		}
+		if q.ToBlock != nil && l.BlockNumber > q.ToBlock.Uint64() {
+			continue
+		}
+		if len(q.Addresses) > 0 && !lo.Contains(q.Addresses, l.Address) {
+			continue
+		}
+		if !matchTopics(q.Topics, l.Topics) {
+			continue
+		}
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
+
+func matchTopics(filter [][]common.Hash, topics []common.Hash) bool {
+	if len(filter) > len(topics) {
+		return false
+	}
+	for i, hashes := range filter {
+		if len(hashes) > 0 && !lo.Contains(hashes, topics[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// newFakeBackendService returns a service reading the network proposal, network withdraw, fee pool and deposit
+// contracts at the fake addresses from backend.
+func newFakeBackendService(t *testing.T, backend connection.ContractBackend) *Service {
+	conn, err := connection.NewConnectionWithEth1Client(backend)
+	require.NoError(t, err)
+	cachedConn, err := connection.NewCachedConnection(conn)
+	require.NoError(t, err)
+	require.NoError(t, cachedConn.SyncEth1LatestBlock())
+	s := &Service{
+		ctx:                      context.Background(),
+		log:                      logrus.NewEntry(logrus.StandardLogger()),
+		connection:               cachedConn,
+		eventFilterMaxSpanBlocks: 1000,
+		networkProposalAddress:   fakeNetworkProposalAddress,
+		dryRunChecked:            make(map[[32]byte]DryRunResult),
+		votedProposals:           make(map[[32]byte]*votedProposal),
+		ownProposals:             make(map[[32]byte]*ownProposal),
+		reportedDivergences:      make(map[[2][32]byte]bool),
+		feePoolAddress:           fakeFeePoolAddress,
+		feeCache:                 newFeeCache(),
+	}
+	require.NoError(t, s.initAbi())
+	s.networkProposalContract, err = network_proposal.NewNetworkProposal(fakeNetworkProposalAddress, conn.Eth1Client())
+	require.NoError(t, err)
+	s.networkWithdrawContract, err = network_withdraw.NewNetworkWithdraw(fakeNetworkWithdrawAddress, conn.Eth1Client())
+	require.NoError(t, err)
+	s.govDepositContract, err = deposit_contract.NewDepositContract(fakeDepositAddress, conn.Eth1Client())
+	require.NoError(t, err)
+	s.feePoolContract, err = fee_pool.NewFeePool(fakeFeePoolAddress, conn.Eth1Client())
+	require.NoError(t, err)
+	return s
+}
+
+var (
+	fakeNetworkProposalAddress = common.HexToAddress("0x1000000000000000000000000000000000000001")
+	fakeNetworkWithdrawAddress = common.HexToAddress("0x1000000000000000000000000000000000000002")
+	fakeDepositAddress         = common.HexToAddress("0x1000000000000000000000000000000000000003")
+	fakeFeePoolAddress         = common.HexToAddress("0x1000000000000000000000000000000000000004")
+)
+
+// voteProposal adds an execProposal tx of voter and its VoteProposal log at block.
+func (b *fakeBackend) voteProposal(s *Service, block uint64, voter, to common.Address, callData []byte, factor *big.Int) [32]byte {
+	data, err := s.networkProposalAbi.Pack("execProposal", to, callData, factor)
+	if err != nil {
+		panic(err)
+	}
+	proposalId := utils.ProposalId(to, callData, factor)
+	txHash := b.addTx(fakeNetworkProposalAddress, data)
+	b.addLog(fakeNetworkProposalAddress, s.networkProposalAbi, "VoteProposal", block, txHash, []common.Hash{proposalId}, voter)
+	return proposalId
+}
+
+// executeProposal adds the ProposalExecuted log of proposalId at block.
+func (b *fakeBackend) executeProposal(s *Service, block uint64, proposalId [32]byte) {
+	b.addLog(fakeNetworkProposalAddress, s.networkProposalAbi, "ProposalExecuted", block, common.Hash{}, []common.Hash{proposalId})
+}
@@ -10,12 +10,13 @@ import (
 	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
 )
 
-func (s *Service) notifyValidatorExit() error {
+func (s *Service) notifyValidatorExit(ctx context.Context) error {
 	l := s.log.WithField("handler", "notifyValidatorExit")
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*60)
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*60)
 	defer cancel()
 	currentCycle, targetTimestamp, err := s.currentCycleAndStartTimestamp()
 	if err != nil {
@@ -24,7 +25,7 @@ func (s *Service) notifyValidatorExit() error {
 	willDealCycle := currentCycle - 1
 
 	targetEpoch := utils.EpochAtTimestamp(s.eth2Config, uint64(targetTimestamp))
-	targetBlockNumber, err := s.getEpochStartBlocknumberWithCheck(targetEpoch)
+	targetBlockNumber, err := s.getEpochStartBlocknumberWithCheck(ctx, targetEpoch)
 	if err != nil {
 		return fmt.Errorf("getEpochStartBlocknumberWithCheck failed: %w", err)
 	}
@@ -33,17 +34,17 @@ func (s *Service) notifyValidatorExit() error {
 	}
 
 	// wait validator updated
-	if targetEpoch > s.latestEpochOfUpdateValidator {
+	if targetEpoch > s.latestEpochOfUpdateValidator.Load() {
 		l.WithField("targetEpoch", targetEpoch).
-			WithField("latestEpochOfUpdateValidator", s.latestEpochOfUpdateValidator).
+			WithField("latestEpochOfUpdateValidator", s.latestEpochOfUpdateValidator.Load()).
 			Debug("wait validator updated")
 		return nil
 	}
 
 	// wait sync block
-	if targetBlockNumber > s.latestBlockOfSyncBlock {
+	if targetBlockNumber > s.latestBlockOfSyncBlock.Load() {
 		l.WithField("targetBlockNumber", targetBlockNumber).
-			WithField("latestBlockOfSyncBlock", s.latestBlockOfSyncBlock).
+			WithField("latestBlockOfSyncBlock", s.latestBlockOfSyncBlock.Load()).
 			Debug("wait sync block")
 		return nil
 	}
@@ -62,7 +63,11 @@ func (s *Service) notifyValidatorExit() error {
 		return nil
 	}
 
-	ejectedValidator, err := s.networkWithdrawContract.GetEjectedValidatorsAtCycle(nil, big.NewInt(willDealCycle))
+	readOpts, err := s.readOpts(ctx, targetBlockNumber)
+	if err != nil {
+		return err
+	}
+	ejectedValidator, err := s.networkWithdrawContract.GetEjectedValidatorsAtCycle(readOpts, big.NewInt(willDealCycle))
 	if err != nil {
 		return fmt.Errorf("GetEjectedValidatorsAtCycle failed: %w", err)
 	}
@@ -102,7 +107,7 @@ func (s *Service) notifyValidatorExit() error {
 	if err != nil {
 		return err
 	}
-	userUndistributedWithdrawalsDeci, _, _, _, err := s.getUserNodePlatformFromWithdrawals(latestDistributeWithdrawalHeight.Uint64(), targetBlockNumber)
+	userUndistributedWithdrawalsDeci, _, _, _, err := s.getUserNodePlatformFromWithdrawals(latestDistributeWithdrawalHeight.Uint64(), targetBlockNumber, nil)
 	if err != nil {
 		return errors.Wrap(err, "getUserNodePlatformFromWithdrawals failed")
 	}
@@ -139,31 +144,18 @@ func (s *Service) notifyValidatorExit() error {
 	}
 
 	// ---- send NotifyValidatorExit tx
-	return s.sendNotifyExitTx(uint64(willDealCycle), uint64(startCycle), selectVals)
+	return s.sendNotifyExitTx(ctx, uint64(willDealCycle), uint64(startCycle), selectVals)
 }
 
-func (s *Service) sendNotifyExitTx(withdrawCycle, startCycle uint64, selectVals []*big.Int) error {
-	err := s.connection.LockAndUpdateTxOpts()
-	if err != nil {
-		return fmt.Errorf("LockAndUpdateTxOpts err: %w", err)
-	}
-	defer s.connection.UnlockTxOpts()
-
+func (s *Service) sendNotifyExitTx(ctx context.Context, withdrawCycle, startCycle uint64, selectVals []*big.Int) error {
 	encodeBts, err := s.networkWithdrawAbi.Pack("notifyValidatorExit", big.NewInt(int64(withdrawCycle)),
 		big.NewInt(int64(startCycle)), selectVals)
 	if err != nil {
 		return err
 	}
-
-	proposalId := utils.ProposalId(s.networkWithdrawAddress, encodeBts, big.NewInt(int64(withdrawCycle)))
-
-	// check voted
-	hasVoted, err := s.networkProposalContract.HasVoted(nil, proposalId, s.connection.Keypair().CommonAddress())
-	if err != nil {
-		return fmt.Errorf("networkProposalContract.HasVoted err: %s", err)
-	}
-	if hasVoted {
-		return nil
+	s.trackOwnProposal(metrics.ProposalNotifyValidatorExit, s.networkWithdrawAddress, encodeBts, big.NewInt(int64(withdrawCycle)))
+	if s.dryRun {
+		return s.dryRunProposal(metrics.ProposalNotifyValidatorExit, s.networkWithdrawAddress, encodeBts, big.NewInt(int64(withdrawCycle)))
 	}
 
 	s.log.WithFields(logrus.Fields{
@@ -172,14 +164,7 @@ func (s *Service) sendNotifyExitTx(withdrawCycle, startCycle uint64, selectVals
 		"selectVal":       selectVals,
 	}).Debug("will sendNotifyValidatorExitTx")
 
-	tx, err := s.networkProposalContract.ExecProposal(s.connection.TxOpts(), s.networkWithdrawAddress, encodeBts, big.NewInt(int64(withdrawCycle)))
-	if err != nil {
-		return err
-	}
-
-	s.log.Info("send NotifyValidatorExit tx hash: ", tx.Hash().String())
-
-	return s.waitProposalTxOk(tx.Hash(), proposalId)
+	return s.voteProposal(ctx, metrics.ProposalNotifyValidatorExit, s.networkWithdrawAddress, encodeBts, big.NewInt(int64(withdrawCycle)))
 }
 
 func (s *Service) currentCycleAndStartTimestamp() (int64, int64, error) {
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// balances of the fee pool requested in one json-rpc batch
+const feePoolBalanceBatchSize = 100
+
+// accounting of the priority fees of blocks proposed by our validators
+const (
+	// the increase of the fee pool balance in the block plus the ether withdrawn from it, which also counts
+	// ether sent to the fee pool by anyone
+	feeAccountingBalance = "balance"
+	// the priority fees of the block receipts if the fee pool is the coinbase, else the payment of the
+	// builder to the fee pool, ether sent to the fee pool by internal calls is not seen
+	feeAccountingReceipts = "receipts"
+)
+
+// feeCache memoizes the priority fees received by the fee pool in execution blocks, so submitBalances,
+// distributePriorityFee and setMerkleRoot share them. Fees are only computed for blocks up to target blocks
+// of finalized epochs, which can not reorg.
+type feeCache struct {
+	mutex    sync.Mutex
+	balance  map[uint64]*big.Int // execution block number -> fee by balance, negative if the balance dropped
+	receipts map[uint64]*big.Int // execution block number -> fee by receipts
+}
+
+func newFeeCache() *feeCache {
+	return &feeCache{
+		balance:  make(map[uint64]*big.Int),
+		receipts: make(map[uint64]*big.Int),
+	}
+}
+
+// prune forgets the fees of blocks up to block, which are already distributed.
+func (c *feeCache) prune(block uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, fees := range []map[uint64]*big.Int{c.balance, c.receipts} {
+		for number := range fees {
+			if number <= block {
+				delete(fees, number)
+			}
+		}
+	}
+}
+
+// priorityFeesOf returns the fees of blocks by the accounting of the service.
+func (s *Service) priorityFeesOf(ctx context.Context, blocks []uint64) (map[uint64]*big.Int, error) {
+	if s.priorityFeeAccounting == feeAccountingReceipts {
+		return s.cachedFees(ctx, s.feeCache.receipts, blocks, s.fetchReceiptFees)
+	}
+	fees, err := s.cachedFees(ctx, s.feeCache.balance, blocks, s.fetchBalanceFees)
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		if fees[block].Sign() < 0 {
+			return nil, fmt.Errorf("should not happened here when cal priority fee, block: %d", block)
+		}
+	}
+	return fees, nil
+}
+
+// cachedFees returns the fees of blocks in cache, the missing ones are fetched by fetch.
+func (s *Service) cachedFees(ctx context.Context, cache map[uint64]*big.Int, blocks []uint64,
+	fetch func(context.Context, []uint64) (map[uint64]*big.Int, error)) (map[uint64]*big.Int, error) {
+	// held while fetching, handlers asking for the same blocks meanwhile wait for the fetched fees
+	s.feeCache.mutex.Lock()
+	defer s.feeCache.mutex.Unlock()
+
+	missing := make([]uint64, 0)
+	for _, block := range blocks {
+		if _, ok := cache[block]; !ok {
+			missing = append(missing, block)
+		}
+	}
+	if len(missing) > 0 {
+		fees, err := fetch(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		for block, fee := range fees {
+			cache[block] = fee
+		}
+	}
+
+	fees := make(map[uint64]*big.Int, len(blocks))
+	for _, block := range blocks {
+		fees[block] = cache[block]
+	}
+	return fees, nil
+}
+
+// fetchBalanceFees fetches the fees by balance with batched balance requests and a log filter over the range
+// of blocks.
+func (s *Service) fetchBalanceFees(ctx context.Context, blocks []uint64) (map[uint64]*big.Int, error) {
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+
+	balanceBlocks := make([]uint64, 0, 2*len(blocks))
+	for _, block := range blocks {
+		if n := len(balanceBlocks); n == 0 || balanceBlocks[n-1] < block-1 {
+			balanceBlocks = append(balanceBlocks, block-1)
+		}
+		balanceBlocks = append(balanceBlocks, block)
+	}
+	balances, err := s.feePoolBalancesAt(ctx, balanceBlocks)
+	if err != nil {
+		return nil, err
+	}
+	withdrawn, err := s.feePoolWithdrawnIn(ctx, blocks[0], blocks[len(blocks)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	fees := make(map[uint64]*big.Int, len(blocks))
+	for _, block := range blocks {
+		fee := new(big.Int).Sub(balances[block], balances[block-1])
+		if amount, ok := withdrawn[block]; ok {
+			fee.Add(fee, amount)
+		}
+		fees[block] = fee
+	}
+	return fees, nil
+}
+
+// feePoolBalancesAt returns the balances of the fee pool at blocks, requested in batches.
+func (s *Service) feePoolBalancesAt(ctx context.Context, blocks []uint64) (map[uint64]*big.Int, error) {
+	balances := make(map[uint64]*big.Int, len(blocks))
+	for i := 0; i < len(blocks); i += feePoolBalanceBatchSize {
+		chunk := blocks[i:min(i+feePoolBalanceBatchSize, len(blocks))]
+		results := make([]hexutil.Big, len(chunk))
+		err := retry.Do(func() error {
+			batch := make([]rpc.BatchElem, len(chunk))
+			for j, block := range chunk {
+				batch[j] = rpc.BatchElem{
+					Method: "eth_getBalance",
+					Args:   []interface{}{s.feePoolAddress, hexutil.EncodeUint64(block)},
+					Result: &results[j],
+				}
+			}
+			if err := s.connection.Eth1Client().BatchCallContext(ctx, batch); err != nil {
+				return err
+			}
+			for j, elem := range batch {
+				if elem.Error != nil {
+					return fmt.Errorf("get fee pool balance at block %d err: %w", chunk[j], elem.Error)
+				}
+			}
+			return nil
+		}, retry.Delay(time.Second*2), retry.Attempts(5), retry.Context(ctx))
+		if err != nil {
+			return nil, err
+		}
+		for j, block := range chunk {
+			balances[block] = results[j].ToInt()
+		}
+	}
+	return balances, nil
+}
+
+// feePoolWithdrawnIn returns the ether withdrawn from the fee pool in the blocks from start to end withdrawing it.
+func (s *Service) feePoolWithdrawnIn(ctx context.Context, start, end uint64) (map[uint64]*big.Int, error) {
+	withdrawn := make(map[uint64]*big.Int)
+	for subStart := start; subStart <= end; subStart += s.eventFilterMaxSpanBlocks {
+		subEnd := min(subStart+s.eventFilterMaxSpanBlocks-1, end)
+		subWithdrawn := make(map[uint64]*big.Int)
+		err := retry.Do(func() error {
+			clear(subWithdrawn)
+			iter, err := s.feePoolContract.FilterEtherWithdrawn(&bind.FilterOpts{
+				Start:   subStart,
+				End:     &subEnd,
+				Context: ctx,
+			})
+			if err != nil {
+				return err
+			}
+			defer iter.Close()
+			for iter.Next() {
+				block := iter.Event.Raw.BlockNumber
+				if subWithdrawn[block] == nil {
+					subWithdrawn[block] = big.NewInt(0)
+				}
+				subWithdrawn[block].Add(subWithdrawn[block], iter.Event.Amount)
+			}
+			return iter.Error()
+		}, retry.Delay(time.Second*2), retry.Attempts(5), retry.Context(ctx))
+		if err != nil {
+			return nil, err
+		}
+		for block, amount := range subWithdrawn {
+			withdrawn[block] = amount
+		}
+	}
+	return withdrawn, nil
+}
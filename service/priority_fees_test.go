@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	fee_pool "github.com/stafiprotocol/eth-lsd-relay/bindings/FeePool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriorityFeesByBalance(t *testing.T) {
+	backend := newFakeBackend(40)
+	s := newFakeBackendService(t, backend)
+	s.eventFilterMaxSpanBlocks = 10
+
+	feePoolAbi, err := abi.JSON(strings.NewReader(fee_pool.FeePoolABI))
+	require.NoError(t, err)
+	withdraw := func(block uint64, amount int64) {
+		backend.addLog(fakeFeePoolAddress, feePoolAbi, "EtherWithdrawn", block, common.Hash{}, nil, big.NewInt(amount), big.NewInt(0))
+	}
+	// the fee pool receives n wei in block n
+	withdrawn := map[uint64]int64{14: 7, 15: 5}
+	withdraw(14, 3)
+	withdraw(14, 4)
+	withdraw(15, 5)
+	backend.balances = make(map[uint64]*big.Int)
+	balance := int64(0)
+	for n := uint64(0); n <= 40; n++ {
+		balance += int64(n) - withdrawn[n]
+		backend.balances[n] = big.NewInt(balance)
+	}
+	feesOf := func(blocks ...uint64) map[uint64]*big.Int {
+		fees := make(map[uint64]*big.Int)
+		for _, block := range blocks {
+			fees[block] = new(big.Int).SetUint64(block)
+		}
+		return fees
+	}
+
+	fees, err := s.priorityFeesOf(context.Background(), []uint64{25, 5, 6, 14, 15})
+	require.NoError(t, err)
+	assert.Equal(t, feesOf(5, 6, 14, 15, 25), fees)
+	// the balance of a block is requested once when it is also the parent of the next block
+	assert.Equal(t, [][]uint64{{4, 5, 6, 13, 14, 15, 24, 25}}, backend.balanceAt)
+	// withdrawals of blocks 14 and 15 are filtered in different spans
+	assert.Len(t, backend.filters, 3)
+
+	// cached blocks are not fetched again
+	fees, err = s.priorityFeesOf(context.Background(), []uint64{5, 6, 30})
+	require.NoError(t, err)
+	assert.Equal(t, feesOf(5, 6, 30), fees)
+	assert.Equal(t, []uint64{29, 30}, backend.balanceAt[1])
+	assert.Len(t, backend.filters, 4)
+
+	// pruned blocks are fetched again
+	s.feeCache.prune(6)
+	assert.NotContains(t, s.feeCache.balance, uint64(5))
+	assert.Contains(t, s.feeCache.balance, uint64(14))
+	fees, err = s.priorityFeesOf(context.Background(), []uint64{6, 14})
+	require.NoError(t, err)
+	assert.Equal(t, feesOf(6, 14), fees)
+	assert.Equal(t, []uint64{5, 6}, backend.balanceAt[2])
+	assert.Len(t, backend.balanceAt, 3)
+}
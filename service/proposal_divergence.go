@@ -0,0 +1,230 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+)
+
+const (
+	maxProposalDivergences  = 100
+	ownProposalKeepDuration = 2 * utils.Day
+)
+
+// ownProposal is a proposal computed by this relay.
+type ownProposal struct {
+	ProposalType string
+	ProposalId   [32]byte
+	To           common.Address
+	CallData     []byte
+	Factor       *big.Int
+	ComputedAt   time.Time
+}
+
+// ProposalDivergence is raised when other voters converge on a proposal different from ours.
+type ProposalDivergence struct {
+	ProposalType      string                 `json:"proposalType"`
+	Factor            string                 `json:"factor"`
+	OwnProposalId     string                 `json:"ownProposalId"`
+	OwnArgs           map[string]interface{} `json:"ownArgs"`
+	OwnVoters         []string               `json:"ownVoters"`
+	LeadingProposalId string                 `json:"leadingProposalId"`
+	LeadingArgs       map[string]interface{} `json:"leadingArgs"`
+	LeadingVoters     []string               `json:"leadingVoters"`
+	LeadingExecuted   bool                   `json:"leadingExecuted"`
+	DetectedAt        time.Time              `json:"detectedAt"`
+}
+
+// trackOwnProposal remembers a proposal computed by this relay, so checkProposalDivergence
+// can compare it with the votes of other voters.
+func (s *Service) trackOwnProposal(proposalType string, to common.Address, callData []byte, factor *big.Int) {
+	proposalId := utils.ProposalId(to, callData, factor)
+
+	s.divergenceMutex.Lock()
+	defer s.divergenceMutex.Unlock()
+	if _, exist := s.ownProposals[proposalId]; exist {
+		return
+	}
+	s.ownProposals[proposalId] = &ownProposal{
+		ProposalType: proposalType,
+		ProposalId:   proposalId,
+		To:           to,
+		CallData:     callData,
+		Factor:       new(big.Int).Set(factor),
+		ComputedAt:   time.Now(),
+	}
+}
+
+// checkProposalDivergence syncs VoteProposal and ProposalExecuted events and flags own proposals
+// whose competing proposal of the same factor got the most votes.
+func (s *Service) checkProposalDivergence(ctx context.Context) error {
+	s.votedProposalsMutex.Lock()
+	defer s.votedProposalsMutex.Unlock()
+	if err := s.syncVotedProposals(ctx); err != nil {
+		return err
+	}
+
+	s.divergenceMutex.Lock()
+	ownProposals := make([]*ownProposal, 0, len(s.ownProposals))
+	for id, own := range s.ownProposals {
+		if time.Since(own.ComputedAt) > ownProposalKeepDuration {
+			delete(s.ownProposals, id)
+			continue
+		}
+		ownProposals = append(ownProposals, own)
+	}
+	for key := range s.reportedDivergences {
+		if _, exist := s.ownProposals[key[0]]; !exist {
+			delete(s.reportedDivergences, key)
+		}
+	}
+	s.divergenceMutex.Unlock()
+
+	for _, own := range ownProposals {
+		var leading *votedProposal
+		for id, p := range s.votedProposals {
+			if id == own.ProposalId || !p.sameKind(own.To, own.CallData, own.Factor) {
+				continue
+			}
+			if leading == nil || p.Executed || (!leading.Executed && len(p.Voters) > len(leading.Voters)) {
+				leading = p
+			}
+		}
+		if leading == nil {
+			continue
+		}
+		ownVoted := s.votedProposals[own.ProposalId]
+		ownVotes := 0
+		if ownVoted != nil {
+			if ownVoted.Executed {
+				continue
+			}
+			ownVotes = len(ownVoted.Voters)
+		}
+		if !leading.Executed && len(leading.Voters) < ownVotes {
+			continue
+		}
+
+		s.recordProposalDivergence(own, ownVoted, leading)
+	}
+
+	return nil
+}
+
+func (s *Service) recordProposalDivergence(own *ownProposal, ownVoted, leading *votedProposal) {
+	key := [2][32]byte{own.ProposalId, leading.ProposalId}
+
+	s.divergenceMutex.Lock()
+	defer s.divergenceMutex.Unlock()
+
+	// report again once the leading proposal is executed
+	if executed, reported := s.reportedDivergences[key]; reported && (executed || !leading.Executed) {
+		return
+	}
+	s.reportedDivergences[key] = leading.Executed
+
+	divergence := ProposalDivergence{
+		ProposalType:      own.ProposalType,
+		Factor:            own.Factor.String(),
+		OwnProposalId:     hex.EncodeToString(own.ProposalId[:]),
+		OwnArgs:           s.decodeProposalArgs(own.To, own.CallData),
+		LeadingProposalId: hex.EncodeToString(leading.ProposalId[:]),
+		LeadingArgs:       s.decodeProposalArgs(leading.To, leading.CallData),
+		LeadingVoters:     addressesToStrings(leading.Voters),
+		LeadingExecuted:   leading.Executed,
+		DetectedAt:        time.Now(),
+	}
+	if ownVoted != nil {
+		divergence.OwnVoters = addressesToStrings(ownVoted.Voters)
+	}
+
+	s.divergences = append(s.divergences, divergence)
+	if len(s.divergences) > maxProposalDivergences {
+		s.divergences = s.divergences[len(s.divergences)-maxProposalDivergences:]
+	}
+	metrics.ProposalDivergences.WithLabelValues(s.lsdTokenAddress.String(), own.ProposalType).Inc()
+
+	s.log.WithFields(logrus.Fields{
+		"proposalType":      divergence.ProposalType,
+		"factor":            divergence.Factor,
+		"ownProposalId":     divergence.OwnProposalId,
+		"ownArgs":           divergence.OwnArgs,
+		"ownVoters":         divergence.OwnVoters,
+		"leadingProposalId": divergence.LeadingProposalId,
+		"leadingArgs":       divergence.LeadingArgs,
+		"leadingVoters":     divergence.LeadingVoters,
+		"leadingExecuted":   divergence.LeadingExecuted,
+	}).Warn("proposal diverged from other voters")
+}
+
+// decodeProposalArgs decodes callData with the abi of the contract it calls.
+func (s *Service) decodeProposalArgs(to common.Address, callData []byte) map[string]interface{} {
+	var contractAbi abi.ABI
+	switch to {
+	case s.networkBalancesAddress:
+		contractAbi = s.networkBalancesAbi
+	case s.networkWithdrawAddress:
+		contractAbi = s.networkWithdrawAbi
+	case s.nodeDepositAddress:
+		contractAbi = s.nodeDepositAbi
+	default:
+		return map[string]interface{}{"callData": hex.EncodeToString(callData)}
+	}
+
+	args, err := decodeCallData(contractAbi, callData)
+	if err != nil {
+		return map[string]interface{}{"callData": hex.EncodeToString(callData), "err": err.Error()}
+	}
+	return args
+}
+
+func decodeCallData(contractAbi abi.ABI, callData []byte) (map[string]interface{}, error) {
+	if len(callData) < 4 {
+		return nil, fmt.Errorf("call data too short")
+	}
+	method, err := contractAbi.MethodById(callData[:4])
+	if err != nil {
+		return nil, err
+	}
+	args := map[string]interface{}{"method": method.Name}
+	if err := method.Inputs.UnpackIntoMap(args, callData[4:]); err != nil {
+		return nil, err
+	}
+	for k, v := range args {
+		switch value := v.(type) {
+		case []byte:
+			args[k] = hex.EncodeToString(value)
+		case [32]byte:
+			args[k] = hex.EncodeToString(value[:])
+		case *big.Int:
+			args[k] = value.String()
+		}
+	}
+	return args, nil
+}
+
+func addressesToStrings(addrs []common.Address) []string {
+	ret := make([]string, len(addrs))
+	for i, addr := range addrs {
+		ret[i] = addr.String()
+	}
+	return ret
+}
+
+// ProposalDivergences returns the latest detected divergences, oldest first.
+func (s *Service) ProposalDivergences() []ProposalDivergence {
+	s.divergenceMutex.Lock()
+	defer s.divergenceMutex.Unlock()
+
+	divergences := make([]ProposalDivergence, len(s.divergences))
+	copy(divergences, s.divergences)
+	return divergences
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckProposalDivergence(t *testing.T) {
+	backend := newFakeBackend(10000)
+	s := newFakeBackendService(t, backend)
+	s.networkWithdrawAddress = common.HexToAddress("0x03")
+	ctx := s.ctx
+
+	merkleRoot := func(epoch int64, root byte) []byte {
+		callData, err := s.networkWithdrawAbi.Pack("setMerkleRoot", big.NewInt(epoch), [32]byte{root}, "cid")
+		require.NoError(t, err)
+		return callData
+	}
+	voters := []common.Address{common.HexToAddress("0xa1"), common.HexToAddress("0xa2"), common.HexToAddress("0xa3")}
+	to := s.networkWithdrawAddress
+
+	// factor 10: ours got one vote, a competing one got two
+	own := backend.voteProposal(s, 9000, voters[0], to, merkleRoot(10, 1), big.NewInt(10))
+	leading := backend.voteProposal(s, 9001, voters[1], to, merkleRoot(10, 2), big.NewInt(10))
+	backend.voteProposal(s, 9002, voters[2], to, merkleRoot(10, 2), big.NewInt(10))
+	// factor 20: ours got two votes, a competing one got one
+	backend.voteProposal(s, 9003, voters[0], to, merkleRoot(20, 1), big.NewInt(20))
+	backend.voteProposal(s, 9004, voters[1], to, merkleRoot(20, 1), big.NewInt(20))
+	backend.voteProposal(s, 9005, voters[2], to, merkleRoot(20, 2), big.NewInt(20))
+	// factor 30: only a proposal of factor 31 was voted
+	backend.voteProposal(s, 9006, voters[2], to, merkleRoot(31, 2), big.NewInt(31))
+
+	s.trackOwnProposal(metrics.ProposalSetMerkleRoot, to, merkleRoot(10, 1), big.NewInt(10))
+	s.trackOwnProposal(metrics.ProposalSetMerkleRoot, to, merkleRoot(20, 1), big.NewInt(20))
+	s.trackOwnProposal(metrics.ProposalSetMerkleRoot, to, merkleRoot(30, 1), big.NewInt(30))
+	// tracking again keeps the first one
+	s.trackOwnProposal(metrics.ProposalSetMerkleRoot, to, merkleRoot(10, 1), big.NewInt(10))
+	assert.Len(t, s.ownProposals, 3)
+
+	require.NoError(t, s.checkProposalDivergence(ctx))
+	divergences := s.ProposalDivergences()
+	require.Len(t, divergences, 1)
+	assert.Equal(t, "10", divergences[0].Factor)
+	assert.Equal(t, hex.EncodeToString(own[:]), divergences[0].OwnProposalId)
+	assert.Equal(t, hex.EncodeToString(leading[:]), divergences[0].LeadingProposalId)
+	assert.Equal(t, []string{voters[0].String()}, divergences[0].OwnVoters)
+	assert.Equal(t, []string{voters[1].String(), voters[2].String()}, divergences[0].LeadingVoters)
+	assert.Equal(t, "setMerkleRoot", divergences[0].LeadingArgs["method"])
+	assert.False(t, divergences[0].LeadingExecuted)
+
+	// reported once until the leading proposal is executed
+	backend.setBlockNumber(s, 10010)
+	require.NoError(t, s.checkProposalDivergence(ctx))
+	assert.Len(t, s.ProposalDivergences(), 1)
+
+	backend.executeProposal(s, 10011, leading)
+	backend.setBlockNumber(s, 10020)
+	require.NoError(t, s.checkProposalDivergence(ctx))
+	divergences = s.ProposalDivergences()
+	require.Len(t, divergences, 2)
+	assert.Equal(t, hex.EncodeToString(leading[:]), divergences[1].LeadingProposalId)
+	assert.True(t, divergences[1].LeadingExecuted)
+
+	// an executed competing proposal leads even with fewer votes
+	s.trackOwnProposal(metrics.ProposalSetMerkleRoot, to, merkleRoot(40, 1), big.NewInt(40))
+	backend.voteProposal(s, 10021, voters[0], to, merkleRoot(40, 1), big.NewInt(40))
+	backend.voteProposal(s, 10022, voters[1], to, merkleRoot(40, 1), big.NewInt(40))
+	executed := backend.voteProposal(s, 10023, voters[2], to, merkleRoot(40, 2), big.NewInt(40))
+	backend.executeProposal(s, 10023, executed)
+	backend.setBlockNumber(s, 10030)
+	require.NoError(t, s.checkProposalDivergence(ctx))
+	divergences = s.ProposalDivergences()
+	require.Len(t, divergences, 3)
+	assert.Equal(t, "40", divergences[2].Factor)
+	assert.Equal(t, hex.EncodeToString(executed[:]), divergences[2].LeadingProposalId)
+	assert.True(t, divergences[2].LeadingExecuted)
+}
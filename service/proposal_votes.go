@@ -0,0 +1,230 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	network_proposal "github.com/stafiprotocol/eth-lsd-relay/bindings/NetworkProposal"
+	node_deposit "github.com/stafiprotocol/eth-lsd-relay/bindings/NodeDeposit"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+)
+
+const (
+	proposalStatusExecuted = 2
+
+	// proposals are usually voted within a day after their factor is decided
+	proposalLookbackBlocks = 7200
+)
+
+// voteWithdrawCredentialsMethod is voted per validator with factor 0, so its proposals only compete with
+// the ones of the same validator.
+var voteWithdrawCredentialsMethod = func() abi.Method {
+	nodeDepositAbi, err := abi.JSON(strings.NewReader(node_deposit.NodeDepositABI))
+	if err != nil {
+		panic(err)
+	}
+	return nodeDepositAbi.Methods["voteWithdrawCredentials"]
+}()
+
+// votedProposal is a proposal recovered from the execProposal/batchExecProposals tx of VoteProposal events.
+type votedProposal struct {
+	ProposalId [32]byte
+	To         common.Address
+	CallData   []byte
+	Factor     *big.Int
+	Voters     []common.Address
+	Executed   bool
+
+	LastVotedBlock uint64
+}
+
+// sameKind reports whether p calls the same method of the same contract with the same factor as
+// (to, callData, factor), so it competes with a proposal built from them. voteWithdrawCredentials
+// proposals also have to be about the same validator.
+func (p *votedProposal) sameKind(to common.Address, callData []byte, factor *big.Int) bool {
+	if p.To != to || p.Factor.Cmp(factor) != 0 || len(p.CallData) < 4 || len(callData) < 4 {
+		return false
+	}
+	if !bytes.Equal(p.CallData[:4], callData[:4]) {
+		return false
+	}
+	if bytes.Equal(callData[:4], voteWithdrawCredentialsMethod.ID) {
+		pubkey, ok := withdrawCredentialsPubkey(callData)
+		if !ok {
+			return false
+		}
+		votedPubkey, ok := withdrawCredentialsPubkey(p.CallData)
+		return ok && bytes.Equal(pubkey, votedPubkey)
+	}
+	return true
+}
+
+// withdrawCredentialsPubkey decodes the validator pubkey of voteWithdrawCredentials call data.
+func withdrawCredentialsPubkey(callData []byte) ([]byte, bool) {
+	args, err := voteWithdrawCredentialsMethod.Inputs.Unpack(callData[4:])
+	if err != nil || len(args) == 0 {
+		return nil, false
+	}
+	pubkey, ok := args[0].([]byte)
+	return pubkey, ok
+}
+
+// syncVotedProposals adds the proposals voted since the last sync to votedProposals and prunes the ones
+// not voted within proposalLookbackBlocks, the first sync looks back proposalLookbackBlocks. The caller
+// must hold votedProposalsMutex.
+func (s *Service) syncVotedProposals(ctx context.Context) error {
+	latestBlock, err := s.connection.Eth1LatestBlock()
+	if err != nil {
+		return err
+	}
+	if latestBlock > s.confirmationBlocks {
+		latestBlock -= s.confirmationBlocks
+	}
+	if s.latestBlockOfSyncProposals == 0 && latestBlock > proposalLookbackBlocks {
+		s.latestBlockOfSyncProposals = latestBlock - proposalLookbackBlocks
+	}
+	if latestBlock <= s.latestBlockOfSyncProposals {
+		return nil
+	}
+
+	if err := s.collectVotedProposals(ctx, s.votedProposals, s.latestBlockOfSyncProposals+1, latestBlock); err != nil {
+		return err
+	}
+	s.latestBlockOfSyncProposals = latestBlock
+
+	// prune
+	for id, p := range s.votedProposals {
+		if p.LastVotedBlock+proposalLookbackBlocks < latestBlock {
+			delete(s.votedProposals, id)
+		}
+	}
+	return nil
+}
+
+// collectVotedProposals adds proposals voted and executed in blocks [start, end] to proposals,
+// which is keyed by proposal id.
+func (s *Service) collectVotedProposals(ctx context.Context, proposals map[[32]byte]*votedProposal, start, end uint64) error {
+	decodedTxs := make(map[common.Hash][]*votedProposal)
+
+	for subStart := start; subStart <= end; subStart += s.eventFilterMaxSpanBlocks {
+		subEnd := subStart + s.eventFilterMaxSpanBlocks - 1
+		if end < subEnd {
+			subEnd = end
+		}
+		opts := &bind.FilterOpts{
+			Start:   subStart,
+			End:     &subEnd,
+			Context: ctx,
+		}
+
+		iter, err := retry.DoWithData(func() (*network_proposal.NetworkProposalVoteProposalIterator, error) {
+			return s.networkProposalContract.FilterVoteProposal(opts, nil)
+		}, retry.Delay(time.Second), retry.Attempts(5), retry.Context(ctx))
+		if err != nil {
+			return err
+		}
+		for iter.Next() {
+			txHash := iter.Event.Raw.TxHash
+			decoded, exist := decodedTxs[txHash]
+			if !exist {
+				decoded, err = s.decodeProposalTx(ctx, txHash)
+				if err != nil {
+					iter.Close()
+					return err
+				}
+				decodedTxs[txHash] = decoded
+			}
+
+			for _, d := range decoded {
+				if d.ProposalId != iter.Event.ProposalId {
+					continue
+				}
+				p, exist := proposals[d.ProposalId]
+				if !exist {
+					p = &votedProposal{
+						ProposalId: d.ProposalId,
+						To:         d.To,
+						CallData:   d.CallData,
+						Factor:     d.Factor,
+					}
+					proposals[d.ProposalId] = p
+				}
+				p.Voters = append(p.Voters, iter.Event.Voter)
+				p.LastVotedBlock = iter.Event.Raw.BlockNumber
+			}
+		}
+		iter.Close()
+
+		executedIter, err := retry.DoWithData(func() (*network_proposal.NetworkProposalProposalExecutedIterator, error) {
+			return s.networkProposalContract.FilterProposalExecuted(opts, nil)
+		}, retry.Delay(time.Second), retry.Attempts(5), retry.Context(ctx))
+		if err != nil {
+			return err
+		}
+		for executedIter.Next() {
+			if p, exist := proposals[executedIter.Event.ProposalId]; exist {
+				p.Executed = true
+			}
+		}
+		executedIter.Close()
+	}
+
+	return nil
+}
+
+// decodeProposalTx decodes the proposals carried by an execProposal or batchExecProposals tx.
+func (s *Service) decodeProposalTx(ctx context.Context, txHash common.Hash) ([]*votedProposal, error) {
+	tx, _, err := s.connection.Eth1Client().TransactionByHash(ctx, txHash)
+	if err != nil {
+		return nil, fmt.Errorf("get proposal tx %s err: %w", txHash, err)
+	}
+	data := tx.Data()
+	if len(data) < 4 {
+		return nil, nil
+	}
+	method, err := s.networkProposalAbi.MethodById(data[:4])
+	if err != nil {
+		// the tx may come from a contract wallet that we can not decode
+		return nil, nil
+	}
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, fmt.Errorf("unpack proposal tx %s err: %w", txHash, err)
+	}
+
+	switch method.Name {
+	case "execProposal":
+		to, callData, factor := args[0].(common.Address), args[1].([]byte), args[2].(*big.Int)
+		return []*votedProposal{{
+			ProposalId: utils.ProposalId(to, callData, factor),
+			To:         to,
+			CallData:   callData,
+			Factor:     factor,
+		}}, nil
+	case "batchExecProposals":
+		tos, callDatas, factors := args[0].([]common.Address), args[1].([][]byte), args[2].([]*big.Int)
+		if len(tos) != len(callDatas) || len(tos) != len(factors) {
+			return nil, fmt.Errorf("batch proposal tx %s args length not match", txHash)
+		}
+		decoded := make([]*votedProposal, len(tos))
+		for i := range tos {
+			decoded[i] = &votedProposal{
+				ProposalId: utils.ProposalId(tos[i], callDatas[i], factors[i]),
+				To:         tos[i],
+				CallData:   callDatas[i],
+				Factor:     factors[i],
+			}
+		}
+		return decoded, nil
+	default:
+		return nil, nil
+	}
+}
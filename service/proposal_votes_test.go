@@ -0,0 +1,39 @@
+package service
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSameKind(t *testing.T) {
+	s := &Service{}
+	require.NoError(t, s.initAbi())
+	to := common.HexToAddress("0x01")
+	merkleRoot := func(root byte) []byte {
+		callData, err := s.networkWithdrawAbi.Pack("setMerkleRoot", big.NewInt(10), [32]byte{root}, "cid")
+		require.NoError(t, err)
+		return callData
+	}
+	voteCredentials := func(pubkey byte, match bool) []byte {
+		callData, err := s.nodeDepositAbi.Pack("voteWithdrawCredentials", []byte{pubkey, 2, 3}, match)
+		require.NoError(t, err)
+		return callData
+	}
+
+	p := &votedProposal{To: to, CallData: merkleRoot(1), Factor: big.NewInt(10)}
+	assert.True(t, p.sameKind(to, merkleRoot(2), big.NewInt(10)))
+	assert.False(t, p.sameKind(to, merkleRoot(2), big.NewInt(11)))
+	assert.False(t, p.sameKind(common.HexToAddress("0x02"), merkleRoot(2), big.NewInt(10)))
+	assert.False(t, p.sameKind(to, voteCredentials(1, true), big.NewInt(10)))
+	assert.False(t, p.sameKind(to, []byte{1}, big.NewInt(10)))
+
+	// votes on withdraw credentials only compete for the same validator
+	p = &votedProposal{To: to, CallData: voteCredentials(1, true), Factor: big.NewInt(0)}
+	assert.True(t, p.sameKind(to, voteCredentials(1, false), big.NewInt(0)))
+	assert.False(t, p.sameKind(to, voteCredentials(2, false), big.NewInt(0)))
+	assert.False(t, p.sameKind(to, voteCredentials(2, true), big.NewInt(0)))
+}
@@ -1,16 +1,17 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
 )
 
-func (s *Service) pruneBlocks() error {
+func (s *Service) pruneBlocks(ctx context.Context) error {
 	latestMerkleRootEpochStartBlock := uint64(0)
 	if s.latestMerkleRootEpoch != 0 {
-		latestMerkleRootEpochStartBlockRes, err := s.getEpochStartBlocknumberWithCheck(s.latestMerkleRootEpoch)
+		latestMerkleRootEpochStartBlockRes, err := s.getEpochStartBlocknumberWithCheck(ctx, s.latestMerkleRootEpoch)
 		if err != nil {
 			return err
 		}
@@ -22,7 +23,7 @@ func (s *Service) pruneBlocks() error {
 		return fmt.Errorf("currentCycleAndStartTimestamp failed: %w", err)
 	}
 	targetEpoch := utils.EpochAtTimestamp(s.eth2Config, uint64(targetTimestamp))
-	targetBlockNumber, err := s.getEpochStartBlocknumberWithCheck(targetEpoch)
+	targetBlockNumber, err := s.getEpochStartBlocknumberWithCheck(ctx, targetEpoch)
 	if err != nil {
 		return err
 	}
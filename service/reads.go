@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection"
+)
+
+// block of the contract reads deciding the votes of a handler other than the ones at its target block, such
+// as limits of proposals and what is already done on chain. Voters reading the same block vote the same
+// regardless of how far their rpc endpoints lag behind.
+const (
+	readsFinalized = "finalized" // the finalized block when the run reads first
+	readsSafe      = "safe"      // the safe block when the run reads first
+	readsTarget    = "target"    // the target block of the handler, the finalized block if it has none
+	readsLatest    = "latest"    // the latest block of each call
+)
+
+type readsKey struct{}
+
+// handlerReads is the read policy of a handler run, with the block it is pinned to once resolved.
+type handlerReads struct {
+	policy string
+	mutex  sync.Mutex
+	pinned *bind.CallOpts
+}
+
+func withReads(ctx context.Context, policy string) context.Context {
+	return context.WithValue(ctx, readsKey{}, &handlerReads{policy: policy})
+}
+
+func (r *handlerReads) tag() connection.BlockTag {
+	switch r.policy {
+	case readsSafe:
+		return connection.BlockTagSafe
+	case readsLatest:
+		return connection.BlockTagLatest
+	default:
+		return connection.BlockTagFinalized
+	}
+}
+
+// readOpts returns the call opts of a read deciding the votes of the handler run of ctx. targetBlock is
+// the block its votes are computed at, 0 if there is none. Runs out of the scheduler read the finalized block,
+// replays read the target block of the replay.
+func (s *Service) readOpts(ctx context.Context, targetBlock uint64) (*bind.CallOpts, error) {
+	if s.replay != nil {
+		opts := s.connection.CallOptsOn(s.replay.block)
+		opts.Context = ctx
+		return opts, nil
+	}
+	reads, ok := ctx.Value(readsKey{}).(*handlerReads)
+	if !ok {
+		reads = &handlerReads{policy: readsFinalized}
+	}
+	if reads.policy == readsTarget && targetBlock > 0 {
+		opts := s.connection.CallOptsOn(targetBlock)
+		opts.Context = ctx
+		return opts, nil
+	}
+	if reads.policy == readsLatest {
+		opts := s.connection.CallOptsAtTag(connection.BlockTagLatest)
+		opts.Context = ctx
+		return opts, nil
+	}
+
+	reads.mutex.Lock()
+	defer reads.mutex.Unlock()
+	if reads.pinned == nil {
+		pinned, err := s.connection.PinnedCallOpts(ctx, reads.tag())
+		if err != nil {
+			return nil, err
+		}
+		reads.pinned = pinned
+	}
+	opts := *reads.pinned
+	opts.Context = ctx
+	return &opts, nil
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// blocks whose txs and receipts are requested in one json-rpc batch
+	feeReceiptsBatchBlocks = 10
+	maxFeeComparisons      = 100
+)
+
+// rpcBlockTxs is the part of a json-rpc block with full txs needed to find payments to the fee pool.
+type rpcBlockTxs struct {
+	Transactions []struct {
+		Hash  common.Hash     `json:"hash"`
+		From  common.Address  `json:"from"`
+		To    *common.Address `json:"to"`
+		Value hexutil.Big     `json:"value"`
+	} `json:"transactions"`
+}
+
+// fetchReceiptFees fetches the fees by receipts of blocks with batched block and receipts requests.
+func (s *Service) fetchReceiptFees(ctx context.Context, blocks []uint64) (map[uint64]*big.Int, error) {
+	fees := make(map[uint64]*big.Int, len(blocks))
+	for i := 0; i < len(blocks); i += feeReceiptsBatchBlocks {
+		chunk := blocks[i:min(i+feeReceiptsBatchBlocks, len(blocks))]
+		rawBlocks := make([]json.RawMessage, len(chunk))
+		receipts := make([][]*types.Receipt, len(chunk))
+		err := retry.Do(func() error {
+			batch := make([]rpc.BatchElem, 0, 2*len(chunk))
+			for j, block := range chunk {
+				batch = append(batch, rpc.BatchElem{
+					Method: "eth_getBlockByNumber",
+					Args:   []interface{}{hexutil.EncodeUint64(block), true},
+					Result: &rawBlocks[j],
+				}, rpc.BatchElem{
+					Method: "eth_getBlockReceipts",
+					Args:   []interface{}{hexutil.EncodeUint64(block)},
+					Result: &receipts[j],
+				})
+			}
+			if err := s.connection.Eth1Client().BatchCallContext(ctx, batch); err != nil {
+				return err
+			}
+			for j, elem := range batch {
+				if elem.Error != nil {
+					return fmt.Errorf("%s of block %d err: %w", elem.Method, chunk[j/2], elem.Error)
+				}
+			}
+			return nil
+		}, retry.Delay(time.Second*2), retry.Attempts(5), retry.Context(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		for j, block := range chunk {
+			fee, err := s.receiptFee(rawBlocks[j], receipts[j])
+			if err != nil {
+				return nil, fmt.Errorf("priority fee of block %d err: %w", block, err)
+			}
+			fees[block] = fee
+		}
+	}
+	return fees, nil
+}
+
+// receiptFee returns what the fee pool received in a block by its txs and receipts: the priority fees if it
+// is the coinbase, else the ether sent to it by the coinbase, which is how builders pay proposers.
+func (s *Service) receiptFee(rawBlock json.RawMessage, receipts []*types.Receipt) (*big.Int, error) {
+	if len(rawBlock) == 0 || string(rawBlock) == "null" {
+		return nil, fmt.Errorf("block not found")
+	}
+	var header types.Header
+	if err := json.Unmarshal(rawBlock, &header); err != nil {
+		return nil, err
+	}
+	var body rpcBlockTxs
+	if err := json.Unmarshal(rawBlock, &body); err != nil {
+		return nil, err
+	}
+	if len(receipts) != len(body.Transactions) {
+		return nil, fmt.Errorf("%d receipts of %d txs", len(receipts), len(body.Transactions))
+	}
+
+	fee := big.NewInt(0)
+	if header.Coinbase == s.feePoolAddress {
+		baseFee := header.BaseFee
+		if baseFee == nil {
+			baseFee = big.NewInt(0)
+		}
+		for _, receipt := range receipts {
+			tip := new(big.Int).Sub(receipt.EffectiveGasPrice, baseFee)
+			fee.Add(fee, tip.Mul(tip, new(big.Int).SetUint64(receipt.GasUsed)))
+		}
+		return fee, nil
+	}
+	for i, tx := range body.Transactions {
+		if tx.From != header.Coinbase || tx.To == nil || *tx.To != s.feePoolAddress {
+			continue
+		}
+		if receipts[i].TxHash != tx.Hash {
+			return nil, fmt.Errorf("receipt %d is of tx %s not %s", i, receipts[i].TxHash, tx.Hash)
+		}
+		if receipts[i].Status == types.ReceiptStatusSuccessful {
+			fee.Add(fee, tx.Value.ToInt())
+		}
+	}
+	return fee, nil
+}
+
+// PriorityFeeComparison is a block whose priority fees by fee pool balance and by receipts differ, the
+// difference is what the fee pool received in the block besides the fees, such as direct transfers.
+type PriorityFeeComparison struct {
+	Block             uint64    `json:"block"`
+	ProposerIndex     uint64    `json:"proposerIndex"`
+	NodeAddress       string    `json:"nodeAddress"`
+	BalanceFee        string    `json:"balanceFee"` // wei
+	ReceiptFee        string    `json:"receiptFee"` // wei
+	UnexplainedInflow string    `json:"unexplainedInflow"`
+	ComparedAt        time.Time `json:"comparedAt"`
+}
+
+// comparePriorityFees computes the fees of blocks by the accounting not used by the service too and reports
+// the blocks whose fees differ. It does not fail the caller, the fees used for votes are already computed.
+func (s *Service) comparePriorityFees(ctx context.Context, blocks []uint64, proposers map[uint64]*Validator, fees map[uint64]*big.Int) {
+	if len(blocks) == 0 {
+		return
+	}
+	balanceFees, receiptFees := fees, fees
+	var err error
+	if s.priorityFeeAccounting == feeAccountingReceipts {
+		balanceFees, err = s.cachedFees(ctx, s.feeCache.balance, blocks, s.fetchBalanceFees)
+	} else {
+		receiptFees, err = s.cachedFees(ctx, s.feeCache.receipts, blocks, s.fetchReceiptFees)
+	}
+	if err != nil {
+		s.log.WithField("err", err.Error()).Warn("compare priority fees")
+		return
+	}
+
+	totalBalance, totalReceipts := big.NewInt(0), big.NewInt(0)
+	differed := 0
+	for _, block := range blocks {
+		totalBalance.Add(totalBalance, balanceFees[block])
+		totalReceipts.Add(totalReceipts, receiptFees[block])
+		if balanceFees[block].Cmp(receiptFees[block]) == 0 {
+			continue
+		}
+		differed++
+		s.recordFeeComparison(PriorityFeeComparison{
+			Block:             block,
+			ProposerIndex:     proposers[block].ValidatorIndex,
+			NodeAddress:       proposers[block].NodeAddress.String(),
+			BalanceFee:        balanceFees[block].String(),
+			ReceiptFee:        receiptFees[block].String(),
+			UnexplainedInflow: new(big.Int).Sub(balanceFees[block], receiptFees[block]).String(),
+			ComparedAt:        time.Now(),
+		})
+	}
+	s.log.WithFields(logrus.Fields{
+		"blocks":            len(blocks),
+		"differedBlocks":    differed,
+		"balanceFee":        decimal.NewFromBigInt(totalBalance, -18).String(),
+		"receiptFee":        decimal.NewFromBigInt(totalReceipts, -18).String(),
+		"unexplainedInflow": decimal.NewFromBigInt(new(big.Int).Sub(totalBalance, totalReceipts), -18).String(),
+	}).Info("compared priority fees by balance and by receipts")
+}
+
+func (s *Service) recordFeeComparison(comparison PriorityFeeComparison) {
+	s.feeComparisonMutex.Lock()
+	defer s.feeComparisonMutex.Unlock()
+	// handlers sharing the fees compare the same blocks
+	for _, c := range s.feeComparisons {
+		if c.Block == comparison.Block {
+			return
+		}
+	}
+	s.feeComparisons = append(s.feeComparisons, comparison)
+	if len(s.feeComparisons) > maxFeeComparisons {
+		s.feeComparisons = s.feeComparisons[len(s.feeComparisons)-maxFeeComparisons:]
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"block":             comparison.Block,
+		"proposerIndex":     comparison.ProposerIndex,
+		"balanceFee":        comparison.BalanceFee,
+		"receiptFee":        comparison.ReceiptFee,
+		"unexplainedInflow": comparison.UnexplainedInflow,
+	}).Warn("priority fees by balance and by receipts differ")
+}
+
+// PriorityFeeComparisons returns the latest blocks whose priority fees differ by accounting, oldest first.
+func (s *Service) PriorityFeeComparisons() []PriorityFeeComparison {
+	s.feeComparisonMutex.Lock()
+	defer s.feeComparisonMutex.Unlock()
+	comparisons := make([]PriorityFeeComparison, len(s.feeComparisons))
+	copy(comparisons, s.feeComparisons)
+	return comparisons
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func rawBlockOf(t *testing.T, header *types.Header, txs []map[string]interface{}) json.RawMessage {
+	raw, err := json.Marshal(header)
+	assert.Nil(t, err)
+	block := make(map[string]interface{})
+	assert.Nil(t, json.Unmarshal(raw, &block))
+	block["transactions"] = txs
+	raw, err = json.Marshal(block)
+	assert.Nil(t, err)
+	return raw
+}
+
+func TestReceiptFee(t *testing.T) {
+	feePool := common.HexToAddress("0xfee")
+	builder := common.HexToAddress("0xb1")
+	user := common.HexToAddress("0x01")
+	s := &Service{feePoolAddress: feePool}
+
+	header := &types.Header{Number: big.NewInt(100), Difficulty: big.NewInt(0), BaseFee: big.NewInt(10), Coinbase: feePool}
+	txs := []map[string]interface{}{
+		{"hash": common.HexToHash("0x1"), "from": user, "to": user, "value": (*hexutil.Big)(big.NewInt(0))},
+		{"hash": common.HexToHash("0x2"), "from": user, "to": feePool, "value": (*hexutil.Big)(big.NewInt(1000))},
+	}
+	receipts := []*types.Receipt{
+		{TxHash: common.HexToHash("0x1"), GasUsed: 21000, EffectiveGasPrice: big.NewInt(12), Status: 1},
+		{TxHash: common.HexToHash("0x2"), GasUsed: 30000, EffectiveGasPrice: big.NewInt(11), Status: 1},
+	}
+	// the fee pool as coinbase gets the priority fees, the direct transfer is not a fee
+	fee, err := s.receiptFee(rawBlockOf(t, header, txs), receipts)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(21000*2+30000*1), fee)
+
+	// a builder as coinbase pays the fee pool by a tx
+	header.Coinbase = builder
+	txs = append(txs, map[string]interface{}{
+		"hash": common.HexToHash("0x3"), "from": builder, "to": feePool, "value": (*hexutil.Big)(big.NewInt(5e9)),
+	})
+	receipts = append(receipts, &types.Receipt{TxHash: common.HexToHash("0x3"), GasUsed: 21000, EffectiveGasPrice: big.NewInt(10), Status: 1})
+	fee, err = s.receiptFee(rawBlockOf(t, header, txs), receipts)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(5e9), fee)
+
+	receipts[2].Status = types.ReceiptStatusFailed
+	fee, err = s.receiptFee(rawBlockOf(t, header, txs), receipts)
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(0), fee)
+
+	_, err = s.receiptFee(rawBlockOf(t, header, txs), receipts[:2])
+	assert.NotNil(t, err)
+	_, err = s.receiptFee(json.RawMessage("null"), nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+)
+
+// syncEvents keeps the ranges of the last maxReorgDepth blocks to roll them back on reorg,
+// deeper reorgs can not happen below finalized blocks.
+const maxReorgDepth = 128
+
+// syncedRange is a block range processed by syncEvents, with the hash of its last block and
+// what undoes its changes to the event state. The range restored from a checkpoint has no undo,
+// the events of its blocks are merged into the restored state.
+type syncedRange struct {
+	start    uint64
+	end      uint64
+	endHash  common.Hash
+	undo     []func()
+	restored bool
+}
+
+func (r *syncedRange) onUndo(fn func()) {
+	r.undo = append(r.undo, fn)
+}
+
+func (r *syncedRange) rollback() {
+	for i := len(r.undo) - 1; i >= 0; i-- {
+		r.undo[i]()
+	}
+}
+
+// eventsSyncTarget returns the last block syncEvents may process, confirmationBlocks below the latest
+// block or the finalized execution block.
+func (s *Service) eventsSyncTarget(ctx context.Context, latestBlock uint64) (uint64, error) {
+	if s.replay != nil {
+		return s.replay.block, nil
+	}
+	if s.syncEventsToFinalized {
+		beaconHead, err := s.beaconHead()
+		if err != nil {
+			return 0, err
+		}
+		return s.getEpochStartBlocknumberWithCheck(ctx, beaconHead.FinalizedEpoch)
+	}
+	if latestBlock > s.confirmationBlocks {
+		return latestBlock - s.confirmationBlocks, nil
+	}
+	return latestBlock, nil
+}
+
+func (s *Service) headerOf(ctx context.Context, number uint64) (*types.Header, error) {
+	return s.connection.Eth1Client().HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+}
+
+// lastSyncedRange returns the latest range processed by syncEvents, nil if none is known.
+func (s *Service) lastSyncedRange() *syncedRange {
+	if len(s.syncedRanges) == 0 {
+		return nil
+	}
+	return s.syncedRanges[len(s.syncedRanges)-1]
+}
+
+// appendSyncedRange records r and forgets ranges too deep to be reorged.
+func (s *Service) appendSyncedRange(r *syncedRange) {
+	s.syncedRanges = append(s.syncedRanges, r)
+	drop := 0
+	for drop < len(s.syncedRanges)-1 && s.syncedRanges[drop].end+maxReorgDepth < r.end {
+		drop++
+	}
+	s.syncedRanges = s.syncedRanges[drop:]
+}
+
+// rollbackReorg walks back the synced ranges until one is still on the canonical chain and undoes the
+// ranges after it, so syncEvents processes the new blocks again. If no synced range is left on the
+// canonical chain, or the range restored from a checkpoint is reorged, the event state can not be
+// rolled back and is synced again from startAtBlock.
+func (s *Service) rollbackReorg(ctx context.Context) error {
+	metrics.Reorgs.WithLabelValues(s.lsdTokenAddress.String()).Inc()
+	for len(s.syncedRanges) > 0 {
+		last := s.lastSyncedRange()
+		header, err := s.headerOf(ctx, last.end)
+		if err != nil {
+			return err
+		}
+		if header.Hash() == last.endHash {
+			return nil
+		}
+		if last.restored {
+			break
+		}
+		last.rollback()
+		s.syncedRanges = s.syncedRanges[:len(s.syncedRanges)-1]
+		s.latestBlockOfSyncEvents.Store(last.start - 1)
+
+		s.log.WithFields(logrus.Fields{
+			"start":   last.start,
+			"end":     last.end,
+			"endHash": last.endHash.String(),
+		}).Warn("rolled back events of reorged blocks")
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"latestBlockOfSyncEvents": s.latestBlockOfSyncEvents.Load(),
+		"startAtBlock":            s.startAtBlock,
+	}).Warnf("reorg can not be rolled back within %d blocks, syncing events again from start block", maxReorgDepth)
+	s.resetSyncedEvents()
+	return nil
+}
+
+// resetSyncedEvents drops the event state, so syncEvents syncs it again from startAtBlock.
+func (s *Service) resetSyncedEvents() {
+	s.govDeposits = make(map[string][][]byte)
+	s.exitElections = make(map[uint64]*ExitElection)
+	s.stakerWithdrawals = make(map[uint64]*StakerWithdrawal)
+	s.syncedRanges = nil
+	s.latestBlockOfSyncEvents.Store(s.startAtBlock)
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/samber/lo"
+	deposit_contract "github.com/stafiprotocol/eth-lsd-relay/bindings/DepositContract"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncedRanges(t *testing.T) {
+	s := &Service{
+		govDeposits:   make(map[string][][]byte),
+		exitElections: make(map[uint64]*ExitElection),
+	}
+	for end := uint64(100); end <= 400; end += 100 {
+		s.appendSyncedRange(&syncedRange{start: end - 99, end: end, endHash: common.BigToHash(common.Big1)})
+	}
+	// ranges more than maxReorgDepth blocks below the latest one are forgotten
+	assert.Equal(t, 2, len(s.syncedRanges))
+	assert.Equal(t, uint64(300), s.syncedRanges[0].end)
+	s.appendSyncedRange(&syncedRange{start: 401, end: 10000})
+	assert.Equal(t, 1, len(s.syncedRanges))
+	assert.Equal(t, uint64(10000), s.lastSyncedRange().end)
+
+	// undo runs in reverse order
+	s.govDeposits["a"] = [][]byte{{1}}
+	r := &syncedRange{}
+	s.govDeposits["a"] = append(s.govDeposits["a"], []byte{2})
+	r.onUndo(func() { s.govDeposits["a"] = s.govDeposits["a"][:1] })
+	s.exitElections[1] = &ExitElection{WithdrawCycle: 1}
+	r.onUndo(func() { delete(s.exitElections, 1) })
+	r.onUndo(func() { s.govDeposits["a"] = append(s.govDeposits["a"], []byte{3}) })
+	r.rollback()
+	assert.Equal(t, [][]byte{{1}}, s.govDeposits["a"])
+	assert.Equal(t, 0, len(s.exitElections))
+}
+
+func TestSyncEventsReorg(t *testing.T) {
+	backend := newFakeBackend(300)
+	s := newFakeBackendService(t, backend)
+	s.eventFilterMaxSpanBlocks = 50
+	s.startAtBlock = 100
+	s.latestBlockOfSyncEvents.Store(s.startAtBlock)
+	s.govDeposits = make(map[string][][]byte)
+	s.exitElections = make(map[uint64]*ExitElection)
+	s.stakerWithdrawals = make(map[uint64]*StakerWithdrawal)
+
+	for _, method := range []string{"latestDistributeWithdrawalsHeight", "latestDistributePriorityFeeHeight", "latestMerkleRootEpoch"} {
+		backend.handleCall(fakeNetworkWithdrawAddress, s.networkWithdrawAbi, method, func([]interface{}) []interface{} {
+			return []interface{}{big.NewInt(0)}
+		})
+	}
+	depositAbi, err := abi.JSON(strings.NewReader(deposit_contract.DepositContractABI))
+	require.NoError(t, err)
+	deposit := func(block uint64, pubkey byte) {
+		backend.addLog(fakeDepositAddress, depositAbi, "DepositEvent", block, common.Hash{}, nil,
+			[]byte{pubkey}, []byte{1}, []byte{}, []byte{}, []byte{})
+	}
+	synced := func() []string {
+		return lo.Keys(s.govDeposits)
+	}
+	pubkey := func(b byte) string { return hex.EncodeToString([]byte{b}) }
+
+	deposit(120, 0xa)
+	deposit(280, 0xb)
+	require.NoError(t, s.syncEvents(context.Background()))
+	assert.Equal(t, uint64(300), s.latestBlockOfSyncEvents.Load())
+	assert.ElementsMatch(t, []string{pubkey(0xa), pubkey(0xb)}, synced())
+
+	// the range of the reorged blocks is rolled back and synced again
+	backend.reorg(260)
+	deposit(270, 0xc)
+	backend.setBlockNumber(s, 320)
+	require.NoError(t, s.syncEvents(context.Background()))
+	assert.Equal(t, uint64(250), s.latestBlockOfSyncEvents.Load())
+	assert.ElementsMatch(t, []string{pubkey(0xa)}, synced())
+	require.NoError(t, s.syncEvents(context.Background()))
+	assert.Equal(t, uint64(320), s.latestBlockOfSyncEvents.Load())
+	assert.ElementsMatch(t, []string{pubkey(0xa), pubkey(0xc)}, synced())
+
+	// a reorg below all synced ranges resets the event state to start block
+	backend.reorg(50)
+	deposit(130, 0xd)
+	backend.setBlockNumber(s, 340)
+	require.NoError(t, s.syncEvents(context.Background()))
+	assert.Equal(t, s.startAtBlock, s.latestBlockOfSyncEvents.Load())
+	assert.Empty(t, synced())
+	require.NoError(t, s.syncEvents(context.Background()))
+	assert.Equal(t, uint64(340), s.latestBlockOfSyncEvents.Load())
+	assert.ElementsMatch(t, []string{pubkey(0xd)}, synced())
+
+	// the events restored from a checkpoint can not be rolled back
+	header, err := backend.HeaderByNumber(context.Background(), big.NewInt(340))
+	require.NoError(t, err)
+	s.syncedRanges = []*syncedRange{{start: 340, end: 340, endHash: header.Hash(), restored: true}}
+	s.govDeposits[pubkey(0xe)] = [][]byte{{1}}
+	backend.reorg(330)
+	backend.setBlockNumber(s, 360)
+	require.NoError(t, s.syncEvents(context.Background()))
+	assert.Equal(t, s.startAtBlock, s.latestBlockOfSyncEvents.Load())
+	assert.Empty(t, synced())
+	require.NoError(t, s.syncEvents(context.Background()))
+	assert.Equal(t, uint64(360), s.latestBlockOfSyncEvents.Load())
+	assert.ElementsMatch(t, []string{pubkey(0xd)}, synced())
+}
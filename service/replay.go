@@ -0,0 +1,372 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/config"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/beacon"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+)
+
+// blocks after the target block searched for the executed proposal of a replay
+const replayProposalSearchBlocks = 4 * proposalLookbackBlocks
+
+// replayState pins a service to the target of a replay and records what it would have voted.
+type replayState struct {
+	epoch     uint64
+	block     uint64
+	head      beacon.BeaconHead // beacon head finalized at epoch
+	proposal  *ownProposal
+	breakdown logrus.Fields
+}
+
+// capture records the proposal instead of voting it.
+func (r *replayState) capture(proposalType string, to common.Address, callData []byte, factor *big.Int) {
+	r.proposal = &ownProposal{
+		ProposalType: proposalType,
+		ProposalId:   utils.ProposalId(to, callData, factor),
+		To:           to,
+		CallData:     callData,
+		Factor:       new(big.Int).Set(factor),
+	}
+}
+
+// note adds fields to the breakdown of the proposal, nil notes nothing.
+func (r *replayState) note(fields logrus.Fields) {
+	if r == nil {
+		return
+	}
+	for k, v := range fields {
+		r.breakdown[k] = v
+	}
+}
+
+// beaconHead returns the beacon head, replays see the target epoch finalized.
+func (s *Service) beaconHead() (beacon.BeaconHead, error) {
+	if s.replay != nil {
+		return s.replay.head, nil
+	}
+	return s.connection.BeaconHead()
+}
+
+// ReplayResult is a past proposal recomputed by Replay and how it compares with the executed one.
+type ReplayResult struct {
+	LsdToken     string                 `json:"lsdToken"`
+	ProposalType string                 `json:"proposalType"`
+	Epoch        uint64                 `json:"epoch"`
+	TargetBlock  uint64                 `json:"targetBlock"`
+	Voted        bool                   `json:"voted"` // false if the relay would not vote at the target
+	Error        string                 `json:"error,omitempty"`
+	ProposalId   string                 `json:"proposalId,omitempty"`
+	To           string                 `json:"to,omitempty"`
+	CallData     string                 `json:"callData,omitempty"`
+	Factor       string                 `json:"factor,omitempty"`
+	Args         map[string]interface{} `json:"args,omitempty"`
+	Breakdown    map[string]interface{} `json:"breakdown,omitempty"`
+
+	OnChain *ReplayOnChainProposal `json:"onChain,omitempty"` // nil if no proposal of the same kind was voted
+	Matched bool                   `json:"matched"`           // the on-chain proposal is the replayed one
+	Diff    []ReplayArgDiff        `json:"diff,omitempty"`
+}
+
+// ReplayOnChainProposal is the proposal of the same kind voted on chain, the executed one if any.
+type ReplayOnChainProposal struct {
+	ProposalId string                 `json:"proposalId"`
+	Args       map[string]interface{} `json:"args"`
+	Voters     []string               `json:"voters"`
+	Executed   bool                   `json:"executed"`
+}
+
+// ReplayArgDiff is an argument differing between the replayed and the on-chain proposal.
+type ReplayArgDiff struct {
+	Arg      string      `json:"arg"`
+	Replayed interface{} `json:"replayed"`
+	OnChain  interface{} `json:"onChain"`
+}
+
+// Replay recomputes the proposal of proposalType the relay would have voted for lsdToken at epoch, or at the
+// epoch starting with block if epoch is 0. It syncs events, validators and beacon blocks up to the target block
+// from the creation of the network, with contract reads at the target block and validator statuses at the
+// target epoch, so replays of a target always agree. The proposal is then compared with the one voted on chain.
+func (m *ServiceManager) Replay(ctx context.Context, lsdToken, proposalType string, epoch, block uint64) (*ReplayResult, error) {
+	srvConfig := *m.cfg
+	srvConfig.Contracts.LsdTokenAddress = lsdToken
+	s, err := newReplayService(ctx, &srvConfig, m)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Stop()
+
+	var handler func(context.Context) error
+	switch proposalType {
+	case metrics.ProposalSubmitBalances:
+		handler = s.submitBalances
+	case metrics.ProposalDistributeWithdrawals:
+		handler = s.distributeWithdrawals
+	case metrics.ProposalDistributePriorityFee:
+		handler = s.distributePriorityFee
+	case metrics.ProposalSetMerkleRoot:
+		return nil, fmt.Errorf("%s can not be replayed, its cid is of the uploaded rewards file", proposalType)
+	default:
+		return nil, fmt.Errorf("unsupported proposal type %s", proposalType)
+	}
+
+	if err = s.initReplay(epoch, block); err != nil {
+		return nil, err
+	}
+	if err = s.syncReplay(); err != nil {
+		return nil, err
+	}
+	return s.replayProposal(proposalType, handler)
+}
+
+// replayProposal runs handler of proposalType on the synced state and compares the captured proposal with the
+// one voted on chain.
+func (s *Service) replayProposal(proposalType string, handler func(context.Context) error) (*ReplayResult, error) {
+	result := &ReplayResult{
+		LsdToken:     s.lsdTokenAddress.String(),
+		ProposalType: proposalType,
+		Epoch:        s.replay.epoch,
+		TargetBlock:  s.replay.block,
+	}
+	if err := handler(s.ctx); err != nil {
+		result.Error = err.Error()
+	}
+	if len(s.replay.breakdown) > 0 {
+		result.Breakdown = s.replay.breakdown
+	}
+	own := s.replay.proposal
+	if own == nil {
+		return result, nil
+	}
+	result.Voted = true
+	result.ProposalId = hex.EncodeToString(own.ProposalId[:])
+	result.To = own.To.String()
+	result.CallData = hex.EncodeToString(own.CallData)
+	result.Factor = own.Factor.String()
+	result.Args = s.decodeProposalArgs(own.To, own.CallData)
+
+	onChain, err := s.votedProposalOfKind(own)
+	if err != nil {
+		return nil, err
+	}
+	if onChain == nil {
+		return result, nil
+	}
+	result.OnChain = &ReplayOnChainProposal{
+		ProposalId: hex.EncodeToString(onChain.ProposalId[:]),
+		Args:       s.decodeProposalArgs(onChain.To, onChain.CallData),
+		Voters:     addressesToStrings(onChain.Voters),
+		Executed:   onChain.Executed,
+	}
+	result.Matched = onChain.ProposalId == own.ProposalId
+	result.Diff = diffProposalArgs(result.Args, result.OnChain.Args)
+	return result, nil
+}
+
+func newReplayService(ctx context.Context, cfg *config.Config, m *ServiceManager) (*Service, error) {
+	s, err := NewService(cfg, m, m.connection, m.localStore)
+	if err != nil {
+		return nil, err
+	}
+	s.cancel()
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	// a replay never sends txs
+	s.dryRun = true
+	return s, nil
+}
+
+// initReplay inits the contracts and the parameters of votes at the target, and the sync state to sync
+// from the creation of the network.
+func (s *Service) initReplay(epoch, block uint64) error {
+	chainId, err := s.connection.ChainID()
+	if err != nil {
+		return err
+	}
+	s.chainID = chainId.Uint64()
+	s.eth2Config, err = s.connection.Eth2Config()
+	if err != nil {
+		return err
+	}
+	if err = s.initContract(); err != nil {
+		return err
+	}
+	if err = s.initAbi(); err != nil {
+		return err
+	}
+
+	if epoch == 0 {
+		if block == 0 {
+			return fmt.Errorf("replay needs a target epoch or block")
+		}
+		header, err := s.headerOf(s.ctx, block)
+		if err != nil {
+			return err
+		}
+		epoch = utils.EpochAtTimestamp(s.eth2Config, header.Time)
+	}
+	targetBlock, err := s.getEpochStartBlocknumberWithCheck(s.ctx, epoch)
+	if err != nil {
+		return err
+	}
+	if block != 0 && block != targetBlock {
+		return fmt.Errorf("block %d is not the target block %d of epoch %d", block, targetBlock, epoch)
+	}
+	targetHeader, err := s.headerOf(s.ctx, targetBlock)
+	if err != nil {
+		return err
+	}
+	targetSlot := utils.SlotAtTimestamp(s.eth2Config, targetHeader.Time)
+
+	opts := s.connection.CallOptsOn(targetBlock)
+	opts.Context = s.ctx
+	updateBalancesEpochs, err := s.networkBalancesContract.UpdateBalancesEpochs(opts)
+	if err != nil {
+		return err
+	}
+	if updateBalancesEpochs.Uint64() == 0 {
+		return fmt.Errorf("updateBalancesEpochs is zero")
+	}
+	if epoch%updateBalancesEpochs.Uint64() != 0 {
+		return fmt.Errorf("epoch %d is not a target epoch, votes are at multiples of %d epochs", epoch, updateBalancesEpochs.Uint64())
+	}
+	s.setUpdateBalancesEpochs(updateBalancesEpochs.Uint64())
+
+	nodeCommissionRate, err := s.networkWithdrawContract.NodeCommissionRate(opts)
+	if err != nil {
+		return err
+	}
+	s.nodeCommissionRate = decimal.NewFromBigInt(nodeCommissionRate, 0).Div(decimal.NewFromInt(1e18))
+	platformCommissionRate, err := s.networkWithdrawContract.PlatformCommissionRate(opts)
+	if err != nil {
+		return err
+	}
+	s.platformCommissionRate = decimal.NewFromBigInt(platformCommissionRate, 0).Div(decimal.NewFromInt(1e18))
+	cycleSeconds, err := s.networkWithdrawContract.WithdrawCycleSeconds(opts)
+	if err != nil {
+		return err
+	}
+	s.cycleSeconds = cycleSeconds.Uint64()
+
+	s.replay = &replayState{
+		epoch: epoch,
+		block: targetBlock,
+		head: beacon.BeaconHead{
+			Epoch:                  epoch,
+			Slot:                   targetSlot,
+			FinalizedEpoch:         epoch,
+			FinalizedSlot:          targetSlot,
+			JustifiedEpoch:         epoch,
+			PreviousJustifiedEpoch: epoch,
+		},
+		breakdown: make(logrus.Fields),
+	}
+
+	// blocks are synced from the earliest undistributed block at the target
+	latestDistributeWithdrawalsHeight, err := s.networkWithdrawContract.LatestDistributeWithdrawalsHeight(opts)
+	if err != nil {
+		return err
+	}
+	latestDistributePriorityFeeHeight, err := s.networkWithdrawContract.LatestDistributePriorityFeeHeight(opts)
+	if err != nil {
+		return err
+	}
+	s.latestBlockOfSyncBlock.Store(max(min(latestDistributeWithdrawalsHeight.Uint64(), latestDistributePriorityFeeHeight.Uint64()), s.startAtBlock))
+	syncBlockHeader, err := s.headerOf(s.ctx, s.latestBlockOfSyncBlock.Load())
+	if err != nil {
+		return err
+	}
+	s.latestSlotOfSyncBlock.Store(utils.SlotAtTimestamp(s.eth2Config, syncBlockHeader.Time))
+	s.latestBlockOfUpdateValidator.Store(s.startAtBlock)
+	s.latestBlockOfSyncEvents.Store(s.startAtBlock)
+	s.minExecutionBlockHeight = s.startAtBlock
+
+	s.log.WithFields(logrus.Fields{
+		"epoch":                  epoch,
+		"targetBlock":            targetBlock,
+		"targetSlot":             targetSlot,
+		"updateBalancesEpochs":   updateBalancesEpochs.Uint64(),
+		"nodeCommissionRate":     s.nodeCommissionRate.String(),
+		"platformCommissionRate": s.platformCommissionRate.String(),
+		"latestBlockOfSyncBlock": s.latestBlockOfSyncBlock.Load(),
+	}).Info("replay target")
+	return nil
+}
+
+// syncReplay runs the sync handlers once in the order they depend on each other, each syncs up to the target.
+func (s *Service) syncReplay() error {
+	for _, step := range []struct {
+		name   string
+		method func(context.Context) error
+	}{
+		{"syncEvents", s.syncEvents},
+		{"updateValidatorsFromNetwork", s.updateValidatorsFromNetwork},
+		{"updateValidatorsFromBeacon", s.updateValidatorsFromBeacon},
+		{"syncBlocks", s.syncBlocks},
+	} {
+		s.log.WithField("handler", step.name).Info("replay syncing")
+		if err := step.method(s.ctx); err != nil {
+			return fmt.Errorf("replay %s err: %w", step.name, err)
+		}
+	}
+	if s.latestBlockOfSyncBlock.Load() < s.replay.block {
+		return fmt.Errorf("blocks synced up to %d, short of target block %d", s.latestBlockOfSyncBlock.Load(), s.replay.block)
+	}
+	return nil
+}
+
+// votedProposalOfKind returns the proposal of the same kind as own voted after the target block, the executed
+// one if any, else the one with the most votes, nil if none.
+func (s *Service) votedProposalOfKind(own *ownProposal) (*votedProposal, error) {
+	latestBlock, err := s.connection.Eth1LatestBlock()
+	if err != nil {
+		return nil, err
+	}
+	start := s.replay.block + 1
+	end := min(latestBlock, s.replay.block+replayProposalSearchBlocks)
+	if end < start {
+		return nil, nil
+	}
+	proposals := make(map[[32]byte]*votedProposal)
+	if err := s.collectVotedProposals(s.ctx, proposals, start, end); err != nil {
+		return nil, err
+	}
+
+	var found *votedProposal
+	for _, p := range proposals {
+		if !p.sameKind(own.To, own.CallData, own.Factor) {
+			continue
+		}
+		if found == nil || p.Executed || (!found.Executed && len(p.Voters) > len(found.Voters)) {
+			found = p
+		}
+	}
+	return found, nil
+}
+
+// diffProposalArgs returns the args of replayed differing from onChain, sorted by arg.
+func diffProposalArgs(replayed, onChain map[string]interface{}) []ReplayArgDiff {
+	args := make(map[string]bool)
+	for arg := range replayed {
+		args[arg] = true
+	}
+	for arg := range onChain {
+		args[arg] = true
+	}
+	diffs := make([]ReplayArgDiff, 0)
+	for arg := range args {
+		if fmt.Sprint(replayed[arg]) != fmt.Sprint(onChain[arg]) {
+			diffs = append(diffs, ReplayArgDiff{Arg: arg, Replayed: replayed[arg], OnChain: onChain[arg]})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Arg < diffs[j].Arg })
+	return diffs
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffProposalArgs(t *testing.T) {
+	replayed := map[string]interface{}{"method": "distribute", "_totalUserEth": "100", "_totalNodeEth": "10"}
+	onChain := map[string]interface{}{"method": "distribute", "_totalUserEth": "101", "_totalNodeEth": "10", "_extra": "1"}
+
+	diffs := diffProposalArgs(replayed, onChain)
+	assert.Equal(t, []ReplayArgDiff{
+		{Arg: "_extra", Replayed: nil, OnChain: "1"},
+		{Arg: "_totalUserEth", Replayed: "100", OnChain: "101"},
+	}, diffs)
+	assert.Empty(t, diffProposalArgs(replayed, replayed))
+}
+
+func TestReplayNote(t *testing.T) {
+	var r *replayState
+	r.note(logrus.Fields{"a": 1})
+
+	r = &replayState{breakdown: make(logrus.Fields)}
+	r.note(logrus.Fields{"a": 1})
+	r.note(logrus.Fields{"b": 2})
+	assert.Equal(t, logrus.Fields{"a": 1, "b": 2}, r.breakdown)
+}
+
+func TestReplayProposal(t *testing.T) {
+	backend := newFakeBackend(1000)
+	s := newFakeBackendService(t, backend)
+	s.networkWithdrawAddress = fakeNetworkWithdrawAddress
+	s.dryRun = true
+	s.replay = &replayState{epoch: 10, block: 320, breakdown: make(logrus.Fields)}
+
+	distribute := func(totalUserEth int64) []byte {
+		callData, err := s.networkWithdrawAbi.Pack("distribute", utils.DistributeTypeWithdrawals, big.NewInt(320),
+			big.NewInt(totalUserEth), big.NewInt(2e17), big.NewInt(1e17), big.NewInt(4))
+		require.NoError(t, err)
+		return callData
+	}
+	// the executed proposal counted less user eth than the replay
+	voter := common.HexToAddress("0xa1")
+	executed := backend.voteProposal(s, 330, voter, s.networkWithdrawAddress, distribute(9e17), big.NewInt(320))
+	backend.executeProposal(s, 330, executed)
+
+	result, err := s.replayProposal(metrics.ProposalDistributeWithdrawals, func(ctx context.Context) error {
+		s.replay.note(logrus.Fields{"targetEth1BlockHeight": 320})
+		return s.sendDistributeTx(ctx, utils.DistributeTypeWithdrawals, big.NewInt(320),
+			big.NewInt(1e18), big.NewInt(2e17), big.NewInt(1e17), big.NewInt(4))
+	})
+	require.NoError(t, err)
+	assert.True(t, result.Voted)
+	assert.Equal(t, uint64(320), result.TargetBlock)
+	assert.Equal(t, "320", result.Factor)
+	assert.Equal(t, hex.EncodeToString(distribute(1e18)), result.CallData)
+	assert.Equal(t, "distribute", result.Args["method"])
+	assert.Equal(t, "1000000000000000000", result.Args["_userAmount"])
+	assert.Equal(t, 320, result.Breakdown["targetEth1BlockHeight"])
+
+	require.NotNil(t, result.OnChain)
+	assert.Equal(t, hex.EncodeToString(executed[:]), result.OnChain.ProposalId)
+	assert.True(t, result.OnChain.Executed)
+	assert.Equal(t, []string{voter.String()}, result.OnChain.Voters)
+	assert.False(t, result.Matched)
+	assert.Equal(t, []ReplayArgDiff{{Arg: "_userAmount", Replayed: "1000000000000000000", OnChain: "900000000000000000"}}, result.Diff)
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+)
+
+// classifications of reward entries
+const (
+	RewardPartialWithdrawal = "partial"     // partial withdrawal, all reward
+	RewardFullWithdrawal    = "full"        // full withdrawal, reward above the effective balance and deposits
+	RewardSlashWithdrawal   = "slash"       // full withdrawal below the effective balance, deposits only
+	RewardPriorityFee       = "priorityFee" // priority fee of a proposed block
+)
+
+// RewardEntry is a withdrawal or proposed block of our validators and how it is split, amounts are in wei.
+type RewardEntry struct {
+	Block          uint64          `json:"block"` // execution block
+	Slot           uint64          `json:"slot"`
+	ValidatorIndex uint64          `json:"validatorIndex"`
+	Pubkey         string          `json:"pubkey"`
+	NodeAddress    string          `json:"nodeAddress"`
+	Classification string          `json:"classification"`
+	Amount         decimal.Decimal `json:"amount"` // withdrawn or priority fee
+	UserReward     decimal.Decimal `json:"userReward"`
+	NodeReward     decimal.Decimal `json:"nodeReward"`
+	PlatformFee    decimal.Decimal `json:"platformFee"`
+	UserDeposit    decimal.Decimal `json:"userDeposit"`
+	NodeDeposit    decimal.Decimal `json:"nodeDeposit"`
+}
+
+// RewardReport attributes the rewards of a distribute vote or a merkle root epoch to validators and nodes.
+type RewardReport struct {
+	LsdToken      string        `json:"lsdToken"`
+	ProposalType  string        `json:"proposalType"`
+	FromBlock     uint64        `json:"fromBlock"`       // exclusive, the latest distributed block
+	ToBlock       uint64        `json:"toBlock"`         // the target block of the proposal
+	Epoch         uint64        `json:"epoch,omitempty"` // setMerkleRoot
+	TotalUser     string        `json:"totalUser"`
+	TotalNode     string        `json:"totalNode"`
+	TotalPlatform string        `json:"totalPlatform"`
+	Entries       []RewardEntry `json:"entries"`
+	CreatedAt     time.Time     `json:"createdAt"`
+}
+
+// rewardReport collects the entries of a RewardReport while rewards are computed, nil collects nothing.
+type rewardReport struct {
+	entries []RewardEntry
+}
+
+func (r *rewardReport) add(entry RewardEntry) {
+	if r == nil {
+		return
+	}
+	r.entries = append(r.entries, entry)
+}
+
+func newRewardEntry(block *CachedBeaconBlock, val *Validator, classification string) RewardEntry {
+	return RewardEntry{
+		Block:          block.ExecutionBlockNumber,
+		Slot:           block.BeaconBlockId,
+		ValidatorIndex: val.ValidatorIndex,
+		Pubkey:         "0x" + hex.EncodeToString(val.Pubkey),
+		NodeAddress:    val.NodeAddress.String(),
+		Classification: classification,
+		UserDeposit:    decimal.Zero,
+		NodeDeposit:    decimal.Zero,
+	}
+}
+
+// newRewardReport returns a collector of entries if reward reports are enabled, else nil.
+func (s *Service) newRewardReport() *rewardReport {
+	if !s.rewardReports {
+		return nil
+	}
+	return &rewardReport{}
+}
+
+func (s *Service) rewardReportPath(proposalType string, target uint64, ext string) string {
+	return filepath.Join(s.rewardReportDir, s.lsdTokenAddress.String(), fmt.Sprintf("%s-%d.%s", proposalType, target, ext))
+}
+
+// saveRewardReport writes the JSON and CSV report of the proposal at target, the target block of a distribute
+// vote or the epoch of a merkle root. Reports are written once, the proposal of a target never changes. A report
+// failing to be written does not hold the vote back.
+func (s *Service) saveRewardReport(r *rewardReport, report RewardReport, target uint64) {
+	if r == nil {
+		return
+	}
+	if err := s.writeRewardReport(r, report, target); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"proposalType": report.ProposalType,
+			"target":       target,
+			"err":          err.Error(),
+		}).Warn("save reward report")
+	}
+}
+
+func (s *Service) writeRewardReport(r *rewardReport, report RewardReport, target uint64) error {
+	jsonPath := s.rewardReportPath(report.ProposalType, target, "json")
+	if _, err := os.Stat(jsonPath); err == nil {
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	report.LsdToken = s.lsdTokenAddress.String()
+	report.Entries = r.entries
+	if report.Entries == nil {
+		report.Entries = make([]RewardEntry, 0)
+	}
+	totalUser, totalNode, totalPlatform := decimal.Zero, decimal.Zero, decimal.Zero
+	for _, e := range report.Entries {
+		totalUser = totalUser.Add(e.UserReward).Add(e.UserDeposit)
+		totalNode = totalNode.Add(e.NodeReward).Add(e.NodeDeposit)
+		totalPlatform = totalPlatform.Add(e.PlatformFee)
+	}
+	report.TotalUser, report.TotalNode, report.TotalPlatform = totalUser.String(), totalNode.String(), totalPlatform.String()
+	report.CreatedAt = time.Now()
+	jsonBts, err := json.MarshalIndent(&report, "", "  ")
+	if err != nil {
+		return err
+	}
+	csvBts, err := rewardEntriesCsv(report.Entries)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(jsonPath), 0700); err != nil {
+		return err
+	}
+	// the json file marks the report written, so it goes last
+	if err = utils.WriteFileAtomic(s.rewardReportPath(report.ProposalType, target, "csv"), csvBts, 0600); err != nil {
+		return fmt.Errorf("write reward report err: %w", err)
+	}
+	if err = utils.WriteFileAtomic(jsonPath, jsonBts, 0600); err != nil {
+		return fmt.Errorf("write reward report err: %w", err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"proposalType": report.ProposalType,
+		"target":       target,
+		"entries":      len(report.Entries),
+	}).Info("saved reward report")
+	return nil
+}
+
+func rewardEntriesCsv(entries []RewardEntry) ([]byte, error) {
+	buf := bytes.Buffer{}
+	w := csv.NewWriter(&buf)
+	if err := w.Write([]string{"block", "slot", "validatorIndex", "pubkey", "nodeAddress", "classification",
+		"amount", "userReward", "nodeReward", "platformFee", "userDeposit", "nodeDeposit"}); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		record := []string{
+			strconv.FormatUint(e.Block, 10),
+			strconv.FormatUint(e.Slot, 10),
+			strconv.FormatUint(e.ValidatorIndex, 10),
+			e.Pubkey,
+			e.NodeAddress,
+			e.Classification,
+			e.Amount.String(),
+			e.UserReward.String(),
+			e.NodeReward.String(),
+			e.PlatformFee.String(),
+			e.UserDeposit.String(),
+			e.NodeDeposit.String(),
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
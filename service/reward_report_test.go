@@ -0,0 +1,78 @@
+package service
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteRewardReport(t *testing.T) {
+	s := &Service{
+		log:             logrus.NewEntry(logrus.New()),
+		lsdTokenAddress: common.HexToAddress("0x15d"),
+		rewardReports:   true,
+		rewardReportDir: t.TempDir(),
+	}
+
+	r := s.newRewardReport()
+	r.add(RewardEntry{
+		Block:          100,
+		ValidatorIndex: 1,
+		Classification: RewardFullWithdrawal,
+		Amount:         decimal.NewFromInt(33e9),
+		UserReward:     decimal.NewFromInt(6e8),
+		NodeReward:     decimal.NewFromInt(3e8),
+		PlatformFee:    decimal.NewFromInt(1e8),
+		UserDeposit:    decimal.NewFromInt(28e9),
+		NodeDeposit:    decimal.NewFromInt(4e9),
+	})
+	r.add(RewardEntry{
+		Block:          101,
+		ValidatorIndex: 2,
+		Classification: RewardPriorityFee,
+		Amount:         decimal.NewFromInt(10),
+		UserReward:     decimal.NewFromInt(6),
+		NodeReward:     decimal.NewFromInt(3),
+		PlatformFee:    decimal.NewFromInt(1),
+		UserDeposit:    decimal.Zero,
+		NodeDeposit:    decimal.Zero,
+	})
+	assert.Nil(t, s.writeRewardReport(r, RewardReport{ProposalType: metrics.ProposalDistributeWithdrawals, ToBlock: 101}, 101))
+
+	bts, err := os.ReadFile(s.rewardReportPath(metrics.ProposalDistributeWithdrawals, 101, "json"))
+	assert.Nil(t, err)
+	var report RewardReport
+	assert.Nil(t, json.Unmarshal(bts, &report))
+	assert.Equal(t, s.lsdTokenAddress.String(), report.LsdToken)
+	assert.Equal(t, "28600000006", report.TotalUser)
+	assert.Equal(t, "4300000003", report.TotalNode)
+	assert.Equal(t, "100000001", report.TotalPlatform)
+	assert.Len(t, report.Entries, 2)
+
+	f, err := os.Open(s.rewardReportPath(metrics.ProposalDistributeWithdrawals, 101, "csv"))
+	assert.Nil(t, err)
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	assert.Nil(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, RewardPriorityFee, records[2][5])
+
+	// a written report is not rewritten
+	r.add(RewardEntry{Block: 102})
+	assert.Nil(t, s.writeRewardReport(r, RewardReport{ProposalType: metrics.ProposalDistributeWithdrawals, ToBlock: 101}, 101))
+	bts, err = os.ReadFile(s.rewardReportPath(metrics.ProposalDistributeWithdrawals, 101, "json"))
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(bts, &report))
+	assert.Len(t, report.Entries, 2)
+
+	// disabled reports collect nothing
+	s.rewardReports = false
+	assert.Nil(t, s.newRewardReport())
+}
@@ -0,0 +1,405 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+)
+
+// actions of a handler running out of its failure budget
+const (
+	onFailureShutdown = "shutdown" // request a shutdown, the rpc endpoints are likely broken
+	onFailureContinue = "continue" // report it and keep retrying
+)
+
+var errHandlerTimeout = errors.New("handler timed out")
+
+// handlerSchedule is how a handler runs in its own goroutine.
+type handlerSchedule struct {
+	name          string
+	method        func(ctx context.Context) error
+	interval      func() time.Duration // between runs, also the first retry delay
+	timeout       time.Duration        // a run longer than it is cancelled and counts as a failure, 0 for none
+	backoffMax    time.Duration        // retry delays double up to it, 0 to retry every interval
+	failureBudget int                  // consecutive failures before onFailure
+	onFailure     string
+	reads         string      // read policy of the runs, see readOpts
+	lock          *sync.Mutex // handlers sharing unsynchronized state hold the same lock
+	dependsOn     []handlerDependency
+	wake          chan struct{} // ends the wait for the next run, see wakeHandler
+}
+
+// handlerDependency holds a handler until another handler has succeeded once and ready returns true.
+type handlerDependency struct {
+	handler string      // optional
+	ready   func() bool // optional
+	reason  string      // reported while ready returns false
+}
+
+// retryDelay returns the delay after the failures-th consecutive failure.
+func (h *handlerSchedule) retryDelay(failures int) time.Duration {
+	delay := h.interval()
+	if h.backoffMax <= 0 {
+		return delay
+	}
+	for i := 1; i < failures && delay < h.backoffMax; i++ {
+		delay *= 2
+	}
+	if delay > h.backoffMax {
+		delay = h.backoffMax
+	}
+	return delay
+}
+
+// sleep waits for dur until stop is closed or the handler is woken up, it returns true if woken up.
+func (h *handlerSchedule) sleep(stop <-chan struct{}, dur time.Duration) bool {
+	t := time.NewTimer(dur)
+	defer t.Stop()
+	select {
+	case <-stop:
+	case <-t.C:
+	case <-h.wake:
+		return true
+	}
+	return false
+}
+
+func after(handler string) handlerDependency {
+	return handlerDependency{handler: handler}
+}
+
+// handlerSchedules declares the handlers of the service. Handlers that used to run one after another in a
+// group keep their order through dependencies, and the ones sharing state hold the same lock, but a failing
+// handler no longer delays the others.
+func (s *Service) handlerSchedules() []*handlerSchedule {
+	syncedToCycle := handlerDependency{
+		handler: "syncBlocks",
+		ready:   s.syncedToCycleEpoch,
+		reason:  "syncBlocks not reached the epoch of current balances cycle",
+	}
+	// followers keep syncing to take over at once, but only the leader votes
+	leader := handlerDependency{
+		ready:  s.manager.IsLeader,
+		reason: "not the leader",
+	}
+
+	schedules := []*handlerSchedule{
+		s.newHandlerSchedule(s.syncEvents, s.syncEventsInterval, &s.syncLock),
+		s.newHandlerSchedule(s.updateValidatorsFromNetwork, s.slotInterval, &s.syncLock, after("syncEvents")),
+		s.newHandlerSchedule(s.syncBlocks, s.slotInterval, &s.syncLock, after("updateValidatorsFromNetwork")),
+		s.newHandlerSchedule(s.voteWithdrawCredentials, s.slotInterval, &s.syncLock, after("updateValidatorsFromNetwork"), leader),
+		s.newHandlerSchedule(s.pruneBlocks, s.slotInterval, &s.syncLock, after("syncBlocks")),
+		s.newHandlerSchedule(s.saveCheckpoint, s.slotInterval, &s.syncLock, after("syncBlocks")),
+		s.newHandlerSchedule(s.saveSnapshot, s.slotInterval, &s.syncLock, after("syncBlocks")),
+		s.newHandlerSchedule(s.updateSyncLag, s.slotInterval, nil),
+		s.newHandlerSchedule(s.checkProposalDivergence, s.slotInterval, nil),
+
+		s.newHandlerSchedule(s.updateValidatorsFromBeacon, s.voteInterval, &s.voteLock, after("updateValidatorsFromNetwork")),
+		s.newHandlerSchedule(s.submitBalances, s.voteInterval, &s.voteLock, after("updateValidatorsFromBeacon"), syncedToCycle, leader),
+		s.newHandlerSchedule(s.distributeWithdrawals, s.voteInterval, &s.voteLock, after("updateValidatorsFromBeacon"), syncedToCycle, leader),
+		s.newHandlerSchedule(s.distributePriorityFee, s.voteInterval, &s.voteLock, after("updateValidatorsFromBeacon"), syncedToCycle, leader),
+		s.newHandlerSchedule(s.setMerkleRoot, s.voteInterval, &s.voteLock, after("updateValidatorsFromBeacon"), syncedToCycle, leader),
+		s.newHandlerSchedule(s.notifyValidatorExit, s.voteInterval, &s.voteLock, after("updateValidatorsFromBeacon"), syncedToCycle, leader),
+	}
+
+	for name := range s.handlerConfigs {
+		known := false
+		for _, schedule := range schedules {
+			known = known || schedule.name == name
+		}
+		if !known {
+			s.log.WithField("handler", name).Warn("config of unknown handler is ignored")
+		}
+	}
+	return schedules
+}
+
+// newHandlerSchedule applies the config of the handler to the defaults, which retry every interval
+// and shut down after utils.RetryLimit consecutive failures.
+func (s *Service) newHandlerSchedule(method func(ctx context.Context) error, interval func() time.Duration, lock *sync.Mutex, dependsOn ...handlerDependency) *handlerSchedule {
+	schedule := &handlerSchedule{
+		name:          handlerName(method),
+		method:        method,
+		interval:      interval,
+		failureBudget: utils.RetryLimit,
+		onFailure:     onFailureShutdown,
+		reads:         readsFinalized,
+		lock:          lock,
+		dependsOn:     dependsOn,
+		wake:          make(chan struct{}, 1),
+	}
+
+	cfg, exist := s.handlerConfigs[schedule.name]
+	if !exist {
+		return schedule
+	}
+	if cfg.Interval > 0 {
+		fixed := time.Duration(cfg.Interval) * time.Second
+		schedule.interval = func() time.Duration { return fixed }
+	}
+	schedule.timeout = time.Duration(cfg.Timeout) * time.Second
+	schedule.backoffMax = time.Duration(cfg.BackoffMax) * time.Second
+	if cfg.FailureBudget > 0 {
+		schedule.failureBudget = cfg.FailureBudget
+	}
+	if cfg.OnFailure != "" {
+		schedule.onFailure = cfg.OnFailure
+	}
+	if cfg.Reads != "" {
+		schedule.reads = cfg.Reads
+	}
+	return schedule
+}
+
+func handlerName(method func(ctx context.Context) error) string {
+	funcNameRaw := runtime.FuncForPC(reflect.ValueOf(method).Pointer()).Name()
+
+	splits := strings.Split(funcNameRaw, "/")
+	funcName := splits[len(splits)-1]
+	funcName = strings.TrimPrefix(funcName, "service.(*Service).")
+	return strings.TrimSuffix(funcName, "-fm")
+}
+
+func (s *Service) slotInterval() time.Duration {
+	return time.Duration(s.eth2Config.SecondsPerSlot) * time.Second
+}
+
+// syncEventsInterval is a slot, or the poll interval of subscriptions when events are pushed.
+func (s *Service) syncEventsInterval() time.Duration {
+	if s.subscribeEvents {
+		return s.subscriptionPollInterval
+	}
+	return s.slotInterval()
+}
+
+// wakeHandler runs the handler at once if it is waiting for its next run, or right after the current run.
+// Once a woken up run succeeds, the handlers depending on it are woken up too.
+func (s *Service) wakeHandler(name string) {
+	schedule, exist := s.schedules[name]
+	if !exist {
+		return
+	}
+	select {
+	case schedule.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Service) wakeDependents(name string) {
+	for _, schedule := range s.schedules {
+		for _, dep := range schedule.dependsOn {
+			if dep.handler == name {
+				s.wakeHandler(schedule.name)
+				break
+			}
+		}
+	}
+}
+
+// voteInterval shortens when the next balances epoch is near.
+func (s *Service) voteInterval() time.Duration {
+	slotDur := time.Duration(s.eth2Config.SecondsPerSlot) * time.Second
+	epochDur := time.Duration(s.eth2Config.SlotsPerEpoch) * slotDur
+	beaconHead, err := s.connection.BeaconHead()
+	if err != nil {
+		return 6 * slotDur
+	}
+	// use 2 advance epoch to calc sleep duration
+	epoch := beaconHead.Epoch + 2
+	targetEpoch := (epoch / s.submitBalancesDuEpochs) * s.submitBalancesDuEpochs
+	distance := epoch - targetEpoch
+	if distance < s.submitBalancesDuEpochs/10 {
+		return slotDur
+	} else if distance < s.submitBalancesDuEpochs/2 {
+		return epochDur
+	}
+	return 2 * epochDur
+}
+
+// syncedToCycleEpoch reports whether syncBlocks has reached the first epoch of the current balances cycle,
+// which is the target of the vote handlers.
+func (s *Service) syncedToCycleEpoch() bool {
+	if s.submitBalancesDuEpochs == 0 {
+		return true
+	}
+	beaconHead, err := s.connection.BeaconHead()
+	if err != nil {
+		// leave it to the handler
+		return true
+	}
+	targetEpoch := (beaconHead.FinalizedEpoch / s.submitBalancesDuEpochs) * s.submitBalancesDuEpochs
+	return s.latestSlotOfSyncBlock.Load() >= utils.StartSlotOfEpoch(s.eth2Config, targetEpoch)
+}
+
+// unmetDependency returns why the handler has to wait, or empty if it can run.
+func (s *Service) unmetDependency(h *handlerSchedule) string {
+	for _, dep := range h.dependsOn {
+		if dep.handler != "" {
+			status, exist := s.handlerStatus.Load(dep.handler)
+			if !exist || status.LastSuccessAt.IsZero() {
+				return fmt.Sprintf("%s not succeeded yet", dep.handler)
+			}
+		}
+		if dep.ready != nil && !dep.ready() {
+			return dep.reason
+		}
+	}
+	return ""
+}
+
+func (s *Service) startScheduledHandler(h *handlerSchedule) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	log := s.log.WithField("handler", h.name)
+	s.handlersWg.Add(1)
+	utils.SafeGo(func() {
+		defer s.handlersWg.Done()
+		failures := 0
+		woken := false
+		for {
+			select {
+			case <-s.ctx.Done():
+				log.Debug("handler stopped")
+				return
+			default:
+			}
+
+			if waiting := s.unmetDependency(h); waiting != "" {
+				s.setHandlerWaiting(h.name, waiting)
+				log.WithField("waiting_for", waiting).Debug("handler waiting")
+				woken = h.sleep(s.ctx.Done(), h.interval()) || woken
+				continue
+			}
+			s.setHandlerWaiting(h.name, "")
+
+			log.Debug("handler begin")
+			err := s.runHandler(h)
+			if err == nil {
+				log.Debug("handler end")
+				if woken {
+					s.wakeDependents(h.name)
+				}
+				failures = 0
+				woken = h.sleep(s.ctx.Done(), h.interval())
+				continue
+			}
+			woken = false
+			if s.ctx.Err() != nil {
+				log.WithField("err", err).Info("handler interrupted by shutdown")
+				return
+			}
+			if errors.Is(err, ErrHandlerExit) {
+				log.Error(err.Error())
+				utils.RequestShutdown(fmt.Sprintf("handler %s: %s", h.name, err.Error()))
+				return
+			}
+
+			var gasErr *connection.GasPriceError
+			if errors.As(err, &gasErr) {
+				retryIn := h.interval()
+				log.WithField("retry_in", retryIn).Error(gasErr.Error())
+				utils.Sleep(s.ctx.Done(), retryIn)
+				continue
+			}
+
+			failures++
+			retryIn := h.retryDelay(failures)
+			retryLog := log.WithFields(logrus.Fields{
+				"retry_times": failures,
+				"err":         err,
+			})
+			if failures > h.failureBudget {
+				if h.onFailure != onFailureContinue {
+					retryLog.Errorf("shutting down for too many attempts failed, check your RPC status first")
+					utils.RequestShutdown(fmt.Sprintf("handler %s failed %d times", h.name, failures))
+					return
+				}
+				if failures == h.failureBudget+1 {
+					retryLog.Error("failure budget of handler exhausted, keep retrying")
+				}
+			}
+			retryLog = retryLog.WithField("retry_in", retryIn)
+			if failures < 50 {
+				retryLog.Debugf("failed waiting retry")
+			} else {
+				retryLog.Warnf("failed waiting retry")
+			}
+			utils.Sleep(s.ctx.Done(), retryIn)
+		}
+	})
+}
+
+// runHandler runs the handler once under its lock. After a timeout the run is cancelled and reported as
+// failed, but still waited for as handlers may ignore cancellation for a while, runs of a handler never overlap.
+func (s *Service) runHandler(h *handlerSchedule) error {
+	start := time.Now()
+	s.runningHandlers.Store(h.name, start)
+	defer s.runningHandlers.Delete(h.name)
+	runCtx, votes := withVotes(withReads(s.ctx, h.reads))
+
+	err := s.runHandlerLocked(h, runCtx)
+	if err == nil {
+		// waited without the lock, so handlers sharing it still queue their votes in the same batch
+		err = votes.wait(s.ctx)
+	}
+	s.recordHandlerRun(h.name, time.Since(start), err)
+	return err
+}
+
+// runHandlerLocked calls the handler under its lock, within its timeout if it has one.
+func (s *Service) runHandlerLocked(h *handlerSchedule, runCtx context.Context) error {
+	if h.lock != nil {
+		h.lock.Lock()
+		defer h.lock.Unlock()
+	}
+	if h.timeout <= 0 {
+		return callHandler(runCtx, h.method)
+	}
+
+	ctx, cancel := context.WithTimeout(runCtx, h.timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- callHandler(ctx, h.method)
+	}()
+	select {
+	case err := <-done:
+		if errors.Is(err, context.DeadlineExceeded) && s.ctx.Err() == nil {
+			err = fmt.Errorf("%w after %s: %w", errHandlerTimeout, h.timeout, err)
+		}
+		return err
+	case <-ctx.Done():
+		if s.ctx.Err() != nil {
+			// shutting down, the caller waits for it
+			return <-done
+		}
+	}
+
+	err := fmt.Errorf("%w after %s", errHandlerTimeout, h.timeout)
+	log := s.log.WithField("handler", h.name)
+	log.Warn(err.Error())
+	if lateErr := <-done; lateErr != nil {
+		log.WithField("err", lateErr).Warn("timed out handler failed")
+	}
+	return err
+}
+
+// callHandler turns a panic of the handler into an error, so its goroutine keeps running.
+func callHandler(ctx context.Context, method func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v\nstack: %s", r, utils.Stack(3))
+		}
+	}()
+	return method(ctx)
+}
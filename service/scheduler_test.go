@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	xsync "github.com/puzpuzpuz/xsync/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerSchedule(t *testing.T) {
+	h := &handlerSchedule{
+		interval:   func() time.Duration { return 10 * time.Second },
+		backoffMax: time.Minute,
+	}
+	assert.Equal(t, 10*time.Second, h.retryDelay(1))
+	assert.Equal(t, 20*time.Second, h.retryDelay(2))
+	assert.Equal(t, 40*time.Second, h.retryDelay(3))
+	assert.Equal(t, time.Minute, h.retryDelay(4))
+	assert.Equal(t, time.Minute, h.retryDelay(100))
+	h.backoffMax = 0
+	assert.Equal(t, 10*time.Second, h.retryDelay(5))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &Service{
+		ctx:             ctx,
+		cancel:          cancel,
+		handlerStatus:   xsync.NewMapOf[string, HandlerStatus](),
+		runningHandlers: xsync.NewMapOf[string, time.Time](),
+		log:             logrus.NewEntry(logrus.New()),
+	}
+
+	// dependencies
+	ready := false
+	h.dependsOn = []handlerDependency{{handler: "syncBlocks", ready: func() bool { return ready }, reason: "not ready"}}
+	assert.Equal(t, "syncBlocks not succeeded yet", s.unmetDependency(h))
+	s.recordHandlerRun("syncBlocks", time.Second, errors.New("rpc down"))
+	assert.Equal(t, "syncBlocks not succeeded yet", s.unmetDependency(h))
+	s.recordHandlerRun("syncBlocks", time.Second, nil)
+	assert.Equal(t, "not ready", s.unmetDependency(h))
+	ready = true
+	assert.Equal(t, "", s.unmetDependency(h))
+
+	// timeout
+	finished := false
+	h = &handlerSchedule{
+		name: "slow",
+		method: func(ctx context.Context) error {
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			finished = true
+			return ctx.Err()
+		},
+		timeout: 10 * time.Millisecond,
+	}
+	assert.True(t, errors.Is(s.runHandler(h), errHandlerTimeout))
+	assert.True(t, finished)
+	status, _ := s.handlerStatus.Load("slow")
+	assert.Equal(t, uint64(1), status.ConsecutiveFailures)
+
+	// panic
+	h = &handlerSchedule{
+		name:   "panic",
+		method: func(context.Context) error { panic("boom") },
+	}
+	assert.NotNil(t, s.runHandler(h))
+	h.method = func(context.Context) error { return nil }
+	assert.Nil(t, s.runHandler(h))
+	status, _ = s.handlerStatus.Load("panic")
+	assert.Equal(t, uint64(2), status.Runs)
+	assert.Equal(t, uint64(0), status.ConsecutiveFailures)
+
+	// each run gets its own read policy
+	var runReads []*handlerReads
+	h = &handlerSchedule{
+		name:  "reads",
+		reads: readsTarget,
+		method: func(ctx context.Context) error {
+			runReads = append(runReads, ctx.Value(readsKey{}).(*handlerReads))
+			return nil
+		},
+	}
+	assert.Nil(t, s.runHandler(h))
+	assert.Nil(t, s.runHandler(h))
+	assert.Equal(t, readsTarget, runReads[0].policy)
+	assert.NotSame(t, runReads[0], runReads[1])
+
+	// queued votes are waited without the lock, a failed vote fails the run
+	var lock sync.Mutex
+	vote := newVoteResult()
+	added := make(chan struct{})
+	h = &handlerSchedule{
+		name: "vote",
+		lock: &lock,
+		method: func(ctx context.Context) error {
+			ctx.Value(votesKey{}).(*handlerVotes).add(vote)
+			close(added)
+			return nil
+		},
+	}
+	go func() {
+		<-added
+		lock.Lock()
+		lock.Unlock()
+		vote.finish(errVoteFailed)
+	}()
+	assert.True(t, errors.Is(s.runHandler(h), errVoteFailed))
+	status, _ = s.handlerStatus.Load("vote")
+	assert.Equal(t, uint64(1), status.ConsecutiveFailures)
+
+	// a woken up handler runs before its interval elapses, wake ups while running are coalesced
+	h.wake = make(chan struct{}, 1)
+	s.schedules = map[string]*handlerSchedule{h.name: h}
+	s.wakeHandler(h.name)
+	s.wakeHandler(h.name)
+	s.wakeHandler("unknown")
+	start := time.Now()
+	assert.True(t, h.sleep(ctx.Done(), time.Minute))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, 0, len(h.wake))
+	assert.False(t, h.sleep(ctx.Done(), time.Millisecond))
+
+	// a woken up run wakes up the handlers depending on it
+	dependent := &handlerSchedule{name: "dependent", wake: make(chan struct{}, 1), dependsOn: []handlerDependency{after(h.name)}}
+	s.schedules[dependent.name] = dependent
+	s.wakeDependents(dependent.name)
+	assert.Equal(t, 0, len(dependent.wake))
+	s.wakeDependents(h.name)
+	assert.Equal(t, 1, len(dependent.wake))
+}
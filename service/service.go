@@ -3,16 +3,14 @@ package service
 import (
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"math"
 	"math/big"
 	"os"
-	"reflect"
-	"runtime"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/avast/retry-go/v4"
@@ -22,6 +20,7 @@ import (
 	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/prysmaticlabs/prysm/v4/beacon-chain/core/signing"
 	"github.com/prysmaticlabs/prysm/v4/config/params"
+	xsync "github.com/puzpuzpuz/xsync/v3"
 	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
 	deposit_contract "github.com/stafiprotocol/eth-lsd-relay/bindings/DepositContract"
@@ -43,8 +42,17 @@ import (
 )
 
 type Service struct {
-	stop             chan struct{}
+	ctx              context.Context // cancelled by Stop, handlers return at their next cancellation point
+	cancel           context.CancelFunc
 	startServiceOnce sync.Once
+	handlersWg       sync.WaitGroup                  // handler goroutines
+	runningHandlers  *xsync.MapOf[string, time.Time] // handler name -> start of the current run
+	handlersStarted  atomic.Bool
+	handlerStatus    *xsync.MapOf[string, HandlerStatus] // handler name -> latest run
+	handlerConfigs   map[string]config.Handler           // handler name -> schedule overrides
+	schedules        map[string]*handlerSchedule         // handler name -> schedule, set once handlers start
+	syncLock         sync.Mutex                          // handlers updating sync state
+	voteLock         sync.Mutex                          // handlers computing votes from synced state
 	log              *logrus.Entry
 	manager          *ServiceManager
 
@@ -55,6 +63,10 @@ type Service struct {
 
 	batchRequestBlocksNumber uint64
 	eventFilterMaxSpanBlocks uint64
+	confirmationBlocks       uint64
+	syncEventsToFinalized    bool
+	subscribeEvents          bool
+	subscriptionPollInterval time.Duration
 	maxEjectedValPerCycle    int
 
 	connection          *connection.CachedConnection
@@ -70,10 +82,31 @@ type Service struct {
 	networkWithdrawAddress   common.Address
 	networkBalancesAddress   common.Address
 	nodeDepositAddress       common.Address
+	networkProposalAddress   common.Address
+	govDepositAddress        common.Address
 
 	networkWithdrawAbi abi.ABI
 	networkBalancesAbi abi.ABI
 	nodeDepositAbi     abi.ABI
+	networkProposalAbi abi.ABI
+
+	// dry run mode computes proposals without sending txs
+	dryRun        bool
+	dryRunVoter   common.Address // optional voter to check HasVoted in dry run mode
+	dryRunMutex   sync.Mutex
+	dryRunChecked map[[32]byte]DryRunResult // proposal id -> latest result
+	dryRunResults []DryRunResult
+
+	// proposals voted by all voters, synced by dry runs and divergence detection
+	votedProposalsMutex        sync.Mutex
+	latestBlockOfSyncProposals uint64
+	votedProposals             map[[32]byte]*votedProposal // proposal id -> voted proposal
+
+	// divergence detection of own proposals against other voters
+	divergenceMutex     sync.Mutex
+	ownProposals        map[[32]byte]*ownProposal // proposal id -> own proposal
+	reportedDivergences map[[2][32]byte]bool      // (own, leading) proposal id -> leading executed
+	divergences         []ProposalDivergence
 
 	lsdNetworkFactoryContract *lsd_network_factory.LsdNetworkFactory
 	nodeDepositContract       *node_deposit.CustomNodeDeposit
@@ -88,16 +121,24 @@ type Service struct {
 	nodeCommissionRate     decimal.Decimal
 	platformCommissionRate decimal.Decimal
 
-	latestSlotOfSyncBlock   uint64
-	latestBlockOfSyncBlock  uint64
-	waitFirstNodeStakeEvent bool
+	// sync heights are written by handlers and read by others and the status api
+	latestSlotOfSyncBlock   atomic.Uint64
+	latestBlockOfSyncBlock  atomic.Uint64
+	waitFirstNodeStakeEvent atomic.Bool
 	localSyncedBlockHeight  uint64
 	localStore              *local_store.LocalStore
-
-	latestBlockOfSyncEvents      uint64
-	latestBlockOfUpdateValidator uint64
-	latestEpochOfUpdateValidator uint64
-	startAtBlock                 uint64
+	localCheckpoint         *local_store.Checkpoint // checkpoint of previous run
+	savedCheckpoint         local_store.Checkpoint
+	lastCheckpointAt        time.Time
+	snapshotPath            string
+	lastSnapshotAt          time.Time
+
+	latestBlockOfSyncEvents        atomic.Uint64
+	syncedRanges                   []*syncedRange // recent ranges of syncEvents, undone on reorg
+	latestBlockOfUpdateValidator   atomic.Uint64
+	latestEpochOfUpdateValidator   atomic.Uint64
+	snapshotEpochOfUpdateValidator uint64 // epoch of the validator statuses restored from the snapshot
+	startAtBlock                   uint64
 
 	cycleSeconds                      uint64
 	latestDistributeWithdrawalsHeight uint64
@@ -108,7 +149,7 @@ type Service struct {
 
 	validators             map[string]*Validator // pubkey(hex.encodeToString) -> validator
 	validatorsByIndex      map[uint64]*Validator // validator index -> validator
-	validatorsByIndexMutex sync.RWMutex
+	validatorsByIndexMutex sync.RWMutex          // also guards fields of validators updated from beacon
 
 	nodes map[common.Address]*Node // nodeAddress -> node
 
@@ -119,7 +160,18 @@ type Service struct {
 	cacheEpochToBlockID      *lru.Cache[uint64, uint64]
 	cacheEpochToBlockIDMutex sync.RWMutex
 
+	feeCache              *feeCache
+	priorityFeeAccounting string // balance or receipts
+	feeComparison         bool   // compute priority fees by both accountings and report the blocks they differ
+	feeComparisonMutex    sync.Mutex
+	feeComparisons        []PriorityFeeComparison
+
+	rewardReports   bool // write a reward attribution report of each distribute and merkle root proposal
+	rewardReportDir string
+
 	exitElections map[uint64]*ExitElection // cycle -> exitElection
+
+	replay *replayState // set in replay mode, the service recomputes one past proposal, see ServiceManager.Replay
 }
 
 type Node struct {
@@ -180,11 +232,6 @@ type CachedWithdrawal struct {
 	Amount         uint64
 }
 
-type Handler struct {
-	method func() error
-	name   string
-}
-
 func NewService(
 	cfg *config.Config,
 	manager *ServiceManager,
@@ -212,17 +259,23 @@ func NewService(
 		return nil, fmt.Errorf("BatchRequestBlocksNumber is zero")
 	}
 
-	info, err := localStore.Read(cfg.Contracts.LsdTokenAddress)
+	info, err := localStore.Read(common.HexToAddress(cfg.Contracts.LsdTokenAddress).Hex())
 	if err != nil {
 		return nil, err
 	}
 	var localSyncedBlockHeight uint64 = 0
+	var localCheckpoint *local_store.Checkpoint
 	if info != nil {
 		localSyncedBlockHeight = info.SyncedHeight
+		localCheckpoint = info.Checkpoint
 	}
 	log := logrus.WithFields(logrus.Fields{
 		"lsdToken": cfg.Contracts.LsdTokenAddress,
 	})
+	var dryRunVoter common.Address
+	if cfg.DryRun && common.IsHexAddress(cfg.Account) {
+		dryRunVoter = common.HexToAddress(cfg.Account)
+	}
 
 	dds, err := pinata.NewClient(
 		cfg.Pinata.Endpoint,
@@ -238,8 +291,13 @@ func NewService(
 		return nil, err
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	s := &Service{
-		stop:                     make(chan struct{}),
+		ctx:                      ctx,
+		cancel:                   cancel,
+		runningHandlers:          xsync.NewMapOf[string, time.Time](),
+		handlerStatus:            xsync.NewMapOf[string, HandlerStatus](),
+		handlerConfigs:           cfg.Handlers,
 		manager:                  manager,
 		connection:               conn,
 		log:                      log,
@@ -248,9 +306,21 @@ func NewService(
 		lsdNetworkFactoryAddress: common.HexToAddress(cfg.Contracts.LsdFactoryAddress),
 		batchRequestBlocksNumber: cfg.BatchRequestBlocksNumber,
 		eventFilterMaxSpanBlocks: cfg.EventFilterMaxSpanBlocks,
+		confirmationBlocks:       *cfg.ConfirmationBlocks,
+		syncEventsToFinalized:    cfg.SyncEventsToFinalized,
+		subscribeEvents:          cfg.SubscribeEvents,
+		subscriptionPollInterval: time.Duration(cfg.SubscriptionPollInterval) * time.Second,
 		maxEjectedValPerCycle:    cfg.MaxEjectedValPerCycle,
 		localSyncedBlockHeight:   localSyncedBlockHeight,
 		localStore:               localStore,
+		localCheckpoint:          localCheckpoint,
+		snapshotPath:             cfg.SnapshotPath,
+		dryRun:                   cfg.DryRun,
+		dryRunVoter:              dryRunVoter,
+		dryRunChecked:            make(map[[32]byte]DryRunResult),
+		votedProposals:           make(map[[32]byte]*votedProposal),
+		ownProposals:             make(map[[32]byte]*ownProposal),
+		reportedDivergences:      make(map[[2][32]byte]bool),
 
 		govDeposits:         make(map[string][][]byte),
 		validators:          make(map[string]*Validator),
@@ -259,6 +329,12 @@ func NewService(
 		stakerWithdrawals:   make(map[uint64]*StakerWithdrawal),
 		exitElections:       make(map[uint64]*ExitElection),
 		cacheEpochToBlockID: cacheEpochToBlockID,
+		feeCache:            newFeeCache(),
+
+		priorityFeeAccounting: cfg.PriorityFeeAccounting,
+		feeComparison:         cfg.ComparePriorityFees,
+		rewardReports:         cfg.RewardReports,
+		rewardReportDir:       cfg.RewardReportPath,
 	}
 
 	return s, nil
@@ -381,8 +457,14 @@ func (s *Service) Start() error {
 	}
 	s.withdrawCredentials = credentials
 
+	// read the parameters at the finalized block, they must not change with a reorg
+	finalizedOpts, err := s.connection.PinnedCallOpts(s.ctx, connection.BlockTagFinalized)
+	if err != nil {
+		return err
+	}
+
 	// get updateBalances epochs
-	updateBalancesEpochs, err := s.networkBalancesContract.UpdateBalancesEpochs(nil)
+	updateBalancesEpochs, err := s.networkBalancesContract.UpdateBalancesEpochs(finalizedOpts)
 	if err != nil {
 		return err
 	}
@@ -393,64 +475,61 @@ func (s *Service) Start() error {
 	s.setUpdateBalancesEpochs(updateBalancesEpochs.Uint64())
 
 	// init commission
-	nodeCommissionRate, err := s.networkWithdrawContract.NodeCommissionRate(nil)
+	nodeCommissionRate, err := s.networkWithdrawContract.NodeCommissionRate(finalizedOpts)
 	if err != nil {
 		return err
 	}
 	s.nodeCommissionRate = decimal.NewFromBigInt(nodeCommissionRate, 0).Div(decimal.NewFromInt(1e18))
-	platformCommissionRate, err := s.networkWithdrawContract.PlatformCommissionRate(nil)
+	platformCommissionRate, err := s.networkWithdrawContract.PlatformCommissionRate(finalizedOpts)
 	if err != nil {
 		return err
 	}
 	s.platformCommissionRate = decimal.NewFromBigInt(platformCommissionRate, 0).Div(decimal.NewFromInt(1e18))
 
 	// init cycle seconds
-	cycleSeconds, err := s.networkWithdrawContract.WithdrawCycleSeconds(nil)
+	cycleSeconds, err := s.networkWithdrawContract.WithdrawCycleSeconds(finalizedOpts)
 	if err != nil {
 		return err
 	}
 	s.cycleSeconds = cycleSeconds.Uint64()
 
 	// init latest block and slot number
-	s.latestBlockOfUpdateValidator = s.startAtBlock
-	s.latestBlockOfSyncEvents = s.startAtBlock
+	s.latestBlockOfUpdateValidator.Store(s.startAtBlock)
+	s.latestBlockOfSyncEvents.Store(s.startAtBlock)
 	if err = s.initLatestBlockOfSyncBlock(); err != nil {
 		return err
 	}
 
-	block, err := s.connection.Eth1Client().BlockByNumber(context.Background(), big.NewInt(int64(s.latestBlockOfSyncBlock)))
+	block, err := s.connection.Eth1Client().BlockByNumber(context.Background(), big.NewInt(int64(s.latestBlockOfSyncBlock.Load())))
 	if err != nil {
 		return err
 	}
-	s.latestSlotOfSyncBlock = utils.SlotAtTimestamp(s.eth2Config, block.Time())
+	s.latestSlotOfSyncBlock.Store(utils.SlotAtTimestamp(s.eth2Config, block.Time()))
+
+	if err = s.restoreSnapshot(); err != nil {
+		return err
+	}
+	if err = s.restoreCheckpoint(); err != nil {
+		return err
+	}
 
 	s.log.WithFields(logrus.Fields{
 		"nodeCommissionRate":      s.nodeCommissionRate.String(),
 		"platformCommissionRate":  s.platformCommissionRate.String(),
 		"updateBalancesEpochs":    updateBalancesEpochs.Uint64(),
 		"cycleSeconds":            cycleSeconds.Uint64(),
-		"latestSlotOfSyncBlock":   s.latestSlotOfSyncBlock,
-		"latestBlockOfSyncBlock":  s.latestBlockOfSyncBlock,
-		"waitFirstNodeStakeEvent": s.waitFirstNodeStakeEvent,
+		"latestSlotOfSyncBlock":   s.latestSlotOfSyncBlock.Load(),
+		"latestBlockOfSyncBlock":  s.latestBlockOfSyncBlock.Load(),
+		"waitFirstNodeStakeEvent": s.waitFirstNodeStakeEvent.Load(),
 	}).Infof("running parameters")
 
-	// init abi
-	s.networkWithdrawAbi, err = abi.JSON(strings.NewReader(network_withdraw.NetworkWithdrawABI))
-	if err != nil {
-		return err
-	}
-	s.networkBalancesAbi, err = abi.JSON(strings.NewReader(network_balances.NetworkBalancesABI))
-	if err != nil {
-		return err
-	}
-	s.nodeDepositAbi, err = abi.JSON(strings.NewReader(node_deposit.NodeDepositABI))
-	if err != nil {
+	if err = s.initAbi(); err != nil {
 		return err
 	}
 
 	// start services
 	s.log.Info("start services...")
-	if s.waitFirstNodeStakeEvent {
+	if s.waitFirstNodeStakeEvent.Load() {
 		s.startSeekFirstNodeStakeEvent()
 	} else {
 		s.startHandlers()
@@ -475,7 +554,7 @@ func (s *Service) startSeekFirstNodeStakeEvent() {
 		defer log.Info("service stopped")
 		for {
 			select {
-			case <-s.stop:
+			case <-s.ctx.Done():
 				return
 			default:
 				found, err := s.seekFirstNodeStakeEvent()
@@ -489,7 +568,7 @@ func (s *Service) startSeekFirstNodeStakeEvent() {
 						"err": err,
 					}).Warn("seek first node stake event error")
 				}
-				utils.Sleep(s.stop, time.Minute*30)
+				utils.Sleep(s.ctx.Done(), time.Minute*30)
 			}
 		}
 	})
@@ -499,7 +578,7 @@ func (s *Service) seekFirstNodeStakeEvent() (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	start := s.latestBlockOfSyncBlock
+	start := s.latestBlockOfSyncBlock.Load()
 	end := latestBlock
 
 	for subStart := start; subStart <= end; subStart += s.eventFilterMaxSpanBlocks {
@@ -512,9 +591,9 @@ func (s *Service) seekFirstNodeStakeEvent() (bool, error) {
 			return s.nodeDepositContract.FilterDeposited(&bind.FilterOpts{
 				Start:   subStart,
 				End:     &subEnd,
-				Context: context.Background(),
+				Context: s.ctx,
 			})
-		}, retry.Delay(time.Second*2), retry.Attempts(5))
+		}, retry.Delay(time.Second*2), retry.Attempts(5), retry.Context(s.ctx))
 		if err != nil {
 			return false, err
 		}
@@ -523,30 +602,27 @@ func (s *Service) seekFirstNodeStakeEvent() (bool, error) {
 		iter.Close()
 		if hasEvent {
 			// found the first node stake event
-			s.waitFirstNodeStakeEvent = false
+			s.waitFirstNodeStakeEvent.Store(false)
 			s.startAtBlock = utils.Max(iter.Event.Raw.BlockNumber-2, s.startAtBlock)
-			s.latestBlockOfSyncBlock = s.startAtBlock
-			s.latestBlockOfUpdateValidator = s.latestBlockOfSyncBlock
-			s.latestBlockOfSyncEvents = s.latestBlockOfSyncBlock
+			s.latestBlockOfSyncBlock.Store(s.startAtBlock)
+			s.latestBlockOfUpdateValidator.Store(s.startAtBlock)
+			s.latestBlockOfSyncEvents.Store(s.startAtBlock)
 
-			block, err := s.connection.Eth1Client().BlockByNumber(context.Background(), big.NewInt(int64(s.latestBlockOfSyncBlock)))
+			block, err := s.connection.Eth1Client().BlockByNumber(s.ctx, big.NewInt(int64(s.startAtBlock)))
 			if err != nil {
 				return false, err
 			}
-			s.latestSlotOfSyncBlock = utils.SlotAtTimestamp(s.eth2Config, block.Time())
+			s.latestSlotOfSyncBlock.Store(utils.SlotAtTimestamp(s.eth2Config, block.Time()))
 
 			s.startHandlers()
 			return true, nil
 		}
 	}
 
-	if err = s.localStore.Update(local_store.Info{
-		SyncedHeight: end,
-		Address:      s.lsdTokenAddress.Hex(),
-	}); err != nil {
+	if err := s.localStore.UpdateSyncedHeight(s.lsdTokenAddress.Hex(), end); err != nil {
 		return false, err
 	}
-	s.latestBlockOfSyncBlock = end
+	s.latestBlockOfSyncBlock.Store(end)
 	return false, nil
 }
 
@@ -555,7 +631,16 @@ func (s *Service) startHandlers() {
 		utils.SafeGoWithRestart(func() {
 			for {
 				s.log.Debug("fetching WithdrawCycleSeconds & UpdateBalancesEpochs from eth1")
-				cycleSeconds, err := s.networkWithdrawContract.WithdrawCycleSeconds(nil)
+				finalizedOpts, err := s.connection.PinnedCallOpts(s.ctx, connection.BlockTagFinalized)
+				if err != nil {
+					s.log.WithError(err).Info("could not get finalized block from eth1")
+					utils.Sleep(s.ctx.Done(), time.Minute)
+					if s.ctx.Err() != nil {
+						return
+					}
+					continue
+				}
+				cycleSeconds, err := s.networkWithdrawContract.WithdrawCycleSeconds(finalizedOpts)
 				if err != nil || cycleSeconds.Uint64() == 0 {
 					s.log.WithError(err).Info("could not get WithdrawCycleSeconds from eth1")
 				} else if s.cycleSeconds != cycleSeconds.Uint64() {
@@ -566,7 +651,7 @@ func (s *Service) startHandlers() {
 					s.cycleSeconds = cycleSeconds.Uint64()
 				}
 
-				updateBalancesEpochs, err := s.networkBalancesContract.UpdateBalancesEpochs(nil)
+				updateBalancesEpochs, err := s.networkBalancesContract.UpdateBalancesEpochs(finalizedOpts)
 				if err != nil || updateBalancesEpochs.Uint64() == 0 {
 					s.log.WithError(err).Info("could not get UpdateBalancesEpochs from eth1")
 				} else if s.submitBalancesDuEpochs != updateBalancesEpochs.Uint64() {
@@ -577,43 +662,78 @@ func (s *Service) startHandlers() {
 					s.setUpdateBalancesEpochs(updateBalancesEpochs.Uint64())
 				}
 
-				time.Sleep(time.Minute * 10)
+				utils.Sleep(s.ctx.Done(), time.Minute*10)
+				if s.ctx.Err() != nil {
+					return
+				}
 			}
 		})
 
 		s.minExecutionBlockHeight = s.startAtBlock
 		s.log.WithFields(logrus.Fields{
-			"latestBlockOfSyncBlock": s.latestBlockOfSyncBlock,
+			"latestBlockOfSyncBlock": s.latestBlockOfSyncBlock.Load(),
 		}).Info("start voting handlers")
 
-		s.startGroupHandlers(func() time.Duration {
-			return time.Duration(s.eth2Config.SecondsPerSlot) * time.Second
-		}, s.syncEvents, s.updateValidatorsFromNetwork, s.syncBlocks, s.voteWithdrawCredentials, s.pruneBlocks)
-		s.startGroupHandlers(func() time.Duration {
-			slotDur := time.Duration(s.eth2Config.SecondsPerSlot) * time.Second
-			epochDur := time.Duration(s.eth2Config.SlotsPerEpoch) * slotDur
-			beaconHead, err := s.connection.BeaconHead()
-			if err != nil {
-				return 6 * slotDur
-			}
-			// use 2 advance epoch to calc sleep duration
-			epoch := beaconHead.Epoch + 2
-			targetEpoch := (epoch / s.submitBalancesDuEpochs) * s.submitBalancesDuEpochs
-			distance := epoch - targetEpoch
-			if distance < s.submitBalancesDuEpochs/10 {
-				return slotDur
-			} else if distance < s.submitBalancesDuEpochs/2 {
-				return epochDur
-			}
-			return 2 * epochDur
-		},
-			s.updateValidatorsFromBeacon, s.submitBalances, s.distributeWithdrawals,
-			s.distributePriorityFee, s.setMerkleRoot, s.notifyValidatorExit)
+		schedules := s.handlerSchedules()
+		s.schedules = make(map[string]*handlerSchedule, len(schedules))
+		for _, schedule := range schedules {
+			s.schedules[schedule.name] = schedule
+		}
+		for _, schedule := range schedules {
+			s.startScheduledHandler(schedule)
+		}
+		if s.subscribeEvents {
+			s.startEventSubscriptions()
+		}
+		s.connection.OnBeaconEvent(s.onBeaconEvent)
+		s.handlersStarted.Store(true)
 	})
 }
 
+// Stop cancels the handlers without waiting for them, see Shutdown.
 func (s *Service) Stop() {
-	close(s.stop)
+	s.cancel()
+}
+
+// ServiceShutdown is what a service left behind when it was shut down.
+type ServiceShutdown struct {
+	LsdToken            string
+	InterruptedHandlers []string // handlers still running at the deadline
+	CheckpointSaved     bool
+	CheckpointError     string
+}
+
+// Shutdown stops the handlers and waits for running ones until ctx is done, then persists the checkpoint.
+// The checkpoint is not saved while handlers are still running, as they may be updating the sync state.
+func (s *Service) Shutdown(ctx context.Context) ServiceShutdown {
+	s.Stop()
+	report := ServiceShutdown{LsdToken: s.lsdTokenAddress.String()}
+
+	done := make(chan struct{})
+	go func() {
+		s.handlersWg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.runningHandlers.Range(func(name string, _ time.Time) bool {
+			report.InterruptedHandlers = append(report.InterruptedHandlers, name)
+			return true
+		})
+		sort.Strings(report.InterruptedHandlers)
+		return report
+	}
+
+	if !s.handlersStarted.Load() {
+		return report
+	}
+	if err := s.writeCheckpoint(); err != nil {
+		report.CheckpointError = err.Error()
+	} else {
+		report.CheckpointSaved = true
+	}
+	return report
 }
 
 func (s *Service) initContract() error {
@@ -635,6 +755,7 @@ func (s *Service) initContract() error {
 		return err
 	}
 
+	s.govDepositAddress = ethDepositAddress
 	s.govDepositContract, err = deposit_contract.NewDepositContract(ethDepositAddress, s.connection.Eth1Client())
 	if err != nil {
 		return err
@@ -654,6 +775,7 @@ func (s *Service) initContract() error {
 		return err
 	}
 
+	s.networkProposalAddress = networkContracts.NetworkProposal
 	s.networkProposalContract, err = network_proposal.NewNetworkProposal(networkContracts.NetworkProposal, s.connection.Eth1Client())
 	if err != nil {
 		return err
@@ -684,27 +806,49 @@ func (s *Service) initContract() error {
 	return nil
 }
 
+func (s *Service) initAbi() error {
+	var err error
+	s.networkWithdrawAbi, err = abi.JSON(strings.NewReader(network_withdraw.NetworkWithdrawABI))
+	if err != nil {
+		return err
+	}
+	s.networkBalancesAbi, err = abi.JSON(strings.NewReader(network_balances.NetworkBalancesABI))
+	if err != nil {
+		return err
+	}
+	s.nodeDepositAbi, err = abi.JSON(strings.NewReader(node_deposit.NodeDepositABI))
+	if err != nil {
+		return err
+	}
+	s.networkProposalAbi, err = abi.JSON(strings.NewReader(network_proposal.NetworkProposalABI))
+	return err
+}
+
 func (s *Service) initLatestBlockOfSyncBlock() error {
-	s.latestBlockOfSyncBlock = math.MaxUint64
+	s.latestBlockOfSyncBlock.Store(math.MaxUint64)
 	checkAndUpdateLatestBlockOfSyncBlock := func(block uint64) {
 		s.log.Debugf("checkAndUpdateLatestBlockOfSyncBlock block: %d", block)
-		if block < s.latestBlockOfSyncBlock {
-			s.latestBlockOfSyncBlock = block
+		if block < s.latestBlockOfSyncBlock.Load() {
+			s.latestBlockOfSyncBlock.Store(block)
 		}
 	}
 
-	latestDistributePriorityFeeHeight, err := s.networkWithdrawContract.LatestDistributePriorityFeeHeight(nil)
+	readOpts, err := s.readOpts(s.ctx, 0)
+	if err != nil {
+		return err
+	}
+	latestDistributePriorityFeeHeight, err := s.networkWithdrawContract.LatestDistributePriorityFeeHeight(readOpts)
 	if err != nil {
 		return err
 	}
 	checkAndUpdateLatestBlockOfSyncBlock(latestDistributePriorityFeeHeight.Uint64())
 
-	merkleRootEpoch, err := s.networkWithdrawContract.LatestMerkleRootEpoch(nil)
+	merkleRootEpoch, err := s.networkWithdrawContract.LatestMerkleRootEpoch(readOpts)
 	if err != nil {
 		return err
 	}
 	if merkleRootEpoch.Uint64() > 0 {
-		epochBlockNumber, err := s.getEpochStartBlocknumberWithCheck(merkleRootEpoch.Uint64())
+		epochBlockNumber, err := s.getEpochStartBlocknumberWithCheck(s.ctx, merkleRootEpoch.Uint64())
 		if err != nil {
 			return err
 		}
@@ -719,7 +863,7 @@ func (s *Service) initLatestBlockOfSyncBlock() error {
 		return fmt.Errorf("currentCycleAndStartTimestamp failed: %w", err)
 	}
 	targetEpoch := utils.EpochAtTimestamp(s.eth2Config, uint64(targetTimestamp))
-	targetBlockNumber, err := s.getEpochStartBlocknumberWithCheck(targetEpoch)
+	targetBlockNumber, err := s.getEpochStartBlocknumberWithCheck(s.ctx, targetEpoch)
 	if err != nil {
 		return err
 	}
@@ -731,108 +875,19 @@ func (s *Service) initLatestBlockOfSyncBlock() error {
 	checkAndUpdateLatestBlockOfSyncBlock(latestDistributeWithdrawalHeight.Uint64())
 
 	// should greater network create block
-	if s.latestBlockOfSyncBlock < s.startAtBlock {
-		s.latestBlockOfSyncBlock = s.startAtBlock
-		s.waitFirstNodeStakeEvent = true
+	if s.latestBlockOfSyncBlock.Load() < s.startAtBlock {
+		s.latestBlockOfSyncBlock.Store(s.startAtBlock)
+		s.waitFirstNodeStakeEvent.Store(true)
 	}
 	// should be greater than local synced block height
-	if s.latestBlockOfSyncBlock < s.localSyncedBlockHeight {
-		s.latestBlockOfSyncBlock = s.localSyncedBlockHeight
-		s.waitFirstNodeStakeEvent = true
+	if s.latestBlockOfSyncBlock.Load() < s.localSyncedBlockHeight {
+		s.latestBlockOfSyncBlock.Store(s.localSyncedBlockHeight)
+		s.waitFirstNodeStakeEvent.Store(true)
 	}
 
 	return nil
 }
 
-func (s *Service) startGroupHandlers(sleepIntervalFn func() time.Duration, handlerFns ...func() error) {
-	if len(handlerFns) == 0 {
-		panic("handlers can not be empty")
-	}
-
-	handlers := make([]Handler, 0, len(handlerFns))
-	for _, handler := range handlerFns {
-
-		funcNameRaw := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
-
-		splits := strings.Split(funcNameRaw, "/")
-		funcName := splits[len(splits)-1]
-		funcName = strings.TrimPrefix(funcName, "service.(*Service).")
-		funcName = strings.TrimSuffix(funcName, "-fm")
-
-		handlers = append(handlers, Handler{
-			method: handler,
-			name:   funcName,
-		})
-	}
-
-	log := s.log
-	utils.SafeGo(func() {
-		retry := 0
-		var retryLog *logrus.Entry
-
-	Out:
-		for {
-			if retry > utils.RetryLimit {
-				if retryLog != nil {
-					retryLog.Errorf("shutting down for too many attempts failed, check your RPC status first")
-				}
-				utils.ShutdownRequestChannel <- struct{}{}
-				return
-			}
-
-			select {
-			case <-s.stop:
-				log.Info("service stopped")
-				return
-			default:
-
-				for _, handler := range handlers {
-					funcName := handler.name
-					log := log.WithField("handler", funcName)
-					log.Debugf("handler begin")
-
-					err := handler.method()
-					if err != nil {
-						if errors.Is(err, ErrHandlerExit) {
-							log.Error(err.Error())
-							utils.ShutdownRequestChannel <- struct{}{}
-							return
-						}
-
-						retryIn := sleepIntervalFn()
-						var gasErr *connection.GasPriceError
-						if errors.As(err, &gasErr) {
-							log.WithField("retry_in", retryIn).Error(gasErr.Error())
-							time.Sleep(retryIn)
-							continue Out
-						}
-
-						retry++
-						retryLog = log.WithFields(logrus.Fields{
-							"retry_times": retry,
-							"err":         err,
-						})
-						log := retryLog.WithField("retry_in", retryIn)
-						if retry < 50 {
-							log.Debugf("failed waiting retry")
-						} else {
-							log.Warnf("failed waiting retry")
-						}
-						time.Sleep(retryIn)
-						continue Out
-					}
-					log.Debugf("handler end")
-				}
-
-				retry = 0
-				retryLog = nil
-			}
-
-			time.Sleep(sleepIntervalFn())
-		}
-	})
-}
-
 func (s *Service) GetValidatorDepositedListBeforeBlock(block uint64) []*Validator {
 	selectedValidator := make([]*Validator, 0)
 	for _, v := range s.validators {
@@ -844,7 +899,10 @@ func (s *Service) GetValidatorDepositedListBeforeBlock(block uint64) []*Validato
 }
 
 func (s *Service) getBeaconBlock(eth1BlockNumber uint64) (*CachedBeaconBlock, error) {
-	block, exist := s.manager.cachedBeaconBlockByExecBlockHeight.Load(eth1BlockNumber)
+	block, exist, err := s.manager.GetBeaconBlockByExecBlockHeight(eth1BlockNumber)
+	if err != nil {
+		return nil, fmt.Errorf("getBeaconBlockByEth1BlockNumber %d error: %w", eth1BlockNumber, err)
+	}
 	if !exist {
 		return nil, fmt.Errorf("getBeaconBlockByEth1BlockNumber %d error: not in cache", eth1BlockNumber)
 	}
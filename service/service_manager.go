@@ -1,9 +1,15 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"math"
 	"math/big"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/avast/retry-go/v4"
@@ -12,11 +18,15 @@ import (
 	"github.com/samber/lo"
 	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
-	"github.com/stafiprotocol/chainbridge/utils/crypto/secp256k1"
 	lsd_network_factory "github.com/stafiprotocol/eth-lsd-relay/bindings/LsdNetworkFactory"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/block_store"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/config"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/leader"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/local_store"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/rpc_fixture"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/signer"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
 )
 
@@ -26,13 +36,22 @@ type ServiceManager struct {
 	connection *connection.CachedConnection
 	srvs       *xsync.MapOf[string, *Service]
 	localStore *local_store.LocalStore
+	blockStore *block_store.BlockStore
+	apiServer  *http.Server
+	// nil in dry run mode
+	voteAggregator *VoteAggregator
+	// nil if leader election is disabled
+	elector        *leader.Elector
+	stopElector    context.CancelFunc
+	electorStopped chan struct{}
+	started        atomic.Bool
 
 	cachedBeaconBlock                  *xsync.MapOf[uint64, *CachedBeaconBlock] // beacon block id: (uint64) => beaconblock: (*CachedBeaconBlock)
 	cachedBeaconBlockByExecBlockHeight *xsync.MapOf[uint64, *CachedBeaconBlock] // execution block height: (uint64) => beaconblock: (*CachedBeaconBlock)
 	beaconBlockMutex                   *utils.KeyedMutex[uint64]
 }
 
-func NewServiceManager(cfg *config.Config, keyPair *secp256k1.Keypair) (*ServiceManager, error) {
+func NewServiceManager(cfg *config.Config, voter signer.Signer) (*ServiceManager, error) {
 	if !common.IsHexAddress(cfg.Contracts.LsdFactoryAddress) {
 		return nil, fmt.Errorf("LsdFactoryAddress contract address fmt err")
 	}
@@ -55,11 +74,58 @@ func NewServiceManager(cfg *config.Config, keyPair *secp256k1.Keypair) (*Service
 	}
 	gasPriceMultiplier := new(big.Float).SetFloat64(cfg.GasPriceMultiplier)
 
-	conn, err := connection.NewConnection(cfg.Endpoints, keyPair,
-		gasLimitDeci.BigInt(), maxGasPriceDeci.BigInt(), gasPriceMultiplier)
+	var transport connection.Transport
+	if cfg.Fixtures.Mode != "" {
+		store, err := rpc_fixture.NewStore(cfg.Fixtures.Path, cfg.Fixtures.Mode)
+		if err != nil {
+			return nil, err
+		}
+		transport = func(name, endpoint string) http.RoundTripper {
+			return store.Transport(name, endpoint, nil)
+		}
+	}
+	conn, err := connection.NewConnectionWithTransport(cfg.Endpoints, voter,
+		gasLimitDeci.BigInt(), maxGasPriceDeci.BigInt(), gasPriceMultiplier, transport)
+	if err != nil {
+		return nil, err
+	}
+	if err = setFeePolicies(conn, cfg.FeeStrategies, gasPriceMultiplier); err != nil {
+		return nil, err
+	}
+	limits := make([]connection.EndpointLimits, len(cfg.Endpoints))
+	for i, e := range cfg.Endpoints {
+		limits[i] = connection.EndpointLimits{Weight: e.Weight, Eth1RateLimit: e.Eth1RateLimit, Eth2RateLimit: e.Eth2RateLimit}
+	}
+	err = conn.SetEndpointRouting(cfg.Routing.Policy, cfg.Routing.FailureThreshold,
+		time.Duration(cfg.Routing.CircuitOpen)*time.Second, limits)
 	if err != nil {
 		return nil, err
 	}
+	if cfg.BeaconQuorum.Enabled {
+		if err = conn.SetBeaconQuorum(cfg.BeaconQuorum.Endpoints, cfg.BeaconQuorum.Quorum); err != nil {
+			return nil, err
+		}
+	}
+	var elector *leader.Elector
+	if voter != nil && cfg.Leader.Enabled {
+		elector, err = newElector(cfg.Leader, conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if voter != nil {
+		txManagerCfg := connection.TxManagerConfig{
+			PendingTxPath:      cfg.PendingTxPath,
+			FeeBumpPercent:     cfg.FeeBumpPercent,
+			FeeBumpAfterBlocks: cfg.FeeBumpAfterBlocks,
+		}
+		if elector != nil {
+			txManagerCfg.IsLeader = elector.IsLeader
+		}
+		if err = conn.StartTxManager(txManagerCfg); err != nil {
+			return nil, err
+		}
+	}
 	cachedConn, err := connection.NewCachedConnection(conn)
 	if err != nil {
 		return nil, err
@@ -67,10 +133,21 @@ func NewServiceManager(cfg *config.Config, keyPair *secp256k1.Keypair) (*Service
 	if err = cachedConn.Start(); err != nil {
 		return nil, err
 	}
+	if cfg.SubscribeBeaconEvents {
+		cachedConn.StartBeaconEvents()
+	}
+	var voteAggregator *VoteAggregator
+	if voter != nil {
+		voteAggregator = NewVoteAggregator(cachedConn)
+	}
 	localStore, err := local_store.NewLocalStore(cfg.BlockstoreFilePath)
 	if err != nil {
 		return nil, err
 	}
+	blockStore, err := block_store.NewBlockStore(cfg.BeaconBlockStorePath)
+	if err != nil {
+		return nil, err
+	}
 
 	return &ServiceManager{
 		stop:                               make(chan struct{}),
@@ -81,16 +158,106 @@ func NewServiceManager(cfg *config.Config, keyPair *secp256k1.Keypair) (*Service
 		cachedBeaconBlockByExecBlockHeight: xsync.NewMapOf[uint64, *CachedBeaconBlock](),
 		beaconBlockMutex:                   &utils.KeyedMutex[uint64]{},
 		localStore:                         localStore,
+		blockStore:                         blockStore,
+		voteAggregator:                     voteAggregator,
+		elector:                            elector,
+		electorStopped:                     make(chan struct{}),
 	}, nil
 }
 
+// newElector campaigns for the voter account, the tx manager of conn takes over pending txs on election
+// and forgets them when the leadership is lost.
+func newElector(cfg config.LeaderElection, conn *connection.Connection) (*leader.Elector, error) {
+	backend, err := leader.NewFileLease(filepath.Join(cfg.SharedPath, "leader.lease"))
+	if err != nil {
+		return nil, err
+	}
+	instanceId := cfg.InstanceId
+	if instanceId == "" {
+		instanceId = leader.DefaultInstanceId()
+	}
+	onChange := func(isLeader bool) {
+		txManager := conn.TxManager()
+		if isLeader {
+			metrics.Leader.Set(1)
+			if txManager != nil {
+				if err := txManager.TakeOver(); err != nil {
+					logrus.Errorf("take over pending txs err: %s", err.Error())
+				}
+			}
+		} else {
+			metrics.Leader.Set(0)
+			if txManager != nil {
+				txManager.StepDown()
+			}
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"instance":   instanceId,
+		"sharedPath": cfg.SharedPath,
+	}).Info("leader election enabled")
+	return leader.NewElector(backend, instanceId,
+		time.Duration(cfg.LeaseTtl)*time.Second, time.Duration(cfg.RenewInterval)*time.Second, onChange)
+}
+
+// IsLeader reports whether this instance may send txs, always true if leader election is disabled.
+func (m *ServiceManager) IsLeader() bool {
+	return m.elector == nil || m.elector.IsLeader()
+}
+
+// setFeePolicies applies fee strategies of config, max gas prices are in Gwei.
+func setFeePolicies(conn *connection.Connection, strategies map[string]config.FeeStrategy, gasPriceMultiplier *big.Float) error {
+	proposalTypes := []string{connection.DefaultFeePolicy, metrics.ProposalSubmitBalances, metrics.ProposalDistributeWithdrawals,
+		metrics.ProposalDistributePriorityFee, metrics.ProposalSetMerkleRoot, metrics.ProposalNotifyValidatorExit,
+		metrics.ProposalVoteWithdrawCredentials}
+	for proposalType, strategyCfg := range strategies {
+		if !lo.Contains(proposalTypes, proposalType) {
+			return fmt.Errorf("fee strategy of unknown proposal type %s", proposalType)
+		}
+		strategy, err := connection.NewFeeStrategy(strategyCfg, gasPriceMultiplier)
+		if err != nil {
+			return fmt.Errorf("fee strategy of %s err: %w", proposalType, err)
+		}
+		maxGasPriceDeci, err := decimal.NewFromString(strategyCfg.MaxGasPrice)
+		if err != nil {
+			return fmt.Errorf("parse maxGasPrice of %s fee strategy error: %w", proposalType, err)
+		}
+		if err := conn.SetFeePolicy(proposalType, strategy, maxGasPriceDeci.Mul(utils.GweiDeci).BigInt()); err != nil {
+			return err
+		}
+		logrus.WithFields(logrus.Fields{
+			"proposalType": proposalType,
+			"strategy":     strategyCfg.Type,
+			"maxGasPrice":  strategyCfg.MaxGasPrice,
+		}).Info("fee strategy")
+	}
+	return nil
+}
+
 func (m *ServiceManager) Start() error {
+	// start api server first, so liveness can be probed during the long startup
+	m.startApiServer()
 	utils.SafeGoWithRestart(m.pruneCachedBeaconBlocksService)
+	if m.elector == nil && m.voteAggregator != nil {
+		metrics.Leader.Set(1)
+	}
+	if m.elector != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		m.stopElector = cancel
+		utils.SafeGo(func() {
+			defer close(m.electorStopped)
+			m.elector.Run(ctx)
+		})
+	}
+	if m.voteAggregator != nil {
+		m.voteAggregator.Start()
+	}
 
 	if !m.cfg.RunForEntrustedLsdNetwork {
 		if _, err := m.newAndStartServiceFor(m.cfg.Contracts.LsdTokenAddress); err != nil {
 			return err
 		}
+		m.started.Store(true)
 		return nil
 	}
 
@@ -101,17 +268,80 @@ func (m *ServiceManager) Start() error {
 	}
 
 	utils.SafeGo(m.startSyncService)
+	m.started.Store(true)
 
 	return nil
 }
 
-func (m *ServiceManager) Stop() {
+// Shutdown stops all services and the vote aggregator, giving running handlers and in-flight vote txs
+// until timeout to finish, then logs what was left behind. Pending vote txs are resumed by the next run.
+func (m *ServiceManager) Shutdown(timeout time.Duration) {
+	log := logrus.WithFields(logrus.Fields{
+		"reason":  utils.ShutdownReason(),
+		"timeout": timeout.String(),
+	})
+	log.Info("shutting down")
 	close(m.stop)
-	m.srvs.Range(func(key string, value *Service) bool {
-		value.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// services first, so no more votes are added while the aggregator drains
+	reports := make([]ServiceShutdown, 0)
+	reportsMutex := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	m.srvs.Range(func(_ string, srv *Service) bool {
+		wg.Add(1)
+		go func(srv *Service) {
+			defer wg.Done()
+			report := srv.Shutdown(ctx)
+			reportsMutex.Lock()
+			reports = append(reports, report)
+			reportsMutex.Unlock()
+		}(srv)
 		return true
 	})
+	wg.Wait()
+
+	for _, report := range reports {
+		srvLog := log.WithField("lsdToken", report.LsdToken)
+		switch {
+		case len(report.InterruptedHandlers) > 0:
+			srvLog.WithField("handlers", report.InterruptedHandlers).Warn("handlers interrupted by shutdown deadline, checkpoint not saved")
+		case report.CheckpointError != "":
+			srvLog.Warnf("save checkpoint err: %s", report.CheckpointError)
+		case report.CheckpointSaved:
+			srvLog.Info("checkpoint saved")
+		}
+	}
+
+	if m.voteAggregator != nil {
+		voteReport := m.voteAggregator.Shutdown(ctx)
+		log.WithFields(logrus.Fields{
+			"flushedVotes": voteReport.FlushedVotes,
+			"droppedVotes": voteReport.DroppedVotes,
+			"pendingTxs":   len(voteReport.PendingTxs),
+		}).Info("vote aggregator stopped")
+		for _, tx := range voteReport.PendingTxs {
+			log.WithFields(logrus.Fields{
+				"nonce":  tx.Nonce,
+				"txHash": tx.Hashes[len(tx.Hashes)-1].String(),
+			}).Warn("vote tx still pending, it will be resumed on next start")
+		}
+	}
+
+	// the lease is released after in-flight votes are drained, so the next leader takes over at once
+	if m.stopElector != nil {
+		m.stopElector()
+		<-m.electorStopped
+	}
+
+	m.stopApiServer()
 	m.connection.Stop()
+	if err := m.blockStore.Close(); err != nil {
+		log.Warnf("close block store err: %s", err.Error())
+	}
+	log.Info("shut down")
 }
 
 func (m *ServiceManager) startSyncService() {
@@ -122,7 +352,7 @@ func (m *ServiceManager) startSyncService() {
 Out:
 	for {
 		if retry > utils.RetryLimit {
-			utils.ShutdownRequestChannel <- struct{}{}
+			utils.RequestShutdown("sync entrusted lsd tokens failed too many times")
 			return
 		}
 
@@ -135,7 +365,7 @@ Out:
 			err := m.syncEntrustedLsdTokens()
 			if err != nil {
 				logrus.Errorf("fail to sync entrusted token: %s", utils.ErrToLogStr(err))
-				time.Sleep(utils.RetryInterval * 4)
+				utils.Sleep(m.stop, utils.RetryInterval*4)
 				retry++
 				continue Out
 			}
@@ -143,7 +373,7 @@ Out:
 			retry = 0
 		}
 
-		time.Sleep(12 * time.Second)
+		utils.Sleep(m.stop, 12*time.Second)
 	}
 }
 
@@ -205,23 +435,47 @@ func (m *ServiceManager) newAndStartServiceFor(lsdToken string) (*Service, error
 
 var notExistBeaconBlock = &CachedBeaconBlock{}
 
-func (m *ServiceManager) CacheBeaconBlock(blockId uint64) (*CachedBeaconBlock, bool, error) {
+func (m *ServiceManager) CacheBeaconBlock(ctx context.Context, blockId uint64) (*CachedBeaconBlock, bool, error) {
 	unlock := m.beaconBlockMutex.Lock(blockId)
 	defer unlock()
 
-	if block, ok := m.cachedBeaconBlock.Load(blockId); ok {
-		if block == notExistBeaconBlock {
+	cached, ok := m.cachedBeaconBlock.Load(blockId)
+	metrics.ObserveCache(metrics.CacheBeaconBlock, ok)
+	if ok {
+		if cached == notExistBeaconBlock {
 			return nil, false, nil
 		}
 
-		return block, true, nil
+		return cached, true, nil
 	}
 
-	block, exist, err := m.connection.GetBeaconBlock(blockId)
+	// read through the persisted block store before requesting beacon node
+	data, exist, found, err := m.blockStore.Get(blockId)
+	if err != nil {
+		return nil, false, err
+	}
+	if found {
+		if !exist {
+			m.cachedBeaconBlock.Store(blockId, notExistBeaconBlock)
+			return nil, false, nil
+		}
+		cachedBlock := CachedBeaconBlock{}
+		if err := json.Unmarshal(data, &cachedBlock); err != nil {
+			return nil, false, fmt.Errorf("decode stored beacon block %d err: %w", blockId, err)
+		}
+		m.cachedBeaconBlockByExecBlockHeight.Store(cachedBlock.ExecutionBlockNumber, &cachedBlock)
+		m.cachedBeaconBlock.Store(blockId, &cachedBlock)
+		return &cachedBlock, true, nil
+	}
+
+	block, exist, err := m.connection.GetBeaconBlock(ctx, blockId)
 	if err != nil {
 		return nil, false, err
 	}
 	if !exist {
+		if err := m.blockStore.PutMissing(blockId); err != nil {
+			return nil, false, err
+		}
 		m.cachedBeaconBlock.Store(blockId, notExistBeaconBlock)
 		return nil, false, nil
 	}
@@ -239,6 +493,14 @@ func (m *ServiceManager) CacheBeaconBlock(blockId uint64) (*CachedBeaconBlock, b
 		})
 	}
 
+	data, err = json.Marshal(&cachedBlock)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := m.blockStore.Put(blockId, block.ExecutionBlockNumber, data); err != nil {
+		return nil, false, err
+	}
+
 	m.cachedBeaconBlockByExecBlockHeight.Store(block.ExecutionBlockNumber, &cachedBlock)
 	m.cachedBeaconBlock.Store(blockId, &cachedBlock)
 
@@ -248,10 +510,34 @@ func (m *ServiceManager) CacheBeaconBlock(blockId uint64) (*CachedBeaconBlock, b
 	return &cachedBlock, true, nil
 }
 
+// GetBeaconBlockByExecBlockHeight returns the cached block of execution block height,
+// loading it from the persisted block store when it is not in memory.
+func (m *ServiceManager) GetBeaconBlockByExecBlockHeight(eth1BlockNumber uint64) (*CachedBeaconBlock, bool, error) {
+	if block, ok := m.cachedBeaconBlockByExecBlockHeight.Load(eth1BlockNumber); ok {
+		return block, true, nil
+	}
+
+	data, found, err := m.blockStore.GetByExecBlockHeight(eth1BlockNumber)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	cachedBlock := CachedBeaconBlock{}
+	if err := json.Unmarshal(data, &cachedBlock); err != nil {
+		return nil, false, fmt.Errorf("decode stored beacon block of eth1 block %d err: %w", eth1BlockNumber, err)
+	}
+	m.cachedBeaconBlockByExecBlockHeight.Store(eth1BlockNumber, &cachedBlock)
+	return &cachedBlock, true, nil
+}
+
 func (m *ServiceManager) pruneCachedBeaconBlocksService() {
 	for {
 		m.pruneCachedBeaconBlocks()
-		time.Sleep(time.Minute)
+		metrics.CacheSize.WithLabelValues(metrics.CacheBeaconBlock).Set(float64(m.cachedBeaconBlock.Size()))
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(time.Minute):
+		}
 	}
 }
 
@@ -260,7 +546,7 @@ func (m *ServiceManager) pruneCachedBeaconBlocks() {
 	var minHeightSrv *Service
 	m.srvs.Range(func(key string, srv *Service) bool {
 		if srv != nil &&
-			!srv.waitFirstNodeStakeEvent &&
+			!srv.waitFirstNodeStakeEvent.Load() &&
 			srv.minExecutionBlockHeight > 0 &&
 			srv.minExecutionBlockHeight < minHeight {
 			minHeightSrv = srv
@@ -299,14 +585,22 @@ func (m *ServiceManager) pruneCachedBeaconBlocks() {
 		}
 		return true
 	})
+
+	eth1RemoveStoreCount, eth2RemoveStoreCount, err := m.blockStore.Prune(minHeight)
+	if err != nil {
+		logrus.Warnf("prune block store err: %s", err.Error())
+	}
+
 	log := logrus.WithFields(logrus.Fields{
 		"eth1MinHeight":        minHeight,
 		"eth1RemoveCacheCount": eth1RemoveCacheCount,
 		"eth2MinHeight":        maxClearableBeaconBlockId,
 		"eth2RemoveCacheCount": eth2RemoveCacheCount,
+		"eth1RemoveStoreCount": eth1RemoveStoreCount,
+		"eth2RemoveStoreCount": eth2RemoveStoreCount,
 		"minHeightLsd":         minHeightSrv.lsdTokenAddress.String(),
 	})
-	if eth1RemoveCacheCount == 0 && eth2RemoveCacheCount == 0 {
+	if eth1RemoveCacheCount == 0 && eth2RemoveCacheCount == 0 && eth1RemoveStoreCount == 0 && eth2RemoveStoreCount == 0 {
 		log.Trace("prune cache blocks")
 	} else {
 		log.Info("prune cache blocks")
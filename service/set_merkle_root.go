@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/big"
@@ -11,6 +12,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
 )
 
@@ -37,8 +39,8 @@ type NodeNewReward struct {
 }
 
 // ensure withdraw and fee already distribute on target epoch
-func (s *Service) setMerkleRoot() error {
-	dealtEpochOnchain, targetEpoch, targetEth1BlockHeight, shouldGoNext, err := s.checkStateForSetMerkleRoot()
+func (s *Service) setMerkleRoot(ctx context.Context) error {
+	dealtEpochOnchain, targetEpoch, targetEth1BlockHeight, shouldGoNext, err := s.checkStateForSetMerkleRoot(ctx)
 	if err != nil {
 		return errors.Wrap(err, "setMerkleRoot checkSyncState failed")
 	}
@@ -53,7 +55,12 @@ func (s *Service) setMerkleRoot() error {
 		// init case
 		dealtEth1BlockHeight = s.startAtBlock
 	} else {
-		preCid, err := s.networkWithdrawContract.NodeRewardsFileCid(nil)
+		// the same block as dealtEpochOnchain, the cid is of the rewards file of that epoch
+		readOpts, err := s.readOpts(ctx, 0)
+		if err != nil {
+			return err
+		}
+		preCid, err := s.networkWithdrawContract.NodeRewardsFileCid(readOpts)
 		if err != nil {
 			return err
 		}
@@ -78,7 +85,7 @@ func (s *Service) setMerkleRoot() error {
 			return fmt.Errorf("pre node reward file epoch does not match, cid: %s", preCid)
 		}
 
-		dealtEth1BlockHeight, err = s.getEpochStartBlocknumberWithCheck(dealtEpochOnchain)
+		dealtEth1BlockHeight, err = s.getEpochStartBlocknumberWithCheck(ctx, dealtEpochOnchain)
 		if err != nil {
 			return err
 		}
@@ -95,10 +102,17 @@ func (s *Service) setMerkleRoot() error {
 		preNodeRewardMap[address] = nodeReward
 	}
 
-	newNodeRewardsMap, err := s.getNodeNewRewardsBetween(dealtEth1BlockHeight, targetEth1BlockHeight)
+	report := s.newRewardReport()
+	newNodeRewardsMap, err := s.getNodeNewRewardsBetween(ctx, dealtEth1BlockHeight, targetEth1BlockHeight, report)
 	if err != nil {
 		return err
 	}
+	s.saveRewardReport(report, RewardReport{
+		ProposalType: metrics.ProposalSetMerkleRoot,
+		FromBlock:    dealtEth1BlockHeight,
+		ToBlock:      targetEth1BlockHeight,
+		Epoch:        targetEpoch,
+	}, targetEpoch)
 
 	// cal finalNodeRewardsMap
 	finalNodeRewardsMap := make(NodeRewardsMap, 0)
@@ -211,7 +225,7 @@ func (s *Service) setMerkleRoot() error {
 	var merkleTreeRootHash [32]byte
 	copy(merkleTreeRootHash[:], rootHash)
 
-	return s.sendSetMerkleRootTx(int64(targetEpoch), merkleTreeRootHash, cid)
+	return s.sendSetMerkleRootTx(ctx, int64(targetEpoch), merkleTreeRootHash, cid)
 }
 
 func buildMerkleTree(nodelist NodeRewardsList) (*utils.MerkleTree, error) {
@@ -229,77 +243,62 @@ func buildMerkleTree(nodelist NodeRewardsList) (*utils.MerkleTree, error) {
 
 // check sync and vote state
 // return (dealtEpoch,targetEpoch, targetEth1Blocknumber, shouldGoNext, err)
-func (s *Service) checkStateForSetMerkleRoot() (uint64, uint64, uint64, bool, error) {
-	beaconHead, err := s.connection.BeaconHead()
+func (s *Service) checkStateForSetMerkleRoot(ctx context.Context) (uint64, uint64, uint64, bool, error) {
+	beaconHead, err := s.beaconHead()
 	if err != nil {
 		return 0, 0, 0, false, err
 	}
 
 	targetEpoch := (beaconHead.FinalizedEpoch / s.merkleRootDuEpochs) * s.merkleRootDuEpochs
 
-	dealtEpochOnchain := s.latestMerkleRootEpoch
+	readOpts, err := s.readOpts(ctx, 0)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	latestMerkleRootEpoch, err := s.networkWithdrawContract.LatestMerkleRootEpoch(readOpts)
 	if err != nil {
 		return 0, 0, 0, false, err
 	}
+	dealtEpochOnchain := latestMerkleRootEpoch.Uint64()
 	if targetEpoch <= dealtEpochOnchain {
 		s.log.Debugf("targetEpoch: %d  dealtEpochOnchain: %d", targetEpoch, dealtEpochOnchain)
 		return 0, 0, 0, false, nil
 	}
 
-	targetEth1BlockHeight, err := s.getEpochStartBlocknumberWithCheck(targetEpoch)
+	targetEth1BlockHeight, err := s.getEpochStartBlocknumberWithCheck(ctx, targetEpoch)
 	if err != nil {
 		return 0, 0, 0, false, err
 	}
 
 	s.log.WithFields(logrus.Fields{
 		"targetEth1BlockHeight":  targetEth1BlockHeight,
-		"latestBlockOfSyncBlock": s.latestBlockOfSyncBlock,
+		"latestBlockOfSyncBlock": s.latestBlockOfSyncBlock.Load(),
 		"dealtEpochOnchain":      dealtEpochOnchain,
 		"targetEpoch":            targetEpoch,
 	}).Debug("setMerkleRoot")
 
 	// wait sync block
-	if targetEth1BlockHeight > s.latestBlockOfSyncBlock {
-		s.log.Debugf("targetEth1BlockHeight: %d  latestBlockOfSyncBlock: %d", targetEth1BlockHeight, s.latestBlockOfSyncBlock)
+	if targetEth1BlockHeight > s.latestBlockOfSyncBlock.Load() {
+		s.log.Debugf("targetEth1BlockHeight: %d  latestBlockOfSyncBlock: %d", targetEth1BlockHeight, s.latestBlockOfSyncBlock.Load())
 		return 0, 0, 0, false, nil
 	}
 
 	return dealtEpochOnchain, targetEpoch, targetEth1BlockHeight, true, nil
 }
 
-func (s *Service) sendSetMerkleRootTx(targetEpoch int64, rootHash [32]byte, cid string) error {
-	err := s.connection.LockAndUpdateTxOpts()
-	if err != nil {
-		return fmt.Errorf("LockAndUpdateTxOpts err: %w", err)
-	}
-	defer s.connection.UnlockTxOpts()
-
+func (s *Service) sendSetMerkleRootTx(ctx context.Context, targetEpoch int64, rootHash [32]byte, cid string) error {
 	encodeBts, err := s.networkWithdrawAbi.Pack("setMerkleRoot", big.NewInt(targetEpoch), rootHash, cid)
 	if err != nil {
 		return err
 	}
-
-	proposalId := utils.ProposalId(s.networkWithdrawAddress, encodeBts, big.NewInt(targetEpoch))
-
-	// check voted
-	hasVoted, err := s.networkProposalContract.HasVoted(nil, proposalId, s.connection.Keypair().CommonAddress())
-	if err != nil {
-		return fmt.Errorf("networkProposalContract.HasVoted err: %s", err)
-	}
-	if hasVoted {
-		return nil
+	s.trackOwnProposal(metrics.ProposalSetMerkleRoot, s.networkWithdrawAddress, encodeBts, big.NewInt(targetEpoch))
+	if s.dryRun {
+		return s.dryRunProposal(metrics.ProposalSetMerkleRoot, s.networkWithdrawAddress, encodeBts, big.NewInt(targetEpoch))
 	}
 
 	s.log.WithFields(logrus.Fields{
 		"cid": cid,
 	}).Info("will sendSetMerkleRootTx")
 
-	tx, err := s.networkProposalContract.ExecProposal(s.connection.TxOpts(), s.networkWithdrawAddress, encodeBts, big.NewInt(int64(targetEpoch)))
-	if err != nil {
-		return err
-	}
-
-	s.log.Info("send setMerkleRoot tx hash: ", tx.Hash().String())
-
-	return s.waitProposalTxOk(tx.Hash(), proposalId)
+	return s.voteProposal(ctx, metrics.ProposalSetMerkleRoot, s.networkWithdrawAddress, encodeBts, big.NewInt(targetEpoch))
 }
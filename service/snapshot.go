@@ -0,0 +1,293 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/signer"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+)
+
+const (
+	stateSnapshotVersion  = 2
+	stateSnapshotInterval = 30 * time.Minute
+)
+
+// stateSnapshot is the validator/node state of a service at Block, with the
+// event state synced to LatestBlockOfSyncEvents.
+type stateSnapshot struct {
+	Version                      uint64
+	LsdToken                     string
+	Block                        uint64 // latestBlockOfUpdateValidator
+	LatestBlockOfSyncEvents      uint64
+	LatestEpochOfUpdateValidator uint64
+	CreatedAt                    int64
+
+	Validators        []*Validator
+	Nodes             []*Node
+	GovDeposits       map[string][]string // pubkey(hex) -> withdrawalCredentials(hex)
+	StakerWithdrawals []*StakerWithdrawal
+	ExitElections     []*ExitElection
+}
+
+type signedStateSnapshot struct {
+	Snapshot  json.RawMessage
+	Signer    string
+	Signature string // hex of EIP-191 personal signature on keccak256(Snapshot)
+}
+
+func (s *Service) snapshotFilePath() string {
+	return filepath.Join(s.snapshotPath, s.lsdTokenAddress.String()+".json")
+}
+
+// saveSnapshot periodically writes a signed snapshot of validators, nodes and event state.
+func (s *Service) saveSnapshot(ctx context.Context) error {
+	if time.Since(s.lastSnapshotAt) < stateSnapshotInterval {
+		return nil
+	}
+	voter := s.connection.Signer()
+	if voter == nil || s.latestBlockOfUpdateValidator.Load() <= s.startAtBlock {
+		return nil
+	}
+
+	snap := stateSnapshot{
+		Version:                      stateSnapshotVersion,
+		LsdToken:                     s.lsdTokenAddress.String(),
+		Block:                        s.latestBlockOfUpdateValidator.Load(),
+		LatestBlockOfSyncEvents:      s.latestBlockOfSyncEvents.Load(),
+		LatestEpochOfUpdateValidator: s.latestEpochOfUpdateValidator.Load(),
+		CreatedAt:                    time.Now().Unix(),
+		Validators:                   make([]*Validator, 0, len(s.validators)),
+		Nodes:                        make([]*Node, 0, len(s.nodes)),
+		GovDeposits:                  make(map[string][]string, len(s.govDeposits)),
+		StakerWithdrawals:            make([]*StakerWithdrawal, 0, len(s.stakerWithdrawals)),
+		ExitElections:                make([]*ExitElection, 0, len(s.exitElections)),
+	}
+	// copies are marshalled, validators are updated from beacon under voteLock
+	s.validatorsByIndexMutex.RLock()
+	for _, val := range s.validators {
+		val := *val
+		snap.Validators = append(snap.Validators, &val)
+	}
+	s.validatorsByIndexMutex.RUnlock()
+	for _, node := range s.nodes {
+		node := *node
+		snap.Nodes = append(snap.Nodes, &node)
+	}
+	for pubkey, credentials := range s.govDeposits {
+		for _, c := range credentials {
+			snap.GovDeposits[pubkey] = append(snap.GovDeposits[pubkey], hex.EncodeToString(c))
+		}
+	}
+	for _, w := range s.stakerWithdrawals {
+		w := *w
+		snap.StakerWithdrawals = append(snap.StakerWithdrawals, &w)
+	}
+	for _, e := range s.exitElections {
+		e := *e
+		snap.ExitElections = append(snap.ExitElections, &e)
+	}
+
+	signedBts, err := signSnapshot(voter, &snap)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(s.snapshotPath, 0700); err != nil {
+		return err
+	}
+	if err = utils.WriteFileAtomic(s.snapshotFilePath(), signedBts, 0600); err != nil {
+		return fmt.Errorf("write snapshot err: %w", err)
+	}
+	s.lastSnapshotAt = time.Now()
+
+	s.log.WithFields(logrus.Fields{
+		"block":      snap.Block,
+		"validators": len(snap.Validators),
+		"nodes":      len(snap.Nodes),
+	}).Info("saved state snapshot")
+
+	return nil
+}
+
+// signSnapshot returns snap signed by voter.
+func signSnapshot(voter signer.Signer, snap *stateSnapshot) ([]byte, error) {
+	snapBts, err := json.Marshal(snap)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := voter.SignText(ethcrypto.Keccak256(snapBts))
+	if err != nil {
+		return nil, fmt.Errorf("sign snapshot err: %w", err)
+	}
+	return json.Marshal(&signedStateSnapshot{
+		Snapshot:  snapBts,
+		Signer:    voter.Address().String(),
+		Signature: hex.EncodeToString(sig),
+	})
+}
+
+// loadSnapshot reads and verifies the snapshot file, return nil if it does not exist.
+func (s *Service) loadSnapshot() (*stateSnapshot, error) {
+	bts, err := os.ReadFile(s.snapshotFilePath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	voter := s.connection.Signer()
+	if voter == nil {
+		return nil, fmt.Errorf("no voter account to verify snapshot")
+	}
+	return verifySnapshot(bts, voter.Address(), s.lsdTokenAddress)
+}
+
+// verifySnapshot decodes a signed snapshot of lsdToken and checks it is signed by voter.
+func verifySnapshot(bts []byte, voter, lsdToken common.Address) (*stateSnapshot, error) {
+	signed := signedStateSnapshot{}
+	if err := json.Unmarshal(bts, &signed); err != nil {
+		return nil, fmt.Errorf("decode signed snapshot err: %w", err)
+	}
+	sig, err := hex.DecodeString(signed.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("decode snapshot signature err: %w", err)
+	}
+	snapSigner, err := signer.RecoverText(ethcrypto.Keccak256(signed.Snapshot), sig)
+	if err != nil {
+		return nil, fmt.Errorf("recover snapshot signer err: %w", err)
+	}
+	if snapSigner != voter {
+		return nil, fmt.Errorf("snapshot is not signed by voter account, signer: %s", snapSigner)
+	}
+
+	snap := stateSnapshot{}
+	if err = json.Unmarshal(signed.Snapshot, &snap); err != nil {
+		return nil, fmt.Errorf("decode snapshot err: %w", err)
+	}
+	if snap.Version != stateSnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	if !common.IsHexAddress(snap.LsdToken) || common.HexToAddress(snap.LsdToken) != lsdToken {
+		return nil, fmt.Errorf("snapshot lsd token %s not match", snap.LsdToken)
+	}
+	return &snap, nil
+}
+
+// checkSnapshot compares the snapshot nodes and pubkeys with NodeDeposit at snapshot block.
+func (s *Service) checkSnapshot(snap *stateSnapshot) error {
+	opts := s.connection.CallOpts(big.NewInt(int64(snap.Block)))
+	nodesLength, err := s.nodeDepositContract.GetNodesLength(opts)
+	if err != nil {
+		return err
+	}
+	if nodesLength.Uint64() != uint64(len(snap.Nodes)) {
+		return fmt.Errorf("nodes length not match, snapshot: %d, chain: %d", len(snap.Nodes), nodesLength.Uint64())
+	}
+
+	pubkeysOfNode := make(map[common.Address]uint64, len(snap.Nodes))
+	for _, val := range snap.Validators {
+		pubkeysOfNode[val.NodeAddress]++
+	}
+	for _, node := range snap.Nodes {
+		pubkeys, err := s.nodeDepositContract.GetPubkeysOfNode(opts, node.NodeAddress)
+		if err != nil {
+			return err
+		}
+		if uint64(len(pubkeys)) != node.PubkeyNumber || pubkeysOfNode[node.NodeAddress] != node.PubkeyNumber {
+			return fmt.Errorf("pubkeys of node %s not match, snapshot: %d, validators: %d, chain: %d",
+				node.NodeAddress, node.PubkeyNumber, pubkeysOfNode[node.NodeAddress], len(pubkeys))
+		}
+	}
+	return nil
+}
+
+// restoreSnapshot loads the snapshot of a previous run, so startup only replays
+// validators and events after the snapshot block. Balances and statuses on beacon
+// are the ones of the snapshot epoch, the checkpoint resumes updating them from it.
+func (s *Service) restoreSnapshot() error {
+	if s.waitFirstNodeStakeEvent.Load() {
+		return nil
+	}
+	snap, err := s.loadSnapshot()
+	if err != nil {
+		s.log.WithError(err).Warn("skip invalid state snapshot")
+		return nil
+	}
+	if snap == nil || snap.Block <= s.latestBlockOfUpdateValidator.Load() {
+		return nil
+	}
+	if err = s.checkSnapshot(snap); err != nil {
+		s.log.WithError(err).Warn("skip inconsistent state snapshot")
+		return nil
+	}
+	return s.applySnapshot(snap)
+}
+
+// applySnapshot replaces the validator/node and event state with the ones of snap.
+func (s *Service) applySnapshot(snap *stateSnapshot) error {
+
+	govDeposits := make(map[string][][]byte, len(snap.GovDeposits))
+	for pubkey, credentials := range snap.GovDeposits {
+		for _, c := range credentials {
+			bts, err := hex.DecodeString(c)
+			if err != nil {
+				return fmt.Errorf("snapshot gov deposit of %s err: %w", pubkey, err)
+			}
+			govDeposits[pubkey] = append(govDeposits[pubkey], bts)
+		}
+	}
+
+	validators := make(map[string]*Validator, len(snap.Validators))
+	validatorsByIndex := make(map[uint64]*Validator, len(snap.Validators))
+	for _, val := range snap.Validators {
+		validators[hex.EncodeToString(val.Pubkey)] = val
+		if val.ValidatorIndex > 0 {
+			validatorsByIndex[val.ValidatorIndex] = val
+		}
+	}
+	nodes := make(map[common.Address]*Node, len(snap.Nodes))
+	for _, node := range snap.Nodes {
+		nodes[node.NodeAddress] = node
+	}
+	stakerWithdrawals := make(map[uint64]*StakerWithdrawal, len(snap.StakerWithdrawals))
+	for _, w := range snap.StakerWithdrawals {
+		stakerWithdrawals[w.WithdrawIndex] = w
+	}
+	exitElections := make(map[uint64]*ExitElection, len(snap.ExitElections))
+	for _, e := range snap.ExitElections {
+		exitElections[e.WithdrawCycle] = e
+	}
+
+	s.validators = validators
+	s.validatorsByIndexMutex.Lock()
+	s.validatorsByIndex = validatorsByIndex
+	s.validatorsByIndexMutex.Unlock()
+	s.nodes = nodes
+	s.govDeposits = govDeposits
+	s.stakerWithdrawals = stakerWithdrawals
+	s.exitElections = exitElections
+	s.latestBlockOfUpdateValidator.Store(snap.Block)
+	s.latestBlockOfSyncEvents.Store(snap.LatestBlockOfSyncEvents)
+	s.snapshotEpochOfUpdateValidator = snap.LatestEpochOfUpdateValidator
+	s.lastSnapshotAt = time.Unix(snap.CreatedAt, 0)
+
+	s.log.WithFields(logrus.Fields{
+		"block":                   snap.Block,
+		"latestBlockOfSyncEvents": snap.LatestBlockOfSyncEvents,
+		"validators":              len(validators),
+		"nodes":                   len(nodes),
+	}).Info("restored state snapshot")
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/shopspring/decimal"
+	node_deposit "github.com/stafiprotocol/eth-lsd-relay/bindings/NodeDeposit"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/signer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	key, err := ethcrypto.GenerateKey()
+	require.NoError(t, err)
+	voter := signer.NewLocalSigner(key)
+	lsdToken := common.HexToAddress("0x3000000000000000000000000000000000000001")
+	node := common.HexToAddress("0x3000000000000000000000000000000000000002")
+	snap := &stateSnapshot{
+		Version:                      stateSnapshotVersion,
+		LsdToken:                     lsdToken.String(),
+		Block:                        200,
+		LatestBlockOfSyncEvents:      210,
+		LatestEpochOfUpdateValidator: 7,
+		Validators: []*Validator{
+			{Pubkey: []byte{0xaa}, NodeAddress: node, NodeDepositAmountDeci: decimal.NewFromInt(1e18), ValidatorIndex: 5, Balance: 32e9, Status: 6},
+			{Pubkey: []byte{0xbb}, NodeAddress: node, NodeDepositAmountDeci: decimal.NewFromInt(1e18), Status: 2},
+		},
+		Nodes:             []*Node{{NodeAddress: node, NodeType: 1, PubkeyNumber: 2}},
+		GovDeposits:       map[string][]string{hex.EncodeToString([]byte{0xaa}): {hex.EncodeToString([]byte{0x01})}},
+		StakerWithdrawals: []*StakerWithdrawal{{WithdrawIndex: 3, Address: node, EthAmount: decimal.NewFromInt(1e18), BlockNumber: 180}},
+		ExitElections:     []*ExitElection{{WithdrawCycle: 5, ValidatorIndexList: []uint64{5}}},
+	}
+
+	// sign and verify
+	bts, err := signSnapshot(voter, snap)
+	require.NoError(t, err)
+	verified, err := verifySnapshot(bts, voter.Address(), lsdToken)
+	require.NoError(t, err)
+	assert.Equal(t, snap.Block, verified.Block)
+	assert.Equal(t, snap.Validators, verified.Validators)
+	assert.Equal(t, snap.GovDeposits, verified.GovDeposits)
+
+	_, err = verifySnapshot(bts, common.Address{1}, lsdToken)
+	assert.ErrorContains(t, err, "not signed by voter account")
+	_, err = verifySnapshot(bts, voter.Address(), common.Address{1})
+	assert.ErrorContains(t, err, "lsd token")
+
+	// a tampered snapshot is not signed by the voter
+	signed := signedStateSnapshot{}
+	require.NoError(t, json.Unmarshal(bts, &signed))
+	signed.Snapshot = json.RawMessage(strings.Replace(string(signed.Snapshot), `"Block":200`, `"Block":201`, 1))
+	tampered, err := json.Marshal(&signed)
+	require.NoError(t, err)
+	_, err = verifySnapshot(tampered, voter.Address(), lsdToken)
+	assert.ErrorContains(t, err, "not signed by voter account")
+
+	// the snapshot is checked against NodeDeposit at its block
+	backend := newFakeBackend(300)
+	s := newFakeBackendService(t, backend)
+	s.lsdTokenAddress = lsdToken
+	nodeDepositAddress := common.HexToAddress("0x1000000000000000000000000000000000000005")
+	s.nodeDepositContract, err = node_deposit.NewCustomNodeDeposit(nodeDepositAddress, backend, s.connection.MultiCaller())
+	require.NoError(t, err)
+	nodeDepositAbi, err := abi.JSON(strings.NewReader(node_deposit.NodeDepositABI))
+	require.NoError(t, err)
+	pubkeys := [][]byte{{0xaa}, {0xbb}}
+	backend.handleCall(nodeDepositAddress, nodeDepositAbi, "getNodesLength", func([]interface{}) []interface{} {
+		return []interface{}{big.NewInt(1)}
+	})
+	backend.handleCall(nodeDepositAddress, nodeDepositAbi, "getPubkeysOfNode", func([]interface{}) []interface{} {
+		return []interface{}{pubkeys}
+	})
+	require.NoError(t, s.checkSnapshot(verified))
+	pubkeys = append(pubkeys, []byte{0xcc})
+	assert.ErrorContains(t, s.checkSnapshot(verified), "pubkeys of node")
+
+	// restore
+	require.NoError(t, s.applySnapshot(verified))
+	assert.Equal(t, uint64(200), s.latestBlockOfUpdateValidator.Load())
+	assert.Equal(t, uint64(210), s.latestBlockOfSyncEvents.Load())
+	assert.Equal(t, uint64(7), s.snapshotEpochOfUpdateValidator)
+	assert.Len(t, s.validators, 2)
+	val, ok := s.getValidatorByIndex(5)
+	require.True(t, ok)
+	assert.Equal(t, []byte{0xaa}, val.Pubkey)
+	assert.Equal(t, uint64(2), s.nodes[node].PubkeyNumber)
+	assert.Equal(t, [][]byte{{0x01}}, s.govDeposits[hex.EncodeToString([]byte{0xaa})])
+	assert.Equal(t, uint64(180), s.stakerWithdrawals[3].BlockNumber)
+	assert.Equal(t, []uint64{5}, s.exitElections[5].ValidatorIndexList)
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+)
+
+// HandlerStatus is the latest run of a scheduled handler.
+type HandlerStatus struct {
+	Name                string    `json:"name"`
+	Runs                uint64    `json:"runs"`
+	Failures            uint64    `json:"failures"`
+	ConsecutiveFailures uint64    `json:"consecutiveFailures"`
+	WaitingFor          string    `json:"waitingFor,omitempty"` // unmet dependency
+	LastRunAt           time.Time `json:"lastRunAt"`
+	LastSuccessAt       time.Time `json:"lastSuccessAt"`
+	LastErrorAt         time.Time `json:"lastErrorAt"`
+	LastError           string    `json:"lastError,omitempty"`
+}
+
+// ServiceStatus is the sync progress of a lsd token service.
+type ServiceStatus struct {
+	LsdToken                     string                  `json:"lsdToken"`
+	HandlersStarted              bool                    `json:"handlersStarted"`
+	WaitFirstNodeStakeEvent      bool                    `json:"waitFirstNodeStakeEvent"`
+	LatestBlockOfSyncBlock       uint64                  `json:"latestBlockOfSyncBlock"`
+	LatestSlotOfSyncBlock        uint64                  `json:"latestSlotOfSyncBlock"`
+	LatestBlockOfSyncEvents      uint64                  `json:"latestBlockOfSyncEvents"`
+	LatestBlockOfUpdateValidator uint64                  `json:"latestBlockOfUpdateValidator"`
+	LatestEpochOfUpdateValidator uint64                  `json:"latestEpochOfUpdateValidator"`
+	Handlers                     []HandlerStatus         `json:"handlers"`
+	DryRunResults                []DryRunResult          `json:"dryRunResults,omitempty"`
+	Divergences                  []ProposalDivergence    `json:"divergences,omitempty"`
+	PriorityFeeComparisons       []PriorityFeeComparison `json:"priorityFeeComparisons,omitempty"`
+}
+
+func (s *Service) recordHandlerRun(name string, duration time.Duration, err error) {
+	lsdToken := s.lsdTokenAddress.String()
+	metrics.HandlerDuration.WithLabelValues(lsdToken, name).Observe(duration.Seconds())
+	if err != nil {
+		var gasErr *connection.GasPriceError
+		if errors.As(err, &gasErr) {
+			metrics.GasPriceErrors.WithLabelValues(lsdToken, name).Inc()
+		} else {
+			metrics.HandlerFailures.WithLabelValues(lsdToken, name).Inc()
+		}
+	}
+
+	now := time.Now()
+	s.handlerStatus.Compute(name, func(status HandlerStatus, _ bool) (HandlerStatus, bool) {
+		status.Name = name
+		status.Runs++
+		status.LastRunAt = now
+		if err != nil {
+			status.Failures++
+			status.ConsecutiveFailures++
+			status.LastErrorAt = now
+			status.LastError = err.Error()
+		} else {
+			status.ConsecutiveFailures = 0
+			status.LastSuccessAt = now
+		}
+		return status, false
+	})
+}
+
+func (s *Service) setHandlerWaiting(name, waitingFor string) {
+	s.handlerStatus.Compute(name, func(status HandlerStatus, loaded bool) (HandlerStatus, bool) {
+		if !loaded && waitingFor == "" {
+			return status, true
+		}
+		status.Name = name
+		status.WaitingFor = waitingFor
+		return status, false
+	})
+}
+
+// updateSyncLag reports how many blocks the sync heights are behind the latest eth1 block.
+func (s *Service) updateSyncLag(ctx context.Context) error {
+	latestBlock, err := s.connection.Eth1LatestBlock()
+	if err != nil {
+		return err
+	}
+	lag := func(height uint64) float64 {
+		if height >= latestBlock {
+			return 0
+		}
+		return float64(latestBlock - height)
+	}
+	lsdToken := s.lsdTokenAddress.String()
+	metrics.SyncLag.WithLabelValues(lsdToken, "syncEvents").Set(lag(s.latestBlockOfSyncEvents.Load()))
+	metrics.SyncLag.WithLabelValues(lsdToken, "syncBlocks").Set(lag(s.latestBlockOfSyncBlock.Load()))
+	metrics.SyncLag.WithLabelValues(lsdToken, "updateValidators").Set(lag(s.latestBlockOfUpdateValidator.Load()))
+	return nil
+}
+
+// Status returns a snapshot of the sync heights and handler runs.
+func (s *Service) Status() ServiceStatus {
+	status := ServiceStatus{
+		LsdToken:                     s.lsdTokenAddress.String(),
+		HandlersStarted:              s.handlersStarted.Load(),
+		WaitFirstNodeStakeEvent:      s.waitFirstNodeStakeEvent.Load(),
+		LatestBlockOfSyncBlock:       s.latestBlockOfSyncBlock.Load(),
+		LatestSlotOfSyncBlock:        s.latestSlotOfSyncBlock.Load(),
+		LatestBlockOfSyncEvents:      s.latestBlockOfSyncEvents.Load(),
+		LatestBlockOfUpdateValidator: s.latestBlockOfUpdateValidator.Load(),
+		LatestEpochOfUpdateValidator: s.latestEpochOfUpdateValidator.Load(),
+		Handlers:                     make([]HandlerStatus, 0, s.handlerStatus.Size()),
+		Divergences:                  s.ProposalDivergences(),
+	}
+	if s.dryRun {
+		status.DryRunResults = s.DryRunResults()
+	}
+	if s.feeComparison {
+		status.PriorityFeeComparisons = s.PriorityFeeComparisons()
+	}
+	s.handlerStatus.Range(func(_ string, handler HandlerStatus) bool {
+		status.Handlers = append(status.Handlers, handler)
+		return true
+	})
+	sort.Slice(status.Handlers, func(i, j int) bool {
+		return status.Handlers[i].Name < status.Handlers[j].Name
+	})
+	return status
+}
+
+// Ready reports whether handlers are running, a service waiting for the first
+// node stake event is also ready as it has nothing to vote on yet.
+func (s *Service) Ready() bool {
+	return s.waitFirstNodeStakeEvent.Load() || s.handlersStarted.Load()
+}
@@ -13,27 +13,32 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/beacon"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/types"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
 )
 
-func (s *Service) submitBalances() error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+func (s *Service) submitBalances(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
 	defer cancel()
-	beaconHead, err := s.connection.BeaconHead()
+	beaconHead, err := s.beaconHead()
 	if err != nil {
 		return err
 	}
 	targetEpoch := (beaconHead.FinalizedEpoch / s.submitBalancesDuEpochs) * s.submitBalancesDuEpochs
 
-	snapshotOnchain, err := s.networkBalancesContract.BalancesSnapshot(nil)
+	targetBlock, err := s.getEpochStartBlocknumberWithCheck(ctx, targetEpoch)
 	if err != nil {
-		return fmt.Errorf("networkBalancesContract.BalancesBlock err: %s", err)
+		return err
 	}
 
-	targetBlock, err := s.getEpochStartBlocknumberWithCheck(targetEpoch)
+	readOpts, err := s.readOpts(ctx, targetBlock)
 	if err != nil {
 		return err
 	}
+	snapshotOnchain, err := s.networkBalancesContract.BalancesSnapshot(readOpts)
+	if err != nil {
+		return fmt.Errorf("networkBalancesContract.BalancesBlock err: %s", err)
+	}
 
 	// already update on this block, no need vote
 	if targetBlock <= snapshotOnchain.Block.Uint64() {
@@ -41,7 +46,7 @@ func (s *Service) submitBalances() error {
 	}
 
 	// wait sync block
-	if targetBlock > s.latestBlockOfSyncBlock {
+	if targetBlock > s.latestBlockOfSyncBlock.Load() {
 		return nil
 	}
 
@@ -111,7 +116,7 @@ func (s *Service) submitBalances() error {
 	if latestDistributeWithdrawalsHeight.Cmp(big.NewInt(0)) == 0 {
 		latestDistributeWithdrawalsHeight = big.NewInt(int64(s.startAtBlock))
 	}
-	userEthFromWithdrawDeci, _, _, _, err := s.getUserNodePlatformFromWithdrawals(latestDistributeWithdrawalsHeight.Uint64(), targetBlock)
+	userEthFromWithdrawDeci, _, _, _, err := s.getUserNodePlatformFromWithdrawals(latestDistributeWithdrawalsHeight.Uint64(), targetBlock, nil)
 	if err != nil {
 		return err
 	}
@@ -124,7 +129,7 @@ func (s *Service) submitBalances() error {
 	if latestDistributePriorityFeeHeight.Cmp(big.NewInt(0)) == 0 {
 		latestDistributePriorityFeeHeight = big.NewInt(int64(s.startAtBlock))
 	}
-	userEthFromPriorityFeeDeci, _, _, _, err := s.getUserNodePlatformFromPriorityFee(latestDistributePriorityFeeHeight.Uint64(), targetBlock)
+	userEthFromPriorityFeeDeci, _, _, _, err := s.getUserNodePlatformFromPriorityFee(ctx, latestDistributePriorityFeeHeight.Uint64(), targetBlock, nil)
 	if err != nil {
 		return err
 	}
@@ -148,7 +153,7 @@ func (s *Service) submitBalances() error {
 	oldExchangeRateDeci := decimal.NewFromBigInt(oldExchangeRate, 0)
 
 	newExchangeRateDeci := totalUserEthDeci.Mul(decimal.NewFromInt(1e18)).Div(lsdTokenTotalSupplyDeci)
-	rateChangeLimit, err := s.networkBalancesContract.RateChangeLimit(nil)
+	rateChangeLimit, err := s.networkBalancesContract.RateChangeLimit(readOpts)
 	if err != nil {
 		return err
 	}
@@ -171,6 +176,7 @@ func (s *Service) submitBalances() error {
 		"oldExchangeRate":                   oldExchangeRateDeci.StringFixed(0),
 		"rateChange":                        rateChange.StringFixed(0),
 	})
+	s.replay.note(rateInfoLog.Data)
 	if rateChange.GreaterThan(decimal.NewFromBigInt(rateChangeLimit, 0)) {
 		rateInfoLog.Error("exchangeRateInfo")
 		return fmt.Errorf("exceed rate change limit %s, newExchangeRate %s, oldExchangeRate %s",
@@ -178,7 +184,7 @@ func (s *Service) submitBalances() error {
 	}
 	rateInfoLog.Info("exchangeRateInfo")
 
-	return s.sendSubmitBalancesTx(big.NewInt(int64(targetBlock)), totalUserEthDeci.BigInt(), lsdTokenTotalSupply)
+	return s.sendSubmitBalancesTx(ctx, big.NewInt(int64(targetBlock)), totalUserEthDeci.BigInt(), lsdTokenTotalSupply)
 
 }
 
@@ -264,36 +270,15 @@ func (s *Service) getUserDepositPlusReward(nodeDepositAmount, validatorBalance d
 	}
 }
 
-func (s *Service) sendSubmitBalancesTx(block, totalUserEth, lsdTokenTotalSupply *big.Int) error {
-	err := s.connection.LockAndUpdateTxOpts()
-	if err != nil {
-		return fmt.Errorf("LockAndUpdateTxOpts err: %w", err)
-	}
-	defer s.connection.UnlockTxOpts()
-
+func (s *Service) sendSubmitBalancesTx(ctx context.Context, block, totalUserEth, lsdTokenTotalSupply *big.Int) error {
 	encodeBts, err := s.networkBalancesAbi.Pack("submitBalances", block, totalUserEth, lsdTokenTotalSupply)
 	if err != nil {
 		return err
 	}
-
-	proposalId := utils.ProposalId(s.networkBalancesAddress, encodeBts, block)
-
-	// check voted
-	hasVoted, err := s.networkProposalContract.HasVoted(nil, proposalId, s.connection.Keypair().CommonAddress())
-	if err != nil {
-		return fmt.Errorf("networkProposalContract.HasVoted err: %s", err)
-	}
-	if hasVoted {
-		s.log.Info("already voted wait other voters")
-		return nil
+	s.trackOwnProposal(metrics.ProposalSubmitBalances, s.networkBalancesAddress, encodeBts, block)
+	if s.dryRun {
+		return s.dryRunProposal(metrics.ProposalSubmitBalances, s.networkBalancesAddress, encodeBts, block)
 	}
 
-	tx, err := s.networkProposalContract.ExecProposal(s.connection.TxOpts(), s.networkBalancesAddress, encodeBts, block)
-	if err != nil {
-		return err
-	}
-
-	s.log.Info("send submitBalances tx hash: ", tx.Hash().String())
-
-	return s.waitProposalTxOk(tx.Hash(), proposalId)
+	return s.voteProposal(ctx, metrics.ProposalSubmitBalances, s.networkBalancesAddress, encodeBts, block)
 }
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+	deposit_contract "github.com/stafiprotocol/eth-lsd-relay/bindings/DepositContract"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/beacon"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+)
+
+const (
+	resubscribeDelay    = 5 * time.Second
+	resubscribeDelayMax = 5 * time.Minute
+)
+
+// startEventSubscriptions wakes syncEvents up when the events it syncs are pushed and their blocks are
+// confirmed, and updateValidatorsFromNetwork when nodes deposit. Events are still fetched by the filter
+// path of the handlers, which also backfills the events missed while resubscribing.
+func (s *Service) startEventSubscriptions() {
+	s.handlersWg.Add(1)
+	utils.SafeGo(func() {
+		defer s.handlersWg.Done()
+		defer metrics.EventsSubscribed.WithLabelValues(s.lsdTokenAddress.String()).Set(0)
+
+		delay := resubscribeDelay
+		for {
+			subscribedAt := time.Now()
+			err := s.serveEventSubscriptions()
+			if s.ctx.Err() != nil {
+				return
+			}
+			if time.Since(subscribedAt) > resubscribeDelayMax {
+				delay = resubscribeDelay
+			}
+			s.log.WithFields(logrus.Fields{
+				"err":      err,
+				"retry_in": delay,
+			}).Warn("event subscription dropped, polling every subscriptionPollInterval meanwhile")
+			utils.Sleep(s.ctx.Done(), delay)
+			if s.ctx.Err() != nil {
+				return
+			}
+			delay = min(2*delay, resubscribeDelayMax)
+		}
+	})
+}
+
+// serveEventSubscriptions serves the subscriptions until one of them fails or the service stops.
+func (s *Service) serveEventSubscriptions() error {
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	query, err := s.eventsQuery()
+	if err != nil {
+		return err
+	}
+	logs := make(chan types.Log, 256)
+	logSub, err := s.connection.Eth1Client().SubscribeFilterLogs(ctx, query, logs)
+	if err != nil {
+		return fmt.Errorf("subscribe logs err: %w", err)
+	}
+	defer logSub.Unsubscribe()
+	heads := make(chan *types.Header, 16)
+	headSub, err := s.connection.Eth1Client().SubscribeNewHead(ctx, heads)
+	if err != nil {
+		return fmt.Errorf("subscribe new heads err: %w", err)
+	}
+	defer headSub.Unsubscribe()
+
+	metrics.EventsSubscribed.WithLabelValues(s.lsdTokenAddress.String()).Set(1)
+	defer metrics.EventsSubscribed.WithLabelValues(s.lsdTokenAddress.String()).Set(0)
+	s.log.Info("subscribed to contract events and new heads")
+
+	// backfill events pushed while not subscribed
+	s.wakeHandler("syncEvents")
+	s.wakeHandler("updateValidatorsFromNetwork")
+
+	// the latest block with events not synced yet, synced once it is below the sync target
+	var pendingBlock uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-logSub.Err():
+			return fmt.Errorf("logs subscription err: %w", err)
+		case err := <-headSub.Err():
+			return fmt.Errorf("new heads subscription err: %w", err)
+		case l := <-logs:
+			if l.Removed {
+				// syncEvents rolls back the events it synced from the block if they are reorged
+				s.log.WithFields(logrus.Fields{
+					"block": l.BlockNumber,
+					"tx":    l.TxHash.String(),
+				}).Debug("pushed event removed by reorg")
+				s.wakeHandler("syncEvents")
+				continue
+			}
+			if l.Address == s.nodeDepositAddress {
+				s.wakeHandler("updateValidatorsFromNetwork")
+				continue
+			}
+			pendingBlock = max(pendingBlock, l.BlockNumber)
+		case head := <-heads:
+			if pendingBlock == 0 {
+				continue
+			}
+			target, err := s.eventsSyncTarget(ctx, head.Number.Uint64())
+			if err != nil {
+				s.log.WithError(err).Debug("events sync target of new head")
+				continue
+			}
+			if target >= pendingBlock {
+				s.wakeHandler("syncEvents")
+				pendingBlock = 0
+			}
+		}
+	}
+}
+
+// onBeaconEvent runs the handlers of a new finalized epoch at once, the vote handlers follow as they depend
+// on them, instead of waiting for their interval.
+func (s *Service) onBeaconEvent(event beacon.Event) {
+	switch event.Topic {
+	case beacon.EventTopicFinalizedCheckpoint:
+		s.log.WithField("epoch", event.FinalizedCheckpoint.Epoch).Debug("checkpoint finalized")
+		s.wakeHandler("syncBlocks")
+		s.wakeHandler("updateValidatorsFromBeacon")
+	case beacon.EventTopicChainReorg:
+		s.log.WithFields(logrus.Fields{
+			"slot":  event.ChainReorg.Slot,
+			"depth": event.ChainReorg.Depth,
+		}).Warn("beacon chain reorg")
+		// execution blocks of the reorged slots are reorged too
+		s.wakeHandler("syncEvents")
+	}
+}
+
+// eventsQuery filters the events synced by syncEvents and the deposits of nodes.
+func (s *Service) eventsQuery() (ethereum.FilterQuery, error) {
+	depositAbi, err := deposit_contract.DepositContractMetaData.GetAbi()
+	if err != nil {
+		return ethereum.FilterQuery{}, err
+	}
+	return ethereum.FilterQuery{
+		Addresses: []common.Address{s.govDepositAddress, s.networkWithdrawAddress, s.nodeDepositAddress},
+		Topics: [][]common.Hash{{
+			depositAbi.Events["DepositEvent"].ID,
+			s.networkWithdrawAbi.Events["NotifyValidatorExit"].ID,
+			s.networkWithdrawAbi.Events["Unstake"].ID,
+			s.networkWithdrawAbi.Events["Withdraw"].ID,
+			s.nodeDepositAbi.Events["Deposited"].ID,
+		}},
+	}, nil
+}
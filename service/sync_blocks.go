@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -14,22 +15,22 @@ var ErrHandlerExit = fmt.Errorf("exit")
 var ErrMissingEth1Block = fmt.Errorf("beacon chain missing eth1 block: %w", ErrHandlerExit)
 
 // sync beacon and execution block info
-func (s *Service) syncBlocks() error {
-	beaconHead, err := s.connection.BeaconHead()
+func (s *Service) syncBlocks(ctx context.Context) error {
+	beaconHead, err := s.beaconHead()
 	if err != nil {
 		return err
 	}
 
-	if beaconHead.FinalizedSlot <= s.latestSlotOfSyncBlock {
+	if beaconHead.FinalizedSlot <= s.latestSlotOfSyncBlock.Load() {
 		s.log.WithField("handler", "syncBlocks").
-			WithField("latestSlotOfSyncBlock", s.latestSlotOfSyncBlock).
+			WithField("latestSlotOfSyncBlock", s.latestSlotOfSyncBlock.Load()).
 			WithField("beaconHead.FinalizedSlot", beaconHead.FinalizedSlot).
 			Debug("synced to head")
 		return nil
 	}
-	latestSlotOfUpdateValidator := utils.EndSlotOfEpoch(s.eth2Config, s.latestEpochOfUpdateValidator)
+	latestSlotOfUpdateValidator := utils.EndSlotOfEpoch(s.eth2Config, s.latestEpochOfUpdateValidator.Load())
 
-	start := uint64(s.latestSlotOfSyncBlock + 1)
+	start := uint64(s.latestSlotOfSyncBlock.Load() + 1)
 	end := beaconHead.FinalizedSlot
 	if end > latestSlotOfUpdateValidator {
 		end = latestSlotOfUpdateValidator
@@ -39,6 +40,10 @@ func (s *Service) syncBlocks() error {
 	g.SetLimit(int(s.batchRequestBlocksNumber))
 
 	for i := start; i <= end; i += s.batchRequestBlocksNumber {
+		// a batch is either saved as a whole or dropped, stop between batches
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		subStart := i
 		subEnd := i + s.batchRequestBlocksNumber - 1
 		if end < i+s.batchRequestBlocksNumber {
@@ -50,7 +55,7 @@ func (s *Service) syncBlocks() error {
 			"end":      end,
 		}).Info("syncing blocks")
 
-		preLatestSyncBlock := s.latestBlockOfSyncBlock
+		preLatestSyncBlock := s.latestBlockOfSyncBlock.Load()
 		batchRequestStartTime := time.Now().Unix()
 
 		blockReceiver := make([]*CachedBeaconBlock, s.batchRequestBlocksNumber)
@@ -59,7 +64,7 @@ func (s *Service) syncBlocks() error {
 			slot := j
 			g.Go(func() error {
 				startTime := time.Now().Unix()
-				beaconBlock, exist, err := s.manager.CacheBeaconBlock(slot)
+				beaconBlock, exist, err := s.manager.CacheBeaconBlock(ctx, slot)
 				if err != nil {
 					return err
 				}
@@ -70,7 +75,7 @@ func (s *Service) syncBlocks() error {
 					return nil
 				}
 				// wait validator updated
-				if beaconBlock.ExecutionBlockNumber > s.latestBlockOfUpdateValidator {
+				if beaconBlock.ExecutionBlockNumber > s.latestBlockOfUpdateValidator.Load() {
 					return ErrExceedsValidatorUpdateBlock
 				}
 
@@ -84,7 +89,7 @@ func (s *Service) syncBlocks() error {
 
 		err = g.Wait()
 		if err != nil {
-			s.latestBlockOfSyncBlock = preLatestSyncBlock
+			s.latestBlockOfSyncBlock.Store(preLatestSyncBlock)
 			if err == ErrExceedsValidatorUpdateBlock {
 				s.log.Debug("ErrExceedsValidatorUpdateBlock")
 				return nil
@@ -101,17 +106,17 @@ func (s *Service) syncBlocks() error {
 			s.log.Tracef("save block: %d", beaconBlock.ExecutionBlockNumber)
 
 			// update latest block
-			if beaconBlock.ExecutionBlockNumber > s.latestBlockOfSyncBlock {
-				if beaconBlock.ExecutionBlockNumber-s.latestBlockOfSyncBlock > 1 {
+			if beaconBlock.ExecutionBlockNumber > s.latestBlockOfSyncBlock.Load() {
+				if beaconBlock.ExecutionBlockNumber-s.latestBlockOfSyncBlock.Load() > 1 {
 					// rpc error missing some blocks
-					return fmt.Errorf("%w at slot: %d desired eth1 block: %d", ErrMissingEth1Block, beaconBlock.BeaconBlockId, s.latestBlockOfSyncBlock+1)
+					return fmt.Errorf("%w at slot: %d desired eth1 block: %d", ErrMissingEth1Block, beaconBlock.BeaconBlockId, s.latestBlockOfSyncBlock.Load()+1)
 				}
-				s.latestBlockOfSyncBlock = beaconBlock.ExecutionBlockNumber
+				s.latestBlockOfSyncBlock.Store(beaconBlock.ExecutionBlockNumber)
 			}
 		}
 
 		// update latest slot
-		s.latestSlotOfSyncBlock = subEnd
+		s.latestSlotOfSyncBlock.Store(subEnd)
 
 		batchRequestEndTime := time.Now().Unix()
 		s.log.Tracef("batch request block, start at: %d, wait at %d, end at %d", batchRequestStartTime, batchRequestWaitTime, batchRequestEndTime)
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
@@ -11,48 +12,57 @@ import (
 )
 
 const (
-	fetchEth1WaitBlockNumbers = uint64(2)
-	depositEventPreBlocks     = 14400 // 2days
+	depositEventPreBlocks = 14400 // 2days
 )
 
-func (s *Service) syncEvents() error {
+var errReorgDuringSync = errors.New("block reorged while syncing events")
+
+func (s *Service) syncEvents(ctx context.Context) error {
 	latestBlockNumber, err := s.connection.Eth1LatestBlock()
 	if err != nil {
 		return err
 	}
 
-	latestDistributeWithdrawalsHeight, err := s.networkWithdrawContract.LatestDistributeWithdrawalsHeight(nil)
+	readOpts, err := s.readOpts(ctx, 0)
+	if err != nil {
+		return err
+	}
+	latestDistributeWithdrawalsHeight, err := s.networkWithdrawContract.LatestDistributeWithdrawalsHeight(readOpts)
 	if err != nil {
 		return err
 	}
 	s.latestDistributeWithdrawalsHeight = latestDistributeWithdrawalsHeight.Uint64()
 
-	latestDistributePriorityFeeHeight, err := s.networkWithdrawContract.LatestDistributePriorityFeeHeight(nil)
+	latestDistributePriorityFeeHeight, err := s.networkWithdrawContract.LatestDistributePriorityFeeHeight(readOpts)
 	if err != nil {
 		return err
 	}
 	s.latestDistributePriorityFeeHeight = latestDistributePriorityFeeHeight.Uint64()
 
-	latestMerkleRootEpoch, err := s.networkWithdrawContract.LatestMerkleRootEpoch(nil)
+	latestMerkleRootEpoch, err := s.networkWithdrawContract.LatestMerkleRootEpoch(readOpts)
 	if err != nil {
 		return err
 	}
 	s.latestMerkleRootEpoch = latestMerkleRootEpoch.Uint64()
 
-	if latestBlockNumber > fetchEth1WaitBlockNumbers {
-		latestBlockNumber -= fetchEth1WaitBlockNumbers
+	latestBlockNumber, err = s.eventsSyncTarget(ctx, latestBlockNumber)
+	if err != nil {
+		return err
 	}
 
-	s.log.Debugf("latestBlockNumber: %d, latestBlockOfSyncEvents: %d", latestBlockNumber, s.latestBlockOfSyncEvents)
+	s.log.Debugf("latestBlockNumber: %d, latestBlockOfSyncEvents: %d", latestBlockNumber, s.latestBlockOfSyncEvents.Load())
 
-	if latestBlockNumber <= uint64(s.latestBlockOfSyncEvents) {
+	if latestBlockNumber <= uint64(s.latestBlockOfSyncEvents.Load()) {
 		return nil
 	}
 
-	start := uint64(s.latestBlockOfSyncEvents + 1)
+	start := uint64(s.latestBlockOfSyncEvents.Load() + 1)
 	end := latestBlockNumber
 
 	for i := start; i <= end; i += s.eventFilterMaxSpanBlocks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		subStart := i
 		subEnd := i + s.eventFilterMaxSpanBlocks - 1
 		if end < i+s.eventFilterMaxSpanBlocks {
@@ -65,27 +75,43 @@ func (s *Service) syncEvents() error {
 			}).Info("catching up events")
 		}
 
-		err = s.fetchDepositContractEventsAndCache(subStart, subEnd)
+		// the parent of the range must be the last synced block, or blocks synced before are reorged
+		startHeader, err := s.headerOf(ctx, subStart)
 		if err != nil {
 			return err
 		}
-
-		err = s.fetchExitElectionEventAndCache(subStart, subEnd)
+		if last := s.lastSyncedRange(); last != nil && last.end == subStart-1 && startHeader.ParentHash != last.endHash {
+			s.log.WithFields(logrus.Fields{
+				"block":      subStart,
+				"parentHash": startHeader.ParentHash.String(),
+				"syncedHash": last.endHash.String(),
+			}).Warn("reorg detected")
+			return s.rollbackReorg(ctx)
+		}
+		endHeader, err := s.headerOf(ctx, subEnd)
 		if err != nil {
 			return err
 		}
 
-		err = s.fetchUnstakeEventAndCache(subStart, subEnd)
-		if err != nil {
+		r := &syncedRange{start: subStart, end: subEnd, endHash: endHeader.Hash()}
+		if err := s.syncEventsOf(ctx, r); err != nil {
+			r.rollback()
 			return err
 		}
-		err = s.fetchWithdrawEventAndUpdate(subStart, subEnd)
+		// logs may be fetched from blocks of a fork replacing the range while it was synced
+		endHeader, err = s.headerOf(ctx, subEnd)
 		if err != nil {
+			r.rollback()
 			return err
 		}
+		if endHeader.Hash() != r.endHash {
+			r.rollback()
+			return errReorgDuringSync
+		}
 
 		// update
-		s.latestBlockOfSyncEvents = subEnd
+		s.appendSyncedRange(r)
+		s.latestBlockOfSyncEvents.Store(subEnd)
 
 		s.log.WithFields(logrus.Fields{
 			"start": subStart,
@@ -96,11 +122,25 @@ func (s *Service) syncEvents() error {
 	return nil
 }
 
-func (s *Service) fetchDepositContractEventsAndCache(start, end uint64) error {
+// syncEventsOf caches the events of range r, r records how to undo them.
+func (s *Service) syncEventsOf(ctx context.Context, r *syncedRange) error {
+	if err := s.fetchDepositContractEventsAndCache(ctx, r); err != nil {
+		return err
+	}
+	if err := s.fetchExitElectionEventAndCache(ctx, r); err != nil {
+		return err
+	}
+	if err := s.fetchUnstakeEventAndCache(ctx, r); err != nil {
+		return err
+	}
+	return s.fetchWithdrawEventAndUpdate(ctx, r)
+}
+
+func (s *Service) fetchDepositContractEventsAndCache(ctx context.Context, r *syncedRange) error {
 	iterDeposited, err := s.govDepositContract.FilterDepositEvent(&bind.FilterOpts{
-		Start:   start,
-		End:     &end,
-		Context: context.Background(),
+		Start:   r.start,
+		End:     &r.end,
+		Context: ctx,
 	})
 	if err != nil {
 		return err
@@ -109,17 +149,25 @@ func (s *Service) fetchDepositContractEventsAndCache(start, end uint64) error {
 	for iterDeposited.Next() {
 		pubkeyStr := hex.EncodeToString(iterDeposited.Event.Pubkey)
 
+		preLen := len(s.govDeposits[pubkeyStr])
 		s.govDeposits[pubkeyStr] = append(s.govDeposits[pubkeyStr], iterDeposited.Event.WithdrawalCredentials)
+		r.onUndo(func() {
+			if preLen == 0 {
+				delete(s.govDeposits, pubkeyStr)
+			} else {
+				s.govDeposits[pubkeyStr] = s.govDeposits[pubkeyStr][:preLen]
+			}
+		})
 	}
 
 	return nil
 }
 
-func (s *Service) fetchExitElectionEventAndCache(start, end uint64) error {
+func (s *Service) fetchExitElectionEventAndCache(ctx context.Context, r *syncedRange) error {
 	iter, err := s.networkWithdrawContract.FilterNotifyValidatorExit(&bind.FilterOpts{
-		Start:   start,
-		End:     &end,
-		Context: context.Background(),
+		Start:   r.start,
+		End:     &r.end,
+		Context: ctx,
 	})
 	if err != nil {
 		return err
@@ -133,20 +181,28 @@ func (s *Service) fetchExitElectionEventAndCache(start, end uint64) error {
 			valList = append(valList, val.Uint64())
 		}
 
+		pre, exist := s.exitElections[cycle]
 		s.exitElections[cycle] = &ExitElection{
 			WithdrawCycle:      cycle,
 			ValidatorIndexList: valList,
 		}
+		r.onUndo(func() {
+			if exist {
+				s.exitElections[cycle] = pre
+			} else {
+				delete(s.exitElections, cycle)
+			}
+		})
 	}
 
 	return nil
 }
 
-func (s *Service) fetchUnstakeEventAndCache(start, end uint64) error {
+func (s *Service) fetchUnstakeEventAndCache(ctx context.Context, r *syncedRange) error {
 	iter, err := s.networkWithdrawContract.FilterUnstake(&bind.FilterOpts{
-		Start:   start,
-		End:     &end,
-		Context: context.Background(),
+		Start:   r.start,
+		End:     &r.end,
+		Context: ctx,
 	}, nil)
 	if err != nil {
 		return err
@@ -158,8 +214,17 @@ func (s *Service) fetchUnstakeEventAndCache(start, end uint64) error {
 			claimedBlockNumber = iter.Event.Raw.BlockNumber
 		}
 
-		s.stakerWithdrawals[iter.Event.WithdrawIndex.Uint64()] = &StakerWithdrawal{
-			WithdrawIndex:      iter.Event.WithdrawIndex.Uint64(),
+		withdrawIndex := iter.Event.WithdrawIndex.Uint64()
+		pre, exist := s.stakerWithdrawals[withdrawIndex]
+		r.onUndo(func() {
+			if exist {
+				s.stakerWithdrawals[withdrawIndex] = pre
+			} else {
+				delete(s.stakerWithdrawals, withdrawIndex)
+			}
+		})
+		s.stakerWithdrawals[withdrawIndex] = &StakerWithdrawal{
+			WithdrawIndex:      withdrawIndex,
 			Address:            iter.Event.From,
 			EthAmount:          decimal.NewFromBigInt(iter.Event.EthAmount, 0),
 			BlockNumber:        iter.Event.Raw.BlockNumber,
@@ -170,11 +235,11 @@ func (s *Service) fetchUnstakeEventAndCache(start, end uint64) error {
 	return nil
 }
 
-func (s *Service) fetchWithdrawEventAndUpdate(start, end uint64) error {
+func (s *Service) fetchWithdrawEventAndUpdate(ctx context.Context, r *syncedRange) error {
 	iter, err := s.networkWithdrawContract.FilterWithdraw(&bind.FilterOpts{
-		Start:   start,
-		End:     &end,
-		Context: context.Background(),
+		Start:   r.start,
+		End:     &r.end,
+		Context: ctx,
 	}, nil)
 	if err != nil {
 		return err
@@ -186,7 +251,9 @@ func (s *Service) fetchWithdrawEventAndUpdate(start, end uint64) error {
 			if !exist {
 				return fmt.Errorf("withdrawal index: %d, not exist", wi.Uint64())
 			}
+			preClaimedBlockNumber := sw.ClaimedBlockNumber
 			sw.ClaimedBlockNumber = iter.Event.Raw.BlockNumber
+			r.onUndo(func() { sw.ClaimedBlockNumber = preClaimedBlockNumber })
 		}
 	}
 
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/rpc_fixture"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixtureBackend answers eth1 requests from the fixtures of a store, txs are not supported.
+type fixtureBackend struct {
+	*ethclient.Client
+}
+
+func newFixtureBackend(t *testing.T, dir string) *fixtureBackend {
+	store, err := rpc_fixture.NewStore(dir, rpc_fixture.ModeReplay)
+	require.NoError(t, err)
+	// fixtures hold no endpoint urls, the endpoint is never requested
+	endpoint := "http://localhost:1"
+	client, err := rpc.DialOptions(context.Background(), endpoint,
+		rpc.WithHTTPClient(&http.Client{Transport: store.Transport("eth1/0", endpoint, nil)}))
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+	return &fixtureBackend{ethclient.NewClient(client)}
+}
+
+func (b *fixtureBackend) BatchCallContext(ctx context.Context, batch []rpc.BatchElem) error {
+	return b.Client.Client().BatchCallContext(ctx, batch)
+}
+
+func (b *fixtureBackend) WaitTxOkCommon(ctx context.Context, txHash common.Hash) (uint64, error) {
+	return 0, fmt.Errorf("fixture backend: txs unsupported")
+}
+
+// TestSyncEventsFixture replays a syncEvents cycle over blocks 101 to 300 recorded into testdata/fixtures/sync_events,
+// with deposits at blocks 120 and 250, an exit election at 150, unstakes at 180 and 260 and a withdraw at 290.
+func TestSyncEventsFixture(t *testing.T) {
+	s := newFakeBackendService(t, newFixtureBackend(t, "testdata/fixtures/sync_events"))
+	s.eventFilterMaxSpanBlocks = 100
+	s.startAtBlock = 100
+	s.latestBlockOfSyncEvents.Store(s.startAtBlock)
+	s.govDeposits = make(map[string][][]byte)
+	s.exitElections = make(map[uint64]*ExitElection)
+	s.stakerWithdrawals = make(map[uint64]*StakerWithdrawal)
+
+	require.NoError(t, s.syncEvents(context.Background()))
+	assert.Equal(t, uint64(300), s.latestBlockOfSyncEvents.Load())
+	assert.Equal(t, uint64(90), s.latestDistributeWithdrawalsHeight)
+	assert.Equal(t, uint64(95), s.latestDistributePriorityFeeHeight)
+	assert.Equal(t, uint64(12), s.latestMerkleRootEpoch)
+	// both ranges are kept to be rolled back on reorg
+	require.Len(t, s.syncedRanges, 2)
+	assert.Equal(t, uint64(200), s.syncedRanges[0].end)
+
+	assert.Equal(t, map[string][][]byte{
+		hex.EncodeToString([]byte{0xaa}): {{0x01}},
+		hex.EncodeToString([]byte{0xbb}): {{0x02}},
+	}, s.govDeposits)
+	assert.Equal(t, map[uint64]*ExitElection{5: {WithdrawCycle: 5, ValidatorIndexList: []uint64{7, 9}}}, s.exitElections)
+
+	staker := common.HexToAddress("0x2000000000000000000000000000000000000001")
+	require.Len(t, s.stakerWithdrawals, 2)
+	// claimed by the withdraw
+	assert.Equal(t, staker, s.stakerWithdrawals[3].Address)
+	assert.Equal(t, big.NewInt(1.01e18).String(), s.stakerWithdrawals[3].EthAmount.String())
+	assert.Equal(t, uint64(180), s.stakerWithdrawals[3].BlockNumber)
+	assert.Equal(t, uint64(290), s.stakerWithdrawals[3].ClaimedBlockNumber)
+	// claimed instantly
+	assert.Equal(t, uint64(260), s.stakerWithdrawals[4].ClaimedBlockNumber)
+
+	// the head did not move, no block is synced again
+	require.NoError(t, s.syncEvents(context.Background()))
+	assert.Equal(t, uint64(300), s.latestBlockOfSyncEvents.Load())
+}
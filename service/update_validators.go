@@ -21,23 +21,18 @@ import (
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
 )
 
-func (s *Service) updateValidatorsFromNetwork() error {
+func (s *Service) updateValidatorsFromNetwork(ctx context.Context) error {
 	// 0. fetch new Nodes
-	jobResult, err := s.connection.SubmitLatestCallJob(s.nodeDepositContract.NewGetNodesLengthMultiCall())
+	eth1LatestBlock, nodesLength, err := s.nodesLength(ctx)
 	if err != nil {
 		return err
 	}
-	call := jobResult.Get()
-	if call.Failed {
-		return fmt.Errorf("nodeDepositContract.GetNodesLength failed: %w height: %d", call.Err, call.BlockNumber)
-	}
-	eth1LatestBlock := call.BlockNumber
-	if eth1LatestBlock <= s.latestBlockOfUpdateValidator {
+	if eth1LatestBlock <= s.latestBlockOfUpdateValidator.Load() {
 		return nil
 	}
 	opts := s.connection.CallOpts(big.NewInt(int64(eth1LatestBlock)))
+	opts.Context = ctx
 
-	nodesLength := call.Outputs.(*node_deposit.GetNodesLengthMultiCallOutput).Length
 	if nodesLength.Uint64() == 0 {
 		return nil
 	}
@@ -49,6 +44,9 @@ func (s *Service) updateValidatorsFromNetwork() error {
 		}
 		newNodes := nodesOnChain[len(s.nodes):]
 		for i, nodeAddress := range newNodes {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			s.log.WithFields(logrus.Fields{
 				"nodeAddress": nodeAddress,
 				"total":       len(newNodes),
@@ -135,19 +133,42 @@ func (s *Service) updateValidatorsFromNetwork() error {
 		s.validators[pubkeyStr].Status = info.Status
 	}
 
-	s.latestBlockOfUpdateValidator = eth1LatestBlock
+	s.latestBlockOfUpdateValidator.Store(eth1LatestBlock)
 	return nil
 }
 
-func (s *Service) updateValidatorsFromBeacon() error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*120)
+// nodesLength returns the latest block and the number of nodes at it, replays return them at the target block.
+func (s *Service) nodesLength(ctx context.Context) (uint64, *big.Int, error) {
+	if s.replay != nil {
+		opts := s.connection.CallOptsOn(s.replay.block)
+		opts.Context = ctx
+		nodesLength, err := s.nodeDepositContract.GetNodesLength(opts)
+		if err != nil {
+			return 0, nil, fmt.Errorf("nodeDepositContract.GetNodesLength failed: %w height: %d", err, s.replay.block)
+		}
+		return s.replay.block, nodesLength, nil
+	}
+
+	jobResult, err := s.connection.SubmitLatestCallJob(s.nodeDepositContract.NewGetNodesLengthMultiCall())
+	if err != nil {
+		return 0, nil, err
+	}
+	call := jobResult.Get()
+	if call.Failed {
+		return 0, nil, fmt.Errorf("nodeDepositContract.GetNodesLength failed: %w height: %d", call.Err, call.BlockNumber)
+	}
+	return call.BlockNumber, call.Outputs.(*node_deposit.GetNodesLengthMultiCallOutput).Length, nil
+}
+
+func (s *Service) updateValidatorsFromBeacon(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*120)
 	defer cancel()
-	beaconHead, err := s.connection.BeaconHead()
+	beaconHead, err := s.beaconHead()
 	if err != nil {
 		return err
 	}
 	finalEpoch := beaconHead.FinalizedEpoch
-	if finalEpoch <= s.latestEpochOfUpdateValidator {
+	if finalEpoch <= s.latestEpochOfUpdateValidator.Load() {
 		return nil
 	}
 
@@ -158,7 +179,7 @@ func (s *Service) updateValidatorsFromBeacon() error {
 		}
 	}
 	if len(pubkeys) == 0 {
-		s.latestEpochOfUpdateValidator = finalEpoch
+		s.latestEpochOfUpdateValidator.Store(finalEpoch)
 		return nil
 	}
 
@@ -173,6 +194,9 @@ func (s *Service) updateValidatorsFromBeacon() error {
 		"validatorStatuses len": len(validatorStatusMap),
 	}).Debug("validator statuses")
 
+	// validators are updated under the mutex, so handlers of other locks may read copies of them
+	s.validatorsByIndexMutex.Lock()
+	defer s.validatorsByIndexMutex.Unlock()
 	for pubkey, status := range validatorStatusMap {
 		pubkeyStr := pubkey.String()
 		if status.Exists {
@@ -223,15 +247,13 @@ func (s *Service) updateValidatorsFromBeacon() error {
 	}
 
 	// cache validators by index
-	s.validatorsByIndexMutex.Lock()
 	for _, validator := range s.validators {
 		if validator.ValidatorIndex > 0 {
 			s.validatorsByIndex[validator.ValidatorIndex] = validator
 		}
 	}
-	s.validatorsByIndexMutex.Unlock()
 
-	s.latestEpochOfUpdateValidator = finalEpoch
+	s.latestEpochOfUpdateValidator.Store(finalEpoch)
 
 	return nil
 }
@@ -299,7 +321,7 @@ func (s *Service) fetchNewVals(call *bind.CallOpts, pubkeys [][]byte) (map[strin
 		depositedIter, err := s.nodeDepositContract.FilterDeposited(&bind.FilterOpts{
 			Start:   filterBlock,
 			End:     &filterBlock,
-			Context: context.Background(),
+			Context: call.Context,
 		})
 		if err != nil {
 			return nil, err
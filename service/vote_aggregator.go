@@ -0,0 +1,571 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/samber/lo"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
+)
+
+const (
+	// votes added within the window after the first one are sent together, handlers of a
+	// cycle usually finish in this window
+	voteBatchWindow        = time.Minute
+	voteFlushCheckInterval = 5 * time.Second
+)
+
+// votes of these proposal types are sent at once with the votes collecting for the same contract, exits must be
+// notified before the withdraw cycle ends
+var urgentProposalTypes = map[string]bool{
+	metrics.ProposalNotifyValidatorExit: true,
+}
+
+var errVoteFailed = errors.New("vote tx mined without voting")
+
+// status of a vote after its tx is mined
+const (
+	voteStatusVoted    = "voted"
+	voteStatusExecuted = "executed"
+	voteStatusFailed   = "failed"
+)
+
+type proposalVote struct {
+	srv          *Service
+	proposalType string
+	proposalId   [32]byte
+	to           common.Address
+	callData     []byte
+	factor       *big.Int
+	result       *voteResult
+}
+
+// voteResult is the outcome of a queued vote, nil once its tx voted or the proposal needs no vote.
+type voteResult struct {
+	done chan struct{}
+	err  error
+}
+
+func newVoteResult() *voteResult {
+	return &voteResult{done: make(chan struct{})}
+}
+
+func doneVoteResult(err error) *voteResult {
+	r := newVoteResult()
+	r.finish(err)
+	return r
+}
+
+// finish must be called once.
+func (r *voteResult) finish(err error) {
+	r.err = err
+	close(r.done)
+}
+
+func (r *voteResult) wait(ctx context.Context) error {
+	select {
+	case <-r.done:
+		return r.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type votesKey struct{}
+
+// handlerVotes collects the votes queued by a handler run. The scheduler waits for them once the run released
+// its lock, so votes of handlers sharing the lock are still sent in one batch, and a failed vote fails the run.
+type handlerVotes struct {
+	mutex   sync.Mutex
+	results []*voteResult
+}
+
+func withVotes(ctx context.Context) (context.Context, *handlerVotes) {
+	votes := &handlerVotes{}
+	return context.WithValue(ctx, votesKey{}, votes), votes
+}
+
+func (v *handlerVotes) add(result *voteResult) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.results = append(v.results, result)
+}
+
+// wait returns the errors of the failed votes once all votes are done.
+func (v *handlerVotes) wait(ctx context.Context) error {
+	v.mutex.Lock()
+	results := v.results
+	v.mutex.Unlock()
+	errs := make([]error, 0)
+	for _, result := range results {
+		if err := result.wait(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// voteBatch collects votes to the same NetworkProposal contract.
+type voteBatch struct {
+	networkProposal common.Address
+	votes           []*proposalVote
+	firstAddedAt    time.Time
+}
+
+// VoteAggregator sends votes of all handlers and lsd tokens in BatchExecProposals txs. Each lsd network has its own
+// NetworkProposal contract, so votes are batched per NetworkProposal contract.
+type VoteAggregator struct {
+	connection *connection.CachedConnection
+	stop       chan struct{}
+	started    atomic.Bool
+	stopped    chan struct{}   // closed when the flush loop returns
+	closing    atomic.Bool     // set by Shutdown, new votes are dropped
+	ctx        context.Context // cancelled at the shutdown deadline, waiting txs stay pending
+	cancel     context.CancelFunc
+
+	mutex    sync.Mutex
+	batches  map[common.Address]*voteBatch // networkProposal -> collecting batch
+	queued   map[[32]byte]*proposalVote    // proposal id -> vote collecting or sending
+	sendLock sync.Mutex                    // one vote tx sent at a time
+	sending  sync.WaitGroup                // batches being sent
+}
+
+func NewVoteAggregator(conn *connection.CachedConnection) *VoteAggregator {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &VoteAggregator{
+		connection: conn,
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+		batches:    make(map[common.Address]*voteBatch),
+		queued:     make(map[[32]byte]*proposalVote),
+	}
+}
+
+func (a *VoteAggregator) Start() {
+	a.started.Store(true)
+	utils.SafeGo(func() {
+		defer close(a.stopped)
+		ticker := time.NewTicker(voteFlushCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.stop:
+				return
+			case <-ticker.C:
+				for _, batch := range a.readyBatches(false) {
+					a.sendAsync(batch)
+				}
+			}
+		}
+	})
+}
+
+// VoteShutdown is what the vote aggregator left behind when it was shut down.
+type VoteShutdown struct {
+	FlushedVotes int                    // votes collecting at shutdown and sent
+	DroppedVotes int                    // votes not sent before the deadline, handlers vote them again in the next run
+	PendingTxs   []connection.PendingTx // sent but not mined, resumed by the next run
+}
+
+// Shutdown stops collecting votes, sends the collecting ones at once and waits for their txs until ctx is done.
+// Txs not mined by then are left to the tx manager, which persisted them.
+func (a *VoteAggregator) Shutdown(ctx context.Context) VoteShutdown {
+	a.closing.Store(true)
+	close(a.stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			a.cancel()
+		case <-a.ctx.Done():
+		}
+	}()
+	defer a.cancel()
+
+	report := VoteShutdown{}
+	if a.started.Load() {
+		<-a.stopped
+	}
+	for _, batch := range a.readyBatches(true) {
+		if a.ctx.Err() != nil {
+			report.DroppedVotes += len(batch.votes)
+			a.dequeue(batch.votes, a.ctx.Err())
+			continue
+		}
+		a.sendAsync(batch)
+		report.FlushedVotes += len(batch.votes)
+	}
+	// sends return once their txs are mined or abandoned
+	a.sending.Wait()
+	if txManager := a.connection.TxManager(); txManager != nil {
+		report.PendingTxs = txManager.PendingTxs()
+	}
+	return report
+}
+
+// Add queues a vote and returns its result, the result of the queued vote if the same proposal is already
+// queued. A vote of an urgent proposal type is sent at once with the votes collecting for its contract.
+func (a *VoteAggregator) Add(srv *Service, proposalType string, to common.Address, callData []byte, factor *big.Int) *voteResult {
+	proposalId := utils.ProposalId(to, callData, factor)
+	if a.closing.Load() {
+		srv.log.WithField("proposalType", proposalType).Info("shutting down, vote dropped")
+		return doneVoteResult(nil)
+	}
+
+	a.mutex.Lock()
+	if queued, exist := a.queued[proposalId]; exist {
+		a.mutex.Unlock()
+		srv.log.WithField("proposalType", proposalType).Debug("vote already queued")
+		return queued.result
+	}
+	vote := &proposalVote{
+		srv:          srv,
+		proposalType: proposalType,
+		proposalId:   proposalId,
+		to:           to,
+		callData:     callData,
+		factor:       factor,
+		result:       newVoteResult(),
+	}
+	a.queued[proposalId] = vote
+
+	batch, exist := a.batches[srv.networkProposalAddress]
+	if !exist {
+		batch = &voteBatch{
+			networkProposal: srv.networkProposalAddress,
+			firstAddedAt:    time.Now(),
+		}
+		a.batches[srv.networkProposalAddress] = batch
+	}
+	batch.votes = append(batch.votes, vote)
+	urgent := urgentProposalTypes[proposalType]
+	if urgent {
+		delete(a.batches, srv.networkProposalAddress)
+	}
+	a.mutex.Unlock()
+
+	srv.log.WithFields(logrus.Fields{
+		"proposalType": proposalType,
+		"proposalId":   common.Hash(proposalId).String(),
+		"batchSize":    len(batch.votes),
+		"urgent":       urgent,
+	}).Info("queue vote")
+	if urgent {
+		a.sendAsync(batch)
+	}
+	return vote.result
+}
+
+// readyBatches detaches batches whose window has passed, or all batches if all is true.
+func (a *VoteAggregator) readyBatches(all bool) []*voteBatch {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	ready := make([]*voteBatch, 0)
+	for networkProposal, batch := range a.batches {
+		if !all && time.Since(batch.firstAddedAt) < voteBatchWindow {
+			continue
+		}
+		delete(a.batches, networkProposal)
+		ready = append(ready, batch)
+	}
+	return ready
+}
+
+// dequeue finishes votes with err, err is nil if they are voted or need no vote.
+func (a *VoteAggregator) dequeue(votes []*proposalVote, err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, vote := range votes {
+		delete(a.queued, vote.proposalId)
+		vote.result.finish(err)
+	}
+}
+
+// sendAsync sends batch without waiting for its tx, so txs of other batches are not held back.
+func (a *VoteAggregator) sendAsync(batch *voteBatch) {
+	a.sending.Add(1)
+	utils.SafeGo(func() {
+		defer a.sending.Done()
+		a.send(batch)
+	})
+}
+
+// send votes of batch in txs of at most the gas limit of a tx and tracks the status of each vote. The results
+// of votes are failed if they are not voted, handlers add them again in their next run.
+func (a *VoteAggregator) send(batch *voteBatch) {
+	votes, err := a.unvoted(batch.votes)
+	if err != nil {
+		logrus.Warnf("check votes of network proposal %s err: %s", batch.networkProposal, err.Error())
+		a.dequeue(batch.votes, err)
+		return
+	}
+	unvoted := make(map[[32]byte]bool, len(votes))
+	for _, vote := range votes {
+		unvoted[vote.proposalId] = true
+	}
+	a.dequeue(lo.Filter(batch.votes, func(vote *proposalVote, _ int) bool { return !unvoted[vote.proposalId] }), nil)
+	if len(votes) == 0 {
+		return
+	}
+	a.sendSplit(batch.networkProposal, votes)
+}
+
+// sendSplit sends votes in one tx, or in halves if their estimated gas is over the gas limit of a tx. A vote
+// whose own estimation fails would revert, it fails without a tx.
+func (a *VoteAggregator) sendSplit(networkProposal common.Address, votes []*proposalVote) {
+	gasLimit, err := a.gasLimit()
+	if err != nil {
+		logrus.Warnf("get gas limit of vote tx err: %s", err.Error())
+		a.dequeue(votes, fmt.Errorf("get gas limit of vote tx err: %w", err))
+		return
+	}
+	gas, err := a.estimateGas(networkProposal, votes)
+	if err == nil && gas <= gasLimit {
+		a.sendBatch(networkProposal, votes)
+		return
+	}
+	if len(votes) == 1 {
+		if err == nil {
+			err = fmt.Errorf("estimated gas %d over gas limit %d", gas, gasLimit)
+		}
+		votes[0].srv.log.WithFields(logrus.Fields{
+			"proposalType": votes[0].proposalType,
+			"proposalId":   common.Hash(votes[0].proposalId).String(),
+		}).Warnf("vote not sent: %s", err.Error())
+		a.dequeue(votes, fmt.Errorf("estimate gas of vote err: %w", err))
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"networkProposal": networkProposal.String(),
+		"votes":           len(votes),
+		"gas":             gas,
+		"gasLimit":        gasLimit,
+		"err":             err,
+	}).Debug("split vote batch")
+	a.sendSplit(networkProposal, votes[:len(votes)/2])
+	a.sendSplit(networkProposal, votes[len(votes)/2:])
+}
+
+// sendBatch sends votes in one tx, waits for it and finishes the votes.
+func (a *VoteAggregator) sendBatch(networkProposal common.Address, votes []*proposalVote) {
+	errs := make(map[[32]byte]error, len(votes))
+	defer func() {
+		for _, vote := range votes {
+			a.dequeue([]*proposalVote{vote}, errs[vote.proposalId])
+		}
+	}()
+	failAll := func(err error) {
+		for _, vote := range votes {
+			errs[vote.proposalId] = err
+		}
+	}
+	log := logrus.WithFields(logrus.Fields{
+		"networkProposal": networkProposal.String(),
+		"votes":           len(votes),
+	})
+
+	// txs are mined concurrently, only assigning nonces and broadcasting is serialized
+	a.sendLock.Lock()
+	nonce, err := a.sendTx(votes)
+	a.sendLock.Unlock()
+	if err != nil {
+		var gasPriceErr *connection.GasPriceError
+		if errors.As(err, &gasPriceErr) {
+			log.Warn(err.Error())
+			failAll(err)
+		} else if errors.Is(err, connection.ErrNotLeader) {
+			log.Info("lost leadership, votes dropped")
+		} else {
+			log.Errorf("send vote tx err: %s", err.Error())
+			failAll(fmt.Errorf("send vote tx err: %w", err))
+		}
+		return
+	}
+	for _, vote := range votes {
+		metrics.VotesSent.WithLabelValues(vote.srv.lsdTokenAddress.String(), vote.proposalType).Inc()
+	}
+
+	receipt, err := a.connection.TxManager().Wait(a.ctx, nonce)
+	if a.ctx.Err() != nil {
+		log.WithField("nonce", nonce).Info("shutting down, vote tx left pending")
+		failAll(a.ctx.Err())
+		return
+	}
+	if err != nil && !errors.Is(err, connection.ErrTxCancelled) {
+		log.Warnf("wait vote tx err: %s", err.Error())
+	}
+	recordVotesGasSpent(votes, receipt)
+
+	for _, vote := range votes {
+		status, err := a.voteStatus(vote)
+		if err != nil {
+			log.Warnf("check vote status err: %s", err.Error())
+			errs[vote.proposalId] = fmt.Errorf("check vote status err: %w", err)
+			continue
+		}
+		if status == voteStatusFailed {
+			metrics.VoteFailures.WithLabelValues(vote.srv.lsdTokenAddress.String(), vote.proposalType).Inc()
+			errs[vote.proposalId] = fmt.Errorf("%w: %s proposal %s nonce %d", errVoteFailed,
+				vote.proposalType, common.Hash(vote.proposalId).String(), nonce)
+		}
+		vote.srv.log.WithFields(logrus.Fields{
+			"proposalType": vote.proposalType,
+			"proposalId":   common.Hash(vote.proposalId).String(),
+			"status":       status,
+		}).Info("vote tx mined")
+	}
+}
+
+// gasLimit is the gas limit of vote txs, the configured one unless the latest block allows less.
+func (a *VoteAggregator) gasLimit() (uint64, error) {
+	header, err := a.connection.Eth1Client().HeaderByNumber(a.ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	return min(a.connection.GasLimit(), header.GasLimit), nil
+}
+
+// estimateGas estimates the gas of the tx sending votes.
+func (a *VoteAggregator) estimateGas(networkProposal common.Address, votes []*proposalVote) (uint64, error) {
+	data, err := packVotes(votes)
+	if err != nil {
+		return 0, err
+	}
+	return a.connection.Eth1Client().EstimateGas(a.ctx, ethereum.CallMsg{
+		From: a.connection.Signer().Address(),
+		To:   &networkProposal,
+		Data: data,
+	})
+}
+
+// packVotes packs the call of ExecProposal if there is only one vote, otherwise BatchExecProposals.
+func packVotes(votes []*proposalVote) ([]byte, error) {
+	networkProposalAbi := votes[0].srv.networkProposalAbi
+	if len(votes) == 1 {
+		return networkProposalAbi.Pack("execProposal", votes[0].to, votes[0].callData, votes[0].factor)
+	}
+	tos := make([]common.Address, len(votes))
+	callDatas := make([][]byte, len(votes))
+	factors := make([]*big.Int, len(votes))
+	for i, vote := range votes {
+		tos[i] = vote.to
+		callDatas[i] = vote.callData
+		factors[i] = vote.factor
+	}
+	return networkProposalAbi.Pack("batchExecProposals", tos, callDatas, factors)
+}
+
+// unvoted returns votes neither voted by us nor executed.
+func (a *VoteAggregator) unvoted(votes []*proposalVote) ([]*proposalVote, error) {
+	voter := a.connection.Signer().Address()
+	ret := make([]*proposalVote, 0, len(votes))
+	for _, vote := range votes {
+		hasVoted, err := vote.srv.networkProposalContract.HasVoted(nil, vote.proposalId, voter)
+		if err != nil {
+			return nil, fmt.Errorf("networkProposalContract.HasVoted err: %w", err)
+		}
+		if hasVoted {
+			continue
+		}
+		p, err := vote.srv.networkProposalContract.Proposals(nil, vote.proposalId)
+		if err != nil {
+			return nil, err
+		}
+		if p.Status == proposalStatusExecuted {
+			continue
+		}
+		ret = append(ret, vote)
+	}
+	return ret, nil
+}
+
+// sendTx sends votes with ExecProposal if there is only one, otherwise BatchExecProposals. Fees follow
+// the fee policies of the proposal types of votes.
+func (a *VoteAggregator) sendTx(votes []*proposalVote) (uint64, error) {
+	proposalTypes := lo.Uniq(lo.Map(votes, func(vote *proposalVote, _ int) string { return vote.proposalType }))
+	if err := a.connection.LockAndUpdateTxOpts(proposalTypes...); err != nil {
+		return 0, err
+	}
+	defer a.connection.UnlockTxOpts()
+
+	contract := votes[0].srv.networkProposalContract
+	proposalIds := make([][32]byte, len(votes))
+	tos := make([]common.Address, len(votes))
+	callDatas := make([][]byte, len(votes))
+	factors := make([]*big.Int, len(votes))
+	for i, vote := range votes {
+		proposalIds[i] = vote.proposalId
+		tos[i] = vote.to
+		callDatas[i] = vote.callData
+		factors[i] = vote.factor
+	}
+
+	var tx *ethtypes.Transaction
+	var err error
+	if len(votes) == 1 {
+		tx, err = contract.ExecProposal(a.connection.TxOpts(), tos[0], callDatas[0], factors[0])
+	} else {
+		tx, err = contract.BatchExecProposals(a.connection.TxOpts(), tos, callDatas, factors)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	nonce, err := a.connection.TxManager().Send(a.ctx, tx, proposalTypes, proposalIds...)
+	if err != nil {
+		return 0, err
+	}
+	for _, vote := range votes {
+		vote.srv.log.WithFields(logrus.Fields{
+			"proposalType": vote.proposalType,
+			"proposalId":   common.Hash(vote.proposalId).String(),
+			"txHash":       tx.Hash().String(),
+			"batchSize":    len(votes),
+		}).Info("send vote tx")
+	}
+	return nonce, nil
+}
+
+func (a *VoteAggregator) voteStatus(vote *proposalVote) (string, error) {
+	p, err := vote.srv.networkProposalContract.Proposals(nil, vote.proposalId)
+	if err != nil {
+		return "", err
+	}
+	if p.Status == proposalStatusExecuted {
+		return voteStatusExecuted, nil
+	}
+	hasVoted, err := vote.srv.networkProposalContract.HasVoted(nil, vote.proposalId, a.connection.Signer().Address())
+	if err != nil {
+		return "", err
+	}
+	if hasVoted {
+		return voteStatusVoted, nil
+	}
+	return voteStatusFailed, nil
+}
+
+// recordVotesGasSpent shares the fee of a mined vote tx among its votes, failed and cancelled txs also cost gas.
+func recordVotesGasSpent(votes []*proposalVote, receipt *ethtypes.Receipt) {
+	if receipt == nil || receipt.EffectiveGasPrice == nil || len(votes) == 0 {
+		return
+	}
+	fee := decimal.NewFromBigInt(receipt.EffectiveGasPrice, 0).Mul(decimal.NewFromInt(int64(receipt.GasUsed)))
+	share := fee.Div(utils.GweiDeci).Div(decimal.NewFromInt(int64(len(votes)))).InexactFloat64()
+	for _, vote := range votes {
+		metrics.GasSpent.WithLabelValues(vote.srv.lsdTokenAddress.String(), vote.proposalType).Add(share)
+	}
+}
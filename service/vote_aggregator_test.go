@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVoteAggregatorAdd(t *testing.T) {
+	a := NewVoteAggregator(nil)
+	s := &Service{log: logrus.NewEntry(logrus.New()), networkProposalAddress: fakeNetworkProposalAddress}
+	to := common.HexToAddress("0x2000000000000000000000000000000000000001")
+
+	result := a.Add(s, metrics.ProposalSubmitBalances, to, []byte{0x01}, big.NewInt(1))
+	// the same proposal is queued once and shares its result
+	assert.Same(t, result, a.Add(s, metrics.ProposalSubmitBalances, to, []byte{0x01}, big.NewInt(1)))
+	other := a.Add(s, metrics.ProposalDistributeWithdrawals, to, []byte{0x02}, big.NewInt(1))
+	require.Len(t, a.batches[fakeNetworkProposalAddress].votes, 2)
+
+	// handler runs wait for every vote and get the failed ones
+	_, votes := withVotes(context.Background())
+	votes.add(result)
+	votes.add(other)
+	batch := a.readyBatches(true)[0]
+	a.dequeue(batch.votes[:1], nil)
+	a.dequeue(batch.votes[1:], errVoteFailed)
+	assert.True(t, errors.Is(votes.wait(context.Background()), errVoteFailed))
+	assert.NoError(t, result.wait(context.Background()))
+	assert.Empty(t, a.queued)
+
+	// a pending vote is not waited after the run is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pending := a.Add(s, metrics.ProposalSubmitBalances, to, []byte{0x03}, big.NewInt(1))
+	assert.ErrorIs(t, pending.wait(ctx), context.Canceled)
+
+	// votes added while shutting down are dropped
+	a.closing.Store(true)
+	assert.NoError(t, a.Add(s, metrics.ProposalSubmitBalances, to, []byte{0x04}, big.NewInt(1)).wait(context.Background()))
+}
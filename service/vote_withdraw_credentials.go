@@ -8,23 +8,23 @@ import (
 	"math/big"
 	"time"
 
-	"github.com/ethereum/go-ethereum/common"
 	"github.com/prysmaticlabs/prysm/v4/contracts/deposit"
 	ethpb "github.com/prysmaticlabs/prysm/v4/proto/prysm/v1alpha1"
 	"github.com/samber/lo"
 	"github.com/sirupsen/logrus"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/beacon"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/connection/types"
+	"github.com/stafiprotocol/eth-lsd-relay/pkg/metrics"
 	"github.com/stafiprotocol/eth-lsd-relay/pkg/utils"
 )
 
-func (s *Service) voteWithdrawCredentials() error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+func (s *Service) voteWithdrawCredentials(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
 	defer cancel()
 	validatorListNeedVote := make([]*Validator, 0, len(s.validators))
 	for _, val := range s.validators {
 		if val.Status == utils.ValidatorStatusDeposited &&
-			val.DepositBlock <= s.latestBlockOfSyncEvents {
+			val.DepositBlock <= s.latestBlockOfSyncEvents.Load() {
 			validatorListNeedVote = append(validatorListNeedVote, val)
 		}
 	}
@@ -97,21 +97,21 @@ func (s *Service) voteWithdrawCredentials() error {
 		validatorMatches = append(validatorMatches, match)
 	}
 
-	return s.batchVoteWithdrawCredentialsTx(validatorPubkeys, validatorMatches, 20)
+	return s.batchVoteWithdrawCredentialsTx(ctx, validatorPubkeys, validatorMatches, 20)
 }
 
-func (s *Service) batchVoteWithdrawCredentialsTx(validatorPubkeys [][]byte, matches []bool, chunkSize int) error {
+func (s *Service) batchVoteWithdrawCredentialsTx(ctx context.Context, validatorPubkeys [][]byte, matches []bool, chunkSize int) error {
 	matchChunks := lo.Chunk[bool](matches, chunkSize)
 	valPubkeyChunks := lo.Chunk[[]byte](validatorPubkeys, chunkSize)
 	for i := range matchChunks {
-		if err := s.voteWithdrawCredentialsTx(valPubkeyChunks[i], matchChunks[i]); err != nil {
+		if err := s.voteWithdrawCredentialsTx(ctx, valPubkeyChunks[i], matchChunks[i]); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (s *Service) voteWithdrawCredentialsTx(validatorPubkeys [][]byte, matches []bool) error {
+func (s *Service) voteWithdrawCredentialsTx(ctx context.Context, validatorPubkeys [][]byte, matches []bool) error {
 	if len(validatorPubkeys) == 0 {
 		return nil
 	}
@@ -119,10 +119,10 @@ func (s *Service) voteWithdrawCredentialsTx(validatorPubkeys [][]byte, matches [
 		return fmt.Errorf("validators and matches len not match")
 	}
 
-	tos := make([]common.Address, 0)
-	callDatas := make([][]byte, 0)
-	blocks := make([]*big.Int, 0)
-	proposalIds := make([][32]byte, 0)
+	s.log.WithFields(logrus.Fields{
+		"pubkeys": pubkeyToHex(validatorPubkeys),
+		"matches": matches,
+	}).Info("voteForNode")
 
 	for i := 0; i < len(validatorPubkeys); i++ {
 		encodeBts, err := s.nodeDepositAbi.Pack("voteWithdrawCredentials", validatorPubkeys[i], matches[i])
@@ -130,51 +130,18 @@ func (s *Service) voteWithdrawCredentialsTx(validatorPubkeys [][]byte, matches [
 			return err
 		}
 
-		proposalId := utils.ProposalId(s.nodeDepositAddress, encodeBts, big.NewInt(0))
-
-		// check voted
-		hasVoted, err := s.networkProposalContract.HasVoted(nil, proposalId, s.connection.Keypair().CommonAddress())
-		if err != nil {
-			return fmt.Errorf("networkProposalContract.HasVoted err: %s", err)
-		}
-		if hasVoted {
+		if s.dryRun {
+			if err := s.dryRunProposal(metrics.ProposalVoteWithdrawCredentials, s.nodeDepositAddress, encodeBts, big.NewInt(0)); err != nil {
+				return err
+			}
 			continue
 		}
 
-		tos = append(tos, s.nodeDepositAddress)
-		callDatas = append(callDatas, encodeBts)
-		blocks = append(blocks, big.NewInt(0))
-		proposalIds = append(proposalIds, proposalId)
-	}
-
-	if len(tos) == 0 {
-		return nil
-	}
-
-	err := s.connection.LockAndUpdateTxOpts()
-	if err != nil {
-		return err
-	}
-	defer s.connection.UnlockTxOpts()
-
-	s.log.WithFields(logrus.Fields{
-		"gasPrice": s.connection.TxOpts().GasPrice.String(),
-		"gasLimit": s.connection.TxOpts().GasLimit,
-	}).Debug("tx opts")
-
-	s.log.WithFields(logrus.Fields{
-		"pubkeys": pubkeyToHex(validatorPubkeys),
-		"matches": matches,
-	}).Info("voteForNode")
-
-	tx, err := s.networkProposalContract.BatchExecProposals(s.connection.TxOpts(), tos, callDatas, blocks)
-	if err != nil {
-		return err
+		if err := s.voteProposal(ctx, metrics.ProposalVoteWithdrawCredentials, s.nodeDepositAddress, encodeBts, big.NewInt(0)); err != nil {
+			return err
+		}
 	}
-
-	s.log.Info("send vote tx hash: ", tx.Hash().String())
-
-	return s.waitProposalsTxOk(tx.Hash(), proposalIds)
+	return nil
 }
 
 func pubkeyToHex(pubkeys [][]byte) []string {